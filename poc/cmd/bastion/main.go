@@ -14,6 +14,7 @@ import (
 	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/database"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/mail"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/server"
 )
 
@@ -32,8 +33,13 @@ func main() {
 	}
 	defer db.Close()
 
+	mailTransport, err := mail.NewTransport(&cfg.Mail)
+	if err != nil {
+		log.Fatalf("Failed to configure mail transport: %v", err)
+	}
+
 	auditLogger := audit.NewLogger(db)
-	srv := server.New(db, cfg, auditLogger)
+	srv := server.New(db, cfg, auditLogger, mailTransport)
 
 	httpServer := &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.Server.Port),
@@ -4,11 +4,10 @@ go 1.24.0
 
 toolchain go1.24.5
 
-require github.com/go-chi/chi/v5 v5.2.4
-
 require (
-	github.com/golang-jwt/jwt/v5 v5.3.1 // indirect
-	github.com/lib/pq v1.10.9 // indirect
-	golang.org/x/crypto v0.47.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	github.com/go-chi/chi/v5 v5.2.4
+	github.com/golang-jwt/jwt/v5 v5.3.1
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.47.0
+	gopkg.in/yaml.v3 v3.0.1
 )
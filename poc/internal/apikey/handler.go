@@ -2,12 +2,14 @@ package apikey
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
-	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/condition"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
 )
 
 type Handler struct {
@@ -54,8 +56,8 @@ func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims := r.Context().Value("claims").(*auth.Claims)
-	h.auditLogger.Log("api_key.created", claims.UserID, map[string]interface{}{
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "api_key.created", caller.UserID(), map[string]interface{}{
 		"api_key_id": key.ID,
 		"name":       key.Name,
 	}, r.RemoteAddr)
@@ -78,9 +80,9 @@ func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
-	claims := r.Context().Value("claims").(*auth.Claims)
+	caller, _ := principal.FromContext(r.Context())
 
-	keys, err := h.service.List(claims.TenantID)
+	keys, err := h.service.List(caller.TenantID)
 	if err != nil {
 		writeError(w, "failed to list api keys", http.StatusInternalServerError)
 		return
@@ -141,8 +143,8 @@ func (h *Handler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims := r.Context().Value("claims").(*auth.Claims)
-	h.auditLogger.Log("api_key.deleted", claims.UserID, map[string]interface{}{
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "api_key.deleted", caller.UserID(), map[string]interface{}{
 		"api_key_id": id,
 	}, r.RemoteAddr)
 
@@ -153,7 +155,8 @@ func (h *Handler) AddPermission(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
 	var req struct {
-		PermissionID string `json:"permission_id"`
+		PermissionID string                `json:"permission_id"`
+		Conditions   *condition.Conditions `json:"conditions,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -161,15 +164,20 @@ func (h *Handler) AddPermission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.AddPermission(id, req.PermissionID); err != nil {
+	if err := h.service.AddPermission(id, req.PermissionID, req.Conditions); err != nil {
+		if errors.Is(err, condition.ErrInvalid) {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		writeError(w, "failed to add permission", http.StatusInternalServerError)
 		return
 	}
 
-	claims := r.Context().Value("claims").(*auth.Claims)
-	h.auditLogger.Log("api_key.permission_added", claims.UserID, map[string]interface{}{
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "api_key.permission_added", caller.UserID(), map[string]interface{}{
 		"api_key_id":    id,
 		"permission_id": req.PermissionID,
+		"conditions":    req.Conditions,
 	}, r.RemoteAddr)
 
 	w.WriteHeader(http.StatusNoContent)
@@ -184,8 +192,8 @@ func (h *Handler) RemovePermission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims := r.Context().Value("claims").(*auth.Claims)
-	h.auditLogger.Log("api_key.permission_removed", claims.UserID, map[string]interface{}{
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "api_key.permission_removed", caller.UserID(), map[string]interface{}{
 		"api_key_id":    id,
 		"permission_id": permissionID,
 	}, r.RemoteAddr)
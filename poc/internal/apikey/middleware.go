@@ -1,15 +1,11 @@
 package apikey
 
 import (
-	"context"
 	"encoding/json"
 	"net/http"
-)
 
-type APIKeyContext struct {
-	APIKeyID string
-	TenantID *string
-}
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
+)
 
 func AuthenticateAPIKey(service *Service) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -27,12 +23,11 @@ func AuthenticateAPIKey(service *Service) func(http.Handler) http.Handler {
 				return
 			}
 
-			keyCtx := &APIKeyContext{
-				APIKeyID: key.ID,
+			ctx := principal.NewContext(r.Context(), &principal.Principal{
+				Type:     principal.TypeAPIKey,
+				ID:       key.ID,
 				TenantID: key.TenantID,
-			}
-
-			ctx := context.WithValue(r.Context(), "apikey", keyCtx)
+			})
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	"time"
+
+	"github.com/rustybrownlee-llm/bastion/poc/internal/condition"
 )
 
 type APIKey struct {
@@ -23,6 +25,7 @@ type Permission struct {
 	ID           string
 	ResourceType string
 	Action       string
+	Conditions   *condition.Conditions
 }
 
 type Repository struct {
@@ -126,12 +129,14 @@ func (r *Repository) UpdateLastUsed(id string) error {
 	return err
 }
 
-func (r *Repository) AddPermission(apiKeyID, permissionID string) error {
+// AddPermission grants a permission to the key under conditions, replacing
+// the conditions of an existing grant.
+func (r *Repository) AddPermission(apiKeyID, permissionID string, conditions *condition.Conditions) error {
 	_, err := r.db.Exec(
-		`INSERT INTO api_key_permissions (api_key_id, permission_id)
-		 VALUES ($1, $2)
-		 ON CONFLICT (api_key_id, permission_id) DO NOTHING`,
-		apiKeyID, permissionID,
+		`INSERT INTO api_key_permissions (api_key_id, permission_id, conditions)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (api_key_id, permission_id) DO UPDATE SET conditions = EXCLUDED.conditions`,
+		apiKeyID, permissionID, conditions,
 	)
 	return err
 }
@@ -146,7 +151,7 @@ func (r *Repository) RemovePermission(apiKeyID, permissionID string) error {
 
 func (r *Repository) GetPermissions(apiKeyID string) ([]*Permission, error) {
 	rows, err := r.db.Query(
-		`SELECT p.id, p.resource_type, p.action
+		`SELECT p.id, p.resource_type, p.action, akp.conditions
 		 FROM permissions p
 		 JOIN api_key_permissions akp ON p.id = akp.permission_id
 		 WHERE akp.api_key_id = $1`,
@@ -160,7 +165,11 @@ func (r *Repository) GetPermissions(apiKeyID string) ([]*Permission, error) {
 	var permissions []*Permission
 	for rows.Next() {
 		perm := &Permission{}
-		if err := rows.Scan(&perm.ID, &perm.ResourceType, &perm.Action); err != nil {
+		var conditions []byte
+		if err := rows.Scan(&perm.ID, &perm.ResourceType, &perm.Action, &conditions); err != nil {
+			return nil, fmt.Errorf("scan permission: %w", err)
+		}
+		if perm.Conditions, err = condition.Parse(conditions); err != nil {
 			return nil, fmt.Errorf("scan permission: %w", err)
 		}
 		permissions = append(permissions, perm)
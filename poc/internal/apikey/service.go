@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/rustybrownlee-llm/bastion/poc/internal/condition"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -31,7 +32,7 @@ func (s *Service) Create(name, description string, tenantID *string, expiresAt *
 	}
 
 	for _, permID := range permissionIDs {
-		if err := s.repo.AddPermission(key.ID, permID); err != nil {
+		if err := s.repo.AddPermission(key.ID, permID, nil); err != nil {
 			return nil, "", fmt.Errorf("add permission: %w", err)
 		}
 	}
@@ -81,6 +82,9 @@ func (s *Service) Authenticate(fullKey string) (*APIKey, error) {
 	return key, nil
 }
 
+// CheckPermission reports whether the key holds the permission without
+// conditions. Conditional grants need the request they are evaluated
+// against; rbac.Service.CheckAPIKeyPermission evaluates them.
 func (s *Service) CheckPermission(apiKeyID, resourceType, action string) (bool, error) {
 	permissions, err := s.repo.GetPermissions(apiKeyID)
 	if err != nil {
@@ -88,7 +92,7 @@ func (s *Service) CheckPermission(apiKeyID, resourceType, action string) (bool,
 	}
 
 	for _, perm := range permissions {
-		if perm.ResourceType == resourceType && perm.Action == action {
+		if perm.ResourceType == resourceType && perm.Action == action && perm.Conditions.Empty() {
 			return true, nil
 		}
 	}
@@ -100,8 +104,16 @@ func (s *Service) GetPermissions(apiKeyID string) ([]*Permission, error) {
 	return s.repo.GetPermissions(apiKeyID)
 }
 
-func (s *Service) AddPermission(apiKeyID, permissionID string) error {
-	return s.repo.AddPermission(apiKeyID, permissionID)
+// AddPermission grants a permission to the key, under conditions if they
+// are not empty.
+func (s *Service) AddPermission(apiKeyID, permissionID string, conditions *condition.Conditions) error {
+	if err := conditions.Validate(); err != nil {
+		return err
+	}
+	if conditions.Empty() {
+		conditions = nil
+	}
+	return s.repo.AddPermission(apiKeyID, permissionID, conditions)
 }
 
 func (s *Service) RemovePermission(apiKeyID, permissionID string) error {
@@ -1,10 +1,15 @@
 package audit
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
+
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
 )
 
 type Logger struct {
@@ -15,7 +20,47 @@ func NewLogger(db *sql.DB) *Logger {
 	return &Logger{db: db}
 }
 
+type contextKey struct{}
+
+// ContextWithActor records that requests in ctx are performed by actorID on
+// behalf of the authenticated subject, e.g. an impersonating admin.
+func ContextWithActor(ctx context.Context, actorID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, actorID)
+}
+
+func ActorFromContext(ctx context.Context) string {
+	actorID, _ := ctx.Value(contextKey{}).(string)
+	return actorID
+}
+
 func (l *Logger) Log(eventType, userID string, details map[string]interface{}, ipAddress string) {
+	l.log(eventType, userID, "", details, ipAddress)
+}
+
+// LogContext is Log for request-scoped events; the acting identity in ctx,
+// if any, is recorded alongside userID.
+func (l *Logger) LogContext(ctx context.Context, eventType, userID string, details map[string]interface{}, ipAddress string) {
+	l.log(eventType, userID, ActorFromContext(ctx), withPrincipal(ctx, details), ipAddress)
+}
+
+// withPrincipal adds a calling service account or API key to details, as
+// only users can be recorded in the user_id column.
+func withPrincipal(ctx context.Context, details map[string]interface{}) map[string]interface{} {
+	p, ok := principal.FromContext(ctx)
+	if !ok || p.IsUser() {
+		return details
+	}
+
+	merged := make(map[string]interface{}, len(details)+2)
+	for k, v := range details {
+		merged[k] = v
+	}
+	merged["principal_type"] = p.Type
+	merged["principal_id"] = p.ID
+	return merged
+}
+
+func (l *Logger) log(eventType, userID, actorID string, details map[string]interface{}, ipAddress string) {
 	var detailsJSON interface{}
 	var err error
 
@@ -27,15 +72,18 @@ func (l *Logger) Log(eventType, userID string, details map[string]interface{}, i
 		}
 	}
 
-	var userIDPtr *string
+	var userIDPtr, actorIDPtr *string
 	if userID != "" {
 		userIDPtr = &userID
 	}
+	if actorID != "" {
+		actorIDPtr = &actorID
+	}
 
 	_, err = l.db.Exec(
-		`INSERT INTO audit_log (event_type, user_id, details, ip_address)
-		 VALUES ($1, $2, $3, $4)`,
-		eventType, userIDPtr, detailsJSON, ipAddress,
+		`INSERT INTO audit_log (event_type, user_id, actor_id, details, ip_address)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		eventType, userIDPtr, actorIDPtr, detailsJSON, ipAddress,
 	)
 
 	if err != nil {
@@ -43,6 +91,92 @@ func (l *Logger) Log(eventType, userID string, details map[string]interface{}, i
 	}
 }
 
+// Event is an audit entry written by Record or RecordBatch.
+type Event struct {
+	EventType string
+	UserID    string
+	Details   map[string]interface{}
+	IPAddress string
+}
+
+// Record writes a request-scoped event like LogContext, but returns the ID
+// of the entry and fails if it could not be written, for callers that must
+// hand out a reference to it.
+func (l *Logger) Record(ctx context.Context, eventType, userID string, details map[string]interface{}, ipAddress string) (string, error) {
+	ids, err := l.RecordBatch(ctx, []Event{{EventType: eventType, UserID: userID, Details: details, IPAddress: ipAddress}})
+	if err != nil {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// RecordBatch writes events with a single statement and returns their IDs
+// in order.
+func (l *Logger) RecordBatch(ctx context.Context, events []Event) ([]string, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	actorID := ActorFromContext(ctx)
+	var actorIDPtr *string
+	if actorID != "" {
+		actorIDPtr = &actorID
+	}
+
+	ids := make([]string, len(events))
+	values := make([]string, 0, len(events))
+	args := make([]interface{}, 0, len(events)*6)
+	for i, e := range events {
+		var detailsJSON interface{}
+		if details := withPrincipal(ctx, e.Details); details != nil {
+			data, err := json.Marshal(details)
+			if err != nil {
+				return nil, fmt.Errorf("marshal audit details: %w", err)
+			}
+			detailsJSON = data
+		}
+
+		var userIDPtr *string
+		if e.UserID != "" {
+			userID := e.UserID
+			userIDPtr = &userID
+		}
+
+		id, err := newID()
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6))
+		args = append(args, id, e.EventType, userIDPtr, actorIDPtr, detailsJSON, e.IPAddress)
+	}
+
+	_, err := l.db.Exec(
+		`INSERT INTO audit_log (id, event_type, user_id, actor_id, details, ip_address)
+		 VALUES `+strings.Join(values, ", "),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("insert audit log: %w", err)
+	}
+
+	return ids, nil
+}
+
+// newID returns a random (version 4) UUID, generated here rather than by
+// the database so that batch inserts know which ID belongs to which event.
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generate audit id: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
 func (l *Logger) LogError(eventType string, err error, ipAddress string) {
 	details := map[string]interface{}{
 		"error": err.Error(),
@@ -56,7 +190,7 @@ func (l *Logger) LogWithUser(eventType, userID string, ipAddress string) {
 
 func (l *Logger) GetRecentEvents(limit int) ([]map[string]interface{}, error) {
 	rows, err := l.db.Query(
-		`SELECT id, event_type, user_id, details, ip_address, created_at
+		`SELECT id, event_type, user_id, actor_id, details, ip_address, created_at
 		 FROM audit_log
 		 ORDER BY created_at DESC
 		 LIMIT $1`,
@@ -70,11 +204,11 @@ func (l *Logger) GetRecentEvents(limit int) ([]map[string]interface{}, error) {
 	var events []map[string]interface{}
 	for rows.Next() {
 		var id, eventType, ipAddress string
-		var userID sql.NullString
+		var userID, actorID sql.NullString
 		var details sql.RawBytes
 		var createdAt string
 
-		if err := rows.Scan(&id, &eventType, &userID, &details, &ipAddress, &createdAt); err != nil {
+		if err := rows.Scan(&id, &eventType, &userID, &actorID, &details, &ipAddress, &createdAt); err != nil {
 			continue
 		}
 
@@ -88,6 +222,9 @@ func (l *Logger) GetRecentEvents(limit int) ([]map[string]interface{}, error) {
 		if userID.Valid {
 			event["user_id"] = userID.String
 		}
+		if actorID.Valid {
+			event["actor_id"] = actorID.String
+		}
 
 		if len(details) > 0 {
 			var detailsMap map[string]interface{}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+)
+
+const (
+	FormatJWT    = "jwt"
+	FormatPASETO = "paseto"
+)
+
+// TokenFormat signs and verifies access tokens in one wire format. Every
+// format carries the same Claims.
+type TokenFormat interface {
+	Name() string
+	Sign(claims *Claims) (string, error)
+	Verify(token string) (*Claims, error)
+}
+
+// SignAccessToken encodes claims in the configured token format.
+func SignAccessToken(cfg *config.AuthConfig, claims *Claims) (string, error) {
+	format, err := signingFormat(cfg)
+	if err != nil {
+		return "", err
+	}
+	return format.Sign(claims)
+}
+
+func signingFormat(cfg *config.AuthConfig) (TokenFormat, error) {
+	switch cfg.TokenFormat {
+	case "", FormatJWT:
+		return &jwtFormat{secret: []byte(cfg.JWTSecret)}, nil
+	case FormatPASETO:
+		return newPASETOFormat(cfg)
+	default:
+		return nil, fmt.Errorf("unknown token format %q", cfg.TokenFormat)
+	}
+}
+
+// verifyingFormat picks the format of a presented token from its prefix.
+// Both formats are accepted unless auth.accept_token_formats narrows them,
+// so that tokens issued before a format switch stay valid until they expire.
+func verifyingFormat(cfg *config.AuthConfig, token string) (TokenFormat, error) {
+	name := FormatJWT
+	if strings.HasPrefix(token, pasetoHeader) {
+		name = FormatPASETO
+	}
+
+	if len(cfg.AcceptTokenFormats) > 0 && !contains(cfg.AcceptTokenFormats, name) {
+		return nil, fmt.Errorf("%s tokens are not accepted", name)
+	}
+
+	if name == FormatPASETO {
+		return newPASETOFormat(cfg)
+	}
+	return &jwtFormat{secret: []byte(cfg.JWTSecret)}, nil
+}
+
+type jwtFormat struct {
+	secret []byte
+}
+
+func (f *jwtFormat) Name() string {
+	return FormatJWT
+}
+
+func (f *jwtFormat) Sign(claims *Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString(f.secret)
+	if err != nil {
+		return "", fmt.Errorf("sign token: %w", err)
+	}
+	return signed, nil
+}
+
+func (f *jwtFormat) Verify(tokenString string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return f.secret, nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("parse token: %w", err)
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	return claims, nil
+}
@@ -1,7 +1,9 @@
 package auth
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -24,18 +26,23 @@ func NewHandler(service *Service, audit *audit.Logger, cfg *config.AuthConfig) *
 }
 
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string  `json:"email"`
+	Password string  `json:"password"`
+	TenantID *string `json:"tenant_id,omitempty"`
+	Scope    string  `json:"scope,omitempty"`
 }
 
 type LoginResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresIn    int    `json:"expires_in"`
+	AccessToken  string  `json:"access_token"`
+	RefreshToken string  `json:"refresh_token"`
+	ExpiresIn    int     `json:"expires_in"`
+	TenantID     *string `json:"tenant_id,omitempty"`
+	Scope        string  `json:"scope,omitempty"`
 }
 
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope,omitempty"`
 }
 
 type RefreshResponse struct {
@@ -54,9 +61,24 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessToken, refreshToken, err := h.service.Login(req.Email, req.Password)
+	sess, err := h.service.Login(req.Email, req.Password, req.TenantID, req.Scope)
+
+	var selection *TenantSelectionError
+	if errors.As(err, &selection) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   selection.Error(),
+			"tenants": selection.Tenants,
+		})
+		return
+	}
+	if errors.Is(err, ErrInvalidScope) {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		h.audit.Log("login_failure", "", map[string]interface{}{
+		h.audit.LogContext(r.Context(), "login_failure", "", map[string]interface{}{
 			"email": req.Email,
 			"error": err.Error(),
 		}, getIP(r))
@@ -64,15 +86,18 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims, _ := ValidateAccessToken(h.cfg, accessToken)
-	h.audit.Log("login_success", claims.UserID, map[string]interface{}{
+	h.audit.LogContext(r.Context(), "login_success", sess.UserID, map[string]interface{}{
 		"email": req.Email,
 	}, getIP(r))
 
+	h.service.SetSessionCookie(w, sess)
+
 	resp := LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		AccessToken:  sess.AccessToken,
+		RefreshToken: sess.RefreshToken,
 		ExpiresIn:    int(h.cfg.AccessTokenTTL.Seconds()),
+		TenantID:     sess.TenantID,
+		Scope:        sess.Scope,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -87,9 +112,13 @@ func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessToken, err := h.service.Refresh(req.RefreshToken)
+	accessToken, err := h.service.Refresh(req.RefreshToken, req.Scope)
+	if errors.Is(err, ErrInvalidScope) {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		h.audit.Log("token_refresh_failure", "", map[string]interface{}{
+		h.audit.LogContext(r.Context(), "token_refresh_failure", "", map[string]interface{}{
 			"error": err.Error(),
 		}, getIP(r))
 		writeError(w, "invalid refresh token", http.StatusUnauthorized)
@@ -97,7 +126,7 @@ func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	claims, _ := ValidateAccessToken(h.cfg, accessToken)
-	h.audit.Log("token_refresh", claims.UserID, nil, getIP(r))
+	h.audit.LogContext(r.Context(), "token_refresh", claims.UserID, nil, getIP(r))
 
 	resp := RefreshResponse{
 		AccessToken: accessToken,
@@ -110,17 +139,48 @@ func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
-	claims := r.Context().Value("claims").(*Claims)
+	claims, _ := ClaimsFromContext(r.Context())
+
+	// An impersonation or delegated token must not end the real user's
+	// sessions.
+	if claims.Act != nil {
+		writeError(w, "logout not available for delegated tokens", http.StatusForbidden)
+		return
+	}
 
 	if err := h.service.Logout(claims.UserID); err != nil {
 		writeError(w, "logout failed", http.StatusInternalServerError)
 		return
 	}
 
-	h.audit.Log("logout", claims.UserID, nil, getIP(r))
+	h.service.ClearSessionCookie(w)
+	h.audit.LogContext(r.Context(), "logout", claims.UserID, nil, getIP(r))
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// JWKS publishes the PASETO verification keys as a JSON Web Key Set (RFC
+// 8037 OKP keys) so that applications can verify access tokens locally and
+// relying parties can verify ID tokens. HS256 JWTs cannot be verified with
+// public keys and need the shared secret.
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	keys := PASETOPublicKeys(h.cfg)
+	jwks := make([]map[string]string, 0, len(keys))
+	for _, k := range keys {
+		jwks = append(jwks, map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"alg": "EdDSA",
+			"use": "sig",
+			"kid": k.KeyID,
+			"x":   base64.RawURLEncoding.EncodeToString(k.Key),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": jwks})
+}
+
 func writeError(w http.ResponseWriter, message string, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
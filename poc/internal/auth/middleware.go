@@ -5,13 +5,16 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
 )
 
 func RequireAuth(cfg *config.AuthConfig) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.Context().Value("apikey") != nil {
+			// Already authenticated, e.g. by an API key.
+			if _, ok := principal.FromContext(r.Context()); ok {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -35,8 +38,44 @@ func RequireAuth(cfg *config.AuthConfig) func(http.Handler) http.Handler {
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), "claims", claims)
+			// Tokens issued for other applications are not valid here.
+			if !claims.HasAudience(Audience(cfg)) {
+				writeError(w, "token not issued for this audience", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := ContextWithClaims(r.Context(), claims)
+			ctx = principal.NewContext(ctx, claims.Principal())
+			if claims.Act != nil {
+				ctx = audit.ContextWithActor(ctx, claims.Act.Subject)
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// RequireUser rejects callers other than users, for routes that act on the
+// caller's own account or session. It must run after RequireAuth.
+func RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFromContext(r.Context())
+		if !ok || !claims.Principal().IsUser() {
+			writeError(w, "user token required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type claimsContextKey struct{}
+
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey{}, claims)
+}
+
+// ClaimsFromContext returns the access token claims of a request
+// authenticated by RequireAuth; API key requests have none.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(*Claims)
+	return claims, ok
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+)
+
+const pasetoHeader = "v4.public."
+
+// pasetoFormat implements PASETO v4.public: Ed25519 signatures over a JSON
+// payload. Registered time claims are RFC 3339 strings on the wire, as the
+// PASETO spec requires, and numeric dates in Claims. The footer names the
+// signing key so that verifiers can pick it from the published key set.
+type pasetoFormat struct {
+	privateKey ed25519.PrivateKey
+	keyID      string
+	keys       map[string]ed25519.PublicKey
+}
+
+// PublicKey is a PASETO verification key as published in the JWKS.
+type PublicKey struct {
+	KeyID string
+	Key   ed25519.PublicKey
+}
+
+type pasetoFooter struct {
+	KeyID string `json:"kid"`
+}
+
+func newPASETOFormat(cfg *config.AuthConfig) (*pasetoFormat, error) {
+	privateKey, err := PASETOPrivateKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeys := PASETOPublicKeys(cfg)
+	f := &pasetoFormat{
+		privateKey: privateKey,
+		keyID:      publicKeys[0].KeyID,
+		keys:       make(map[string]ed25519.PublicKey, len(publicKeys)),
+	}
+	for _, k := range publicKeys {
+		f.keys[k.KeyID] = k.Key
+	}
+	return f, nil
+}
+
+// PASETOPublicKeys returns the current verification key followed by any
+// previous keys still accepted after a rotation
+// (auth.paseto_previous_public_keys). It is empty when no signing key has
+// been loaded.
+func PASETOPublicKeys(cfg *config.AuthConfig) []PublicKey {
+	if cfg.SigningKey == nil {
+		return nil
+	}
+
+	current := cfg.SigningKey.Public().(ed25519.PublicKey)
+	keys := []PublicKey{{KeyID: keyID(current), Key: current}}
+	for _, key := range cfg.PreviousPublicKeys {
+		keys = append(keys, PublicKey{KeyID: keyID(key), Key: key})
+	}
+	return keys
+}
+
+func keyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// PASETOPrivateKey returns the Ed25519 key that signs PASETO tokens and ID
+// tokens, loaded from auth.paseto_private_key when the config was loaded.
+func PASETOPrivateKey(cfg *config.AuthConfig) (ed25519.PrivateKey, error) {
+	if cfg.SigningKey == nil {
+		return nil, fmt.Errorf("no paseto signing key configured")
+	}
+	return cfg.SigningKey, nil
+}
+
+func (f *pasetoFormat) Name() string {
+	return FormatPASETO
+}
+
+func (f *pasetoFormat) Sign(claims *Claims) (string, error) {
+	payload, err := encodePASETOClaims(claims)
+	if err != nil {
+		return "", err
+	}
+
+	footer, err := json.Marshal(pasetoFooter{KeyID: f.keyID})
+	if err != nil {
+		return "", fmt.Errorf("marshal footer: %w", err)
+	}
+
+	sig := ed25519.Sign(f.privateKey, pae([]byte(pasetoHeader), payload, footer, nil))
+	return pasetoHeader + base64.RawURLEncoding.EncodeToString(append(payload, sig...)) +
+		"." + base64.RawURLEncoding.EncodeToString(footer), nil
+}
+
+func (f *pasetoFormat) Verify(token string) (*Claims, error) {
+	if !strings.HasPrefix(token, pasetoHeader) {
+		return nil, fmt.Errorf("not a v4.public token")
+	}
+
+	body, footer := strings.TrimPrefix(token, pasetoHeader), ""
+	if i := strings.IndexByte(body, '.'); i >= 0 {
+		body, footer = body[:i], body[i+1:]
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil || len(raw) < ed25519.SignatureSize {
+		return nil, fmt.Errorf("malformed token")
+	}
+	footerBytes, err := base64.RawURLEncoding.DecodeString(footer)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token footer")
+	}
+
+	key := f.keys[f.keyID]
+	if len(footerBytes) > 0 {
+		var ft pasetoFooter
+		if err := json.Unmarshal(footerBytes, &ft); err != nil {
+			return nil, fmt.Errorf("malformed token footer")
+		}
+		if key = f.keys[ft.KeyID]; key == nil {
+			return nil, fmt.Errorf("unknown signing key")
+		}
+	}
+
+	payload, sig := raw[:len(raw)-ed25519.SignatureSize], raw[len(raw)-ed25519.SignatureSize:]
+	if !ed25519.Verify(key, pae([]byte(pasetoHeader), payload, footerBytes, nil), sig) {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	claims, err := decodePASETOClaims(payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := jwt.NewValidator().Validate(claims); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	return claims, nil
+}
+
+var pasetoTimeClaims = []string{"exp", "iat", "nbf"}
+
+func encodePASETOClaims(claims *Claims) ([]byte, error) {
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("marshal claims: %w", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("marshal claims: %w", err)
+	}
+	for _, name := range pasetoTimeClaims {
+		if v, ok := fields[name].(float64); ok {
+			fields[name] = time.Unix(int64(v), 0).UTC().Format(time.RFC3339)
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+func decodePASETOClaims(payload []byte) (*Claims, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, fmt.Errorf("decode claims: %w", err)
+	}
+	for _, name := range pasetoTimeClaims {
+		s, ok := fields[name].(string)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, fmt.Errorf("decode %s claim: %w", name, err)
+		}
+		fields[name] = t.Unix()
+	}
+
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("decode claims: %w", err)
+	}
+	claims := &Claims{}
+	if err := json.Unmarshal(raw, claims); err != nil {
+		return nil, fmt.Errorf("decode claims: %w", err)
+	}
+	return claims, nil
+}
+
+// pae is PASETO's pre-authentication encoding: the piece count followed by
+// each piece prefixed with its length, all as little-endian 64-bit integers
+// with the top bit cleared.
+func pae(pieces ...[]byte) []byte {
+	var buf bytes.Buffer
+	le64 := func(n int) {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(n)&^(1<<63))
+		buf.Write(b[:])
+	}
+
+	le64(len(pieces))
+	for _, p := range pieces {
+		le64(len(p))
+		buf.Write(p)
+	}
+	return buf.Bytes()
+}
@@ -2,72 +2,167 @@ package auth
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrNotMember = errors.New("user is not a member of the tenant")
+
+type TenantChoice struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// TenantSelectionError is returned by Login when a user belongs to several
+// tenants and has not used any of them yet; the client retries with one of
+// Tenants.
+type TenantSelectionError struct {
+	Tenants []TenantChoice
+}
+
+func (e *TenantSelectionError) Error() string {
+	return "tenant selection required"
+}
+
+// PermissionResolver lists the permissions ("resource_type:action") a user
+// holds in a tenant. The rbac package provides it.
+type PermissionResolver func(userID string, tenantID *string) ([]string, error)
+
+// ClaimsEnricher adds claims to a user access token before it is signed.
+// The rbac package provides it to embed authorization data.
+type ClaimsEnricher func(claims *Claims) error
+
 type Service struct {
-	db  *sql.DB
-	cfg *config.AuthConfig
+	db          *sql.DB
+	cfg         *config.AuthConfig
+	permissions PermissionResolver
+	enrich      ClaimsEnricher
 }
 
 func NewService(db *sql.DB, cfg *config.AuthConfig) *Service {
 	return &Service{db: db, cfg: cfg}
 }
 
-func (s *Service) Login(email, password string) (string, string, error) {
-	var userID, passwordHash string
-	var tenantID *string
+// Login verifies a password and opens a session in one of the user's
+// tenants: the requested one, the only one, or the most recently used.
+// Users with several tenants and no history get a TenantSelectionError.
+func (s *Service) Login(email, password string, requestedTenantID *string, scope string) (*IssuedSession, error) {
+	var userID, identityID string
+	var credentialHash, tenantID *string
 	err := s.db.QueryRow(
-		"SELECT id, password_hash, tenant_id FROM users WHERE email = $1",
-		email,
-	).Scan(&userID, &passwordHash, &tenantID)
+		`SELECT u.id, u.email, u.tenant_id, i.id, i.credential_hash
+		 FROM user_identities i
+		 JOIN users u ON u.id = i.user_id
+		 WHERE i.provider = 'local' AND i.subject = $1`,
+		strings.ToLower(email),
+	).Scan(&userID, &email, &tenantID, &identityID, &credentialHash)
 
 	if err == sql.ErrNoRows {
-		return "", "", fmt.Errorf("invalid credentials")
+		return nil, fmt.Errorf("invalid credentials")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query identity: %w", err)
 	}
+
+	if credentialHash == nil {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(*credentialHash), []byte(password)); err != nil {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
+	tenantID, err = s.ResolveTenant(userID, tenantID, requestedTenantID)
 	if err != nil {
-		return "", "", fmt.Errorf("query user: %w", err)
+		return nil, err
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
-		return "", "", fmt.Errorf("invalid credentials")
+	scope, err = s.NarrowScope(userID, tenantID, scope)
+	if err != nil {
+		return nil, err
 	}
 
+	if _, err := s.db.Exec(
+		"UPDATE user_identities SET last_used_at = NOW() WHERE id = $1",
+		identityID,
+	); err != nil {
+		return nil, fmt.Errorf("update identity: %w", err)
+	}
+
+	return s.IssueSession(userID, email, tenantID, "", scope, time.Now())
+}
+
+// IssueSession creates a session row for an authenticated user and returns a
+// new access token, refresh token and browser SSO token. Every login method
+// ends here once the user's credentials have been verified; authTime records
+// when the user last proved their identity. scope must already have been
+// narrowed with NarrowScope.
+func (s *Service) IssueSession(userID, email string, tenantID *string, audience, scope string, authTime time.Time) (*IssuedSession, error) {
 	refreshToken, err := GenerateRefreshToken()
 	if err != nil {
-		return "", "", fmt.Errorf("generate refresh token: %w", err)
+		return nil, fmt.Errorf("generate refresh token: %w", err)
 	}
 
 	refreshTokenHash, err := bcrypt.GenerateFromPassword([]byte(refreshToken), bcrypt.DefaultCost)
 	if err != nil {
-		return "", "", fmt.Errorf("hash refresh token: %w", err)
+		return nil, fmt.Errorf("hash refresh token: %w", err)
 	}
 
+	ssoToken, err := GenerateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("generate sso token: %w", err)
+	}
+
+	var sessionID string
 	expiresAt := time.Now().Add(s.cfg.RefreshTokenTTL)
-	_, err = s.db.Exec(
-		`INSERT INTO sessions (user_id, refresh_token_hash, expires_at)
-		 VALUES ($1, $2, $3)`,
-		userID, string(refreshTokenHash), expiresAt,
-	)
+	err = s.db.QueryRow(
+		`INSERT INTO sessions (user_id, tenant_id, audience, scope, refresh_token_hash, sso_token_hash, auth_time, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 RETURNING id`,
+		userID, tenantID, audience, scope, string(refreshTokenHash), hashSSOToken(ssoToken), authTime, expiresAt,
+	).Scan(&sessionID)
 	if err != nil {
-		return "", "", fmt.Errorf("create session: %w", err)
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+
+	if tenantID != nil {
+		if _, err := s.db.Exec(
+			"UPDATE tenant_memberships SET last_used_at = NOW() WHERE user_id = $1 AND tenant_id = $2",
+			userID, *tenantID,
+		); err != nil {
+			return nil, fmt.Errorf("update membership: %w", err)
+		}
 	}
 
-	accessToken, err := GenerateAccessToken(s.cfg, userID, email, tenantID)
+	accessToken, err := s.generateAccessToken(userID, email, tenantID, sessionID, audience, scope, authTime)
 	if err != nil {
-		return "", "", fmt.Errorf("generate access token: %w", err)
+		return nil, fmt.Errorf("generate access token: %w", err)
 	}
 
-	return accessToken, refreshToken, nil
+	return &IssuedSession{
+		ID:           sessionID,
+		UserID:       userID,
+		TenantID:     tenantID,
+		Scope:        scope,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		SSOToken:     ssoToken,
+		ExpiresAt:    expiresAt,
+	}, nil
 }
 
-func (s *Service) Refresh(refreshToken string) (string, error) {
+// Refresh issues a new access token for the session holding refreshToken.
+// A requested scope may narrow the new token but never widen it beyond the
+// session's scope.
+func (s *Service) Refresh(refreshToken, scope string) (string, error) {
 	rows, err := s.db.Query(
-		`SELECT s.id, s.user_id, s.refresh_token_hash, u.email, u.tenant_id
+		`SELECT s.id, s.user_id, s.refresh_token_hash, s.auth_time, s.audience, s.scope, u.email, s.tenant_id
 		 FROM sessions s
 		 JOIN users u ON u.id = s.user_id
 		 WHERE s.revoked = FALSE AND s.expires_at > NOW()`,
@@ -78,9 +173,10 @@ func (s *Service) Refresh(refreshToken string) (string, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var sessionID, userID, hash, email string
+		var sessionID, userID, hash, audience, sessionScope, email string
+		var authTime time.Time
 		var tenantID *string
-		if err := rows.Scan(&sessionID, &userID, &hash, &email, &tenantID); err != nil {
+		if err := rows.Scan(&sessionID, &userID, &hash, &authTime, &audience, &sessionScope, &email, &tenantID); err != nil {
 			continue
 		}
 
@@ -93,7 +189,21 @@ func (s *Service) Refresh(refreshToken string) (string, error) {
 				return "", fmt.Errorf("update session: %w", err)
 			}
 
-			accessToken, err := GenerateAccessToken(s.cfg, userID, email, tenantID)
+			tokenScope := sessionScope
+			if scope != "" {
+				tokenScope, err = s.NarrowScope(userID, tenantID, scope)
+				if err != nil {
+					return "", err
+				}
+				if sessionScope != "" {
+					tokenScope, err = IntersectScope(tokenScope, strings.Fields(sessionScope))
+					if err != nil {
+						return "", err
+					}
+				}
+			}
+
+			accessToken, err := s.generateAccessToken(userID, email, tenantID, sessionID, audience, tokenScope, authTime)
 			if err != nil {
 				return "", fmt.Errorf("generate access token: %w", err)
 			}
@@ -105,6 +215,146 @@ func (s *Service) Refresh(refreshToken string) (string, error) {
 	return "", fmt.Errorf("invalid refresh token")
 }
 
+// SwitchTenant moves the caller's session to another tenant they belong to
+// and returns an access token for it. Later refreshes stay in the new tenant.
+func (s *Service) SwitchTenant(claims *Claims, tenantID string) (string, error) {
+	if claims.Act != nil || claims.SessionID == "" {
+		return "", fmt.Errorf("token is not bound to a login session")
+	}
+
+	member, err := s.IsMember(claims.UserID, tenantID)
+	if err != nil {
+		return "", err
+	}
+	if !member {
+		return "", ErrNotMember
+	}
+
+	var audience, scope string
+	err = s.db.QueryRow(
+		`UPDATE sessions SET tenant_id = $1, last_activity = NOW()
+		 WHERE id = $2 AND user_id = $3 AND revoked = FALSE AND expires_at > NOW()
+		 RETURNING audience, scope`,
+		tenantID, claims.SessionID, claims.UserID,
+	).Scan(&audience, &scope)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("session expired or revoked")
+	}
+	if err != nil {
+		return "", fmt.Errorf("update session: %w", err)
+	}
+
+	if _, err := s.db.Exec(
+		"UPDATE tenant_memberships SET last_used_at = NOW() WHERE user_id = $1 AND tenant_id = $2",
+		claims.UserID, tenantID,
+	); err != nil {
+		return "", fmt.Errorf("update membership: %w", err)
+	}
+
+	return s.generateAccessToken(claims.UserID, claims.Email, &tenantID, claims.SessionID, audience, scope, time.Unix(claims.AuthTime, 0))
+}
+
+func (s *Service) SetPermissionResolver(resolve PermissionResolver) {
+	s.permissions = resolve
+}
+
+func (s *Service) SetClaimsEnricher(enrich ClaimsEnricher) {
+	s.enrich = enrich
+}
+
+func (s *Service) generateAccessToken(userID, email string, tenantID *string, sessionID, audience, scope string, authTime time.Time) (string, error) {
+	claims := NewAccessClaims(s.cfg, userID, email, tenantID, sessionID, audience, scope, authTime)
+	if s.enrich != nil {
+		if err := s.enrich(claims); err != nil {
+			return "", fmt.Errorf("embed claims: %w", err)
+		}
+	}
+	return SignAccessToken(s.cfg, claims)
+}
+
+// NarrowScope intersects the permission scopes in requested with what the
+// user holds in tenantID. Requests without permission scopes, such as a
+// plain "openid", yield the empty, unrestricted scope.
+func (s *Service) NarrowScope(userID string, tenantID *string, requested string) (string, error) {
+	if !hasPermissionScope(requested) {
+		return "", nil
+	}
+	if s.permissions == nil {
+		return "", fmt.Errorf("permission scopes are not supported")
+	}
+
+	held, err := s.permissions(userID, tenantID)
+	if err != nil {
+		return "", fmt.Errorf("resolve permissions: %w", err)
+	}
+	return IntersectScope(requested, held)
+}
+
+func (s *Service) IsMember(userID, tenantID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM tenant_memberships WHERE user_id = $1 AND tenant_id = $2)`,
+		userID, tenantID,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("check membership: %w", err)
+	}
+	return exists, nil
+}
+
+// ResolveTenant picks the tenant for a new session: the requested one, the
+// only one, or the most recently used. homeTenantID is the user's
+// users.tenant_id, used when the user has no memberships at all.
+func (s *Service) ResolveTenant(userID string, homeTenantID, requested *string) (*string, error) {
+	rows, err := s.db.Query(
+		`SELECT t.id, t.name, t.slug, m.last_used_at
+		 FROM tenant_memberships m
+		 JOIN tenants t ON t.id = m.tenant_id
+		 WHERE m.user_id = $1
+		 ORDER BY m.last_used_at DESC NULLS LAST, t.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query memberships: %w", err)
+	}
+	defer rows.Close()
+
+	var choices []TenantChoice
+	var lastUsed *time.Time
+	for rows.Next() {
+		var c TenantChoice
+		var used *time.Time
+		if err := rows.Scan(&c.ID, &c.Name, &c.Slug, &used); err != nil {
+			return nil, fmt.Errorf("scan membership: %w", err)
+		}
+		if len(choices) == 0 {
+			lastUsed = used
+		}
+		choices = append(choices, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query memberships: %w", err)
+	}
+
+	if requested != nil {
+		for _, c := range choices {
+			if c.ID == *requested {
+				return &c.ID, nil
+			}
+		}
+		return nil, ErrNotMember
+	}
+
+	switch {
+	case len(choices) == 0:
+		return homeTenantID, nil
+	case len(choices) == 1 || lastUsed != nil:
+		return &choices[0].ID, nil
+	default:
+		return nil, &TenantSelectionError{Tenants: choices}
+	}
+}
+
 func (s *Service) Logout(userID string) error {
 	_, err := s.db.Exec(
 		"UPDATE sessions SET revoked = TRUE WHERE user_id = $1 AND revoked = FALSE",
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const defaultSessionCookieName = "bastion_session"
+
+// IssuedSession is the result of a successful login. SSOToken is the opaque
+// browser session credential carried in the session cookie and shared by
+// every application that signs in through Bastion.
+type IssuedSession struct {
+	ID           string
+	UserID       string
+	TenantID     *string
+	Scope        string
+	AccessToken  string
+	RefreshToken string
+	SSOToken     string
+	ExpiresAt    time.Time
+}
+
+// Session is an active login session resolved from an SSO token.
+type Session struct {
+	ID       string
+	UserID   string
+	Email    string
+	TenantID *string
+	AuthTime time.Time
+}
+
+// SessionFromRequest resolves the session cookie on r to an active session.
+func (s *Service) SessionFromRequest(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(s.sessionCookieName())
+	if err != nil || cookie.Value == "" {
+		return nil, fmt.Errorf("no session cookie")
+	}
+
+	sess := &Session{}
+	err = s.db.QueryRow(
+		`SELECT s.id, s.user_id, u.email, s.tenant_id, s.auth_time
+		 FROM sessions s
+		 JOIN users u ON u.id = s.user_id
+		 WHERE s.sso_token_hash = $1 AND s.revoked = FALSE AND s.expires_at > NOW()`,
+		hashSSOToken(cookie.Value),
+	).Scan(&sess.ID, &sess.UserID, &sess.Email, &sess.TenantID, &sess.AuthTime)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("session not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query session: %w", err)
+	}
+
+	return sess, nil
+}
+
+// SetSessionCookie stores the SSO token of a new session in an HttpOnly
+// cookie so that later /oauth/authorize requests can skip the login step.
+func (s *Service) SetSessionCookie(w http.ResponseWriter, sess *IssuedSession) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.sessionCookieName(),
+		Value:    sess.SSOToken,
+		Path:     "/",
+		Expires:  sess.ExpiresAt,
+		HttpOnly: true,
+		Secure:   s.cfg.SessionCookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Service) ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.sessionCookieName(),
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.cfg.SessionCookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Service) sessionCookieName() string {
+	if s.cfg.SessionCookieName == "" {
+		return defaultSessionCookieName
+	}
+	return s.cfg.SessionCookieName
+}
+
+func hashSSOToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,13 +1,17 @@
 package auth
 
 import (
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
 )
 
 type Claims struct {
@@ -16,29 +20,195 @@ type Claims struct {
 	IdentityType string  `json:"identity_type,omitempty"`
 	Name         string  `json:"name,omitempty"`
 	TenantID     *string `json:"tenant_id,omitempty"`
+	SessionID    string  `json:"sid,omitempty"`
+	AuthTime     int64   `json:"auth_time,omitempty"`
+	Scope        string  `json:"scope,omitempty"`
+	Act          *Actor  `json:"act,omitempty"`
+
+	// Authorization data embedded for audiences with a claims profile.
+	Roles              []string `json:"roles,omitempty"`
+	Permissions        []string `json:"perms,omitempty"`
+	PermissionsHash    string   `json:"perms_hash,omitempty"`
+	PermissionsVersion int64    `json:"perms_ver,omitempty"`
+
 	jwt.RegisteredClaims
 }
 
-func GenerateAccessToken(cfg *config.AuthConfig, userID, email string, tenantID *string) (string, error) {
+// Actor identifies the party acting on behalf of the token subject
+// (RFC 8693 §4.1). Nested actors record earlier links of a delegation chain.
+type Actor struct {
+	Subject      string `json:"sub"`
+	IdentityType string `json:"identity_type,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Act          *Actor `json:"act,omitempty"`
+}
+
+const defaultReauthWindow = 5 * time.Minute
+
+// IsFresh reports whether the user authenticated recently enough to perform
+// sensitive operations such as linking or unlinking login identities.
+func (c *Claims) IsFresh(cfg *config.AuthConfig) bool {
+	window := cfg.ReauthWindow
+	if window == 0 {
+		window = defaultReauthWindow
+	}
+	return c.AuthTime > 0 && time.Since(time.Unix(c.AuthTime, 0)) <= window
+}
+
+// Principal returns the identity the token was issued to.
+func (c *Claims) Principal() *principal.Principal {
+	identityType := c.IdentityType
+	if identityType == "" {
+		identityType = principal.TypeUser
+	}
+	return &principal.Principal{
+		Type:     identityType,
+		ID:       c.UserID,
+		TenantID: c.TenantID,
+		Scope:    c.Scope,
+	}
+}
+
+// ErrInvalidScope is returned when a requested scope names permissions but
+// the identity holds none of them.
+var ErrInvalidScope = errors.New("identity holds none of the requested permissions")
+
+// IsPermissionScope reports whether a scope value names a permission
+// ("resource_type:action") rather than an OpenID Connect scope like openid.
+func IsPermissionScope(scope string) bool {
+	i := strings.LastIndex(scope, ":")
+	return i > 0 && i < len(scope)-1
+}
+
+// IntersectScope narrows the permission scopes in requested to those in
+// held. A request that names no permissions yields the empty, unrestricted
+// scope; one whose permissions are all outside held fails.
+func IntersectScope(requested string, held []string) (string, error) {
+	holds := make(map[string]bool, len(held))
+	for _, h := range held {
+		holds[h] = true
+	}
+
+	var asked bool
+	var granted []string
+	for _, s := range strings.Fields(requested) {
+		if !IsPermissionScope(s) {
+			continue
+		}
+		asked = true
+		if holds[s] && !contains(granted, s) {
+			granted = append(granted, s)
+		}
+	}
+
+	if asked && len(granted) == 0 {
+		return "", ErrInvalidScope
+	}
+	return strings.Join(granted, " "), nil
+}
+
+func hasPermissionScope(scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if IsPermissionScope(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAudience reports whether the token was issued for audience.
+func (c *Claims) HasAudience(audience string) bool {
+	for _, aud := range c.Audience {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAccessClaims builds the claims of a user access token for audience, or
+// for the Bastion API when audience is empty. A non-empty scope limits the
+// token to those permissions.
+func NewAccessClaims(cfg *config.AuthConfig, userID, email string, tenantID *string, sessionID, audience, scope string, authTime time.Time) *Claims {
+	if audience == "" {
+		audience = Audience(cfg)
+	}
+
 	now := time.Now()
-	claims := &Claims{
+	return &Claims{
 		UserID:       userID,
 		Email:        email,
 		IdentityType: "user",
 		TenantID:     tenantID,
+		SessionID:    sessionID,
+		AuthTime:     authTime.Unix(),
+		Scope:        scope,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer(cfg),
+			Audience:  jwt.ClaimStrings{audience},
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.AccessTokenTTL)),
 		},
 	}
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString([]byte(cfg.JWTSecret))
-	if err != nil {
-		return "", fmt.Errorf("sign token: %w", err)
+func GenerateAccessToken(cfg *config.AuthConfig, userID, email string, tenantID *string, sessionID, audience, scope string, authTime time.Time) (string, error) {
+	return SignAccessToken(cfg, NewAccessClaims(cfg, userID, email, tenantID, sessionID, audience, scope, authTime))
+}
+
+// GenerateDelegatedToken issues a narrowed copy of a user token for another
+// audience, with the acting party recorded in the act claim.
+func GenerateDelegatedToken(cfg *config.AuthConfig, subject *Claims, audience, scope string, actor *Actor, expiresAt time.Time) (string, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID:       subject.UserID,
+		Email:        subject.Email,
+		IdentityType: subject.IdentityType,
+		TenantID:     subject.TenantID,
+		AuthTime:     subject.AuthTime,
+		Scope:        scope,
+		Act:          actor,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer(cfg),
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
 	}
 
-	return signed, nil
+	return SignAccessToken(cfg, claims)
+}
+
+// GenerateImpersonationToken issues a short-lived token for the target user
+// with the impersonating admin in the act claim. It carries no auth_time, so
+// operations that require a fresh login are unavailable while impersonating.
+func GenerateImpersonationToken(cfg *config.AuthConfig, userID, email string, tenantID *string, actor *Actor, sessionID string, expiresAt time.Time) (string, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID:       userID,
+		Email:        email,
+		IdentityType: "user",
+		TenantID:     tenantID,
+		Act:          actor,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
+			Issuer:    Issuer(cfg),
+			Audience:  jwt.ClaimStrings{Audience(cfg)},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	return SignAccessToken(cfg, claims)
 }
 
 func GenerateRefreshToken() (string, error) {
@@ -49,22 +219,78 @@ func GenerateRefreshToken() (string, error) {
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
 
+// ValidateAccessToken verifies an access token in any accepted format and
+// returns its claims.
 func ValidateAccessToken(cfg *config.AuthConfig, tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(cfg.JWTSecret), nil
-	})
+	format, err := verifyingFormat(cfg, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	return format.Verify(tokenString)
+}
 
+const (
+	defaultIssuer   = "bastion"
+	defaultAudience = "bastion"
+)
+
+// IDTokenClaims are the OpenID Connect claims returned to client
+// applications from the authorization code grant.
+type IDTokenClaims struct {
+	Email    string `json:"email,omitempty"`
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time"`
+	jwt.RegisteredClaims
+}
+
+func Issuer(cfg *config.AuthConfig) string {
+	if cfg.Issuer == "" {
+		return defaultIssuer
+	}
+	return cfg.Issuer
+}
+
+// Audience is the aud value of access tokens for the Bastion API itself.
+func Audience(cfg *config.AuthConfig) string {
+	if cfg.Audience == "" {
+		return defaultAudience
+	}
+	return cfg.Audience
+}
+
+// IDTokenSigningAlg is the JWS algorithm of ID tokens, advertised in the
+// OpenID Connect discovery document.
+const IDTokenSigningAlg = "EdDSA"
+
+// GenerateIDToken issues an OpenID Connect ID token. It is signed with the
+// Ed25519 key published in the JWKS, under that key's ID, so that relying
+// parties can verify it without holding any Bastion secret.
+func GenerateIDToken(cfg *config.AuthConfig, userID, email, audience, nonce string, authTime time.Time) (string, error) {
+	privateKey, err := PASETOPrivateKey(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("parse token: %w", err)
+		return "", err
+	}
+
+	now := time.Now()
+	claims := &IDTokenClaims{
+		Email:    email,
+		Nonce:    nonce,
+		AuthTime: authTime.Unix(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer(cfg),
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.AccessTokenTTL)),
+		},
 	}
 
-	claims, ok := token.Claims.(*Claims)
-	if !ok || !token.Valid {
-		return nil, fmt.Errorf("invalid token")
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = keyID(privateKey.Public().(ed25519.PublicKey))
+	signed, err := token.SignedString(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("sign id token: %w", err)
 	}
 
-	return claims, nil
+	return signed, nil
 }
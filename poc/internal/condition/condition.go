@@ -0,0 +1,361 @@
+// Package condition evaluates the conditions that can be attached to a
+// permission grant (DD-001 §3.3): time windows, IP ranges, attribute
+// matching and policy expressions. A grant with conditions only applies
+// when all of them hold.
+package condition
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustybrownlee-llm/bastion/poc/internal/expr"
+)
+
+// Conditions of a grant. Any one of the time windows and any one of the IP
+// ranges must match, every attribute match must hold and the expression,
+// written in the language of package expr, must evaluate to true.
+type Conditions struct {
+	TimeWindows []TimeWindow     `json:"time_windows,omitempty"`
+	IPRanges    []string         `json:"ip_ranges,omitempty"`
+	Attributes  []AttributeMatch `json:"attributes,omitempty"`
+	Expression  string           `json:"expression,omitempty"`
+}
+
+// TimeWindow is open during the minutes matched by Schedule, a five-field
+// cron expression ("* 9-16 * * 1-5" for weekdays 9:00 to 16:59), in the
+// IANA time zone Timezone, UTC if empty.
+type TimeWindow struct {
+	Schedule string `json:"schedule"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// AttributeMatch compares a resource or principal attribute, named
+// "resource.{key}" or "principal.{key}", with Value or with the attribute
+// named by Ref. An attribute that is not set never matches.
+type AttributeMatch struct {
+	Attribute string      `json:"attribute"`
+	Operator  string      `json:"operator"`
+	Value     interface{} `json:"value,omitempty"`
+	Ref       string      `json:"ref,omitempty"`
+}
+
+// Attribute match operators.
+const (
+	OpEqual    = "eq"
+	OpNotEqual = "ne"
+)
+
+var ErrInvalid = errors.New("invalid conditions")
+
+// Parse decodes conditions stored as JSON. Absent or empty conditions are
+// returned as nil.
+func Parse(data []byte) (*Conditions, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var c Conditions
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("decode conditions: %w", err)
+	}
+	if c.Empty() {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+func (c *Conditions) Empty() bool {
+	return c == nil || (len(c.TimeWindows) == 0 && len(c.IPRanges) == 0 && len(c.Attributes) == 0 && c.Expression == "")
+}
+
+// Value stores conditions as JSON, and empty conditions as NULL.
+func (c *Conditions) Value() (driver.Value, error) {
+	if c.Empty() {
+		return nil, nil
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (c *Conditions) String() string {
+	if c.Empty() {
+		return ""
+	}
+	data, _ := json.Marshal(c)
+	return string(data)
+}
+
+func (c *Conditions) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for _, w := range c.TimeWindows {
+		if _, err := parseSchedule(w.Schedule); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if _, err := time.LoadLocation(w.Timezone); err != nil {
+			return fmt.Errorf("%w: unknown time zone %q", ErrInvalid, w.Timezone)
+		}
+	}
+	for _, r := range c.IPRanges {
+		if _, err := netip.ParsePrefix(r); err != nil {
+			return fmt.Errorf("%w: %q is not a CIDR range", ErrInvalid, r)
+		}
+	}
+	for _, a := range c.Attributes {
+		if _, _, ok := splitAttribute(a.Attribute); !ok {
+			return fmt.Errorf("%w: attribute %q must be resource.{key} or principal.{key}", ErrInvalid, a.Attribute)
+		}
+		if a.Operator != OpEqual && a.Operator != OpNotEqual {
+			return fmt.Errorf("%w: operator of %s must be eq or ne", ErrInvalid, a.Attribute)
+		}
+		if a.Ref != "" {
+			if _, _, ok := splitAttribute(a.Ref); !ok {
+				return fmt.Errorf("%w: ref %q must be resource.{key} or principal.{key}", ErrInvalid, a.Ref)
+			}
+			if a.Value != nil {
+				return fmt.Errorf("%w: %s compares with either a value or a ref", ErrInvalid, a.Attribute)
+			}
+		}
+	}
+	if c.Expression != "" {
+		if _, err := compile(c.Expression); err != nil {
+			return fmt.Errorf("%w: expression: %v", ErrInvalid, err)
+		}
+	}
+	return nil
+}
+
+// programs caches compiled expressions by source, as grants are loaded
+// again for every check.
+var programs sync.Map
+
+func compile(source string) (*expr.Program, error) {
+	if p, ok := programs.Load(source); ok {
+		return p.(*expr.Program), nil
+	}
+	p, err := expr.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	programs.Store(source, p)
+	return p, nil
+}
+
+// Env is the request conditions are evaluated against. Principal
+// attributes and the tenant are only loaded when a condition refers to
+// them.
+type Env struct {
+	Time      time.Time
+	IP        string
+	Resource  map[string]interface{}
+	Principal func() (map[string]interface{}, error)
+
+	// The identity and resource checked and the tenant of the check, for
+	// expressions.
+	PrincipalType string
+	PrincipalID   string
+	TenantID      *string
+	ResourceType  string
+	ResourceID    string
+	Tenant        func() (map[string]interface{}, error)
+}
+
+// Evaluate returns "" when the conditions hold, and otherwise a
+// description of the first one that does not.
+func (c *Conditions) Evaluate(env Env) (string, error) {
+	if c.Empty() {
+		return "", nil
+	}
+	if failure := c.evaluateTime(env.Time); failure != "" {
+		return failure, nil
+	}
+	if failure := c.evaluateIP(env.IP); failure != "" {
+		return failure, nil
+	}
+
+	var principal map[string]interface{}
+	lookupPrincipal := func() (map[string]interface{}, error) {
+		if principal == nil && env.Principal != nil {
+			var err error
+			if principal, err = env.Principal(); err != nil {
+				return nil, err
+			}
+		}
+		if principal == nil {
+			principal = map[string]interface{}{}
+		}
+		return principal, nil
+	}
+	lookup := func(name string) (interface{}, bool, error) {
+		source, key, _ := splitAttribute(name)
+		attrs := env.Resource
+		if source == "principal" {
+			var err error
+			if attrs, err = lookupPrincipal(); err != nil {
+				return nil, false, err
+			}
+		}
+		v, ok := attrs[key]
+		return v, ok, nil
+	}
+
+	for _, a := range c.Attributes {
+		actual, ok, err := lookup(a.Attribute)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return fmt.Sprintf("%s is not set", a.Attribute), nil
+		}
+
+		expected, what := a.Value, jsonString(a.Value)
+		if a.Ref != "" {
+			if expected, ok, err = lookup(a.Ref); err != nil {
+				return "", err
+			}
+			if !ok {
+				return fmt.Sprintf("%s is not set", a.Ref), nil
+			}
+			what = fmt.Sprintf("%s %s", a.Ref, jsonString(expected))
+		}
+
+		equal := reflect.DeepEqual(actual, expected)
+		if a.Operator == OpEqual && !equal {
+			return fmt.Sprintf("%s is %s, not %s", a.Attribute, jsonString(actual), what), nil
+		}
+		if a.Operator == OpNotEqual && equal {
+			return fmt.Sprintf("%s must not be %s", a.Attribute, what), nil
+		}
+	}
+
+	if c.Expression != "" {
+		return evaluateExpression(c.Expression, env, lookupPrincipal)
+	}
+	return "", nil
+}
+
+func evaluateExpression(source string, env Env, principal func() (map[string]interface{}, error)) (string, error) {
+	program, err := compile(source)
+	if err != nil {
+		return fmt.Sprintf("expression %q is invalid: %v", source, err), nil
+	}
+
+	ok, err := program.Eval(func(name string) (map[string]interface{}, error) {
+		switch name {
+		case "principal":
+			attrs, err := principal()
+			if err != nil {
+				return nil, err
+			}
+			var tenantID interface{}
+			if env.TenantID != nil {
+				tenantID = *env.TenantID
+			}
+			return map[string]interface{}{
+				"type":       env.PrincipalType,
+				"id":         env.PrincipalID,
+				"tenant_id":  tenantID,
+				"attributes": attrs,
+			}, nil
+		case "resource":
+			attrs := env.Resource
+			if attrs == nil {
+				attrs = map[string]interface{}{}
+			}
+			return map[string]interface{}{
+				"type":       env.ResourceType,
+				"id":         env.ResourceID,
+				"attributes": attrs,
+			}, nil
+		case "request":
+			return map[string]interface{}{"ip": env.IP, "time": env.Time}, nil
+		case "tenant":
+			if env.TenantID == nil || env.Tenant == nil {
+				return nil, nil
+			}
+			return env.Tenant()
+		}
+		return nil, nil
+	})
+
+	var exprErr *expr.Error
+	switch {
+	case errors.As(err, &exprErr):
+		return fmt.Sprintf("expression %q failed: %v", source, err), nil
+	case err != nil:
+		return "", err
+	case !ok:
+		return fmt.Sprintf("expression %q is false", source), nil
+	}
+	return "", nil
+}
+
+func (c *Conditions) evaluateTime(t time.Time) string {
+	if len(c.TimeWindows) == 0 {
+		return ""
+	}
+
+	var windows []string
+	for _, w := range c.TimeWindows {
+		s, err := parseSchedule(w.Schedule)
+		if err != nil {
+			continue
+		}
+		loc, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			continue
+		}
+		if s.matches(t.In(loc)) {
+			return ""
+		}
+		windows = append(windows, fmt.Sprintf("%q (%s)", w.Schedule, loc))
+	}
+	return fmt.Sprintf("request time %s is outside time window %s", t.UTC().Format(time.RFC3339), strings.Join(windows, " or "))
+}
+
+func (c *Conditions) evaluateIP(ip string) string {
+	if len(c.IPRanges) == 0 {
+		return ""
+	}
+	if ip == "" {
+		return "request IP is unknown"
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return fmt.Sprintf("request IP %q is not an IP address", ip)
+	}
+
+	addr = addr.Unmap()
+	for _, r := range c.IPRanges {
+		if prefix, err := netip.ParsePrefix(r); err == nil && prefix.Contains(addr) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("request IP %s is not in %s", addr, strings.Join(c.IPRanges, ", "))
+}
+
+func splitAttribute(name string) (source, key string, ok bool) {
+	source, key, found := strings.Cut(name, ".")
+	if !found || key == "" || (source != "resource" && source != "principal") {
+		return "", "", false
+	}
+	return source, key, true
+}
+
+func jsonString(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
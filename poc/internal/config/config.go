@@ -1,6 +1,8 @@
 package config
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"fmt"
 	"os"
 	"time"
@@ -9,9 +11,12 @@ import (
 )
 
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Database DatabaseConfig `yaml:"database"`
-	Auth     AuthConfig     `yaml:"auth"`
+	Server       ServerConfig       `yaml:"server"`
+	Database     DatabaseConfig     `yaml:"database"`
+	Auth         AuthConfig         `yaml:"auth"`
+	Mail         MailConfig         `yaml:"mail"`
+	Passwordless PasswordlessConfig `yaml:"passwordless"`
+	OAuth        OAuthConfig        `yaml:"oauth"`
 }
 
 type ServerConfig struct {
@@ -28,9 +33,51 @@ type DatabaseConfig struct {
 }
 
 type AuthConfig struct {
-	AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`
-	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
-	JWTSecret       string        `yaml:"jwt_secret"`
+	AccessTokenTTL      time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL     time.Duration `yaml:"refresh_token_ttl"`
+	JWTSecret           string        `yaml:"jwt_secret"`
+	ReauthWindow        time.Duration `yaml:"reauth_window"`
+	Issuer              string        `yaml:"issuer"`
+	Audience            string        `yaml:"audience"`
+	TokenFormat         string        `yaml:"token_format"`
+	AcceptTokenFormats  []string      `yaml:"accept_token_formats"`
+	PASETOPrivateKey    string        `yaml:"paseto_private_key"`
+	SessionCookieName   string        `yaml:"session_cookie_name"`
+	SessionCookieSecure bool          `yaml:"session_cookie_secure"`
+	ImpersonationTTL    time.Duration `yaml:"impersonation_ttl"`
+
+	// Public keys of rotated-out PASETO signing keys, still published and
+	// accepted until tokens signed with them have expired.
+	PASETOPreviousPublicKeys []string `yaml:"paseto_previous_public_keys"`
+
+	// Decoded by Load from PASETOPrivateKey and PASETOPreviousPublicKeys.
+	// The signing key signs PASETO access tokens and ID tokens; it is
+	// configured separately from JWTSecret so that leaking one does not
+	// allow forging tokens of the other kind.
+	SigningKey         ed25519.PrivateKey  `yaml:"-"`
+	PreviousPublicKeys []ed25519.PublicKey `yaml:"-"`
+}
+
+type MailConfig struct {
+	Transport string `yaml:"transport"`
+	From      string `yaml:"from"`
+	OutboxDir string `yaml:"outbox_dir"`
+}
+
+type PasswordlessConfig struct {
+	LinkBaseURL string        `yaml:"link_base_url"`
+	TTL         time.Duration `yaml:"ttl"`
+	MaxAttempts int           `yaml:"max_attempts"`
+	RateLimit   int           `yaml:"rate_limit"`
+	RateWindow  time.Duration `yaml:"rate_window"`
+}
+
+type OAuthConfig struct {
+	LoginURL              string        `yaml:"login_url"`
+	CodeTTL               time.Duration `yaml:"code_ttl"`
+	DeviceVerificationURL string        `yaml:"device_verification_url"`
+	DeviceCodeTTL         time.Duration `yaml:"device_code_ttl"`
+	DevicePollInterval    time.Duration `yaml:"device_poll_interval"`
 }
 
 func Load(path string) (*Config, error) {
@@ -44,5 +91,38 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("parse config: %w", err)
 	}
 
+	if err := cfg.Auth.loadSigningKeys(); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
+
+// placeholderSigningKey is the paseto_private_key shipped in config.yaml.
+const placeholderSigningKey = "change-me-in-production"
+
+// loadSigningKeys decodes auth.paseto_private_key, a base64 Ed25519 seed,
+// and the base64 public keys in auth.paseto_previous_public_keys.
+func (c *AuthConfig) loadSigningKeys() error {
+	if c.PASETOPrivateKey == "" || c.PASETOPrivateKey == placeholderSigningKey {
+		return fmt.Errorf("auth.paseto_private_key must be set to a generated seed (openssl rand -base64 32)")
+	}
+	seed, err := base64.StdEncoding.DecodeString(c.PASETOPrivateKey)
+	if err != nil {
+		return fmt.Errorf("decode auth.paseto_private_key: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return fmt.Errorf("auth.paseto_private_key must be a %d-byte seed", ed25519.SeedSize)
+	}
+	c.SigningKey = ed25519.NewKeyFromSeed(seed)
+
+	c.PreviousPublicKeys = nil
+	for _, encoded := range c.PASETOPreviousPublicKeys {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid key in auth.paseto_previous_public_keys")
+		}
+		c.PreviousPublicKeys = append(c.PreviousPublicKeys, ed25519.PublicKey(raw))
+	}
+	return nil
+}
@@ -8,8 +8,9 @@ import (
 	_ "github.com/lib/pq"
 )
 
-func Connect(cfg *config.DatabaseConfig) (*sql.DB, error) {
-	dsn := fmt.Sprintf(
+// DSN returns the lib/pq connection string for cfg.
+func DSN(cfg *config.DatabaseConfig) string {
+	return fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		cfg.Host,
 		cfg.Port,
@@ -18,8 +19,10 @@ func Connect(cfg *config.DatabaseConfig) (*sql.DB, error) {
 		cfg.Name,
 		cfg.SSLMode,
 	)
+}
 
-	db, err := sql.Open("postgres", dsn)
+func Connect(cfg *config.DatabaseConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", DSN(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("open database: %w", err)
 	}
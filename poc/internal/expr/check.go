@@ -0,0 +1,316 @@
+package expr
+
+import "fmt"
+
+type Kind int
+
+const (
+	KindDyn Kind = iota
+	KindNull
+	KindBool
+	KindInt
+	KindDouble
+	KindString
+	KindTimestamp
+	KindDuration
+	KindList
+	KindMap
+	KindObject
+)
+
+var kindNames = map[Kind]string{
+	KindDyn:       "dyn",
+	KindNull:      "null",
+	KindBool:      "bool",
+	KindInt:       "int",
+	KindDouble:    "double",
+	KindString:    "string",
+	KindTimestamp: "timestamp",
+	KindDuration:  "duration",
+	KindList:      "list",
+	KindMap:       "map",
+	KindObject:    "object",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Type is the static type of an expression. Objects have a fixed set of
+// fields; attribute maps and values read from them are dyn, checked when
+// the expression is evaluated.
+type Type struct {
+	Kind   Kind
+	Name   string
+	Fields map[string]*Type
+}
+
+func (t *Type) String() string {
+	if t.Kind == KindObject {
+		return t.Name
+	}
+	return t.Kind.String()
+}
+
+var (
+	typeDyn       = &Type{Kind: KindDyn}
+	typeNull      = &Type{Kind: KindNull}
+	typeBool      = &Type{Kind: KindBool}
+	typeInt       = &Type{Kind: KindInt}
+	typeDouble    = &Type{Kind: KindDouble}
+	typeString    = &Type{Kind: KindString}
+	typeTimestamp = &Type{Kind: KindTimestamp}
+	typeDuration  = &Type{Kind: KindDuration}
+	typeList      = &Type{Kind: KindList}
+	typeMap       = &Type{Kind: KindMap}
+)
+
+// Declarations of the objects expressions are evaluated against. Fields
+// that may be unset, like principal.tenant_id, are dyn.
+var declarations = map[string]*Type{
+	"principal": {Kind: KindObject, Name: "principal", Fields: map[string]*Type{
+		"type":       typeString,
+		"id":         typeString,
+		"tenant_id":  typeDyn,
+		"attributes": typeMap,
+	}},
+	"resource": {Kind: KindObject, Name: "resource", Fields: map[string]*Type{
+		"type":       typeString,
+		"id":         typeString,
+		"attributes": typeMap,
+	}},
+	"request": {Kind: KindObject, Name: "request", Fields: map[string]*Type{
+		"ip":   typeString,
+		"time": typeTimestamp,
+	}},
+	"tenant": {Kind: KindObject, Name: "tenant", Fields: map[string]*Type{
+		"id":       typeString,
+		"slug":     typeString,
+		"name":     typeString,
+		"settings": typeMap,
+	}},
+}
+
+type checker struct {
+	errs Errors
+}
+
+func (c *checker) errorf(pos Pos, format string, args ...interface{}) *Type {
+	c.errs = append(c.errs, errorf(pos, format, args...))
+	return typeDyn
+}
+
+// check returns the type of n, recording the errors found in it. Nodes
+// with errors are typed dyn so that checking carries on.
+func (c *checker) check(n node) *Type {
+	switch n := n.(type) {
+	case *literalNode:
+		return typeOfLiteral(n.value)
+
+	case *identNode:
+		t, ok := declarations[n.name]
+		if !ok {
+			return c.errorf(n.at, "undeclared reference to %q", n.name)
+		}
+		return t
+
+	case *selectNode:
+		operand := c.check(n.operand)
+		var t *Type
+		switch operand.Kind {
+		case KindObject:
+			field, ok := operand.Fields[n.field]
+			if !ok {
+				return c.errorf(n.at, "%s has no field %q", operand, n.field)
+			}
+			t = field
+		case KindMap, KindDyn:
+			t = typeDyn
+		default:
+			return c.errorf(n.at, "%s does not support field selection", operand)
+		}
+		if n.test {
+			return typeBool
+		}
+		return t
+
+	case *indexNode:
+		operand := c.check(n.operand)
+		index := c.check(n.index)
+		switch operand.Kind {
+		case KindList:
+			if index.Kind != KindInt && index.Kind != KindDyn {
+				return c.errorf(n.index.pos(), "list index must be int, not %s", index)
+			}
+		case KindMap, KindObject:
+			if index.Kind != KindString && index.Kind != KindDyn {
+				return c.errorf(n.index.pos(), "map key must be string, not %s", index)
+			}
+		case KindDyn:
+		default:
+			return c.errorf(n.at, "%s does not support indexing", operand)
+		}
+		if operand.Kind == KindObject {
+			if lit, ok := n.index.(*literalNode); ok {
+				if field, ok := operand.Fields[lit.value.(string)]; ok {
+					return field
+				}
+				return c.errorf(n.index.pos(), "%s has no field %q", operand, lit.value)
+			}
+		}
+		return typeDyn
+
+	case *listNode:
+		for _, elem := range n.elems {
+			c.check(elem)
+		}
+		return typeList
+
+	case *logicalNode:
+		for _, operand := range []node{n.left, n.right} {
+			if t := c.check(operand); t.Kind != KindBool && t.Kind != KindDyn {
+				c.errorf(operand.pos(), "operand of %s must be bool, not %s", n.op, t)
+			}
+		}
+		return typeBool
+
+	case *condNode:
+		if t := c.check(n.cond); t.Kind != KindBool && t.Kind != KindDyn {
+			c.errorf(n.cond.pos(), "condition must be bool, not %s", t)
+		}
+		then, els := c.check(n.then), c.check(n.els)
+		if then.Kind == els.Kind && then.Kind != KindObject {
+			return then
+		}
+		return typeDyn
+
+	case *callNode:
+		return c.checkCall(n)
+	}
+	panic(fmt.Sprintf("expr: unexpected node %T", n))
+}
+
+func (c *checker) checkCall(n *callNode) *Type {
+	args := make([]*Type, 0, len(n.args)+1)
+	if n.target != nil {
+		args = append(args, c.check(n.target))
+	}
+	for _, arg := range n.args {
+		args = append(args, c.check(arg))
+	}
+
+	candidates, ok := functions[n.name]
+	if !ok {
+		return c.errorf(n.at, "undeclared function %q", displayName(n.name))
+	}
+
+	var result *Type
+	for _, o := range candidates {
+		if o.member != (n.target != nil) || !o.accepts(args) {
+			continue
+		}
+		n.overloads = append(n.overloads, o)
+		if result == nil {
+			result = o.result
+		} else if result.Kind != o.result.Kind {
+			result = typeDyn
+		}
+	}
+	if result == nil {
+		return c.errorf(n.at, "no matching overload for %s applied to %s", displayName(n.name), signature(n.target != nil, args))
+	}
+	if (n.name == "_==_" || n.name == "_!=_") && !equatable(args[0], args[1]) {
+		return c.errorf(n.at, "cannot compare %s with %s", args[0], args[1])
+	}
+
+	// Arguments known at compile time, such as CIDR ranges and time zones,
+	// are validated now rather than on every evaluation.
+	offset := 0
+	if n.target != nil {
+		offset = 1
+	}
+	for _, o := range n.overloads {
+		if o.validate == nil {
+			continue
+		}
+		for i, arg := range n.args {
+			if lit, ok := arg.(*literalNode); ok {
+				if err := o.validate(i+offset, lit.value); err != nil {
+					c.errorf(arg.pos(), "%s", err)
+				}
+			}
+		}
+		break
+	}
+
+	return result
+}
+
+func typeOfLiteral(v interface{}) *Type {
+	switch v.(type) {
+	case nil:
+		return typeNull
+	case bool:
+		return typeBool
+	case int64:
+		return typeInt
+	case float64:
+		return typeDouble
+	case string:
+		return typeString
+	}
+	return typeDyn
+}
+
+func displayName(name string) string {
+	switch {
+	case name == "@in":
+		return "in"
+	case len(name) > 2 && name[0] == '_' && name[len(name)-1] == '_':
+		return name[1 : len(name)-1]
+	case len(name) > 1 && name[len(name)-1] == '_':
+		return name[:len(name)-1]
+	}
+	return name
+}
+
+// signature describes the argument types of a call, CEL style: a member
+// call s.f(x) as "string.(int)".
+func signature(member bool, args []*Type) string {
+	s := ""
+	if member {
+		s = args[0].String() + "."
+		args = args[1:]
+	}
+	s += "("
+	for i, t := range args {
+		if i > 0 {
+			s += ", "
+		}
+		s += t.String()
+	}
+	return s + ")"
+}
+
+// equatable reports whether values of types a and b can be equal. Dyn and
+// null compare with anything, objects with maps and ints with doubles.
+func equatable(a, b *Type) bool {
+	ka, kb := a.Kind, b.Kind
+	if ka == KindObject {
+		ka = KindMap
+	}
+	if kb == KindObject {
+		kb = KindMap
+	}
+	switch {
+	case ka == KindDyn || kb == KindDyn || ka == KindNull || kb == KindNull:
+		return true
+	case numeric(ka) && numeric(kb):
+		return true
+	}
+	return ka == kb
+}
+
+func numeric(k Kind) bool {
+	return k == KindInt || k == KindDouble
+}
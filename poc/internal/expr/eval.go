@@ -0,0 +1,262 @@
+package expr
+
+import (
+	"errors"
+	"time"
+)
+
+type evaluator struct {
+	activation Activation
+	objects    map[string]map[string]interface{}
+	cost       int
+}
+
+func (e *evaluator) charge(pos Pos, cost int) error {
+	e.cost += cost
+	if e.cost > MaxCost {
+		return &Error{Pos: pos, Message: ErrCostExceeded.Error(), err: ErrCostExceeded}
+	}
+	return nil
+}
+
+func (e *evaluator) eval(n node) (interface{}, error) {
+	if err := e.charge(n.pos(), 1); err != nil {
+		return nil, err
+	}
+
+	switch n := n.(type) {
+	case *literalNode:
+		return n.value, nil
+
+	case *identNode:
+		obj, ok := e.objects[n.name]
+		if !ok {
+			var err error
+			if obj, err = e.activation(n.name); err != nil {
+				return nil, err
+			}
+			e.objects[n.name] = obj
+		}
+		if obj == nil {
+			return nil, nil
+		}
+		return obj, nil
+
+	case *selectNode:
+		operand, err := e.eval(n.operand)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := operand.(map[string]interface{})
+		if !ok {
+			return nil, errorf(n.at, "cannot select field %q from %s", n.field, kindOf(operand))
+		}
+		v, found := m[n.field]
+		if n.test {
+			return found && v != nil, nil
+		}
+		if !found {
+			return nil, errorf(n.at, "no such key %q", n.field)
+		}
+		return normalize(v), nil
+
+	case *indexNode:
+		operand, err := e.eval(n.operand)
+		if err != nil {
+			return nil, err
+		}
+		index, err := e.eval(n.index)
+		if err != nil {
+			return nil, err
+		}
+		switch o := operand.(type) {
+		case []interface{}:
+			i, ok := index.(int64)
+			if !ok {
+				return nil, errorf(n.index.pos(), "list index must be int, not %s", kindOf(index))
+			}
+			if i < 0 || i >= int64(len(o)) {
+				return nil, errorf(n.index.pos(), "index %d out of range for list of size %d", i, len(o))
+			}
+			return normalize(o[i]), nil
+		case map[string]interface{}:
+			key, ok := index.(string)
+			if !ok {
+				return nil, errorf(n.index.pos(), "map key must be string, not %s", kindOf(index))
+			}
+			v, found := o[key]
+			if !found {
+				return nil, errorf(n.index.pos(), "no such key %q", key)
+			}
+			return normalize(v), nil
+		}
+		return nil, errorf(n.at, "cannot index %s", kindOf(operand))
+
+	case *listNode:
+		list := make([]interface{}, len(n.elems))
+		for i, elem := range n.elems {
+			v, err := e.eval(elem)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return list, nil
+
+	case *logicalNode:
+		return e.logical(n)
+
+	case *condNode:
+		cond, err := e.eval(n.cond)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := cond.(bool)
+		if !ok {
+			return nil, errorf(n.cond.pos(), "condition must be bool, not %s", kindOf(cond))
+		}
+		if b {
+			return e.eval(n.then)
+		}
+		return e.eval(n.els)
+
+	case *callNode:
+		return e.call(n)
+	}
+	return nil, errorf(n.pos(), "unsupported expression")
+}
+
+// logical evaluates && and || as CEL does: the result is decided by either
+// operand, so an error in one is ignored when the other decides it.
+func (e *evaluator) logical(n *logicalNode) (interface{}, error) {
+	decisive := n.op == "||"
+
+	left, leftErr := e.operand(n.left, n.op)
+	if leftErr == nil && left == decisive {
+		return decisive, nil
+	}
+	if isCostExceeded(leftErr) {
+		return nil, leftErr
+	}
+
+	right, rightErr := e.operand(n.right, n.op)
+	if rightErr == nil && right == decisive {
+		return decisive, nil
+	}
+	if leftErr != nil {
+		return nil, leftErr
+	}
+	if rightErr != nil {
+		return nil, rightErr
+	}
+	return !decisive, nil
+}
+
+func (e *evaluator) operand(n node, op string) (bool, error) {
+	v, err := e.eval(n)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, errorf(n.pos(), "operand of %s must be bool, not %s", op, kindOf(v))
+	}
+	return b, nil
+}
+
+func isCostExceeded(err error) bool {
+	return errors.Is(err, ErrCostExceeded)
+}
+
+func (e *evaluator) call(n *callNode) (interface{}, error) {
+	args := make([]interface{}, 0, len(n.args)+1)
+	if n.target != nil {
+		v, err := e.eval(n.target)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	for _, arg := range n.args {
+		v, err := e.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	for _, o := range n.overloads {
+		if !o.applies(args) {
+			continue
+		}
+		if o.cost != nil {
+			if err := e.charge(n.at, o.cost(args)); err != nil {
+				return nil, err
+			}
+		}
+		v, err := o.impl(args)
+		if err != nil {
+			var argErr *errArgument
+			if errors.As(err, &argErr) {
+				return nil, errorf(n.at, "%s: %s", displayName(n.name), argErr.msg)
+			}
+			return nil, err
+		}
+		return v, nil
+	}
+
+	kinds := make([]*Type, len(args))
+	for i, arg := range args {
+		kinds[i] = &Type{Kind: kindOf(arg)}
+	}
+	return nil, errorf(n.at, "no matching overload for %s applied to %s", displayName(n.name), signature(n.target != nil, kinds))
+}
+
+func kindOf(v interface{}) Kind {
+	switch v.(type) {
+	case nil:
+		return KindNull
+	case bool:
+		return KindBool
+	case int64:
+		return KindInt
+	case float64:
+		return KindDouble
+	case string:
+		return KindString
+	case time.Time:
+		return KindTimestamp
+	case time.Duration:
+		return KindDuration
+	case []interface{}:
+		return KindList
+	case map[string]interface{}:
+		return KindMap
+	}
+	return KindDyn
+}
+
+// normalize converts values supplied by activations to the types the
+// evaluator works with.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case *string:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case []string:
+		list := make([]interface{}, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		return list
+	}
+	return v
+}
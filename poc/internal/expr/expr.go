@@ -0,0 +1,134 @@
+// Package expr implements the policy expression language of conditional
+// grants: a small, side-effect-free language with CEL-like syntax.
+//
+// Expressions are evaluated against four objects:
+//
+//	principal  type, id, tenant_id and attributes of the identity checked
+//	resource   type, id and attributes of the resource
+//	request    ip and time of the request
+//	tenant     id, slug, name and settings of the tenant, or null
+//
+// and support literals (ints, doubles, strings, true, false, null and
+// lists), comparisons, &&, || and !, arithmetic, "in" for lists and maps,
+// the conditional operator and the functions in functions.go, such as
+// startsWith, matches, inCidr and getHours. An expression is compiled and
+// type-checked once, when it is saved, and each evaluation is bounded by
+// MaxCost.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxLength bounds the source of an expression, in bytes.
+	MaxLength = 4096
+	// MaxDepth bounds the nesting of an expression.
+	MaxDepth = 32
+	// MaxCost bounds the work of one evaluation: every operation costs
+	// one, and operations on strings and lists cost their size.
+	MaxCost = 10000
+)
+
+// Pos is a position in an expression's source. Lines and columns start
+// at 1.
+type Pos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Error is a compile or evaluation error at a position of the source.
+type Error struct {
+	Pos     Pos
+	Message string
+	err     error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Pos.Line, e.Pos.Column, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+func errorf(pos Pos, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// Errors lists the errors found compiling an expression.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+var ErrCostExceeded = errors.New("expression exceeded its evaluation cost limit")
+
+// Program is a compiled expression.
+type Program struct {
+	source string
+	root   node
+}
+
+// Compile parses and type-checks an expression. It must evaluate to a
+// bool. Invalid expressions are reported as Errors.
+func Compile(source string) (*Program, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, Errors{errorf(Pos{Line: 1, Column: 1}, "empty expression")}
+	}
+	if len(source) > MaxLength {
+		return nil, Errors{errorf(Pos{Line: 1, Column: 1}, "expression longer than %d bytes", MaxLength)}
+	}
+
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, Errors{err.(*Error)}
+	}
+	root, err := parse(tokens)
+	if err != nil {
+		return nil, Errors{err.(*Error)}
+	}
+
+	c := &checker{}
+	t := c.check(root)
+	if len(c.errs) == 0 && t.Kind != KindBool && t.Kind != KindDyn {
+		c.errorf(root.pos(), "expression must evaluate to bool, not %s", t)
+	}
+	if len(c.errs) > 0 {
+		return nil, c.errs
+	}
+
+	return &Program{source: source, root: root}, nil
+}
+
+func (p *Program) String() string {
+	return p.source
+}
+
+// Activation supplies the value of the objects an expression refers to,
+// as maps of their fields, or nil. It is called at most once per object
+// and evaluation, and only for objects the evaluation needs.
+type Activation func(name string) (map[string]interface{}, error)
+
+// Eval evaluates the program. Errors in the expression, such as a missing
+// attribute, are returned as *Error, and errors of the activation as they
+// are.
+func (p *Program) Eval(activation Activation) (bool, error) {
+	e := &evaluator{activation: activation, objects: make(map[string]map[string]interface{})}
+	v, err := e.eval(p.root)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, errorf(p.root.pos(), "expression evaluated to %s, not bool", kindOf(v))
+	}
+	return b, nil
+}
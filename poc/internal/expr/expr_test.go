@@ -0,0 +1,328 @@
+package expr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testObjects returns the objects expressions are evaluated against in
+// these tests: a platform principal, a document and a request at
+// 2024-03-15T14:30:45Z, a Friday, with no tenant.
+func testObjects() map[string]map[string]interface{} {
+	big := make([]interface{}, 2*MaxCost)
+	for i := range big {
+		big[i] = "x"
+	}
+	return map[string]map[string]interface{}{
+		"principal": {
+			"type":      "user",
+			"id":        "u1",
+			"tenant_id": (*string)(nil),
+			"attributes": map[string]interface{}{
+				"department": "eng",
+				"level":      3.0,
+				"groups":     []interface{}{"admins", "dev"},
+				"manager":    nil,
+			},
+		},
+		"resource": {
+			"type": "document",
+			"id":   "d1",
+			"attributes": map[string]interface{}{
+				"owner":   "u1",
+				"tags":    []string{"a", "b"},
+				"size":    10,
+				"pattern": "[",
+				"ip":      "nope",
+				"when":    "soon",
+				"blob":    strings.Repeat("x", 2*MaxCost),
+				"big":     big,
+			},
+		},
+		"request": {
+			"ip":   "10.1.2.3",
+			"time": time.Date(2024, time.March, 15, 14, 30, 45, 0, time.UTC),
+		},
+		"tenant": nil,
+	}
+}
+
+func testActivation(objects map[string]map[string]interface{}) Activation {
+	return func(name string) (map[string]interface{}, error) {
+		return objects[name], nil
+	}
+}
+
+func evalSource(t *testing.T, source string) (bool, error) {
+	t.Helper()
+	p, err := Compile(source)
+	if err != nil {
+		t.Fatalf("Compile(%q): %v", source, err)
+	}
+	return p.Eval(testActivation(testObjects()))
+}
+
+func TestCompile(t *testing.T) {
+	tests := []string{
+		`true`,
+		`principal.id == resource.attributes.owner`,
+		"principal.type == \"user\" &&\n  resource.type == 'document' // owner check",
+		`"admins" in principal.attributes.groups ? true : principal.id == "u1"`,
+		`resource.attributes["owner"].startsWith("u")`,
+		`principal["id"] == "u1"`,
+		`true` + strings.Repeat(" ", MaxLength-len("true")),
+	}
+	for _, source := range tests {
+		if _, err := Compile(source); err != nil {
+			t.Errorf("Compile(%q): %v", source, err)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		// Parse errors.
+		{"empty", "", "empty expression"},
+		{"blank", "  \n ", "empty expression"},
+		{"too long", strings.Repeat("a", MaxLength+1), "longer than 4096 bytes"},
+		{"unterminated string", `principal.id == "u1`, "unterminated string"},
+		{"invalid escape", `principal.id == "\q"`, `invalid escape sequence \q`},
+		{"unexpected character", `principal.id # "u1"`, "unexpected character '#'"},
+		{"int out of range", `99999999999999999999 > 1`, "integer 99999999999999999999 out of range"},
+		{"missing operand", `principal.id ==`, "unexpected end of expression"},
+		{"unclosed paren", `(true`, `expected ")", found end of expression`},
+		{"trailing token", `true true`, "unexpected identifier true"},
+		{"missing field name", `principal.(id)`, "expected field name"},
+		{"missing else", `true ? true`, `expected ":"`},
+		{"has without selection", `has(principal)`, "has() takes a field selection"},
+
+		// Type errors.
+		{"undeclared reference", `user.id == "u1"`, `undeclared reference to "user"`},
+		{"unknown field", `principal.name == "x"`, `principal has no field "name"`},
+		{"unknown indexed field", `principal["name"] == "x"`, `principal has no field "name"`},
+		{"select on string", `principal.id.length == 1`, "string does not support field selection"},
+		{"index int", `1[0] == 1`, "int does not support indexing"},
+		{"list index type", `[1, 2]["a"] == 1`, "list index must be int, not string"},
+		{"map key type", `principal.attributes[1] == 1`, "map key must be string, not int"},
+		{"undeclared function", `frobnicate(1)`, `undeclared function "frobnicate"`},
+		{"not bool", `principal.id`, "expression must evaluate to bool, not string"},
+		{"no operator overload", `1 + "a" == 2`, "no matching overload for + applied to (int, string)"},
+		{"no member overload", `principal.id.startsWith(1)`, "no matching overload for startsWith applied to string.(int)"},
+		{"member call as global", `startsWith("a", "b")`, "no matching overload for startsWith applied to (string, string)"},
+		{"incomparable", `principal.id == 1`, "cannot compare string with int"},
+		{"logical operand", `1 && true`, "operand of && must be bool, not int"},
+		{"negated string", `!principal.id`, "no matching overload for ! applied to (string)"},
+		{"condition", `1 ? true : false`, "condition must be bool, not int"},
+
+		// Literal arguments are validated at compile time.
+		{"invalid pattern", `principal.id.matches("[")`, "invalid regular expression"},
+		{"invalid cidr", `request.ip.inCidr("10.0.0.0/33")`, `"10.0.0.0/33" is not a CIDR range`},
+		{"invalid timestamp", `request.time > timestamp("yesterday")`, `"yesterday" is not an RFC 3339 timestamp`},
+		{"invalid duration", `duration("soon") > duration("1h")`, `"soon" is not a duration`},
+		{"invalid time zone", `request.time.getHours("Mars/Base") > 1`, `unknown time zone "Mars/Base"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.source)
+			if err == nil {
+				t.Fatalf("Compile(%q) succeeded, want error containing %q", tt.source, tt.want)
+			}
+			var errs Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("Compile(%q) returned %T, want Errors", tt.source, err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Compile(%q) = %q, want error containing %q", tt.source, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrorPositions(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []Pos
+	}{
+		{`principal.id == 1`, []Pos{{Line: 1, Column: 14}}},
+		{"true &&\n  1", []Pos{{Line: 2, Column: 3}}},
+		{`user.id == "a" || group.id == "b"`, []Pos{{Line: 1, Column: 1}, {Line: 1, Column: 19}}},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.source)
+		var errs Errors
+		if !errors.As(err, &errs) {
+			t.Fatalf("Compile(%q) = %v, want Errors", tt.source, err)
+		}
+		if len(errs) != len(tt.want) {
+			t.Fatalf("Compile(%q) reported %d errors (%v), want %d", tt.source, len(errs), err, len(tt.want))
+		}
+		for i, e := range errs {
+			if e.Pos != tt.want[i] {
+				t.Errorf("Compile(%q) error %d at %+v, want %+v", tt.source, i, e.Pos, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestDepthLimit(t *testing.T) {
+	// The expression itself is one level; each parenthesis, list and
+	// unary operator adds another.
+	nested := func(open, inner, close string, levels int) string {
+		return strings.Repeat(open, levels) + inner + strings.Repeat(close, levels)
+	}
+	tests := []struct {
+		name   string
+		source string
+		ok     bool
+	}{
+		{"parentheses at limit", nested("(", "true", ")", MaxDepth-1), true},
+		{"parentheses over limit", nested("(", "true", ")", MaxDepth), false},
+		{"negations at limit", nested("!", "true", "", MaxDepth-1), true},
+		{"negations over limit", nested("!", "true", "", MaxDepth), false},
+		{"lists at limit", "size(" + nested("[", "1", "]", MaxDepth-2) + ") == 1", true},
+		{"lists over limit", "size(" + nested("[", "1", "]", MaxDepth-1) + ") == 1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.source)
+			switch {
+			case tt.ok && err != nil:
+				t.Errorf("Compile: %v", err)
+			case !tt.ok && err == nil:
+				t.Errorf("Compile succeeded, want depth error")
+			case !tt.ok && !strings.Contains(err.Error(), "nested deeper than 32 levels"):
+				t.Errorf("Compile = %q, want depth error", err)
+			}
+		})
+	}
+}
+
+func TestCostLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		want     bool
+		exceeded bool
+	}{
+		{"small string", `resource.attributes.owner.contains("x")`, false, false},
+		{"large string", `resource.attributes.blob.contains("y")`, false, true},
+		{"large list", `"y" in resource.attributes.big`, false, true},
+		{"large concatenation", `size(resource.attributes.blob + "y") > 0`, false, true},
+		{"large comparison", `resource.attributes.blob == "y"`, false, true},
+		{"not evaluated", `true || resource.attributes.blob.contains("y")`, true, false},
+		// Running out of budget is not an ordinary error that the other
+		// operand of || or && may decide away.
+		{"not absorbed by ||", `resource.attributes.blob.contains("y") || true`, false, true},
+		{"not absorbed by &&", `resource.attributes.blob.contains("y") && false`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evalSource(t, tt.source)
+			if tt.exceeded {
+				if !errors.Is(err, ErrCostExceeded) {
+					t.Fatalf("Eval = %v, %v, want ErrCostExceeded", got, err)
+				}
+				var exprErr *Error
+				if !errors.As(err, &exprErr) {
+					t.Errorf("Eval returned %T, want *Error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Eval: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNullAndMissingAttributes(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    bool
+		wantErr string
+	}{
+		{"null object", `tenant == null`, true, ""},
+		{"field of null object", `tenant.id == "t1"`, false, `cannot select field "id" from null`},
+		{"unset pointer field", `principal.tenant_id == null`, true, ""},
+		{"null attribute", `principal.attributes.manager == null`, true, ""},
+		{"field of null attribute", `principal.attributes.manager.name == "x"`, false, `cannot select field "name" from null`},
+		{"null is not a value", `principal.attributes.manager != "x"`, true, ""},
+		{"missing attribute", `resource.attributes.missing == "x"`, false, `no such key "missing"`},
+		{"missing indexed attribute", `resource.attributes["missing"] == "x"`, false, `no such key "missing"`},
+		{"has present", `has(resource.attributes.owner)`, true, ""},
+		{"has missing", `has(resource.attributes.missing)`, false, ""},
+		{"has null", `has(principal.attributes.manager)`, false, ""},
+		{"missing guarded by has", `has(resource.attributes.missing) && resource.attributes.missing == "x"`, false, ""},
+		{"in missing-safe", `"missing" in resource.attributes`, false, ""},
+		// && and || are decided by either operand, as in CEL.
+		{"error decided by ||", `resource.attributes.missing == "x" || true`, true, ""},
+		{"error decided by &&", `resource.attributes.missing == "x" && false`, false, ""},
+		{"error not decided by &&", `resource.attributes.missing == "x" && true`, false, `no such key "missing"`},
+		{"error not decided by ||", `false || resource.attributes.missing == "x"`, false, `no such key "missing"`},
+		{"error in condition", `resource.attributes.missing == "x" ? true : false`, false, `no such key "missing"`},
+		{"dyn not bool", `resource.attributes.owner`, false, "expression evaluated to string, not bool"},
+		{"dyn operand not bool", `resource.attributes.owner && true`, false, "operand of && must be bool, not string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evalSource(t, tt.source)
+			if tt.wantErr != "" {
+				var exprErr *Error
+				if !errors.As(err, &exprErr) {
+					t.Fatalf("Eval = %v, %v, want *Error containing %q", got, err, tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Eval = %q, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Eval: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActivation(t *testing.T) {
+	p, err := Compile(`resource.id == "d1" && resource.type == "document" || principal.id == "u1"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := map[string]int{}
+	objects := testObjects()
+	got, err := p.Eval(func(name string) (map[string]interface{}, error) {
+		calls[name]++
+		return objects[name], nil
+	})
+	if err != nil || !got {
+		t.Fatalf("Eval = %v, %v, want true", got, err)
+	}
+	if calls["resource"] != 1 {
+		t.Errorf("resource activated %d times, want 1", calls["resource"])
+	}
+	if calls["principal"] != 0 {
+		t.Errorf("principal activated %d times, want 0", calls["principal"])
+	}
+
+	failure := errors.New("attributes unavailable")
+	_, err = p.Eval(func(name string) (map[string]interface{}, error) {
+		return nil, failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("Eval = %v, want the activation's error", err)
+	}
+}
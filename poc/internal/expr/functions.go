@@ -0,0 +1,556 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// overload is one signature of a function or operator. Member overloads
+// are called on their first argument, as in s.startsWith("x").
+type overload struct {
+	member bool
+	args   []*Type
+	result *Type
+	impl   func(args []interface{}) (interface{}, error)
+	// cost is the work of a call beyond the unit every call costs.
+	cost func(args []interface{}) int
+	// validate checks an argument given as a literal at compile time.
+	validate func(i int, v interface{}) error
+}
+
+func (o *overload) accepts(args []*Type) bool {
+	if len(args) != len(o.args) {
+		return false
+	}
+	for i, param := range o.args {
+		arg := args[i].Kind
+		if arg == KindObject {
+			arg = KindMap
+		}
+		if param.Kind != KindDyn && arg != KindDyn && arg != param.Kind {
+			return false
+		}
+	}
+	return true
+}
+
+// applies reports whether o can be called with the runtime values args.
+func (o *overload) applies(args []interface{}) bool {
+	for i, param := range o.args {
+		if param.Kind != KindDyn && kindOf(args[i]) != param.Kind {
+			return false
+		}
+	}
+	return true
+}
+
+// errArgument is returned by implementations for invalid argument values;
+// the evaluator reports it at the position of the call.
+type errArgument struct{ msg string }
+
+func (e *errArgument) Error() string { return e.msg }
+
+func argumentf(format string, args ...interface{}) error {
+	return &errArgument{msg: fmt.Sprintf(format, args...)}
+}
+
+func fn(member bool, result *Type, impl func(args []interface{}) (interface{}, error), args ...*Type) *overload {
+	return &overload{member: member, args: args, result: result, impl: impl}
+}
+
+func (o *overload) withCost(cost func(args []interface{}) int) *overload {
+	o.cost = cost
+	return o
+}
+
+func (o *overload) withValidate(validate func(i int, v interface{}) error) *overload {
+	o.validate = validate
+	return o
+}
+
+// functions maps function and operator names to their overloads.
+var functions = map[string][]*overload{}
+
+func register(name string, overloads ...*overload) {
+	functions[name] = append(functions[name], overloads...)
+}
+
+func init() {
+	register("_==_", fn(false, typeBool, func(a []interface{}) (interface{}, error) {
+		return equal(a[0], a[1]), nil
+	}, typeDyn, typeDyn).withCost(sizeCost(0, 1)))
+	register("_!=_", fn(false, typeBool, func(a []interface{}) (interface{}, error) {
+		return !equal(a[0], a[1]), nil
+	}, typeDyn, typeDyn).withCost(sizeCost(0, 1)))
+
+	for op, test := range map[string]func(int) bool{
+		"_<_":  func(c int) bool { return c < 0 },
+		"_<=_": func(c int) bool { return c <= 0 },
+		"_>_":  func(c int) bool { return c > 0 },
+		"_>=_": func(c int) bool { return c >= 0 },
+	} {
+		impl := func(a []interface{}) (interface{}, error) {
+			return test(compare(a[0], a[1])), nil
+		}
+		register(op,
+			fn(false, typeBool, impl, typeInt, typeInt),
+			fn(false, typeBool, impl, typeDouble, typeDouble),
+			fn(false, typeBool, impl, typeInt, typeDouble),
+			fn(false, typeBool, impl, typeDouble, typeInt),
+			fn(false, typeBool, impl, typeString, typeString).withCost(sizeCost(0, 1)),
+			fn(false, typeBool, impl, typeTimestamp, typeTimestamp),
+			fn(false, typeBool, impl, typeDuration, typeDuration),
+		)
+	}
+
+	register("_+_",
+		fn(false, typeInt, func(a []interface{}) (interface{}, error) {
+			x, y := a[0].(int64), a[1].(int64)
+			if (y > 0 && x > math.MaxInt64-y) || (y < 0 && x < math.MinInt64-y) {
+				return nil, argumentf("integer overflow")
+			}
+			return x + y, nil
+		}, typeInt, typeInt),
+		fn(false, typeString, func(a []interface{}) (interface{}, error) {
+			return a[0].(string) + a[1].(string), nil
+		}, typeString, typeString).withCost(sizeCost(0, 1)),
+		fn(false, typeList, func(a []interface{}) (interface{}, error) {
+			x, y := a[0].([]interface{}), a[1].([]interface{})
+			return append(append(make([]interface{}, 0, len(x)+len(y)), x...), y...), nil
+		}, typeList, typeList).withCost(sizeCost(0, 1)),
+		fn(false, typeTimestamp, func(a []interface{}) (interface{}, error) {
+			return a[0].(time.Time).Add(a[1].(time.Duration)), nil
+		}, typeTimestamp, typeDuration),
+		fn(false, typeTimestamp, func(a []interface{}) (interface{}, error) {
+			return a[1].(time.Time).Add(a[0].(time.Duration)), nil
+		}, typeDuration, typeTimestamp),
+		fn(false, typeDuration, func(a []interface{}) (interface{}, error) {
+			return a[0].(time.Duration) + a[1].(time.Duration), nil
+		}, typeDuration, typeDuration),
+	)
+	register("_+_", doubleOverloads(func(x, y float64) (interface{}, error) { return x + y, nil })...)
+
+	register("_-_",
+		fn(false, typeInt, func(a []interface{}) (interface{}, error) {
+			x, y := a[0].(int64), a[1].(int64)
+			if (y < 0 && x > math.MaxInt64+y) || (y > 0 && x < math.MinInt64+y) {
+				return nil, argumentf("integer overflow")
+			}
+			return x - y, nil
+		}, typeInt, typeInt),
+		fn(false, typeDuration, func(a []interface{}) (interface{}, error) {
+			return a[0].(time.Time).Sub(a[1].(time.Time)), nil
+		}, typeTimestamp, typeTimestamp),
+		fn(false, typeTimestamp, func(a []interface{}) (interface{}, error) {
+			return a[0].(time.Time).Add(-a[1].(time.Duration)), nil
+		}, typeTimestamp, typeDuration),
+		fn(false, typeDuration, func(a []interface{}) (interface{}, error) {
+			return a[0].(time.Duration) - a[1].(time.Duration), nil
+		}, typeDuration, typeDuration),
+	)
+	register("_-_", doubleOverloads(func(x, y float64) (interface{}, error) { return x - y, nil })...)
+
+	register("_*_", fn(false, typeInt, func(a []interface{}) (interface{}, error) {
+		x, y := a[0].(int64), a[1].(int64)
+		if x != 0 && ((x*y)/x != y || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64)) {
+			return nil, argumentf("integer overflow")
+		}
+		return x * y, nil
+	}, typeInt, typeInt))
+	register("_*_", doubleOverloads(func(x, y float64) (interface{}, error) { return x * y, nil })...)
+
+	register("_/_", fn(false, typeInt, func(a []interface{}) (interface{}, error) {
+		x, y := a[0].(int64), a[1].(int64)
+		if y == 0 {
+			return nil, argumentf("division by zero")
+		}
+		if x == math.MinInt64 && y == -1 {
+			return nil, argumentf("integer overflow")
+		}
+		return x / y, nil
+	}, typeInt, typeInt))
+	register("_/_", doubleOverloads(func(x, y float64) (interface{}, error) { return x / y, nil })...)
+
+	register("_%_", fn(false, typeInt, func(a []interface{}) (interface{}, error) {
+		x, y := a[0].(int64), a[1].(int64)
+		if y == 0 {
+			return nil, argumentf("modulus by zero")
+		}
+		if y == -1 {
+			return int64(0), nil
+		}
+		return x % y, nil
+	}, typeInt, typeInt))
+
+	register("-_",
+		fn(false, typeInt, func(a []interface{}) (interface{}, error) {
+			if a[0].(int64) == math.MinInt64 {
+				return nil, argumentf("integer overflow")
+			}
+			return -a[0].(int64), nil
+		}, typeInt),
+		fn(false, typeDouble, func(a []interface{}) (interface{}, error) {
+			return -a[0].(float64), nil
+		}, typeDouble),
+		fn(false, typeDuration, func(a []interface{}) (interface{}, error) {
+			return -a[0].(time.Duration), nil
+		}, typeDuration),
+	)
+	register("!_", fn(false, typeBool, func(a []interface{}) (interface{}, error) {
+		return !a[0].(bool), nil
+	}, typeBool))
+
+	register("@in",
+		fn(false, typeBool, func(a []interface{}) (interface{}, error) {
+			for _, elem := range a[1].([]interface{}) {
+				if equal(a[0], elem) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}, typeDyn, typeList).withCost(sizeCost(1)),
+		fn(false, typeBool, func(a []interface{}) (interface{}, error) {
+			key, ok := a[0].(string)
+			if !ok {
+				return nil, argumentf("map key must be string, not %s", kindOf(a[0]))
+			}
+			_, found := a[1].(map[string]interface{})[key]
+			return found, nil
+		}, typeDyn, typeMap),
+	)
+
+	size := func(a []interface{}) (interface{}, error) {
+		switch v := a[0].(type) {
+		case string:
+			return int64(utf8.RuneCountInString(v)), nil
+		case []interface{}:
+			return int64(len(v)), nil
+		case map[string]interface{}:
+			return int64(len(v)), nil
+		}
+		return nil, argumentf("size of %s", kindOf(a[0]))
+	}
+	for _, member := range []bool{false, true} {
+		register("size",
+			fn(member, typeInt, size, typeString).withCost(sizeCost(0)),
+			fn(member, typeInt, size, typeList),
+			fn(member, typeInt, size, typeMap),
+		)
+	}
+
+	for name, test := range map[string]func(s, x string) bool{
+		"startsWith": strings.HasPrefix,
+		"endsWith":   strings.HasSuffix,
+		"contains":   strings.Contains,
+	} {
+		register(name, fn(true, typeBool, func(a []interface{}) (interface{}, error) {
+			return test(a[0].(string), a[1].(string)), nil
+		}, typeString, typeString).withCost(sizeCost(0, 1)))
+	}
+	for name, convert := range map[string]func(string) string{
+		"lowerAscii": lowerASCII,
+		"upperAscii": upperASCII,
+		"trim":       strings.TrimSpace,
+	} {
+		register(name, fn(true, typeString, func(a []interface{}) (interface{}, error) {
+			return convert(a[0].(string)), nil
+		}, typeString).withCost(sizeCost(0)))
+	}
+
+	matches := func(a []interface{}) (interface{}, error) {
+		re, err := regexp.Compile(a[1].(string))
+		if err != nil {
+			return nil, argumentf("invalid regular expression %q", a[1])
+		}
+		return re.MatchString(a[0].(string)), nil
+	}
+	validatePattern := func(i int, v interface{}) error {
+		if i != 1 {
+			return nil
+		}
+		if _, err := regexp.Compile(v.(string)); err != nil {
+			return fmt.Errorf("invalid regular expression: %v", err)
+		}
+		return nil
+	}
+	for _, member := range []bool{false, true} {
+		register("matches", fn(member, typeBool, matches, typeString, typeString).
+			withCost(sizeCost(0, 1)).withValidate(validatePattern))
+	}
+
+	register("inCidr", fn(true, typeBool, func(a []interface{}) (interface{}, error) {
+		addr, err := netip.ParseAddr(a[0].(string))
+		if err != nil {
+			return nil, argumentf("%q is not an IP address", a[0])
+		}
+		prefix, err := netip.ParsePrefix(a[1].(string))
+		if err != nil {
+			return nil, argumentf("%q is not a CIDR range", a[1])
+		}
+		return prefix.Contains(addr.Unmap()), nil
+	}, typeString, typeString).withValidate(func(i int, v interface{}) error {
+		if i != 1 {
+			return nil
+		}
+		if _, err := netip.ParsePrefix(v.(string)); err != nil {
+			return fmt.Errorf("%q is not a CIDR range", v)
+		}
+		return nil
+	}))
+
+	register("timestamp", fn(false, typeTimestamp, func(a []interface{}) (interface{}, error) {
+		t, err := time.Parse(time.RFC3339, a[0].(string))
+		if err != nil {
+			return nil, argumentf("%q is not an RFC 3339 timestamp", a[0])
+		}
+		return t, nil
+	}, typeString).withValidate(func(_ int, v interface{}) error {
+		if _, err := time.Parse(time.RFC3339, v.(string)); err != nil {
+			return fmt.Errorf("%q is not an RFC 3339 timestamp", v)
+		}
+		return nil
+	}))
+	register("duration", fn(false, typeDuration, func(a []interface{}) (interface{}, error) {
+		d, err := time.ParseDuration(a[0].(string))
+		if err != nil {
+			return nil, argumentf("%q is not a duration", a[0])
+		}
+		return d, nil
+	}, typeString).withValidate(func(_ int, v interface{}) error {
+		if _, err := time.ParseDuration(v.(string)); err != nil {
+			return fmt.Errorf("%q is not a duration, such as \"90m\" or \"1h30m\"", v)
+		}
+		return nil
+	}))
+
+	register("int",
+		fn(false, typeInt, func(a []interface{}) (interface{}, error) { return a[0], nil }, typeInt),
+		fn(false, typeInt, func(a []interface{}) (interface{}, error) {
+			f := a[0].(float64)
+			if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return nil, argumentf("%v out of int range", f)
+			}
+			return int64(f), nil
+		}, typeDouble),
+		fn(false, typeInt, func(a []interface{}) (interface{}, error) {
+			i, err := strconv.ParseInt(a[0].(string), 10, 64)
+			if err != nil {
+				return nil, argumentf("%q is not an int", a[0])
+			}
+			return i, nil
+		}, typeString),
+	)
+	register("double",
+		fn(false, typeDouble, func(a []interface{}) (interface{}, error) { return float64(a[0].(int64)), nil }, typeInt),
+		fn(false, typeDouble, func(a []interface{}) (interface{}, error) { return a[0], nil }, typeDouble),
+		fn(false, typeDouble, func(a []interface{}) (interface{}, error) {
+			f, err := strconv.ParseFloat(a[0].(string), 64)
+			if err != nil {
+				return nil, argumentf("%q is not a double", a[0])
+			}
+			return f, nil
+		}, typeString),
+	)
+	register("string", fn(false, typeString, func(a []interface{}) (interface{}, error) {
+		switch v := a[0].(type) {
+		case string:
+			return v, nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case float64:
+			return strconv.FormatFloat(v, 'g', -1, 64), nil
+		case time.Time:
+			return v.Format(time.RFC3339Nano), nil
+		case time.Duration:
+			return v.String(), nil
+		}
+		return nil, argumentf("cannot convert %s to string", kindOf(a[0]))
+	}, typeDyn))
+
+	// Timestamp accessors follow CEL: months, days of the month and days
+	// of the year count from 0, getDate from 1, and days of the week from
+	// Sunday, 0. They take an optional IANA time zone, UTC by default.
+	for name, get := range map[string]func(t time.Time) int{
+		"getFullYear":   func(t time.Time) int { return t.Year() },
+		"getMonth":      func(t time.Time) int { return int(t.Month()) - 1 },
+		"getDate":       func(t time.Time) int { return t.Day() },
+		"getDayOfMonth": func(t time.Time) int { return t.Day() - 1 },
+		"getDayOfWeek":  func(t time.Time) int { return int(t.Weekday()) },
+		"getDayOfYear":  func(t time.Time) int { return t.YearDay() - 1 },
+		"getHours":      func(t time.Time) int { return t.Hour() },
+		"getMinutes":    func(t time.Time) int { return t.Minute() },
+		"getSeconds":    func(t time.Time) int { return t.Second() },
+	} {
+		register(name,
+			fn(true, typeInt, func(a []interface{}) (interface{}, error) {
+				return int64(get(a[0].(time.Time).UTC())), nil
+			}, typeTimestamp),
+			fn(true, typeInt, func(a []interface{}) (interface{}, error) {
+				loc, err := time.LoadLocation(a[1].(string))
+				if err != nil {
+					return nil, argumentf("unknown time zone %q", a[1])
+				}
+				return int64(get(a[0].(time.Time).In(loc))), nil
+			}, typeTimestamp, typeString).withValidate(func(i int, v interface{}) error {
+				if i != 1 {
+					return nil
+				}
+				if _, err := time.LoadLocation(v.(string)); err != nil {
+					return fmt.Errorf("unknown time zone %q", v)
+				}
+				return nil
+			}),
+		)
+	}
+	// Duration accessors return the whole duration in the unit.
+	for name, unit := range map[string]time.Duration{
+		"getHours":   time.Hour,
+		"getMinutes": time.Minute,
+		"getSeconds": time.Second,
+	} {
+		register(name, fn(true, typeInt, func(a []interface{}) (interface{}, error) {
+			return int64(a[0].(time.Duration) / unit), nil
+		}, typeDuration))
+	}
+}
+
+// doubleOverloads registers an arithmetic operator for doubles, and for
+// ints mixed with doubles, since JSON attributes are always doubles.
+func doubleOverloads(op func(x, y float64) (interface{}, error)) []*overload {
+	impl := func(a []interface{}) (interface{}, error) {
+		return op(toDouble(a[0]), toDouble(a[1]))
+	}
+	return []*overload{
+		fn(false, typeDouble, impl, typeDouble, typeDouble),
+		fn(false, typeDouble, impl, typeInt, typeDouble),
+		fn(false, typeDouble, impl, typeDouble, typeInt),
+	}
+}
+
+// sizeCost charges the size of the listed arguments.
+func sizeCost(indexes ...int) func(args []interface{}) int {
+	return func(args []interface{}) int {
+		cost := 0
+		for _, i := range indexes {
+			switch v := args[i].(type) {
+			case string:
+				cost += len(v)
+			case []interface{}:
+				cost += len(v)
+			case map[string]interface{}:
+				cost += len(v)
+			}
+		}
+		return cost
+	}
+}
+
+func toDouble(v interface{}) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
+
+// equal compares values deeply. Ints equal doubles of the same value.
+func equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case int64, float64:
+		switch b.(type) {
+		case int64, float64:
+			return toDouble(a) == toDouble(b)
+		}
+		return false
+	case time.Time:
+		y, ok := b.(time.Time)
+		return ok && x.Equal(y)
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// compare orders two values of an ordered kind, as selected by the
+// overloads of the relational operators.
+func compare(a, b interface{}) int {
+	switch x := a.(type) {
+	case string:
+		return strings.Compare(x, b.(string))
+	case time.Time:
+		return x.Compare(b.(time.Time))
+	case time.Duration:
+		y := b.(time.Duration)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case int64:
+		if y, ok := b.(int64); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	x, y := toDouble(a), toDouble(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func lowerASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+func upperASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - ('a' - 'A')
+		}
+		return r
+	}, s)
+}
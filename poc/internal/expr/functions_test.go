@@ -0,0 +1,180 @@
+package expr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFunctions(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   bool
+	}{
+		// Equality compares deeply, and ints equal doubles of the same value.
+		{"== int", `1 == 1`, true},
+		{"== int double", `1 == 1.0`, true},
+		{"== attribute double", `principal.attributes.level == 3`, true},
+		{"== string", `principal.id == "u2"`, false},
+		{"== list", `[1, "a"] == [1, "a"]`, true},
+		{"== attribute list", `resource.attributes.tags == ["a", "b"]`, true},
+		{"== list order", `[1, 2] == [2, 1]`, false},
+		{"== timestamp", `request.time == timestamp("2024-03-15T16:30:45+02:00")`, true},
+		{"== null", `null == null`, true},
+		{"== mixed kinds", `resource.attributes.owner == 1`, false},
+		{"!= string", `principal.id != "u2"`, true},
+
+		{"< int", `1 < 2`, true},
+		{"<= double", `1.5 <= 1.5`, true},
+		{"< int double", `1 < 1.5`, true},
+		{"> double int", `2.5 > 2`, true},
+		{">= string", `"a" >= "b"`, false},
+		{"> timestamp", `request.time > timestamp("2024-01-01T00:00:00Z")`, true},
+		{">= duration", `duration("1h") >= duration("60m")`, true},
+		{"< dyn", `resource.attributes.size < 11`, true},
+
+		{"+ int", `1 + 2 == 3`, true},
+		{"+ string", `"a" + "b" == "ab"`, true},
+		{"+ list", `[1] + [2] == [1, 2]`, true},
+		{"+ timestamp duration", `request.time + duration("1h") == timestamp("2024-03-15T15:30:45Z")`, true},
+		{"+ duration timestamp", `duration("1h") + request.time == timestamp("2024-03-15T15:30:45Z")`, true},
+		{"+ duration", `duration("1h") + duration("30m") == duration("90m")`, true},
+		{"+ double", `1.5 + 1.5 == 3.0`, true},
+		{"+ int double", `1 + 0.5 == 1.5`, true},
+		{"+ double int", `0.5 + 1 == 1.5`, true},
+
+		{"- int", `1 - 3 == -2`, true},
+		{"- timestamp", `request.time - timestamp("2024-03-15T13:30:45Z") == duration("1h")`, true},
+		{"- timestamp duration", `request.time - duration("30s") == timestamp("2024-03-15T14:30:15Z")`, true},
+		{"- duration", `duration("1h") - duration("30m") == duration("30m")`, true},
+		{"- double", `principal.attributes.level - 0.5 == 2.5`, true},
+
+		{"* int", `6 * 7 == 42`, true},
+		{"* double", `1.5 * 2 == 3.0`, true},
+		{"/ int", `7 / 2 == 3`, true},
+		{"/ double", `7.0 / 2 == 3.5`, true},
+		{"% int", `7 % 3 == 1`, true},
+		{"% minus one", `7 % -1 == 0`, true},
+
+		{"negate int", `-(1 + 1) == -2`, true},
+		{"negate double", `-principal.attributes.level == -3.0`, true},
+		{"negate duration", `-duration("1h") < duration("0s")`, true},
+		{"not", `!false`, true},
+
+		{"in list", `"admins" in principal.attributes.groups`, true},
+		{"in list kinds", `1 in ["1"]`, false},
+		{"in list numbers", `3 in [1.0, 3.0]`, true},
+		{"in map", `"department" in principal.attributes`, true},
+		{"in object", `"tenant_id" in principal`, true},
+
+		{"size string", `size("héllo") == 5`, true},
+		{"size string member", `"abc".size() == 3`, true},
+		{"size list", `size([1, 2]) == 2`, true},
+		{"size list member", `principal.attributes.groups.size() == 2`, true},
+		{"size map", `size(principal.attributes) == 4`, true},
+
+		{"startsWith", `principal.id.startsWith("u")`, true},
+		{"endsWith", `principal.id.endsWith("2")`, false},
+		{"contains", `"administrators".contains("min")`, true},
+		{"lowerAscii", `"ÄBC".lowerAscii() == "Äbc"`, true},
+		{"upperAscii", `"äbc".upperAscii() == "äBC"`, true},
+		{"trim", `"  a b ".trim() == "a b"`, true},
+		{"matches", `matches(principal.id, "^u[0-9]+$")`, true},
+		{"matches member", `resource.type.matches("^doc")`, true},
+
+		{"inCidr", `request.ip.inCidr("10.0.0.0/8")`, true},
+		{"inCidr outside", `request.ip.inCidr("192.168.0.0/16")`, false},
+		{"inCidr mapped", `"::ffff:10.1.2.3".inCidr("10.0.0.0/8")`, true},
+		{"inCidr ipv6", `"2001:db8::1".inCidr("2001:db8::/32")`, true},
+
+		{"int int", `int(1) == 1`, true},
+		{"int double", `int(2.9) == 2`, true},
+		{"int string", `int("42") == 42`, true},
+		{"double int", `double(1) == 1.0`, true},
+		{"double double", `double(1.5) == 1.5`, true},
+		{"double string", `double("2.5") == 2.5`, true},
+		{"string string", `string("a") == "a"`, true},
+		{"string bool", `string(true) == "true"`, true},
+		{"string int", `string(12) == "12"`, true},
+		{"string double", `string(1.5) == "1.5"`, true},
+		{"string timestamp", `string(request.time) == "2024-03-15T14:30:45Z"`, true},
+		{"string duration", `string(duration("90m")) == "1h30m0s"`, true},
+
+		// Timestamp accessors count months and days as CEL does.
+		{"getFullYear", `request.time.getFullYear() == 2024`, true},
+		{"getMonth", `request.time.getMonth() == 2`, true},
+		{"getDate", `request.time.getDate() == 15`, true},
+		{"getDayOfMonth", `request.time.getDayOfMonth() == 14`, true},
+		{"getDayOfWeek", `request.time.getDayOfWeek() == 5`, true},
+		{"getDayOfYear", `request.time.getDayOfYear() == 74`, true},
+		{"getHours", `request.time.getHours() == 14`, true},
+		{"getMinutes", `request.time.getMinutes() == 30`, true},
+		{"getSeconds", `request.time.getSeconds() == 45`, true},
+		{"getHours time zone", `request.time.getHours("Asia/Tokyo") == 23`, true},
+		{"getDayOfWeek time zone", `request.time.getDayOfWeek("Asia/Tokyo") == 5`, true},
+		{"getDate time zone", `timestamp("2024-03-15T23:30:00Z").getDate("Asia/Tokyo") == 16`, true},
+
+		// Duration accessors return the whole duration in the unit.
+		{"duration getHours", `duration("90m").getHours() == 1`, true},
+		{"duration getMinutes", `duration("90m").getMinutes() == 90`, true},
+		{"duration getSeconds", `duration("90m").getSeconds() == 5400`, true},
+
+		{"conditional", `(resource.attributes.size > 5 ? "big" : "small") == "big"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evalSource(t, tt.source)
+			if err != nil {
+				t.Fatalf("Eval(%q): %v", tt.source, err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval(%q) = %v, want %v", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFunctionErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"division by zero", `1 / 0 == 0`, "/: division by zero"},
+		{"modulus by zero", `7 % 0 == 0`, "%: modulus by zero"},
+		{"overflow +", `9223372036854775807 + 1 > 0`, "+: integer overflow"},
+		{"overflow -", `-9223372036854775807 - 2 < 0`, "-: integer overflow"},
+		{"overflow *", `9223372036854775807 * 2 > 0`, "*: integer overflow"},
+		{"overflow /", `(-9223372036854775807 - 1) / -1 > 0`, "/: integer overflow"},
+		{"overflow negation", `-(-9223372036854775807 - 1) > 0`, "-: integer overflow"},
+		{"int out of range", `int(1e19) == 0`, "int: 1e+19 out of int range"},
+		{"int of string", `int("x") == 0`, `int: "x" is not an int`},
+		{"double of string", `double("x") == 0.0`, `double: "x" is not a double`},
+		{"string of list", `string([1]) == ""`, "string: cannot convert list to string"},
+		{"pattern", `principal.id.matches(resource.attributes.pattern)`, "matches: invalid regular expression"},
+		{"ip address", `resource.attributes.ip.inCidr("10.0.0.0/8")`, `inCidr: "nope" is not an IP address`},
+		{"timestamp", `timestamp(resource.attributes.when) > request.time`, `timestamp: "soon" is not an RFC 3339 timestamp`},
+		{"duration", `duration(resource.attributes.when) > duration("1h")`, `duration: "soon" is not a duration`},
+		{"time zone", `request.time.getHours(resource.attributes.when) > 1`, `getHours: unknown time zone "soon"`},
+		{"in map key", `1 in principal.attributes`, "in: map key must be string, not int"},
+		{"list index", `[1, 2][5] == 1`, "index 5 out of range for list of size 2"},
+		{"dyn list index", `principal.attributes.groups["a"] == 1`, "list index must be int, not string"},
+		{"dyn index", `resource.attributes.size[0] == 1`, "cannot index int"},
+		{"dyn member overload", `resource.attributes.size.startsWith("1")`, "no matching overload for startsWith applied to int.(string)"},
+		{"dyn overload", `size(resource.attributes.size) == 1`, "no matching overload for size applied to (int)"},
+		{"dyn relation", `resource.attributes.owner < 1`, "no matching overload for < applied to (string, int)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evalSource(t, tt.source)
+			var exprErr *Error
+			if !errors.As(err, &exprErr) {
+				t.Fatalf("Eval(%q) = %v, %v, want *Error containing %q", tt.source, got, err, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Eval(%q) = %q, want error containing %q", tt.source, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package expr
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokDouble
+	tokString
+	tokOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   Pos
+}
+
+// operators lists the punctuation of the language, longest first so that
+// "<=" is not read as "<".
+var operators = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"<", ">", "!", "+", "-", "*", "/", "%", "?", ":", ".", ",", "(", ")", "[", "]",
+}
+
+type lexer struct {
+	src  string
+	off  int
+	line int
+	col  int
+}
+
+func lex(src string) ([]token, error) {
+	l := &lexer{src: src, line: 1, col: 1}
+	var tokens []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		if tok.kind == tokEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) advance(n int) {
+	for _, r := range l.src[l.off : l.off+n] {
+		if r == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+	}
+	l.off += n
+}
+
+func (l *lexer) next() (token, error) {
+	for l.off < len(l.src) {
+		r, size := utf8.DecodeRuneInString(l.src[l.off:])
+		if unicode.IsSpace(r) {
+			l.advance(size)
+			continue
+		}
+		// Comments run to the end of the line.
+		if strings.HasPrefix(l.src[l.off:], "//") {
+			end := strings.IndexByte(l.src[l.off:], '\n')
+			if end < 0 {
+				end = len(l.src) - l.off
+			}
+			l.advance(end)
+			continue
+		}
+		break
+	}
+
+	pos := Pos{Line: l.line, Column: l.col}
+	if l.off >= len(l.src) {
+		return token{kind: tokEOF, pos: pos}, nil
+	}
+
+	rest := l.src[l.off:]
+	r, _ := utf8.DecodeRuneInString(rest)
+	switch {
+	case r == '_' || unicode.IsLetter(r):
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if end < 0 {
+			end = len(rest)
+		}
+		l.advance(end)
+		return token{kind: tokIdent, text: rest[:end], pos: pos}, nil
+
+	case r >= '0' && r <= '9':
+		return l.number(pos)
+
+	case r == '"' || r == '\'':
+		return l.string(pos, byte(r))
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			l.advance(len(op))
+			return token{kind: tokOperator, text: op, pos: pos}, nil
+		}
+	}
+	return token{}, errorf(pos, "unexpected character %q", r)
+}
+
+func (l *lexer) number(pos Pos) (token, error) {
+	rest := l.src[l.off:]
+	end := 0
+	double := false
+scan:
+	for end < len(rest) {
+		c := rest[end]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' && !double && end+1 < len(rest) && rest[end+1] >= '0' && rest[end+1] <= '9':
+			double = true
+		case (c == 'e' || c == 'E') && end > 0:
+			double = true
+			if end+1 < len(rest) && (rest[end+1] == '+' || rest[end+1] == '-') {
+				end++
+			}
+		default:
+			break scan
+		}
+		end++
+	}
+	text := rest[:end]
+	l.advance(end)
+
+	if double {
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return token{}, errorf(pos, "invalid number %s", text)
+		}
+		return token{kind: tokDouble, text: text, value: v, pos: pos}, nil
+	}
+	v, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return token{}, errorf(pos, "integer %s out of range", text)
+	}
+	return token{kind: tokInt, text: text, value: v, pos: pos}, nil
+}
+
+func (l *lexer) string(pos Pos, quote byte) (token, error) {
+	var b strings.Builder
+	i := 1
+	rest := l.src[l.off:]
+	for {
+		if i >= len(rest) || rest[i] == '\n' {
+			return token{}, errorf(pos, "unterminated string")
+		}
+		c := rest[i]
+		if c == quote {
+			i++
+			break
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		if i+1 >= len(rest) {
+			return token{}, errorf(pos, "unterminated string")
+		}
+		switch rest[i+1] {
+		case '\\', '"', '\'':
+			b.WriteByte(rest[i+1])
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			l.advance(i)
+			return token{}, errorf(Pos{Line: l.line, Column: l.col}, "invalid escape sequence \\%c", rest[i+1])
+		}
+		i += 2
+	}
+	l.advance(i)
+	return token{kind: tokString, text: rest[:i], value: b.String(), pos: pos}, nil
+}
@@ -0,0 +1,403 @@
+package expr
+
+type node interface {
+	pos() Pos
+}
+
+type literalNode struct {
+	at    Pos
+	value interface{}
+}
+
+type identNode struct {
+	at   Pos
+	name string
+}
+
+// selectNode reads a field. With test set, as the argument of has(), it
+// reports whether the field is present instead.
+type selectNode struct {
+	at      Pos
+	operand node
+	field   string
+	test    bool
+}
+
+type indexNode struct {
+	at      Pos
+	operand node
+	index   node
+}
+
+// callNode calls a function or operator; operators are named like "_+_"
+// and "!_". target is the receiver of member calls such as s.size().
+// overloads is set by the checker to the overloads that may apply.
+type callNode struct {
+	at        Pos
+	name      string
+	target    node
+	args      []node
+	overloads []*overload
+}
+
+type listNode struct {
+	at    Pos
+	elems []node
+}
+
+type logicalNode struct {
+	at          Pos
+	op          string
+	left, right node
+}
+
+type condNode struct {
+	at              Pos
+	cond, then, els node
+}
+
+func (n *literalNode) pos() Pos { return n.at }
+func (n *identNode) pos() Pos   { return n.at }
+func (n *selectNode) pos() Pos  { return n.at }
+func (n *indexNode) pos() Pos   { return n.at }
+func (n *callNode) pos() Pos    { return n.at }
+func (n *listNode) pos() Pos    { return n.at }
+func (n *logicalNode) pos() Pos { return n.at }
+func (n *condNode) pos() Pos    { return n.at }
+
+var relationOperators = map[string]string{
+	"==": "_==_", "!=": "_!=_", "<": "_<_", "<=": "_<=_", ">": "_>_", ">=": "_>=_", "in": "@in",
+}
+
+type parser struct {
+	tokens []token
+	i      int
+	depth  int
+}
+
+// parse builds the syntax tree of:
+//
+//	expr     = or ["?" or ":" expr]
+//	or       = and {"||" and}
+//	and      = relation {"&&" relation}
+//	relation = sum {("==" | "!=" | "<" | "<=" | ">" | ">=" | "in") sum}
+//	sum      = product {("+" | "-") product}
+//	product  = unary {("*" | "/" | "%") unary}
+//	unary    = ("!" | "-") unary | member
+//	member   = primary {"." ident ["(" [args] ")"] | "[" expr "]"}
+//	primary  = literal | ident ["(" [args] ")"] | "(" expr ")" | "[" [args] "]"
+func parse(tokens []token) (node, error) {
+	p := &parser{tokens: tokens}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, errorf(tok.pos, "unexpected %s", describe(tok))
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the operator op.
+func (p *parser) accept(op string) (token, bool) {
+	tok := p.peek()
+	if tok.kind == tokOperator && tok.text == op {
+		p.i++
+		return tok, true
+	}
+	return tok, false
+}
+
+func (p *parser) expect(op string) (token, error) {
+	tok, ok := p.accept(op)
+	if !ok {
+		return tok, errorf(tok.pos, "expected %q, found %s", op, describe(tok))
+	}
+	return tok, nil
+}
+
+func describe(tok token) string {
+	switch tok.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokIdent:
+		return "identifier " + tok.text
+	case tokOperator:
+		return "'" + tok.text + "'"
+	default:
+		return tok.text
+	}
+}
+
+func (p *parser) enter(pos Pos) error {
+	p.depth++
+	if p.depth > MaxDepth {
+		return errorf(pos, "expression nested deeper than %d levels", MaxDepth)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) expr() (node, error) {
+	if err := p.enter(p.peek().pos); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	cond, err := p.logical("||", p.and)
+	if err != nil {
+		return nil, err
+	}
+	tok, ok := p.accept("?")
+	if !ok {
+		return cond, nil
+	}
+	then, err := p.logical("||", p.and)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	els, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return &condNode{at: tok.pos, cond: cond, then: then, els: els}, nil
+}
+
+func (p *parser) and() (node, error) {
+	return p.logical("&&", p.relation)
+}
+
+func (p *parser) logical(op string, operand func() (node, error)) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.accept(op)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{at: tok.pos, op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) relation() (node, error) {
+	left, err := p.binary(p.product, "+", "-")
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		name, ok := relationOperators[tok.text]
+		if !ok || (tok.kind != tokOperator && !(tok.kind == tokIdent && tok.text == "in")) {
+			return left, nil
+		}
+		p.next()
+		right, err := p.binary(p.product, "+", "-")
+		if err != nil {
+			return nil, err
+		}
+		left = &callNode{at: tok.pos, name: name, args: []node{left, right}}
+	}
+}
+
+func (p *parser) product() (node, error) {
+	return p.binary(p.unary, "*", "/", "%")
+}
+
+func (p *parser) binary(operand func() (node, error), ops ...string) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		matched := false
+		for _, op := range ops {
+			if tok.kind == tokOperator && tok.text == op {
+				matched = true
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+		p.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &callNode{at: tok.pos, name: "_" + tok.text + "_", args: []node{left, right}}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	tok := p.peek()
+	if tok.kind != tokOperator || (tok.text != "!" && tok.text != "-") {
+		return p.member()
+	}
+
+	if err := p.enter(tok.pos); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	p.next()
+	operand, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	// Fold negative literals into constants.
+	if lit, ok := operand.(*literalNode); ok && tok.text == "-" {
+		switch v := lit.value.(type) {
+		case int64:
+			return &literalNode{at: tok.pos, value: -v}, nil
+		case float64:
+			return &literalNode{at: tok.pos, value: -v}, nil
+		}
+	}
+	return &callNode{at: tok.pos, name: tok.text + "_", args: []node{operand}}, nil
+}
+
+func (p *parser) member() (node, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if tok, ok := p.accept("."); ok {
+			field := p.next()
+			if field.kind != tokIdent {
+				return nil, errorf(field.pos, "expected field name, found %s", describe(field))
+			}
+			if _, ok := p.accept("("); ok {
+				args, err := p.args(")")
+				if err != nil {
+					return nil, err
+				}
+				n = &callNode{at: field.pos, name: field.text, target: n, args: args}
+				continue
+			}
+			n = &selectNode{at: tok.pos, operand: n, field: field.text}
+			continue
+		}
+		if tok, ok := p.accept("["); ok {
+			index, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{at: tok.pos, operand: n, index: index}
+			continue
+		}
+		return n, nil
+	}
+}
+
+func (p *parser) primary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokInt, tokDouble, tokString:
+		return &literalNode{at: tok.pos, value: tok.value}, nil
+
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{at: tok.pos, value: true}, nil
+		case "false":
+			return &literalNode{at: tok.pos, value: false}, nil
+		case "null":
+			return &literalNode{at: tok.pos, value: nil}, nil
+		case "in":
+			return nil, errorf(tok.pos, "unexpected %s", describe(tok))
+		}
+		if _, ok := p.accept("("); !ok {
+			return &identNode{at: tok.pos, name: tok.text}, nil
+		}
+		args, err := p.args(")")
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == "has" {
+			return hasMacro(tok.pos, args)
+		}
+		return &callNode{at: tok.pos, name: tok.text, args: args}, nil
+
+	case tokOperator:
+		switch tok.text {
+		case "(":
+			n, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		case "[":
+			elems, err := p.args("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{at: tok.pos, elems: elems}, nil
+		}
+	}
+	return nil, errorf(tok.pos, "unexpected %s", describe(tok))
+}
+
+// args parses a comma-separated list of expressions up to the closing
+// operator.
+func (p *parser) args(closing string) ([]node, error) {
+	var args []node
+	if _, ok := p.accept(closing); ok {
+		return args, nil
+	}
+	for {
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if _, ok := p.accept(","); ok {
+			continue
+		}
+		if _, err := p.expect(closing); err != nil {
+			return nil, err
+		}
+		return args, nil
+	}
+}
+
+// hasMacro turns has(x.f) into a test of whether x has the field f, like
+// CEL's has() macro.
+func hasMacro(pos Pos, args []node) (node, error) {
+	if len(args) == 1 {
+		if sel, ok := args[0].(*selectNode); ok {
+			return &selectNode{at: pos, operand: sel.operand, field: sel.field, test: true}, nil
+		}
+	}
+	return nil, errorf(pos, "has() takes a field selection, such as has(resource.attributes.owner)")
+}
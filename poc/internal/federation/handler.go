@@ -0,0 +1,383 @@
+package federation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
+)
+
+type Handler struct {
+	service     *Service
+	authService *auth.Service
+	auditLogger *audit.Logger
+	cfg         *config.AuthConfig
+}
+
+func NewHandler(service *Service, authService *auth.Service, auditLogger *audit.Logger, cfg *config.AuthConfig) *Handler {
+	return &Handler{service: service, authService: authService, auditLogger: auditLogger, cfg: cfg}
+}
+
+const nonceCookieName = "bastion_idp_nonce"
+
+// StartSignIn opens a sign-in at a connection's IdP. The returned nonce goes
+// into the client's authentication request to the IdP; it is also set as a
+// cookie scoped to the connection's callback, so that only the browser that
+// started the sign-in can complete it.
+func (h *Handler) StartSignIn(w http.ResponseWriter, r *http.Request) {
+	connectionID := chi.URLParam(r, "connectionId")
+
+	nonce, err := h.service.StartSignIn(connectionID)
+	if err != nil {
+		writeSignInError(w, err)
+		return
+	}
+
+	// The IdP's form_post response is a cross-site POST, which carries
+	// the cookie only with SameSite=None, and browsers accept that only
+	// on secure cookies.
+	sameSite := http.SameSiteLaxMode
+	if h.cfg.SessionCookieSecure {
+		sameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     nonceCookieName,
+		Value:    nonce,
+		Path:     "/api/v1/auth/federation/" + connectionID + "/",
+		MaxAge:   int(SignInRequestTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.cfg.SessionCookieSecure,
+		SameSite: sameSite,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"nonce":      nonce,
+		"expires_in": int(SignInRequestTTL.Seconds()),
+	})
+}
+
+// Callback completes a sign-in at a connection's IdP. The IdP, or the
+// client that ran the OpenID Connect flow, posts the ID token either as
+// JSON or as a form (response_mode=form_post). The token must carry the
+// nonce of a sign-in opened with StartSignIn, read from the nonce cookie
+// or, for JSON requests, which cannot be forged cross-site, from the body.
+// The token is verified, the user is provisioned and their mapped roles
+// reconciled, and a session is issued in the connection's tenant.
+func (h *Handler) Callback(w http.ResponseWriter, r *http.Request) {
+	connectionID := chi.URLParam(r, "connectionId")
+
+	var idToken, nonce string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		idToken = r.PostFormValue("id_token")
+	} else {
+		var req struct {
+			IDToken string `json:"id_token"`
+			Nonce   string `json:"nonce"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		idToken, nonce = req.IDToken, req.Nonce
+	}
+	if cookie, err := r.Cookie(nonceCookieName); err == nil {
+		nonce = cookie.Value
+	}
+	if idToken == "" {
+		writeError(w, "id_token required", http.StatusBadRequest)
+		return
+	}
+	if nonce == "" {
+		writeError(w, "sign-in was not started", http.StatusBadRequest)
+		return
+	}
+
+	u, conn, err := h.service.SignIn(r.Context(), connectionID, idToken, nonce, r.RemoteAddr)
+	if err != nil {
+		h.auditLogger.LogContext(r.Context(), "login_failure", "", map[string]interface{}{
+			"method":        "federated",
+			"connection_id": connectionID,
+			"error":         err.Error(),
+		}, r.RemoteAddr)
+		writeSignInError(w, err)
+		return
+	}
+
+	sess, err := h.authService.IssueSession(u.ID, u.Email, &conn.TenantID, "", "", time.Now())
+	if err != nil {
+		writeError(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "login_success", u.ID, map[string]interface{}{
+		"method":        "federated",
+		"connection_id": conn.ID,
+	}, r.RemoteAddr)
+
+	h.authService.SetSessionCookie(w, sess)
+	http.SetCookie(w, &http.Cookie{
+		Name:     nonceCookieName,
+		Path:     "/api/v1/auth/federation/" + connectionID + "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.cfg.SessionCookieSecure,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(auth.LoginResponse{
+		AccessToken:  sess.AccessToken,
+		RefreshToken: sess.RefreshToken,
+		ExpiresIn:    int(h.cfg.AccessTokenTTL.Seconds()),
+		TenantID:     sess.TenantID,
+	})
+}
+
+func writeSignInError(w http.ResponseWriter, err error) {
+	var linkRequired *LinkRequiredError
+	switch {
+	case errors.Is(err, ErrConnectionNotFound):
+		writeError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidAssertion):
+		writeError(w, "invalid idp assertion", http.StatusUnauthorized)
+	case errors.Is(err, ErrSignInRejected):
+		writeError(w, err.Error(), http.StatusForbidden)
+	case errors.As(err, &linkRequired):
+		// The ticket is redeemed through POST /users/me/identities once
+		// the user has signed in to the existing account another way.
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":       linkRequired.Error(),
+			"link_ticket": linkRequired.Ticket,
+		})
+	default:
+		writeError(w, "federated sign-in failed", http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) CreateConnection(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name           string   `json:"name"`
+		Issuer         string   `json:"issuer"`
+		ClientID       string   `json:"client_id"`
+		JWKSURI        *string  `json:"jwks_uri"`
+		TenantID       string   `json:"tenant_id"`
+		JITEnabled     bool     `json:"jit_enabled"`
+		AllowedDomains []string `json:"allowed_domains"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Tenant-bound callers create connections for their own tenant.
+	caller, _ := principal.FromContext(r.Context())
+	if caller.TenantID != nil {
+		if req.TenantID != "" && req.TenantID != *caller.TenantID {
+			writeError(w, "token is bound to another tenant", http.StatusForbidden)
+			return
+		}
+		req.TenantID = *caller.TenantID
+	}
+
+	conn, err := h.service.CreateConnection(req.Name, req.Issuer, req.ClientID, req.JWKSURI, req.TenantID, req.JITEnabled, req.AllowedDomains)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "idp_connection.created", caller.UserID(), map[string]interface{}{
+		"connection_id": conn.ID,
+		"issuer":        conn.Issuer,
+		"tenant_id":     conn.TenantID,
+		"jit_enabled":   conn.JITEnabled,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(conn)
+}
+
+func (h *Handler) ListConnections(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principal.FromContext(r.Context())
+
+	connections, err := h.service.ListConnections(caller.TenantID)
+	if err != nil {
+		writeError(w, "failed to list idp connections", http.StatusInternalServerError)
+		return
+	}
+
+	if connections == nil {
+		connections = []*Connection{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"connections": connections})
+}
+
+func (h *Handler) GetConnection(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	caller, _ := principal.FromContext(r.Context())
+
+	conn, err := h.service.GetConnection(id, caller.TenantID)
+	if err != nil {
+		writeError(w, "idp connection not found", http.StatusNotFound)
+		return
+	}
+
+	mappings, err := h.service.ListMappings(conn.ID)
+	if err != nil {
+		writeError(w, "failed to list role mappings", http.StatusInternalServerError)
+		return
+	}
+
+	if mappings == nil {
+		mappings = []*RoleMapping{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"connection":    conn,
+		"role_mappings": mappings,
+	})
+}
+
+func (h *Handler) UpdateConnection(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req struct {
+		Name           string   `json:"name"`
+		ClientID       string   `json:"client_id"`
+		JWKSURI        *string  `json:"jwks_uri"`
+		JITEnabled     bool     `json:"jit_enabled"`
+		AllowedDomains []string `json:"allowed_domains"`
+		Enabled        *bool    `json:"enabled"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	if err := h.service.UpdateConnection(caller.TenantID, id, req.Name, req.ClientID, req.JWKSURI, req.JITEnabled, req.AllowedDomains, enabled); err != nil {
+		writeConnectionError(w, err)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "idp_connection.updated", caller.UserID(), map[string]interface{}{
+		"connection_id":   id,
+		"client_id":       req.ClientID,
+		"jit_enabled":     req.JITEnabled,
+		"allowed_domains": req.AllowedDomains,
+		"enabled":         enabled,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) DeleteConnection(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	caller, _ := principal.FromContext(r.Context())
+	if err := h.service.DeleteConnection(caller.TenantID, id); err != nil {
+		if errors.Is(err, ErrConnectionNotFound) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeError(w, "failed to delete idp connection", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "idp_connection.deleted", caller.UserID(), map[string]interface{}{
+		"connection_id": id,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) CreateMapping(w http.ResponseWriter, r *http.Request) {
+	connectionID := chi.URLParam(r, "id")
+
+	var req struct {
+		Claim    string `json:"claim"`
+		Operator string `json:"operator"`
+		Value    string `json:"value"`
+		Role     string `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	mapping, err := h.service.CreateMapping(caller.TenantID, connectionID, req.Claim, req.Operator, req.Value, req.Role)
+	if err != nil {
+		writeConnectionError(w, err)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "idp_connection.mapping_created", caller.UserID(), map[string]interface{}{
+		"connection_id": connectionID,
+		"mapping_id":    mapping.ID,
+		"claim":         mapping.Claim,
+		"operator":      mapping.Operator,
+		"value":         mapping.Value,
+		"role_name":     mapping.RoleName,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(mapping)
+}
+
+func (h *Handler) DeleteMapping(w http.ResponseWriter, r *http.Request) {
+	connectionID := chi.URLParam(r, "id")
+	mappingID := chi.URLParam(r, "mappingId")
+
+	caller, _ := principal.FromContext(r.Context())
+	if err := h.service.DeleteMapping(caller.TenantID, connectionID, mappingID); err != nil {
+		if errors.Is(err, ErrConnectionNotFound) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeError(w, "failed to delete role mapping", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "idp_connection.mapping_deleted", caller.UserID(), map[string]interface{}{
+		"connection_id": connectionID,
+		"mapping_id":    mappingID,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeConnectionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrConnectionNotFound) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeError(w, err.Error(), http.StatusBadRequest)
+}
+
+func writeError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
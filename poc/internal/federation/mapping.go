@@ -0,0 +1,77 @@
+package federation
+
+import (
+	"fmt"
+	"strings"
+)
+
+var validOperators = map[string]bool{
+	"equals":   true,
+	"contains": true,
+	"exists":   true,
+}
+
+// Matches reports whether the mapping's rule holds for the given IdP claims.
+// Array claims (such as groups) match "contains" when any element equals the
+// value; string claims match when the value is a substring.
+func (m *RoleMapping) Matches(claims map[string]interface{}) bool {
+	claim, ok := claims[m.Claim]
+	if !ok || claim == nil {
+		return false
+	}
+
+	switch m.Operator {
+	case "exists":
+		return true
+	case "equals":
+		return claimString(claim) == m.Value
+	case "contains":
+		switch v := claim.(type) {
+		case []interface{}:
+			for _, item := range v {
+				if claimString(item) == m.Value {
+					return true
+				}
+			}
+			return false
+		case []string:
+			for _, item := range v {
+				if item == m.Value {
+					return true
+				}
+			}
+			return false
+		case string:
+			return strings.Contains(v, m.Value)
+		}
+	}
+
+	return false
+}
+
+func claimString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func emailDomainAllowed(email string, allowedDomains []string) bool {
+	if len(allowedDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at == -1 {
+		return false
+	}
+
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range allowedDomains {
+		if strings.ToLower(allowed) == domain {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,226 @@
+package federation
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Connection struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Issuer         string    `json:"issuer"`
+	ClientID       string    `json:"client_id"`
+	JWKSURI        *string   `json:"jwks_uri,omitempty"`
+	TenantID       string    `json:"tenant_id"`
+	JITEnabled     bool      `json:"jit_enabled"`
+	AllowedDomains []string  `json:"allowed_domains"`
+	Enabled        bool      `json:"enabled"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type RoleMapping struct {
+	ID           string    `json:"id"`
+	ConnectionID string    `json:"connection_id"`
+	Claim        string    `json:"claim"`
+	Operator     string    `json:"operator"`
+	Value        string    `json:"value"`
+	RoleID       string    `json:"role_id"`
+	RoleName     string    `json:"role_name"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+var ErrConnectionNotFound = errors.New("idp connection not found")
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+const connectionColumns = `id, name, issuer, client_id, jwks_uri, tenant_id, jit_enabled, allowed_domains, enabled, created_at, updated_at`
+
+func scanConnection(row interface{ Scan(...interface{}) error }) (*Connection, error) {
+	c := &Connection{}
+	err := row.Scan(&c.ID, &c.Name, &c.Issuer, &c.ClientID, &c.JWKSURI, &c.TenantID, &c.JITEnabled, pq.Array(&c.AllowedDomains), &c.Enabled, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *Repository) CreateConnection(name, issuer, clientID string, jwksURI *string, tenantID string, jitEnabled bool, allowedDomains []string) (*Connection, error) {
+	if allowedDomains == nil {
+		allowedDomains = []string{}
+	}
+
+	c, err := scanConnection(r.db.QueryRow(
+		`INSERT INTO idp_connections (name, issuer, client_id, jwks_uri, tenant_id, jit_enabled, allowed_domains)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING `+connectionColumns,
+		name, issuer, clientID, jwksURI, tenantID, jitEnabled, pq.Array(allowedDomains),
+	))
+
+	if err != nil {
+		return nil, fmt.Errorf("create idp connection: %w", err)
+	}
+
+	return c, nil
+}
+
+func (r *Repository) GetConnection(id string) (*Connection, error) {
+	c, err := scanConnection(r.db.QueryRow(
+		`SELECT `+connectionColumns+` FROM idp_connections WHERE id = $1`,
+		id,
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrConnectionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get idp connection: %w", err)
+	}
+
+	return c, nil
+}
+
+func (r *Repository) ListConnections(tenantID *string) ([]*Connection, error) {
+	var rows *sql.Rows
+	var err error
+
+	if tenantID == nil {
+		rows, err = r.db.Query(
+			`SELECT ` + connectionColumns + ` FROM idp_connections ORDER BY name`,
+		)
+	} else {
+		rows, err = r.db.Query(
+			`SELECT `+connectionColumns+` FROM idp_connections WHERE tenant_id = $1 ORDER BY name`,
+			*tenantID,
+		)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("list idp connections: %w", err)
+	}
+	defer rows.Close()
+
+	var connections []*Connection
+	for rows.Next() {
+		c, err := scanConnection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan idp connection: %w", err)
+		}
+		connections = append(connections, c)
+	}
+
+	return connections, rows.Err()
+}
+
+func (r *Repository) UpdateConnection(id, name, clientID string, jwksURI *string, jitEnabled bool, allowedDomains []string, enabled bool) error {
+	if allowedDomains == nil {
+		allowedDomains = []string{}
+	}
+
+	_, err := r.db.Exec(
+		`UPDATE idp_connections
+		 SET name = $1, client_id = $2, jwks_uri = $3, jit_enabled = $4, allowed_domains = $5, enabled = $6, updated_at = NOW()
+		 WHERE id = $7`,
+		name, clientID, jwksURI, jitEnabled, pq.Array(allowedDomains), enabled, id,
+	)
+	if err != nil {
+		return fmt.Errorf("update idp connection: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) DeleteConnection(id string) error {
+	_, err := r.db.Exec(`DELETE FROM idp_connections WHERE id = $1`, id)
+	return err
+}
+
+func (r *Repository) CreateMapping(connectionID, claim, operator, value, roleID string) (*RoleMapping, error) {
+	m := &RoleMapping{}
+	err := r.db.QueryRow(
+		`WITH m AS (
+			INSERT INTO idp_role_mappings (connection_id, claim, operator, value, role_id)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, connection_id, claim, operator, value, role_id, created_at
+		 )
+		 SELECT m.id, m.connection_id, m.claim, m.operator, m.value, m.role_id, r.name, m.created_at
+		 FROM m JOIN roles r ON r.id = m.role_id`,
+		connectionID, claim, operator, value, roleID,
+	).Scan(&m.ID, &m.ConnectionID, &m.Claim, &m.Operator, &m.Value, &m.RoleID, &m.RoleName, &m.CreatedAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("create role mapping: %w", err)
+	}
+
+	return m, nil
+}
+
+func (r *Repository) ListMappings(connectionID string) ([]*RoleMapping, error) {
+	rows, err := r.db.Query(
+		`SELECT m.id, m.connection_id, m.claim, m.operator, m.value, m.role_id, r.name, m.created_at
+		 FROM idp_role_mappings m
+		 JOIN roles r ON r.id = m.role_id
+		 WHERE m.connection_id = $1
+		 ORDER BY m.created_at`,
+		connectionID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list role mappings: %w", err)
+	}
+	defer rows.Close()
+
+	var mappings []*RoleMapping
+	for rows.Next() {
+		m := &RoleMapping{}
+		if err := rows.Scan(&m.ID, &m.ConnectionID, &m.Claim, &m.Operator, &m.Value, &m.RoleID, &m.RoleName, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan role mapping: %w", err)
+		}
+		mappings = append(mappings, m)
+	}
+
+	return mappings, rows.Err()
+}
+
+func (r *Repository) DeleteMapping(connectionID, mappingID string) error {
+	_, err := r.db.Exec(
+		`DELETE FROM idp_role_mappings WHERE id = $1 AND connection_id = $2`,
+		mappingID, connectionID,
+	)
+	return err
+}
+
+func (r *Repository) CreateSignInRequest(nonceHash, connectionID string, expiresAt time.Time) error {
+	_, err := r.db.Exec(
+		`INSERT INTO idp_sign_in_requests (nonce_hash, connection_id, expires_at) VALUES ($1, $2, $3)`,
+		nonceHash, connectionID, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("create sign-in request: %w", err)
+	}
+	return nil
+}
+
+// ConsumeSignInRequest marks an open sign-in request of a connection as
+// completed. It reports false when there is none with that nonce, so that
+// each request completes at most once.
+func (r *Repository) ConsumeSignInRequest(nonceHash, connectionID string) (bool, error) {
+	result, err := r.db.Exec(
+		`UPDATE idp_sign_in_requests SET consumed_at = NOW()
+		 WHERE nonce_hash = $1 AND connection_id = $2 AND consumed_at IS NULL AND expires_at > NOW()`,
+		nonceHash, connectionID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("consume sign-in request: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
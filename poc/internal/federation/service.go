@@ -0,0 +1,361 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/identity"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/rbac"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/user"
+)
+
+// ExternalIdentity is the verified result of a sign-in at an external IdP.
+type ExternalIdentity struct {
+	Subject string
+	Email   string
+	Claims  map[string]interface{}
+}
+
+type Service struct {
+	repo        *Repository
+	verifier    *verifier
+	users       *user.Service
+	identities  *identity.Service
+	rbac        *rbac.Service
+	auditLogger *audit.Logger
+}
+
+func NewService(repo *Repository, users *user.Service, identities *identity.Service, rbacService *rbac.Service, auditLogger *audit.Logger) *Service {
+	return &Service{
+		repo:        repo,
+		verifier:    newVerifier(),
+		users:       users,
+		identities:  identities,
+		rbac:        rbacService,
+		auditLogger: auditLogger,
+	}
+}
+
+func (s *Service) CreateConnection(name, issuer, clientID string, jwksURI *string, tenantID string, jitEnabled bool, allowedDomains []string) (*Connection, error) {
+	if name == "" {
+		return nil, fmt.Errorf("name required")
+	}
+	if issuer == "" {
+		return nil, fmt.Errorf("issuer required")
+	}
+	if clientID == "" {
+		return nil, fmt.Errorf("client ID required")
+	}
+	if tenantID == "" {
+		return nil, fmt.Errorf("tenant ID required")
+	}
+
+	return s.repo.CreateConnection(name, issuer, clientID, jwksURI, tenantID, jitEnabled, allowedDomains)
+}
+
+// GetConnection returns a connection visible in scope: any connection when
+// scope is nil, otherwise only the tenant's own.
+func (s *Service) GetConnection(id string, scope *string) (*Connection, error) {
+	conn, err := s.repo.GetConnection(id)
+	if err != nil {
+		return nil, err
+	}
+	if scope != nil && conn.TenantID != *scope {
+		return nil, ErrConnectionNotFound
+	}
+	return conn, nil
+}
+
+func (s *Service) ListConnections(tenantID *string) ([]*Connection, error) {
+	return s.repo.ListConnections(tenantID)
+}
+
+func (s *Service) UpdateConnection(scope *string, id, name, clientID string, jwksURI *string, jitEnabled bool, allowedDomains []string, enabled bool) error {
+	if name == "" {
+		return fmt.Errorf("name required")
+	}
+	if clientID == "" {
+		return fmt.Errorf("client ID required")
+	}
+	if _, err := s.GetConnection(id, scope); err != nil {
+		return err
+	}
+	return s.repo.UpdateConnection(id, name, clientID, jwksURI, jitEnabled, allowedDomains, enabled)
+}
+
+func (s *Service) DeleteConnection(scope *string, id string) error {
+	if _, err := s.GetConnection(id, scope); err != nil {
+		return err
+	}
+	return s.repo.DeleteConnection(id)
+}
+
+func (s *Service) CreateMapping(scope *string, connectionID, claim, operator, value, roleName string) (*RoleMapping, error) {
+	if claim == "" {
+		return nil, fmt.Errorf("claim required")
+	}
+	if !validOperators[operator] {
+		return nil, fmt.Errorf("operator must be one of equals, contains, exists")
+	}
+	if operator != "exists" && value == "" {
+		return nil, fmt.Errorf("value required")
+	}
+
+	conn, err := s.GetConnection(connectionID, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := s.rbac.GetRoleByName(roleName)
+	if err != nil {
+		return nil, fmt.Errorf("get role: %w", err)
+	}
+	// Mapped roles are granted to anyone the IdP vouches for, so they are
+	// limited to application roles and the connection tenant's own roles.
+	switch role.RoleType {
+	case rbac.RoleTypeApplication:
+	case rbac.RoleTypeTenant:
+		if role.TenantID == nil || *role.TenantID != conn.TenantID {
+			return nil, fmt.Errorf("get role: %w", rbac.ErrRoleNotFound)
+		}
+	default:
+		return nil, fmt.Errorf("only application roles and the connection tenant's roles can be mapped")
+	}
+
+	return s.repo.CreateMapping(connectionID, claim, operator, value, role.ID)
+}
+
+func (s *Service) ListMappings(connectionID string) ([]*RoleMapping, error) {
+	return s.repo.ListMappings(connectionID)
+}
+
+func (s *Service) DeleteMapping(scope *string, connectionID, mappingID string) error {
+	if _, err := s.GetConnection(connectionID, scope); err != nil {
+		return err
+	}
+	return s.repo.DeleteMapping(connectionID, mappingID)
+}
+
+// ErrSignInRejected is returned when a verified external identity may not
+// sign in through a connection.
+var ErrSignInRejected = errors.New("federated sign-in rejected")
+
+// LinkRequiredError is returned when a federated login has no linked
+// identity but its email matches an existing account. The ticket lets the
+// account owner attach the identity after signing in with an existing method.
+type LinkRequiredError struct {
+	Ticket string
+}
+
+func (e *LinkRequiredError) Error() string {
+	return "identity must be linked to existing account"
+}
+
+// SignInRequestTTL bounds the time between starting a federated sign-in
+// and completing it at the callback.
+const SignInRequestTTL = 10 * time.Minute
+
+// StartSignIn opens a sign-in request at an enabled connection and returns
+// its nonce, which the client sends to the IdP in the authentication
+// request so that the ID token is bound to this sign-in.
+func (s *Service) StartSignIn(connectionID string) (string, error) {
+	conn, err := s.repo.GetConnection(connectionID)
+	if err != nil {
+		return "", err
+	}
+	if !conn.Enabled {
+		return "", fmt.Errorf("%w: idp connection disabled", ErrSignInRejected)
+	}
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(bytes)
+
+	if err := s.repo.CreateSignInRequest(hashNonce(nonce), conn.ID, time.Now().Add(SignInRequestTTL)); err != nil {
+		return "", err
+	}
+	return nonce, nil
+}
+
+// SignIn verifies an ID token issued by a connection's IdP for the sign-in
+// request started with nonce and provisions the user it identifies. It is
+// called by the federated sign-in callback.
+func (s *Service) SignIn(ctx context.Context, connectionID, idToken, nonce, ipAddress string) (*user.User, *Connection, error) {
+	conn, err := s.repo.GetConnection(connectionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !conn.Enabled {
+		return nil, nil, fmt.Errorf("%w: idp connection disabled", ErrSignInRejected)
+	}
+
+	ext, err := s.verifier.Verify(ctx, conn, idToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	claimed, _ := ext.Claims["nonce"].(string)
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claimed), []byte(nonce)) != 1 {
+		return nil, nil, fmt.Errorf("%w: nonce does not match the sign-in request", ErrInvalidAssertion)
+	}
+	open, err := s.repo.ConsumeSignInRequest(hashNonce(nonce), conn.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !open {
+		return nil, nil, fmt.Errorf("%w: sign-in request expired or already completed", ErrInvalidAssertion)
+	}
+
+	u, err := s.provision(conn, ext, ipAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, conn, nil
+}
+
+// provision resolves the Bastion user for a verified external identity
+// through its linked identity, creating the user in the connection's
+// tenant on first login when JIT provisioning is enabled, and then
+// reconciles the user's mapped roles against the presented claims.
+func (s *Service) provision(conn *Connection, ext *ExternalIdentity, ipAddress string) (*user.User, error) {
+	provider := identity.ProviderForConnection(conn.ID)
+	email := strings.ToLower(ext.Email)
+
+	var u *user.User
+	linked, err := s.identities.Resolve(provider, ext.Subject)
+	switch {
+	case err == nil:
+		u, err = s.users.GetByID(linked.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("get linked user: %w", err)
+		}
+	case errors.Is(err, identity.ErrIdentityNotFound):
+		if email == "" {
+			return nil, fmt.Errorf("%w: verified email claim required", ErrSignInRejected)
+		}
+
+		if _, err := s.users.GetByEmail(email); err == nil {
+			ticket, err := s.identities.IssueLinkTicket(provider, ext.Subject, email, conn.TenantID)
+			if err != nil {
+				return nil, err
+			}
+			return nil, &LinkRequiredError{Ticket: ticket}
+		}
+
+		if !conn.JITEnabled {
+			return nil, fmt.Errorf("%w: user not provisioned", ErrSignInRejected)
+		}
+		if !emailDomainAllowed(email, conn.AllowedDomains) {
+			return nil, fmt.Errorf("%w: email domain not allowed for connection", ErrSignInRejected)
+		}
+
+		u, err = s.users.CreateFederatedUser(email, &conn.TenantID, provider, ext.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("provision user: %w", err)
+		}
+
+		s.auditLogger.Log("federation.user_provisioned", u.ID, map[string]interface{}{
+			"connection_id": conn.ID,
+			"tenant_id":     conn.TenantID,
+			"subject":       ext.Subject,
+			"email":         email,
+		}, ipAddress)
+	default:
+		return nil, err
+	}
+
+	if u.TenantID == nil || *u.TenantID != conn.TenantID {
+		return nil, fmt.Errorf("%w: user belongs to another tenant", ErrSignInRejected)
+	}
+
+	if err := s.SyncRoles(conn, u.ID, ext.Claims, ipAddress); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// SyncRoles grants every role whose mapping matches the claims and revokes
+// roles that are referenced by the connection's mappings but no longer match.
+// Roles that no mapping references are left untouched, so manual assignments
+// survive federated logins.
+func (s *Service) SyncRoles(conn *Connection, userID string, claims map[string]interface{}, ipAddress string) error {
+	mappings, err := s.repo.ListMappings(conn.ID)
+	if err != nil {
+		return err
+	}
+
+	managed := make(map[string]*RoleMapping)
+	desired := make(map[string]*RoleMapping)
+	for _, m := range mappings {
+		if _, ok := managed[m.RoleName]; !ok {
+			managed[m.RoleName] = m
+		}
+		if _, ok := desired[m.RoleName]; !ok && m.Matches(claims) {
+			desired[m.RoleName] = m
+		}
+	}
+
+	current, err := s.rbac.GetUserRoles(userID, &conn.TenantID)
+	if err != nil {
+		return fmt.Errorf("get user roles: %w", err)
+	}
+
+	held := make(map[string]bool)
+	for _, ur := range current {
+		if ur.InheritedFrom == nil && ur.TenantID != nil && *ur.TenantID == conn.TenantID {
+			held[ur.RoleName] = true
+		}
+	}
+
+	for roleName, m := range desired {
+		if held[roleName] {
+			continue
+		}
+		if err := s.rbac.AssignRole(context.Background(), &conn.TenantID, userID, roleName, &conn.TenantID, nil); err != nil {
+			return fmt.Errorf("grant mapped role: %w", err)
+		}
+		s.auditLogger.Log("federation.role_granted", userID, map[string]interface{}{
+			"connection_id": conn.ID,
+			"mapping_id":    m.ID,
+			"role_name":     roleName,
+			"tenant_id":     conn.TenantID,
+		}, ipAddress)
+	}
+
+	for roleName, m := range managed {
+		if !held[roleName] {
+			continue
+		}
+		if _, ok := desired[roleName]; ok {
+			continue
+		}
+		if err := s.rbac.RevokeRole(context.Background(), &conn.TenantID, userID, roleName, &conn.TenantID); err != nil {
+			return fmt.Errorf("revoke mapped role: %w", err)
+		}
+		s.auditLogger.Log("federation.role_revoked", userID, map[string]interface{}{
+			"connection_id": conn.ID,
+			"mapping_id":    m.ID,
+			"role_name":     roleName,
+			"tenant_id":     conn.TenantID,
+		}, ipAddress)
+	}
+
+	return nil
+}
+
+func hashNonce(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(sum[:])
+}
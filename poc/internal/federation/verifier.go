@@ -0,0 +1,246 @@
+package federation
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	keyCacheTTL      = 10 * time.Minute
+	minRefreshPeriod = 30 * time.Second
+)
+
+var ErrInvalidAssertion = errors.New("invalid idp assertion")
+
+// verifier checks OIDC ID tokens issued by IdP connections against the
+// signing keys each IdP publishes. Key sets are cached per JWKS URI; a
+// token naming an unknown key triggers a refetch, rate limited so that
+// bogus key IDs cannot make every sign-in hit the IdP.
+type verifier struct {
+	httpClient *http.Client
+
+	mu   sync.Mutex
+	sets map[string]*keySet
+}
+
+type keySet struct {
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+func newVerifier() *verifier {
+	return &verifier{
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		sets:       make(map[string]*keySet),
+	}
+}
+
+// Verify checks an ID token's signature, issuer, audience and expiry for
+// a connection and returns the external identity it asserts. The email is
+// left out unless the IdP asserts that it is verified, so that an
+// unverified address can be used neither to provision nor to match
+// existing accounts.
+func (v *verifier) Verify(ctx context.Context, conn *Connection, idToken string) (*ExternalIdentity, error) {
+	if conn.ClientID == "" {
+		return nil, fmt.Errorf("%w: connection has no client ID configured", ErrInvalidAssertion)
+	}
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "EdDSA"}),
+		jwt.WithIssuer(conn.Issuer),
+		jwt.WithAudience(conn.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(ctx, conn, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAssertion, err)
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: subject claim required", ErrInvalidAssertion)
+	}
+
+	ext := &ExternalIdentity{Subject: subject, Claims: claims}
+	if email, ok := claims["email"].(string); ok {
+		if verified, _ := claims["email_verified"].(bool); verified {
+			ext.Email = email
+		}
+	}
+	return ext, nil
+}
+
+// key returns the connection's signing key with the given ID. A token
+// without a key ID is accepted only when the IdP publishes a single key.
+func (v *verifier) key(ctx context.Context, conn *Connection, kid string) (crypto.PublicKey, error) {
+	jwksURI, err := v.jwksURI(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	set, ok := v.sets[jwksURI]
+	if !ok {
+		set = &keySet{}
+		v.sets[jwksURI] = set
+	}
+
+	if key, ok := set.lookup(kid); ok && time.Since(set.fetchedAt) < keyCacheTTL {
+		return key, nil
+	}
+	if time.Since(set.lastAttempt) < minRefreshPeriod {
+		if key, ok := set.lookup(kid); ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	set.lastAttempt = time.Now()
+	keys, err := v.fetchKeys(ctx, jwksURI)
+	if err != nil {
+		if key, ok := set.lookup(kid); ok {
+			return key, nil
+		}
+		return nil, err
+	}
+	set.keys = keys
+	set.fetchedAt = time.Now()
+
+	if key, ok := set.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (s *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// jwksURI returns the connection's configured JWKS URI, or the one its
+// issuer advertises through OpenID Connect discovery.
+func (v *verifier) jwksURI(ctx context.Context, conn *Connection) (string, error) {
+	if conn.JWKSURI != nil && *conn.JWKSURI != "" {
+		return *conn.JWKSURI, nil
+	}
+
+	var doc struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	discovery := strings.TrimSuffix(conn.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := v.getJSON(ctx, discovery, &doc); err != nil {
+		return "", fmt.Errorf("discover idp keys: %w", err)
+	}
+	if doc.Issuer != conn.Issuer || doc.JWKSURI == "" {
+		return "", fmt.Errorf("discover idp keys: discovery document does not match issuer")
+	}
+	return doc.JWKSURI, nil
+}
+
+func (v *verifier) fetchKeys(ctx context.Context, jwksURI string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			Curve   string `json:"crv"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+			X       string `json:"x"`
+			Y       string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := v.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("fetch idp keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		switch {
+		case k.KeyType == "RSA":
+			n, errN := decodeBigInt(k.N)
+			e, errE := decodeBigInt(k.E)
+			if errN != nil || errE != nil || !e.IsInt64() {
+				continue
+			}
+			key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case k.KeyType == "EC" && k.Curve == "P-256":
+			x, errX := decodeBigInt(k.X)
+			y, errY := decodeBigInt(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		case k.KeyType == "OKP" && k.Curve == "Ed25519":
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				continue
+			}
+			key = ed25519.PublicKey(x)
+		default:
+			continue
+		}
+		keys[k.KeyID] = key
+	}
+	return keys, nil
+}
+
+func (v *verifier) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,146 @@
+package identity
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
+)
+
+type Handler struct {
+	service     *Service
+	auditLogger *audit.Logger
+	cfg         *config.AuthConfig
+}
+
+func NewHandler(service *Service, auditLogger *audit.Logger, cfg *config.AuthConfig) *Handler {
+	return &Handler{service: service, auditLogger: auditLogger, cfg: cfg}
+}
+
+func (h *Handler) ListMyIdentities(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+
+	identities, err := h.service.List(claims.UserID)
+	if err != nil {
+		writeError(w, "failed to list identities", http.StatusInternalServerError)
+		return
+	}
+
+	if identities == nil {
+		identities = []*Identity{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"identities": identities})
+}
+
+// LinkIdentity attaches a new login identity to the current user. The body
+// carries either a link ticket from a federated login or a local password to
+// add. Requires fresh authentication.
+func (h *Handler) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	if !claims.IsFresh(h.cfg) {
+		writeError(w, "fresh authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		LinkTicket string `json:"link_ticket"`
+		Provider   string `json:"provider"`
+		Password   string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var identity *Identity
+	var err error
+	switch {
+	case req.LinkTicket != "":
+		identity, err = h.service.LinkWithTicket(claims.UserID, req.LinkTicket)
+	case req.Provider == ProviderLocal:
+		identity, err = h.service.LinkPassword(claims.UserID, claims.Email, req.Password)
+	default:
+		writeError(w, "link_ticket or local provider required", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		h.auditLogger.LogContext(r.Context(), "identity.link_failed", claims.UserID, map[string]interface{}{
+			"error": err.Error(),
+		}, r.RemoteAddr)
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "identity.linked", claims.UserID, map[string]interface{}{
+		"identity_id": identity.ID,
+		"provider":    identity.Provider,
+		"subject":     identity.Subject,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(identity)
+}
+
+func (h *Handler) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	if !claims.IsFresh(h.cfg) {
+		writeError(w, "fresh authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	if err := h.service.Unlink(claims.UserID, id); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "identity.unlinked", claims.UserID, map[string]interface{}{
+		"identity_id": id,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MergeUsers folds a duplicate account into the target user identified in the
+// URL. All of the source account's identities and roles move to the target.
+func (h *Handler) MergeUsers(w http.ResponseWriter, r *http.Request) {
+	targetUserID := chi.URLParam(r, "userId")
+
+	var req struct {
+		SourceUserID string `json:"source_user_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.Merge(req.SourceUserID, targetUserID); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "user.merged", caller.UserID(), map[string]interface{}{
+		"source_user_id": req.SourceUserID,
+		"target_user_id": targetUserID,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
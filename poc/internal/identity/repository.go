@@ -0,0 +1,251 @@
+package identity
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrIdentityNotFound = errors.New("identity not found")
+
+type Identity struct {
+	ID             string     `json:"id"`
+	UserID         string     `json:"user_id"`
+	Provider       string     `json:"provider"`
+	Subject        string     `json:"subject"`
+	Email          *string    `json:"email,omitempty"`
+	CredentialHash *string    `json:"-"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+}
+
+type LinkTicket struct {
+	ID       string
+	Provider string
+	Subject  string
+	Email    *string
+	TenantID *string
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) Create(userID, provider, subject string, email, credentialHash *string) (*Identity, error) {
+	i := &Identity{}
+	err := r.db.QueryRow(
+		`INSERT INTO user_identities (user_id, provider, subject, email, credential_hash)
+		 VALUES ($1, $2, $3, $4, $5)
+		 RETURNING id, user_id, provider, subject, email, credential_hash, created_at, last_used_at`,
+		userID, provider, subject, email, credentialHash,
+	).Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CredentialHash, &i.CreatedAt, &i.LastUsedAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("create identity: %w", err)
+	}
+
+	return i, nil
+}
+
+func (r *Repository) GetByProviderSubject(provider, subject string) (*Identity, error) {
+	i := &Identity{}
+	err := r.db.QueryRow(
+		`SELECT id, user_id, provider, subject, email, credential_hash, created_at, last_used_at
+		 FROM user_identities WHERE provider = $1 AND subject = $2`,
+		provider, subject,
+	).Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CredentialHash, &i.CreatedAt, &i.LastUsedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get identity: %w", err)
+	}
+
+	return i, nil
+}
+
+func (r *Repository) ListByUser(userID string) ([]*Identity, error) {
+	rows, err := r.db.Query(
+		`SELECT id, user_id, provider, subject, email, credential_hash, created_at, last_used_at
+		 FROM user_identities WHERE user_id = $1
+		 ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list identities: %w", err)
+	}
+	defer rows.Close()
+
+	var identities []*Identity
+	for rows.Next() {
+		i := &Identity{}
+		if err := rows.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CredentialHash, &i.CreatedAt, &i.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("scan identity: %w", err)
+		}
+		identities = append(identities, i)
+	}
+
+	return identities, rows.Err()
+}
+
+// Delete removes one of a user's identities, refusing to remove the last one
+// so the account always keeps a way to sign in.
+func (r *Repository) Delete(userID, identityID string) error {
+	result, err := r.db.Exec(
+		`DELETE FROM user_identities
+		 WHERE id = $1 AND user_id = $2
+		 AND (SELECT COUNT(*) FROM user_identities WHERE user_id = $2) > 1`,
+		identityID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete identity: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete identity: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("identity not found or is the last remaining identity")
+	}
+
+	return nil
+}
+
+func (r *Repository) UpdateLastUsed(id string) error {
+	_, err := r.db.Exec(
+		`UPDATE user_identities SET last_used_at = NOW() WHERE id = $1`,
+		id,
+	)
+	return err
+}
+
+func (r *Repository) CreateLinkTicket(tokenHash, provider, subject string, email *string, tenantID string, expiresAt time.Time) error {
+	_, err := r.db.Exec(
+		`INSERT INTO identity_link_tickets (token_hash, provider, subject, email, tenant_id, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		tokenHash, provider, subject, email, tenantID, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("create link ticket: %w", err)
+	}
+	return nil
+}
+
+// ConsumeLinkTicket marks an unexpired ticket as used and returns it. A ticket
+// can only be consumed once.
+func (r *Repository) ConsumeLinkTicket(tokenHash string) (*LinkTicket, error) {
+	t := &LinkTicket{}
+	err := r.db.QueryRow(
+		`UPDATE identity_link_tickets SET consumed_at = NOW()
+		 WHERE token_hash = $1 AND consumed_at IS NULL AND expires_at > NOW()
+		 RETURNING id, provider, subject, email, tenant_id`,
+		tokenHash,
+	).Scan(&t.ID, &t.Provider, &t.Subject, &t.Email, &t.TenantID)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("invalid or expired link ticket")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("consume link ticket: %w", err)
+	}
+
+	return t, nil
+}
+
+// GetUserHome returns a user's email and home tenant.
+func (r *Repository) GetUserHome(userID string) (string, *string, error) {
+	var email string
+	var tenantID *string
+	err := r.db.QueryRow(
+		`SELECT email, tenant_id FROM users WHERE id = $1`,
+		userID,
+	).Scan(&email, &tenantID)
+
+	if err == sql.ErrNoRows {
+		return "", nil, fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("get user: %w", err)
+	}
+
+	return email, tenantID, nil
+}
+
+// Merge moves every identity, tenant membership and role assignment from the
+// source user to the target user, revokes the source user's sessions and
+// marks it as merged. Users of different home tenants cannot be merged, as
+// the target would gain the source's roles in a tenant it does not belong to.
+func (r *Repository) Merge(sourceUserID, targetUserID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin merge: %w", err)
+	}
+	defer tx.Rollback()
+
+	var merged, sourceTenantID, targetTenantID *string
+	err = tx.QueryRow(
+		`SELECT merged_into, tenant_id FROM users WHERE id = $1 FOR UPDATE`,
+		sourceUserID,
+	).Scan(&merged, &sourceTenantID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("source user not found")
+	}
+	if err != nil {
+		return fmt.Errorf("lock source user: %w", err)
+	}
+	if merged != nil {
+		return fmt.Errorf("source user already merged")
+	}
+
+	err = tx.QueryRow(
+		`SELECT merged_into, tenant_id FROM users WHERE id = $1 FOR UPDATE`,
+		targetUserID,
+	).Scan(&merged, &targetTenantID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("target user not found")
+	}
+	if err != nil {
+		return fmt.Errorf("lock target user: %w", err)
+	}
+	if merged != nil {
+		return fmt.Errorf("target user already merged")
+	}
+	if sourceTenantID != nil && targetTenantID != nil && *sourceTenantID != *targetTenantID {
+		return fmt.Errorf("cannot merge users of different tenants")
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE user_identities SET user_id = $2 WHERE user_id = $1`, []interface{}{sourceUserID, targetUserID}},
+		{`INSERT INTO tenant_memberships (user_id, tenant_id, last_used_at, created_at)
+		  SELECT $2, tenant_id, last_used_at, created_at FROM tenant_memberships WHERE user_id = $1
+		  ON CONFLICT DO NOTHING`, []interface{}{sourceUserID, targetUserID}},
+		{`INSERT INTO user_roles (user_id, role_id, tenant_id, granted_by, granted_at)
+		  SELECT $2, role_id, tenant_id, granted_by, granted_at FROM user_roles WHERE user_id = $1
+		  ON CONFLICT DO NOTHING`, []interface{}{sourceUserID, targetUserID}},
+		{`DELETE FROM user_roles WHERE user_id = $1`, []interface{}{sourceUserID}},
+		{`UPDATE sessions SET revoked = TRUE WHERE user_id = $1 AND revoked = FALSE`, []interface{}{sourceUserID}},
+		{`UPDATE users SET merged_into = $2, updated_at = NOW() WHERE id = $1`, []interface{}{sourceUserID, targetUserID}},
+	}
+
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return fmt.Errorf("merge users: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit merge: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	ProviderLocal = "local"
+
+	linkTicketTTL = 10 * time.Minute
+)
+
+// ProviderForConnection returns the identity provider name used for logins
+// through a federated IdP connection.
+func ProviderForConnection(connectionID string) string {
+	return "idp:" + connectionID
+}
+
+type Service struct {
+	repo *Repository
+}
+
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo}
+}
+
+// Resolve finds the identity for a provider subject and records its use.
+func (s *Service) Resolve(provider, subject string) (*Identity, error) {
+	identity, err := s.repo.GetByProviderSubject(provider, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.UpdateLastUsed(identity.ID); err != nil {
+		return nil, fmt.Errorf("update last used: %w", err)
+	}
+
+	return identity, nil
+}
+
+func (s *Service) List(userID string) ([]*Identity, error) {
+	return s.repo.ListByUser(userID)
+}
+
+// IssueLinkTicket records a verified external identity that could not be
+// linked automatically. The returned ticket lets the owner of the matching
+// account in tenantID, the tenant of the IdP connection, attach it after
+// signing in.
+func (s *Service) IssueLinkTicket(provider, subject, email, tenantID string) (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("generate link ticket: %w", err)
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(bytes)
+
+	var emailPtr *string
+	if email != "" {
+		emailPtr = &email
+	}
+
+	if err := s.repo.CreateLinkTicket(hashTicket(ticket), provider, subject, emailPtr, tenantID, time.Now().Add(linkTicketTTL)); err != nil {
+		return "", err
+	}
+
+	return ticket, nil
+}
+
+// LinkWithTicket attaches the identity of a link ticket to userID. The
+// ticket is only honoured for the account it was issued for: the same email
+// and, as home tenant, the tenant of the IdP connection.
+func (s *Service) LinkWithTicket(userID, ticket string) (*Identity, error) {
+	if ticket == "" {
+		return nil, fmt.Errorf("link ticket required")
+	}
+
+	t, err := s.repo.ConsumeLinkTicket(hashTicket(ticket))
+	if err != nil {
+		return nil, err
+	}
+
+	email, tenantID, err := s.repo.GetUserHome(userID)
+	if err != nil {
+		return nil, err
+	}
+	if t.Email == nil || !strings.EqualFold(*t.Email, email) {
+		return nil, fmt.Errorf("link ticket was issued for another account")
+	}
+	if t.TenantID == nil || tenantID == nil || *t.TenantID != *tenantID {
+		return nil, fmt.Errorf("link ticket was issued for another tenant")
+	}
+
+	if existing, err := s.repo.GetByProviderSubject(t.Provider, t.Subject); err == nil {
+		if existing.UserID == userID {
+			return existing, nil
+		}
+		return nil, fmt.Errorf("identity already linked to another user")
+	}
+
+	return s.repo.Create(userID, t.Provider, t.Subject, t.Email, nil)
+}
+
+// LinkPassword adds a local email/password identity to an account that so
+// far signs in only through an external IdP.
+func (s *Service) LinkPassword(userID, email, password string) (*Identity, error) {
+	if email == "" {
+		return nil, fmt.Errorf("email required")
+	}
+	if password == "" {
+		return nil, fmt.Errorf("password required")
+	}
+
+	identities, err := s.repo.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range identities {
+		if i.Provider == ProviderLocal {
+			return nil, fmt.Errorf("user already has a local identity")
+		}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("hash password: %w", err)
+	}
+	hashStr := string(hash)
+
+	return s.repo.Create(userID, ProviderLocal, strings.ToLower(email), &email, &hashStr)
+}
+
+func (s *Service) Unlink(userID, identityID string) error {
+	return s.repo.Delete(userID, identityID)
+}
+
+func (s *Service) Merge(sourceUserID, targetUserID string) error {
+	if sourceUserID == "" || targetUserID == "" {
+		return fmt.Errorf("source and target user IDs required")
+	}
+	if sourceUserID == targetUserID {
+		return fmt.Errorf("cannot merge a user into itself")
+	}
+
+	return s.repo.Merge(sourceUserID, targetUserID)
+}
+
+func hashTicket(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return hex.EncodeToString(sum[:])
+}
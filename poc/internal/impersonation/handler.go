@@ -0,0 +1,94 @@
+package impersonation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/rbac"
+)
+
+type Handler struct {
+	service     *Service
+	auditLogger *audit.Logger
+}
+
+func NewHandler(service *Service, auditLogger *audit.Logger) *Handler {
+	return &Handler{service: service, auditLogger: auditLogger}
+}
+
+func (h *Handler) Impersonate(w http.ResponseWriter, r *http.Request) {
+	targetUserID := chi.URLParam(r, "userId")
+	claims, _ := auth.ClaimsFromContext(r.Context())
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	ctx := rbac.WithCheckContext(r.Context(), rbac.RequestCheckContext(r))
+	session, token, err := h.service.Start(ctx, claims, targetUserID, req.Reason, r.RemoteAddr)
+	if err != nil {
+		h.auditLogger.LogContext(r.Context(), "impersonation.denied", claims.UserID, map[string]interface{}{
+			"target_user_id": targetUserID,
+			"error":          err.Error(),
+		}, r.RemoteAddr)
+
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrForbidden) {
+			status = http.StatusForbidden
+		}
+		writeError(w, err.Error(), status)
+		return
+	}
+
+	// Recorded against the target so the event shows up in their history,
+	// with the admin as actor.
+	h.auditLogger.LogContext(audit.ContextWithActor(r.Context(), claims.UserID), "impersonation.started", targetUserID, map[string]interface{}{
+		"impersonation_id": session.ID,
+		"reason":           session.Reason,
+		"expires_at":       session.ExpiresAt,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  token,
+		"token_type":    "Bearer",
+		"expires_in":    int(time.Until(session.ExpiresAt).Seconds()),
+		"impersonation": session,
+	})
+}
+
+// ListMyImpersonations lets users see when and by whom they were
+// impersonated.
+func (h *Handler) ListMyImpersonations(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+
+	sessions, err := h.service.ListForTarget(claims.UserID)
+	if err != nil {
+		writeError(w, "failed to list impersonations", http.StatusInternalServerError)
+		return
+	}
+
+	if sessions == nil {
+		sessions = []*Session{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"impersonations": sessions})
+}
+
+func writeError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
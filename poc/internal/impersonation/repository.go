@@ -0,0 +1,92 @@
+package impersonation
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type Session struct {
+	ID           string    `json:"id"`
+	AdminID      string    `json:"admin_id"`
+	AdminEmail   string    `json:"admin_email"`
+	TargetUserID string    `json:"target_user_id"`
+	TenantID     *string   `json:"tenant_id,omitempty"`
+	Reason       string    `json:"reason"`
+	StartedAt    time.Time `json:"started_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) Create(adminID, targetUserID string, tenantID *string, reason, ipAddress string, expiresAt time.Time) (*Session, error) {
+	s := &Session{}
+	err := r.db.QueryRow(
+		`WITH s AS (
+			INSERT INTO impersonation_sessions (admin_id, target_user_id, tenant_id, reason, ip_address, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, admin_id, target_user_id, tenant_id, reason, started_at, expires_at
+		)
+		SELECT s.id, s.admin_id, u.email, s.target_user_id, s.tenant_id, s.reason, s.started_at, s.expires_at
+		FROM s JOIN users u ON u.id = s.admin_id`,
+		adminID, targetUserID, tenantID, reason, ipAddress, expiresAt,
+	).Scan(&s.ID, &s.AdminID, &s.AdminEmail, &s.TargetUserID, &s.TenantID, &s.Reason, &s.StartedAt, &s.ExpiresAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("create impersonation session: %w", err)
+	}
+
+	return s, nil
+}
+
+// ListForTarget returns the impersonation sessions started against a user,
+// most recent first.
+func (r *Repository) ListForTarget(targetUserID string) ([]*Session, error) {
+	rows, err := r.db.Query(
+		`SELECT s.id, s.admin_id, u.email, s.target_user_id, s.tenant_id, s.reason, s.started_at, s.expires_at
+		 FROM impersonation_sessions s
+		 JOIN users u ON u.id = s.admin_id
+		 WHERE s.target_user_id = $1
+		 ORDER BY s.started_at DESC`,
+		targetUserID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list impersonation sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		s := &Session{}
+		if err := rows.Scan(&s.ID, &s.AdminID, &s.AdminEmail, &s.TargetUserID, &s.TenantID, &s.Reason, &s.StartedAt, &s.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scan impersonation session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// HasPlatformRole reports whether a user holds any platform role. Platform
+// users cannot be impersonated.
+func (r *Repository) HasPlatformRole(userID string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM user_roles ur
+			JOIN roles ro ON ro.id = ur.role_id
+			WHERE ur.user_id = $1 AND ro.role_type = 'platform'
+		)`,
+		userID,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("check platform role: %w", err)
+	}
+	return exists, nil
+}
@@ -0,0 +1,96 @@
+package impersonation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/rbac"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/user"
+)
+
+const defaultTTL = 15 * time.Minute
+
+var ErrForbidden = errors.New("impersonation not permitted")
+
+type Service struct {
+	repo  *Repository
+	users *user.Service
+	rbac  *rbac.Service
+	cfg   *config.AuthConfig
+}
+
+func NewService(repo *Repository, users *user.Service, rbacService *rbac.Service, cfg *config.AuthConfig) *Service {
+	return &Service{repo: repo, users: users, rbac: rbacService, cfg: cfg}
+}
+
+// Start records an impersonation session and issues a token for the target
+// user. The admin needs bastion:user impersonate in the target's tenant,
+// and in their token's scope if it is narrowed, a recent login, and must
+// not already be acting for someone else. Users with
+// platform roles cannot be impersonated.
+func (s *Service) Start(ctx context.Context, admin *auth.Claims, targetUserID, reason, ipAddress string) (*Session, string, error) {
+	if reason == "" {
+		return nil, "", fmt.Errorf("reason required")
+	}
+	if admin.IdentityType != "user" || admin.Act != nil {
+		return nil, "", fmt.Errorf("%w: delegated tokens cannot impersonate", ErrForbidden)
+	}
+	if !admin.Principal().HasScope("bastion:user", "impersonate") {
+		return nil, "", fmt.Errorf("%w: token scope does not include bastion:user:impersonate", ErrForbidden)
+	}
+	if !admin.IsFresh(s.cfg) {
+		return nil, "", fmt.Errorf("%w: recent authentication required", ErrForbidden)
+	}
+	if admin.UserID == targetUserID {
+		return nil, "", fmt.Errorf("cannot impersonate yourself")
+	}
+
+	target, err := s.users.GetByID(targetUserID)
+	if err != nil {
+		return nil, "", fmt.Errorf("user not found")
+	}
+
+	allowed, denyReason, err := s.rbac.CheckPermission(ctx, admin.UserID, target.TenantID, "bastion:user", "impersonate")
+	if err != nil {
+		return nil, "", err
+	}
+	if !allowed {
+		return nil, "", fmt.Errorf("%w: no impersonate permission for the user's tenant (%s)", ErrForbidden, denyReason)
+	}
+
+	privileged, err := s.repo.HasPlatformRole(target.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	if privileged {
+		return nil, "", fmt.Errorf("%w: platform users cannot be impersonated", ErrForbidden)
+	}
+
+	session, err := s.repo.Create(admin.UserID, target.ID, target.TenantID, reason, ipAddress, time.Now().Add(s.ttl()))
+	if err != nil {
+		return nil, "", err
+	}
+
+	actor := &auth.Actor{Subject: admin.UserID, IdentityType: "user", Name: admin.Email}
+	token, err := auth.GenerateImpersonationToken(s.cfg, target.ID, target.Email, target.TenantID, actor, session.ID, session.ExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return session, token, nil
+}
+
+func (s *Service) ListForTarget(userID string) ([]*Session, error) {
+	return s.repo.ListForTarget(userID)
+}
+
+func (s *Service) ttl() time.Duration {
+	if s.cfg.ImpersonationTTL == 0 {
+		return defaultTTL
+	}
+	return s.cfg.ImpersonationTTL
+}
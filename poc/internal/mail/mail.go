@@ -0,0 +1,91 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Transport delivers outbound mail. Production deployments plug in an SMTP
+// or provider-backed implementation; the POC ships the outbox and log
+// transports.
+type Transport interface {
+	Send(msg *Message) error
+}
+
+func NewTransport(cfg *config.MailConfig) (Transport, error) {
+	switch cfg.Transport {
+	case "", "outbox":
+		dir := cfg.OutboxDir
+		if dir == "" {
+			dir = "outbox"
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("create outbox dir: %w", err)
+		}
+		return &OutboxTransport{dir: dir, from: cfg.From}, nil
+	case "log":
+		return &LogTransport{from: cfg.From}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport: %s", cfg.Transport)
+	}
+}
+
+// OutboxTransport writes each message as an .eml file in a directory, standing
+// in for real delivery during development.
+type OutboxTransport struct {
+	dir  string
+	from string
+}
+
+func (t *OutboxTransport) Send(msg *Message) error {
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitize(msg.To))
+	path := filepath.Join(t.dir, name)
+
+	if err := os.WriteFile(path, []byte(format(t.from, msg)), 0o600); err != nil {
+		return fmt.Errorf("write outbox message: %w", err)
+	}
+
+	return nil
+}
+
+// LogTransport prints messages to the server log.
+type LogTransport struct {
+	from string
+}
+
+func (t *LogTransport) Send(msg *Message) error {
+	log.Printf("mail to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+func format(from string, msg *Message) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(msg.Body)
+	return b.String()
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
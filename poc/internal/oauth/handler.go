@@ -0,0 +1,530 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
+)
+
+type Handler struct {
+	service     *Service
+	authService *auth.Service
+	auditLogger *audit.Logger
+	authCfg     *config.AuthConfig
+	cfg         *config.OAuthConfig
+}
+
+func NewHandler(service *Service, authService *auth.Service, auditLogger *audit.Logger, authCfg *config.AuthConfig, cfg *config.OAuthConfig) *Handler {
+	return &Handler{service: service, authService: authService, auditLogger: auditLogger, authCfg: authCfg, cfg: cfg}
+}
+
+// TokenEndpoint dispatches token requests to the handler registered for the
+// request's grant_type.
+type TokenEndpoint struct {
+	grants map[string]http.HandlerFunc
+}
+
+func NewTokenEndpoint() *TokenEndpoint {
+	return &TokenEndpoint{grants: make(map[string]http.HandlerFunc)}
+}
+
+func (e *TokenEndpoint) RegisterGrant(grantType string, handler http.HandlerFunc) {
+	e.grants[grantType] = handler
+}
+
+func (e *TokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, oauthError("invalid_request", "failed to parse form"))
+		return
+	}
+
+	handler, ok := e.grants[r.FormValue("grant_type")]
+	if !ok {
+		writeOAuthError(w, oauthError("unsupported_grant_type", "unsupported grant_type"))
+		return
+	}
+
+	handler(w, r)
+}
+
+// Discovery serves the OpenID Connect discovery document, which tells
+// relying parties where the endpoints and signing keys are and how ID
+// tokens are signed.
+func (h *Handler) Discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := strings.TrimSuffix(auth.Issuer(h.authCfg), "/")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                auth.Issuer(h.authCfg),
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/api/v1/auth/token",
+		"device_authorization_endpoint":         issuer + "/oauth/device_authorization",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{GrantAuthorizationCode, GrantDeviceCode},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{auth.IDTokenSigningAlg},
+		"scopes_supported":                      []string{"openid"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// Authorize implements the authorization endpoint. Users already signed in
+// to Bastion are recognised through the session cookie, which gives single
+// sign-on across every registered application.
+func (h *Handler) Authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := &AuthorizeRequest{
+		ResponseType:        q.Get("response_type"),
+		ClientID:            q.Get("client_id"),
+		RedirectURI:         q.Get("redirect_uri"),
+		Scope:               q.Get("scope"),
+		State:               q.Get("state"),
+		Nonce:               q.Get("nonce"),
+		CodeChallenge:       q.Get("code_challenge"),
+		CodeChallengeMethod: q.Get("code_challenge_method"),
+		Prompt:              q.Get("prompt"),
+		Audience:            q.Get("audience"),
+	}
+
+	// Until the client and redirect URI are verified, errors are shown to the
+	// user agent rather than redirected, to avoid acting as an open redirector.
+	client, err := h.service.ResolveClient(req.ClientID, req.RedirectURI)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sess, err := h.authService.SessionFromRequest(r)
+	if err != nil {
+		if req.Prompt != "none" && h.cfg.LoginURL != "" {
+			http.Redirect(w, r, h.cfg.LoginURL+"?return_to="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		redirectError(w, r, req, oauthError("login_required", "user is not signed in"))
+		return
+	}
+
+	code, err := h.service.Authorize(client, req, sess)
+	if err != nil {
+		h.auditLogger.LogContext(r.Context(), "oauth.authorize_rejected", sess.UserID, map[string]interface{}{
+			"client_id": client.ClientID,
+			"error":     err.Error(),
+		}, r.RemoteAddr)
+
+		var oe *Error
+		if !errors.As(err, &oe) {
+			oe = oauthError("server_error", "failed to issue authorization code")
+		}
+		redirectError(w, r, req, oe)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "oauth.code_issued", sess.UserID, map[string]interface{}{
+		"client_id": client.ClientID,
+		"scope":     req.Scope,
+	}, r.RemoteAddr)
+
+	params := url.Values{"code": {code}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	http.Redirect(w, r, appendQuery(req.RedirectURI, params), http.StatusFound)
+}
+
+// AuthorizationCodeToken handles grant_type=authorization_code.
+func (h *Handler) AuthorizationCodeToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret := clientCredentials(r)
+
+	resp, userID, err := h.service.ExchangeCode(
+		clientID, clientSecret, r.FormValue("code"), r.FormValue("redirect_uri"), r.FormValue("code_verifier"),
+	)
+	if err != nil {
+		h.auditLogger.LogContext(r.Context(), "oauth.code_rejected", userID, map[string]interface{}{
+			"client_id": clientID,
+			"error":     err.Error(),
+		}, r.RemoteAddr)
+
+		var oe *Error
+		if !errors.As(err, &oe) {
+			oe = oauthError("server_error", "failed to issue tokens")
+		}
+		writeOAuthError(w, oe)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "oauth.code_exchanged", userID, map[string]interface{}{
+		"client_id": clientID,
+		"scope":     resp.Scope,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DeviceAuthorization implements the device authorization endpoint of
+// RFC 8628 §3.1.
+func (h *Handler) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, oauthError("invalid_request", "failed to parse form"))
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(r)
+	resp, err := h.service.StartDevice(clientID, clientSecret, r.FormValue("scope"), r.FormValue("audience"), h.verificationURI(r))
+	if err != nil {
+		var oe *Error
+		if !errors.As(err, &oe) {
+			oe = oauthError("server_error", "failed to start device authorization")
+		}
+		writeOAuthError(w, oe)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "oauth.device_started", "", map[string]interface{}{
+		"client_id": clientID,
+		"scope":     r.FormValue("scope"),
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetDevice shows the signed-in user which application is asking for
+// access before they approve it.
+func (h *Handler) GetDevice(w http.ResponseWriter, r *http.Request) {
+	if _, _, _, err := h.currentUser(r); err != nil {
+		if h.cfg.LoginURL != "" {
+			http.Redirect(w, r, h.cfg.LoginURL+"?return_to="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		writeError(w, "sign in required", http.StatusUnauthorized)
+		return
+	}
+
+	d, err := h.service.LookupDevice(r.URL.Query().Get("user_code"))
+	if err != nil {
+		writeError(w, "invalid or expired user code", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// DecideDevice records the signed-in user's approval or denial of a device
+// authorization. The user is identified by the session cookie or a bearer
+// token.
+func (h *Handler) DecideDevice(w http.ResponseWriter, r *http.Request) {
+	userID, tenantID, authTime, err := h.currentUser(r)
+	if err != nil {
+		writeError(w, "sign in required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		UserCode string `json:"user_code"`
+		Approve  bool   `json:"approve"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	d, err := h.service.DecideDevice(req.UserCode, req.Approve, userID, tenantID, authTime)
+	if errors.Is(err, ErrDeviceTenant) {
+		writeError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		writeError(w, "invalid or expired user code", http.StatusNotFound)
+		return
+	}
+
+	event := "oauth.device_denied"
+	if req.Approve {
+		event = "oauth.device_approved"
+	}
+	h.auditLogger.LogContext(r.Context(), event, userID, map[string]interface{}{
+		"client_id": d.ClientPublicID,
+		"scope":     d.Scope,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// DeviceCodeToken handles the device_code grant polled by the device.
+func (h *Handler) DeviceCodeToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret := clientCredentials(r)
+
+	resp, userID, err := h.service.PollDevice(clientID, clientSecret, r.FormValue("device_code"))
+	if err != nil {
+		var oe *Error
+		if !errors.As(err, &oe) {
+			oe = oauthError("server_error", "failed to issue tokens")
+		}
+		if oe.Code != "authorization_pending" && oe.Code != "slow_down" {
+			h.auditLogger.LogContext(r.Context(), "oauth.device_rejected", userID, map[string]interface{}{
+				"client_id": clientID,
+				"error":     err.Error(),
+			}, r.RemoteAddr)
+		}
+		writeOAuthError(w, oe)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "oauth.device_exchanged", userID, map[string]interface{}{
+		"client_id": clientID,
+		"scope":     resp.Scope,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// currentUser identifies the signed-in user and the tenant they are signed
+// in to from the SSO session cookie or, failing that, a bearer access token.
+func (h *Handler) currentUser(r *http.Request) (string, *string, time.Time, error) {
+	if sess, err := h.authService.SessionFromRequest(r); err == nil {
+		return sess.UserID, sess.TenantID, sess.AuthTime, nil
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", nil, time.Time{}, fmt.Errorf("not signed in")
+	}
+
+	claims, err := auth.ValidateAccessToken(h.authCfg, strings.TrimPrefix(header, "Bearer "))
+	if err != nil || claims.IdentityType != "user" || !claims.HasAudience(auth.Audience(h.authCfg)) {
+		return "", nil, time.Time{}, fmt.Errorf("not signed in")
+	}
+
+	return claims.UserID, claims.TenantID, time.Unix(claims.AuthTime, 0), nil
+}
+
+func (h *Handler) verificationURI(r *http.Request) string {
+	if h.cfg.DeviceVerificationURL != "" {
+		return h.cfg.DeviceVerificationURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/oauth/device"
+}
+
+func (h *Handler) CreateClient(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		GrantTypes   []string `json:"grant_types"`
+		Audiences    []string `json:"audiences"`
+		TenantID     *string  `json:"tenant_id"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Tenant-bound callers create clients for their own tenant.
+	caller, _ := principal.FromContext(r.Context())
+	if caller.TenantID != nil {
+		if req.TenantID != nil && *req.TenantID != *caller.TenantID {
+			writeError(w, "token is bound to another tenant", http.StatusForbidden)
+			return
+		}
+		req.TenantID = caller.TenantID
+	}
+
+	client, secret, err := h.service.CreateClient(req.Name, req.RedirectURIs, req.GrantTypes, req.Audiences, req.TenantID, req.Confidential)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "oauth_client.created", caller.UserID(), map[string]interface{}{
+		"oauth_client_id": client.ID,
+		"client_id":       client.ClientID,
+		"redirect_uris":   client.RedirectURIs,
+		"grant_types":     client.GrantTypes,
+		"audiences":       client.Audiences,
+		"confidential":    client.Confidential,
+	}, r.RemoteAddr)
+
+	resp := map[string]interface{}{"client": client}
+	if secret != "" {
+		resp["client_secret"] = secret
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) ListClients(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principal.FromContext(r.Context())
+
+	clients, err := h.service.ListClients(caller.TenantID)
+	if err != nil {
+		writeError(w, "failed to list oauth clients", http.StatusInternalServerError)
+		return
+	}
+
+	if clients == nil {
+		clients = []*Client{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"clients": clients})
+}
+
+func (h *Handler) GetClient(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principal.FromContext(r.Context())
+
+	client, err := h.service.GetClient(chi.URLParam(r, "id"), caller.TenantID)
+	if err != nil {
+		writeError(w, "oauth client not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(client)
+}
+
+func (h *Handler) UpdateClient(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		GrantTypes   []string `json:"grant_types"`
+		Audiences    []string `json:"audiences"`
+		Enabled      *bool    `json:"enabled"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	if err := h.service.UpdateClient(caller.TenantID, id, req.Name, req.RedirectURIs, req.GrantTypes, req.Audiences, enabled); err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "oauth_client.updated", caller.UserID(), map[string]interface{}{
+		"oauth_client_id": id,
+		"redirect_uris":   req.RedirectURIs,
+		"grant_types":     req.GrantTypes,
+		"audiences":       req.Audiences,
+		"enabled":         enabled,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	caller, _ := principal.FromContext(r.Context())
+	if err := h.service.DeleteClient(caller.TenantID, id); err != nil {
+		if errors.Is(err, ErrClientNotFound) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeError(w, "failed to delete oauth client", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "oauth_client.deleted", caller.UserID(), map[string]interface{}{
+		"oauth_client_id": id,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clientCredentials reads client authentication from HTTP Basic auth or,
+// failing that, the form body.
+func clientCredentials(r *http.Request) (string, string) {
+	if clientID, clientSecret, ok := r.BasicAuth(); ok {
+		return clientID, clientSecret
+	}
+	return r.FormValue("client_id"), r.FormValue("client_secret")
+}
+
+func redirectError(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest, oe *Error) {
+	params := url.Values{
+		"error":             {oe.Code},
+		"error_description": {oe.Description},
+	}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	http.Redirect(w, r, appendQuery(req.RedirectURI, params), http.StatusFound)
+}
+
+func appendQuery(rawURL string, params url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func writeClientError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrClientNotFound) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeError(w, err.Error(), http.StatusBadRequest)
+}
+
+func writeError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func writeOAuthError(w http.ResponseWriter, oe *Error) {
+	status := http.StatusBadRequest
+	if oe.Code == "invalid_client" {
+		status = http.StatusUnauthorized
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             oe.Code,
+		"error_description": oe.Description,
+	})
+}
@@ -0,0 +1,350 @@
+package oauth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrClientNotFound = errors.New("oauth client not found")
+
+type Client struct {
+	ID           string    `json:"id"`
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	SecretHash   *string   `json:"-"`
+	Confidential bool      `json:"confidential"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Audiences    []string  `json:"audiences"`
+	TenantID     *string   `json:"tenant_id,omitempty"`
+	Enabled      bool      `json:"enabled"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// AuthorizationCode is a consumed authorization code together with the
+// session and user it was issued for.
+type AuthorizationCode struct {
+	ClientID      string
+	UserID        string
+	SessionID     string
+	RedirectURI   string
+	CodeChallenge string
+	Scope         string
+	Nonce         string
+	Audience      string
+	Email         string
+	TenantID      *string
+	AuthTime      time.Time
+}
+
+// DeviceAuthorization is a device flow request. Status is one of pending,
+// approved, denied or consumed; PollDevice additionally reports slow_down
+// and expired.
+type DeviceAuthorization struct {
+	ID             string    `json:"-"`
+	ClientID       string    `json:"-"`
+	ClientPublicID string    `json:"client_id"`
+	ClientName     string    `json:"client_name"`
+	ClientTenantID *string   `json:"-"`
+	Scope          string    `json:"scope"`
+	Audience       string    `json:"audience"`
+	Status         string    `json:"status"`
+	Interval       int       `json:"-"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	UserID         string    `json:"-"`
+	Email          string    `json:"-"`
+	TenantID       *string   `json:"-"`
+	AuthTime       time.Time `json:"-"`
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+const clientColumns = `id, client_id, name, secret_hash, redirect_uris, grant_types, audiences, tenant_id, enabled, created_at, updated_at`
+
+func scanClient(row interface{ Scan(...interface{}) error }) (*Client, error) {
+	c := &Client{}
+	err := row.Scan(&c.ID, &c.ClientID, &c.Name, &c.SecretHash, pq.Array(&c.RedirectURIs), pq.Array(&c.GrantTypes), pq.Array(&c.Audiences), &c.TenantID, &c.Enabled, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	c.Confidential = c.SecretHash != nil
+	return c, nil
+}
+
+func (r *Repository) CreateClient(clientID, name string, secretHash *string, redirectURIs, grantTypes, audiences []string, tenantID *string) (*Client, error) {
+	c, err := scanClient(r.db.QueryRow(
+		`INSERT INTO oauth_clients (client_id, name, secret_hash, redirect_uris, grant_types, audiences, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING `+clientColumns,
+		clientID, name, secretHash, pq.Array(redirectURIs), pq.Array(grantTypes), pq.Array(audiences), tenantID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("create oauth client: %w", err)
+	}
+	return c, nil
+}
+
+func (r *Repository) GetClient(id string) (*Client, error) {
+	c, err := scanClient(r.db.QueryRow(
+		`SELECT `+clientColumns+` FROM oauth_clients WHERE id = $1`,
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get oauth client: %w", err)
+	}
+	return c, nil
+}
+
+func (r *Repository) GetClientByClientID(clientID string) (*Client, error) {
+	c, err := scanClient(r.db.QueryRow(
+		`SELECT `+clientColumns+` FROM oauth_clients WHERE client_id = $1`,
+		clientID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("oauth client not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get oauth client: %w", err)
+	}
+	return c, nil
+}
+
+func (r *Repository) ListClients(tenantID *string) ([]*Client, error) {
+	var rows *sql.Rows
+	var err error
+
+	if tenantID == nil {
+		rows, err = r.db.Query(`SELECT ` + clientColumns + ` FROM oauth_clients ORDER BY name`)
+	} else {
+		rows, err = r.db.Query(
+			`SELECT `+clientColumns+` FROM oauth_clients WHERE tenant_id = $1 ORDER BY name`,
+			*tenantID,
+		)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("list oauth clients: %w", err)
+	}
+	defer rows.Close()
+
+	var clients []*Client
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan oauth client: %w", err)
+		}
+		clients = append(clients, c)
+	}
+
+	return clients, rows.Err()
+}
+
+func (r *Repository) UpdateClient(id, name string, redirectURIs, grantTypes, audiences []string, enabled bool) error {
+	_, err := r.db.Exec(
+		`UPDATE oauth_clients
+		 SET name = $1, redirect_uris = $2, grant_types = $3, audiences = $4, enabled = $5, updated_at = NOW()
+		 WHERE id = $6`,
+		name, pq.Array(redirectURIs), pq.Array(grantTypes), pq.Array(audiences), enabled, id,
+	)
+	if err != nil {
+		return fmt.Errorf("update oauth client: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) DeleteClient(id string) error {
+	_, err := r.db.Exec(`DELETE FROM oauth_clients WHERE id = $1`, id)
+	return err
+}
+
+func (r *Repository) CreateCode(codeHash, clientID, userID, sessionID, redirectURI, codeChallenge, scope, nonce, audience string, expiresAt time.Time) error {
+	_, err := r.db.Exec(
+		`INSERT INTO oauth_authorization_codes
+		 (code_hash, client_id, user_id, session_id, redirect_uri, code_challenge, scope, nonce, audience, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10)`,
+		codeHash, clientID, userID, sessionID, redirectURI, codeChallenge, scope, nonce, audience, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("create authorization code: %w", err)
+	}
+	return nil
+}
+
+// ConsumeCode marks an unexpired authorization code as used and returns it.
+// The conditional update makes each code redeemable exactly once, even under
+// concurrent token requests.
+func (r *Repository) ConsumeCode(codeHash string) (*AuthorizationCode, error) {
+	c := &AuthorizationCode{}
+	var nonce *string
+	err := r.db.QueryRow(
+		`WITH c AS (
+			UPDATE oauth_authorization_codes SET consumed_at = NOW()
+			WHERE code_hash = $1 AND consumed_at IS NULL AND expires_at > NOW()
+			RETURNING client_id, user_id, session_id, redirect_uri, code_challenge, scope, nonce, audience
+		)
+		SELECT c.client_id, c.user_id, c.session_id, c.redirect_uri, c.code_challenge, c.scope, c.nonce, c.audience,
+		       u.email, s.tenant_id, s.auth_time
+		FROM c
+		JOIN users u ON u.id = c.user_id
+		JOIN sessions s ON s.id = c.session_id
+		WHERE s.revoked = FALSE`,
+		codeHash,
+	).Scan(&c.ClientID, &c.UserID, &c.SessionID, &c.RedirectURI, &c.CodeChallenge, &c.Scope, &nonce, &c.Audience,
+		&c.Email, &c.TenantID, &c.AuthTime)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("invalid or expired authorization code")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("consume authorization code: %w", err)
+	}
+
+	if nonce != nil {
+		c.Nonce = *nonce
+	}
+	return c, nil
+}
+
+func (r *Repository) CreateDeviceAuthorization(deviceCodeHash, userCode, clientID, scope, audience string, interval int, expiresAt time.Time) error {
+	_, err := r.db.Exec(
+		`INSERT INTO oauth_device_authorizations
+		 (device_code_hash, user_code, client_id, scope, audience, interval_seconds, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		deviceCodeHash, userCode, clientID, scope, audience, interval, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("create device authorization: %w", err)
+	}
+	return nil
+}
+
+// GetPendingDevice returns the unexpired, undecided device authorization
+// for a user code together with the requesting client's name.
+func (r *Repository) GetPendingDevice(userCode string) (*DeviceAuthorization, error) {
+	d := &DeviceAuthorization{}
+	err := r.db.QueryRow(
+		`SELECT d.id, d.client_id, c.client_id, c.name, c.tenant_id, d.scope, d.audience, d.status, d.expires_at
+		 FROM oauth_device_authorizations d
+		 JOIN oauth_clients c ON c.id = d.client_id
+		 WHERE d.user_code = $1 AND d.status = 'pending' AND d.expires_at > NOW()`,
+		userCode,
+	).Scan(&d.ID, &d.ClientID, &d.ClientPublicID, &d.ClientName, &d.ClientTenantID, &d.Scope, &d.Audience, &d.Status, &d.ExpiresAt)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("device authorization not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get device authorization: %w", err)
+	}
+
+	return d, nil
+}
+
+// DecideDevice approves or denies a pending device authorization on behalf
+// of a signed-in user in the tenant of their session.
+func (r *Repository) DecideDevice(id, status, userID string, tenantID *string, authTime time.Time) error {
+	result, err := r.db.Exec(
+		`UPDATE oauth_device_authorizations
+		 SET status = $1, user_id = $2, tenant_id = $3, auth_time = $4
+		 WHERE id = $5 AND status = 'pending' AND expires_at > NOW()`,
+		status, userID, tenantID, authTime, id,
+	)
+	if err != nil {
+		return fmt.Errorf("update device authorization: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("update device authorization: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("device authorization already decided or expired")
+	}
+
+	return nil
+}
+
+// PollDevice records a token poll for a device code and reports its outcome.
+// The row is locked for the duration so that polling rate checks are exact
+// and an approved authorization is handed out exactly once.
+func (r *Repository) PollDevice(deviceCodeHash string) (*DeviceAuthorization, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	d := &DeviceAuthorization{}
+	var lastPolled *time.Time
+	var userID *string
+	var authTime *time.Time
+	err = tx.QueryRow(
+		`SELECT d.id, d.client_id, c.client_id, d.scope, d.audience, d.status, d.user_id, d.tenant_id, d.auth_time,
+		        d.interval_seconds, d.last_polled_at, d.expires_at
+		 FROM oauth_device_authorizations d
+		 JOIN oauth_clients c ON c.id = d.client_id
+		 WHERE d.device_code_hash = $1
+		 FOR UPDATE OF d`,
+		deviceCodeHash,
+	).Scan(&d.ID, &d.ClientID, &d.ClientPublicID, &d.Scope, &d.Audience, &d.Status, &userID, &d.TenantID, &authTime,
+		&d.Interval, &lastPolled, &d.ExpiresAt)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("device authorization not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get device authorization: %w", err)
+	}
+
+	now := time.Now()
+	switch {
+	case d.Status == "consumed":
+		// Reported as expired: the device code has already been exchanged.
+		d.Status = "expired"
+	case now.After(d.ExpiresAt):
+		d.Status = "expired"
+	case lastPolled != nil && now.Before(lastPolled.Add(time.Duration(d.Interval)*time.Second)):
+		d.Interval += 5
+		d.Status = "slow_down"
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE oauth_device_authorizations
+		 SET last_polled_at = $1, interval_seconds = $2,
+		     status = CASE WHEN $3 THEN 'consumed' ELSE status END
+		 WHERE id = $4`,
+		now, d.Interval, d.Status == "approved", d.ID,
+	); err != nil {
+		return nil, fmt.Errorf("update device authorization: %w", err)
+	}
+
+	if d.Status == "approved" {
+		if err := tx.QueryRow(
+			`SELECT id, email FROM users WHERE id = $1`,
+			*userID,
+		).Scan(&d.UserID, &d.Email); err != nil {
+			return nil, fmt.Errorf("get device user: %w", err)
+		}
+		d.AuthTime = *authTime
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return d, nil
+}
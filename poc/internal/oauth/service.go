@@ -0,0 +1,572 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+
+	defaultCodeTTL            = time.Minute
+	defaultDeviceCodeTTL      = 10 * time.Minute
+	defaultDevicePollInterval = 5 * time.Second
+
+	// userCodeAlphabet omits vowels and look-alike characters so that user
+	// codes are easy to type and never spell words (RFC 8628 §6.1).
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+// Error is an OAuth 2.0 protocol error as defined in RFC 6749 §4.1.2.1 and
+// §5.2.
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
+
+// AuthorizeRequest holds the parameters of an /oauth/authorize request.
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Prompt              string
+	Audience            string
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// DeviceCodeResponse is the device authorization response of RFC 8628 §3.2.
+type DeviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type Service struct {
+	repo    *Repository
+	auth    *auth.Service
+	authCfg *config.AuthConfig
+	cfg     *config.OAuthConfig
+}
+
+func NewService(repo *Repository, authService *auth.Service, authCfg *config.AuthConfig, cfg *config.OAuthConfig) *Service {
+	return &Service{repo: repo, auth: authService, authCfg: authCfg, cfg: cfg}
+}
+
+// CreateClient registers a client application. Confidential clients receive
+// a secret that is returned once; public clients authenticate with PKCE only.
+func (s *Service) CreateClient(name string, redirectURIs, grantTypes, audiences []string, tenantID *string, confidential bool) (*Client, string, error) {
+	if name == "" {
+		return nil, "", fmt.Errorf("name required")
+	}
+	if len(grantTypes) == 0 {
+		grantTypes = []string{GrantAuthorizationCode}
+	}
+	if redirectURIs == nil {
+		redirectURIs = []string{}
+	}
+	if audiences == nil {
+		audiences = []string{}
+	}
+	if err := validateClient(redirectURIs, grantTypes, audiences); err != nil {
+		return nil, "", err
+	}
+
+	var secret string
+	var secretHash *string
+	if confidential {
+		secret = randomString(40)
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, "", fmt.Errorf("hash client secret: %w", err)
+		}
+		h := string(hash)
+		secretHash = &h
+	}
+
+	client, err := s.repo.CreateClient("app_"+randomString(20), name, secretHash, redirectURIs, grantTypes, audiences, tenantID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return client, secret, nil
+}
+
+func (s *Service) ListClients(tenantID *string) ([]*Client, error) {
+	return s.repo.ListClients(tenantID)
+}
+
+// GetClient returns a client by ID. A tenant-bound scope only sees the
+// clients of its own tenant; others are reported as not found.
+func (s *Service) GetClient(id string, scope *string) (*Client, error) {
+	client, err := s.repo.GetClient(id)
+	if err != nil {
+		return nil, err
+	}
+	if scope != nil && (client.TenantID == nil || *client.TenantID != *scope) {
+		return nil, ErrClientNotFound
+	}
+	return client, nil
+}
+
+func (s *Service) UpdateClient(scope *string, id, name string, redirectURIs, grantTypes, audiences []string, enabled bool) error {
+	if _, err := s.GetClient(id, scope); err != nil {
+		return err
+	}
+	if len(grantTypes) == 0 {
+		grantTypes = []string{GrantAuthorizationCode}
+	}
+	if redirectURIs == nil {
+		redirectURIs = []string{}
+	}
+	if audiences == nil {
+		audiences = []string{}
+	}
+	if err := validateClient(redirectURIs, grantTypes, audiences); err != nil {
+		return err
+	}
+	return s.repo.UpdateClient(id, name, redirectURIs, grantTypes, audiences, enabled)
+}
+
+func (s *Service) DeleteClient(scope *string, id string) error {
+	if _, err := s.GetClient(id, scope); err != nil {
+		return err
+	}
+	return s.repo.DeleteClient(id)
+}
+
+// ResolveClient looks up an enabled client and checks that redirectURI is
+// one of its registered URIs. Redirect URIs are compared exactly; errors
+// from this step must not be sent to the redirect URI.
+func (s *Service) ResolveClient(clientID, redirectURI string) (*Client, error) {
+	client, err := s.repo.GetClientByClientID(clientID)
+	if err != nil || !client.Enabled {
+		return nil, oauthError("invalid_client", "unknown client")
+	}
+	if !client.allows(GrantAuthorizationCode) {
+		return nil, oauthError("unauthorized_client", "client may not use the authorization code flow")
+	}
+
+	for _, uri := range client.RedirectURIs {
+		if uri == redirectURI {
+			return client, nil
+		}
+	}
+
+	return nil, oauthError("invalid_request", "redirect_uri is not registered for this client")
+}
+
+// Authorize issues an authorization code for the signed-in session. The
+// client and redirect URI must already have been checked with ResolveClient.
+func (s *Service) Authorize(client *Client, req *AuthorizeRequest, sess *auth.Session) (string, error) {
+	if req.ResponseType != "code" {
+		return "", oauthError("unsupported_response_type", "only response_type=code is supported")
+	}
+	if req.CodeChallenge == "" {
+		return "", oauthError("invalid_request", "code_challenge required")
+	}
+	if req.CodeChallengeMethod != "S256" {
+		return "", oauthError("invalid_request", "code_challenge_method must be S256")
+	}
+	if client.TenantID != nil && (sess.TenantID == nil || *sess.TenantID != *client.TenantID) {
+		return "", oauthError("access_denied", "user is not a member of the client's tenant")
+	}
+
+	audience, err := s.resolveAudience(client, req.Audience)
+	if err != nil {
+		return "", err
+	}
+
+	code := randomString(43)
+	err = s.repo.CreateCode(
+		hashCode(code), client.ID, sess.UserID, sess.ID, req.RedirectURI,
+		req.CodeChallenge, req.Scope, req.Nonce, audience, time.Now().Add(s.codeTTL()),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ExchangeCode redeems an authorization code. The code is consumed before
+// any other check so that a failed or replayed exchange cannot be retried.
+func (s *Service) ExchangeCode(clientID, clientSecret, code, redirectURI, codeVerifier string) (*TokenResponse, string, error) {
+	client, err := s.authenticateClient(clientID, clientSecret, GrantAuthorizationCode)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if code == "" || codeVerifier == "" {
+		return nil, "", oauthError("invalid_request", "code and code_verifier required")
+	}
+
+	ac, err := s.repo.ConsumeCode(hashCode(code))
+	if err != nil {
+		return nil, "", oauthError("invalid_grant", "invalid or expired authorization code")
+	}
+	if ac.ClientID != client.ID {
+		return nil, ac.UserID, oauthError("invalid_grant", "authorization code was issued to another client")
+	}
+	if ac.RedirectURI != redirectURI {
+		return nil, ac.UserID, oauthError("invalid_grant", "redirect_uri does not match authorization request")
+	}
+	if !verifyPKCE(codeVerifier, ac.CodeChallenge) {
+		return nil, ac.UserID, oauthError("invalid_grant", "code_verifier does not match code_challenge")
+	}
+
+	granted, err := s.narrowScope(ac.UserID, ac.TenantID, ac.Scope)
+	if err != nil {
+		return nil, ac.UserID, err
+	}
+
+	sess, err := s.auth.IssueSession(ac.UserID, ac.Email, ac.TenantID, ac.Audience, granted, ac.AuthTime)
+	if err != nil {
+		return nil, ac.UserID, err
+	}
+
+	resp := &TokenResponse{
+		AccessToken:  sess.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.authCfg.AccessTokenTTL.Seconds()),
+		RefreshToken: sess.RefreshToken,
+		Scope:        grantedScope(ac.Scope, granted),
+	}
+
+	if hasScope(ac.Scope, "openid") {
+		resp.IDToken, err = auth.GenerateIDToken(s.authCfg, ac.UserID, ac.Email, client.ClientID, ac.Nonce, ac.AuthTime)
+		if err != nil {
+			return nil, ac.UserID, err
+		}
+	}
+
+	return resp, ac.UserID, nil
+}
+
+// StartDevice begins a device authorization for a CLI or other input-
+// constrained client (RFC 8628 §3.1). verificationURI is where the user
+// approves the request from a signed-in browser.
+func (s *Service) StartDevice(clientID, clientSecret, scope, audience, verificationURI string) (*DeviceCodeResponse, error) {
+	client, err := s.authenticateClient(clientID, clientSecret, GrantDeviceCode)
+	if err != nil {
+		return nil, err
+	}
+
+	audience, err = s.resolveAudience(client, audience)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceCode := randomString(43)
+	userCode, err := generateUserCode()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := s.deviceCodeTTL()
+	interval := int(s.devicePollInterval().Seconds())
+	if err := s.repo.CreateDeviceAuthorization(
+		hashCode(deviceCode), userCode, client.ID, scope, audience, interval, time.Now().Add(ttl),
+	); err != nil {
+		return nil, err
+	}
+
+	display := userCode[:4] + "-" + userCode[4:]
+	return &DeviceCodeResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                display,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + display,
+		ExpiresIn:               int(ttl.Seconds()),
+		Interval:                interval,
+	}, nil
+}
+
+// LookupDevice returns the pending device authorization for a user code so
+// that the user can confirm which application they are approving.
+func (s *Service) LookupDevice(userCode string) (*DeviceAuthorization, error) {
+	return s.repo.GetPendingDevice(normalizeUserCode(userCode))
+}
+
+// ErrDeviceTenant is returned when a user approves a device authorization
+// for a tenant-bound client from a session in another tenant.
+var ErrDeviceTenant = errors.New("user is not signed in to the client's tenant")
+
+// DecideDevice approves or denies a pending device authorization. The
+// device's tokens are issued in tenantID, the tenant of the approving
+// session, with authTime carried into them.
+func (s *Service) DecideDevice(userCode string, approve bool, userID string, tenantID *string, authTime time.Time) (*DeviceAuthorization, error) {
+	d, err := s.repo.GetPendingDevice(normalizeUserCode(userCode))
+	if err != nil {
+		return nil, err
+	}
+
+	status := "denied"
+	if approve {
+		status = "approved"
+		if d.ClientTenantID != nil && (tenantID == nil || *tenantID != *d.ClientTenantID) {
+			return nil, ErrDeviceTenant
+		}
+	}
+	if err := s.repo.DecideDevice(d.ID, status, userID, tenantID, authTime); err != nil {
+		return nil, err
+	}
+
+	d.Status = status
+	return d, nil
+}
+
+// PollDevice handles a device_code grant request. Until the user decides,
+// the client receives authorization_pending, or slow_down when it polls
+// faster than the advertised interval.
+func (s *Service) PollDevice(clientID, clientSecret, deviceCode string) (*TokenResponse, string, error) {
+	client, err := s.authenticateClient(clientID, clientSecret, GrantDeviceCode)
+	if err != nil {
+		return nil, "", err
+	}
+	if deviceCode == "" {
+		return nil, "", oauthError("invalid_request", "device_code required")
+	}
+
+	d, err := s.repo.PollDevice(hashCode(deviceCode))
+	if err != nil {
+		return nil, "", oauthError("invalid_grant", "invalid device_code")
+	}
+	if d.ClientID != client.ID {
+		return nil, "", oauthError("invalid_grant", "device_code was issued to another client")
+	}
+
+	switch d.Status {
+	case "pending":
+		return nil, "", oauthError("authorization_pending", "the user has not yet approved the request")
+	case "slow_down":
+		return nil, "", oauthError("slow_down", fmt.Sprintf("poll no more than every %d seconds", d.Interval))
+	case "denied":
+		return nil, "", oauthError("access_denied", "the user denied the request")
+	case "expired":
+		return nil, "", oauthError("expired_token", "the device_code has expired")
+	}
+
+	granted, err := s.narrowScope(d.UserID, d.TenantID, d.Scope)
+	if err != nil {
+		return nil, d.UserID, err
+	}
+
+	sess, err := s.auth.IssueSession(d.UserID, d.Email, d.TenantID, d.Audience, granted, d.AuthTime)
+	if err != nil {
+		return nil, d.UserID, err
+	}
+
+	return &TokenResponse{
+		AccessToken:  sess.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.authCfg.AccessTokenTTL.Seconds()),
+		RefreshToken: sess.RefreshToken,
+		Scope:        grantedScope(d.Scope, granted),
+	}, d.UserID, nil
+}
+
+// authenticateClient resolves an enabled client permitted to use grantType.
+// Confidential clients must present their secret; public clients are
+// identified by client_id alone.
+func (s *Service) authenticateClient(clientID, clientSecret, grantType string) (*Client, error) {
+	client, err := s.repo.GetClientByClientID(clientID)
+	if err != nil || !client.Enabled {
+		return nil, oauthError("invalid_client", "unknown client")
+	}
+	if client.Confidential {
+		if clientSecret == "" || bcrypt.CompareHashAndPassword([]byte(*client.SecretHash), []byte(clientSecret)) != nil {
+			return nil, oauthError("invalid_client", "invalid client credentials")
+		}
+	}
+	if !client.allows(grantType) {
+		return nil, oauthError("unauthorized_client", "client may not use this grant type")
+	}
+	return client, nil
+}
+
+// narrowScope limits the permission scopes of a request to those the user
+// holds; OpenID Connect scopes are not permissions and pass through.
+func (s *Service) narrowScope(userID string, tenantID *string, requested string) (string, error) {
+	granted, err := s.auth.NarrowScope(userID, tenantID, requested)
+	if errors.Is(err, auth.ErrInvalidScope) {
+		return "", oauthError("invalid_scope", "user holds none of the requested permissions")
+	}
+	return granted, err
+}
+
+// grantedScope is the scope reported in a token response: the requested
+// non-permission scopes followed by the permissions actually granted.
+func grantedScope(requested, granted string) string {
+	var scopes []string
+	for _, s := range strings.Fields(requested) {
+		if !auth.IsPermissionScope(s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if granted != "" {
+		scopes = append(scopes, granted)
+	}
+	return strings.Join(scopes, " ")
+}
+
+// resolveAudience returns the aud of tokens issued to client. Clients get
+// tokens for their own application by default and may instead request
+// tokens for an audience listed for the client, such as the Bastion API.
+func (s *Service) resolveAudience(client *Client, requested string) (string, error) {
+	if requested == "" || requested == client.ClientID {
+		return client.ClientID, nil
+	}
+	for _, aud := range client.Audiences {
+		if aud == requested {
+			return requested, nil
+		}
+	}
+	return "", oauthError("invalid_target", "client may not request tokens for this audience")
+}
+
+func (c *Client) allows(grantType string) bool {
+	for _, g := range c.GrantTypes {
+		if g == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) deviceCodeTTL() time.Duration {
+	if s.cfg.DeviceCodeTTL == 0 {
+		return defaultDeviceCodeTTL
+	}
+	return s.cfg.DeviceCodeTTL
+}
+
+func (s *Service) devicePollInterval() time.Duration {
+	if s.cfg.DevicePollInterval == 0 {
+		return defaultDevicePollInterval
+	}
+	return s.cfg.DevicePollInterval
+}
+
+func (s *Service) codeTTL() time.Duration {
+	if s.cfg.CodeTTL == 0 {
+		return defaultCodeTTL
+	}
+	return s.cfg.CodeTTL
+}
+
+func validateClient(redirectURIs, grantTypes, audiences []string) error {
+	for _, aud := range audiences {
+		if aud == "" || len(aud) > 255 {
+			return fmt.Errorf("invalid audience: %q", aud)
+		}
+	}
+	for _, g := range grantTypes {
+		if g != GrantAuthorizationCode && g != GrantDeviceCode {
+			return fmt.Errorf("unsupported grant type: %s", g)
+		}
+		if g == GrantAuthorizationCode && len(redirectURIs) == 0 {
+			return fmt.Errorf("at least one redirect_uri required for the authorization code flow")
+		}
+	}
+	for _, uri := range redirectURIs {
+		if !strings.HasPrefix(uri, "https://") && !strings.HasPrefix(uri, "http://localhost") &&
+			!strings.HasPrefix(uri, "http://127.0.0.1") {
+			return fmt.Errorf("redirect_uri must use https or a loopback address: %s", uri)
+		}
+		if strings.Contains(uri, "#") {
+			return fmt.Errorf("redirect_uri must not contain a fragment: %s", uri)
+		}
+	}
+	return nil
+}
+
+// verifyPKCE checks an RFC 7636 S256 code verifier against its challenge.
+func verifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+func generateUserCode() (string, error) {
+	code := make([]byte, 8)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", fmt.Errorf("generate user code: %w", err)
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode accepts user codes as typed: in any case and with or
+// without the separator.
+func normalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(length int) string {
+	bytes := make([]byte, length)
+	rand.Read(bytes)
+	return base64.RawURLEncoding.EncodeToString(bytes)[:length]
+}
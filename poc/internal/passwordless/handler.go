@@ -0,0 +1,187 @@
+package passwordless
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+)
+
+type Handler struct {
+	service     *Service
+	authService *auth.Service
+	auditLogger *audit.Logger
+	cfg         *config.AuthConfig
+}
+
+func NewHandler(service *Service, authService *auth.Service, auditLogger *audit.Logger, cfg *config.AuthConfig) *Handler {
+	return &Handler{service: service, authService: authService, auditLogger: auditLogger, cfg: cfg}
+}
+
+type StartRequest struct {
+	Email  string `json:"email"`
+	Method string `json:"method"`
+}
+
+type VerifyRequest struct {
+	Token    string  `json:"token"`
+	Email    string  `json:"email"`
+	Code     string  `json:"code"`
+	TenantID *string `json:"tenant_id,omitempty"`
+}
+
+// Start always answers 202 for well-formed requests so that the response does
+// not reveal whether an account exists or is eligible.
+func (h *Handler) Start(w http.ResponseWriter, r *http.Request) {
+	var req StartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Method == "" {
+		req.Method = MethodLink
+	}
+	if req.Method != MethodLink && req.Method != MethodCode {
+		writeError(w, "method must be link or code", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.service.Start(req.Email, req.Method, r.RemoteAddr)
+	switch {
+	case err == nil:
+		h.auditLogger.LogContext(r.Context(), "passwordless.requested", userID, map[string]interface{}{
+			"method": req.Method,
+		}, r.RemoteAddr)
+	case errors.Is(err, ErrRateLimited):
+		h.auditLogger.LogContext(r.Context(), "passwordless.rate_limited", userID, map[string]interface{}{
+			"method": req.Method,
+		}, r.RemoteAddr)
+	default:
+		h.auditLogger.LogContext(r.Context(), "passwordless.request_rejected", userID, map[string]interface{}{
+			"email":  req.Email,
+			"method": req.Method,
+			"error":  err.Error(),
+		}, r.RemoteAddr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "if the account is eligible, a sign-in message has been sent",
+	})
+}
+
+// linkLanding is the page a magic link opens. Redeeming the token takes a
+// POST from the user, so that mail scanners following the link do not use
+// it up.
+var linkLanding = template.Must(template.New("link").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in to Bastion</title></head>
+<body>
+<form method="post" action="/api/v1/auth/passwordless/verify">
+<input type="hidden" name="token" value="{{.}}">
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// LinkLanding serves the page behind the default magic link URL, which asks
+// the user to confirm the sign-in and posts the link's token to Verify.
+func (h *Handler) LinkLanding(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeError(w, "token required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; form-action 'self'")
+	linkLanding.Execute(w, token)
+}
+
+// Verify redeems a magic link token or a one-time code, sent as JSON or, from
+// the magic link landing page, as a form.
+func (h *Handler) Verify(w http.ResponseWriter, r *http.Request) {
+	var req VerifyRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		req.Token = r.PostFormValue("token")
+		req.Email = r.PostFormValue("email")
+		req.Code = r.PostFormValue("code")
+		if tenantID := r.PostFormValue("tenant_id"); tenantID != "" {
+			req.TenantID = &tenantID
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var sess *auth.IssuedSession
+	var userID, method string
+	var err error
+	switch {
+	case req.Token != "":
+		method = MethodLink
+		sess, userID, err = h.service.RedeemLink(req.Token, req.TenantID)
+	case req.Email != "" && req.Code != "":
+		method = MethodCode
+		sess, userID, err = h.service.RedeemCode(req.Email, req.Code, req.TenantID)
+	default:
+		writeError(w, "token or email and code required", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		h.auditLogger.LogContext(r.Context(), "login_failure", userID, map[string]interface{}{
+			"method": "passwordless_" + method,
+			"email":  req.Email,
+			"error":  err.Error(),
+		}, r.RemoteAddr)
+
+		// The credentials were good; the user has to pick a tenant and
+		// retry, as with Login.
+		var selection *auth.TenantSelectionError
+		if errors.As(err, &selection) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   selection.Error(),
+				"tenants": selection.Tenants,
+			})
+			return
+		}
+		writeError(w, "invalid or expired sign-in credentials", http.StatusUnauthorized)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "login_success", userID, map[string]interface{}{
+		"method": "passwordless_" + method,
+	}, r.RemoteAddr)
+
+	h.authService.SetSessionCookie(w, sess)
+
+	resp := auth.LoginResponse{
+		AccessToken:  sess.AccessToken,
+		RefreshToken: sess.RefreshToken,
+		ExpiresIn:    int(h.cfg.AccessTokenTTL.Seconds()),
+		TenantID:     sess.TenantID,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func writeError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
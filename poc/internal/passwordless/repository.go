@@ -0,0 +1,242 @@
+package passwordless
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Account is the login-relevant view of a user for passwordless sign-in.
+// Account is a user as seen by passwordless login. PasswordlessEnabled
+// reports whether any of the user's tenants allows passwordless login;
+// the tenant a session is opened in must allow it itself.
+type Account struct {
+	UserID              string
+	Email               string
+	TenantID            *string
+	PasswordlessEnabled bool
+	Privileged          bool
+}
+
+type Challenge struct {
+	ID         string
+	UserID     string
+	Method     string
+	SecretHash string
+	Attempts   int
+	ExpiresAt  time.Time
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// GetAccount resolves an email through the user's local identity. Users that
+// hold any platform role are reported as privileged.
+func (r *Repository) GetAccount(email string) (*Account, error) {
+	a := &Account{}
+	err := r.db.QueryRow(
+		`SELECT u.id, u.email, u.tenant_id,
+		        EXISTS (
+		            SELECT 1 FROM tenants t
+		            WHERE t.passwordless_enabled
+		            AND (t.id = u.tenant_id OR t.id IN (SELECT tenant_id FROM tenant_memberships WHERE user_id = u.id))
+		        ),
+		        EXISTS (
+		            SELECT 1 FROM user_roles ur
+		            JOIN roles ro ON ro.id = ur.role_id
+		            WHERE ur.user_id = u.id AND ro.role_type = 'platform'
+		        )
+		 FROM user_identities i
+		 JOIN users u ON u.id = i.user_id
+		 WHERE i.provider = 'local' AND i.subject = $1`,
+		email,
+	).Scan(&a.UserID, &a.Email, &a.TenantID, &a.PasswordlessEnabled, &a.Privileged)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("account not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query account: %w", err)
+	}
+
+	return a, nil
+}
+
+func (r *Repository) GetAccountByUserID(userID string) (*Account, error) {
+	a := &Account{}
+	err := r.db.QueryRow(
+		`SELECT u.id, u.email, u.tenant_id,
+		        EXISTS (
+		            SELECT 1 FROM tenants t
+		            WHERE t.passwordless_enabled
+		            AND (t.id = u.tenant_id OR t.id IN (SELECT tenant_id FROM tenant_memberships WHERE user_id = u.id))
+		        ),
+		        EXISTS (
+		            SELECT 1 FROM user_roles ur
+		            JOIN roles ro ON ro.id = ur.role_id
+		            WHERE ur.user_id = u.id AND ro.role_type = 'platform'
+		        )
+		 FROM users u
+		 WHERE u.id = $1`,
+		userID,
+	).Scan(&a.UserID, &a.Email, &a.TenantID, &a.PasswordlessEnabled, &a.Privileged)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("account not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query account: %w", err)
+	}
+
+	return a, nil
+}
+
+func (r *Repository) CountRecent(userID string, since time.Time) (int, error) {
+	var count int
+	err := r.db.QueryRow(
+		`SELECT COUNT(*) FROM login_challenges WHERE user_id = $1 AND created_at > $2`,
+		userID, since,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("count challenges: %w", err)
+	}
+	return count, nil
+}
+
+func (r *Repository) CreateChallenge(userID, method, secretHash string, expiresAt time.Time, ipAddress string) error {
+	_, err := r.db.Exec(
+		`INSERT INTO login_challenges (user_id, method, secret_hash, expires_at, ip_address)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		userID, method, secretHash, expiresAt, ipAddress,
+	)
+	if err != nil {
+		return fmt.Errorf("create challenge: %w", err)
+	}
+	return nil
+}
+
+// GetActiveCode returns the user's most recent unconsumed, unexpired code
+// challenge.
+func (r *Repository) GetActiveCode(userID string) (*Challenge, error) {
+	c := &Challenge{}
+	err := r.db.QueryRow(
+		`SELECT id, user_id, method, secret_hash, attempts, expires_at
+		 FROM login_challenges
+		 WHERE user_id = $1 AND method = 'code'
+		 AND consumed_at IS NULL AND expires_at > NOW()
+		 ORDER BY created_at DESC
+		 LIMIT 1`,
+		userID,
+	).Scan(&c.ID, &c.UserID, &c.Method, &c.SecretHash, &c.Attempts, &c.ExpiresAt)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no active code")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query challenge: %w", err)
+	}
+
+	return c, nil
+}
+
+// RecordFailedAttempt increments the attempt counter and invalidates the
+// challenge once maxAttempts is reached.
+func (r *Repository) RecordFailedAttempt(id string, maxAttempts int) error {
+	_, err := r.db.Exec(
+		`UPDATE login_challenges
+		 SET attempts = attempts + 1,
+		     consumed_at = CASE WHEN attempts + 1 >= $2 THEN NOW() ELSE consumed_at END
+		 WHERE id = $1`,
+		id, maxAttempts,
+	)
+	return err
+}
+
+// Consume marks a challenge as used. It fails if the challenge was already
+// used or expired, which makes every challenge single-use even under
+// concurrent redemption.
+func (r *Repository) Consume(id string) error {
+	result, err := r.db.Exec(
+		`UPDATE login_challenges SET consumed_at = NOW()
+		 WHERE id = $1 AND consumed_at IS NULL AND expires_at > NOW()`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("consume challenge: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("consume challenge: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("challenge already used or expired")
+	}
+
+	return nil
+}
+
+// TenantAllowsPasswordless reports whether a tenant has enabled
+// passwordless login.
+func (r *Repository) TenantAllowsPasswordless(tenantID string) (bool, error) {
+	var enabled bool
+	err := r.db.QueryRow(
+		`SELECT passwordless_enabled FROM tenants WHERE id = $1`,
+		tenantID,
+	).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("query tenant: %w", err)
+	}
+	return enabled, nil
+}
+
+// GetLinkUser returns the user of the unexpired, unused link challenge with
+// the given hash without consuming it.
+func (r *Repository) GetLinkUser(secretHash string) (string, error) {
+	var userID string
+	err := r.db.QueryRow(
+		`SELECT user_id FROM login_challenges
+		 WHERE secret_hash = $1 AND method = 'link'
+		 AND consumed_at IS NULL AND expires_at > NOW()`,
+		secretHash,
+	).Scan(&userID)
+
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("invalid or expired link")
+	}
+	if err != nil {
+		return "", fmt.Errorf("query link: %w", err)
+	}
+
+	return userID, nil
+}
+
+// ConsumeLink marks the unexpired link challenge with the given hash as used
+// and returns its user.
+func (r *Repository) ConsumeLink(secretHash string) (string, error) {
+	var userID string
+	err := r.db.QueryRow(
+		`UPDATE login_challenges SET consumed_at = NOW()
+		 WHERE secret_hash = $1 AND method = 'link'
+		 AND consumed_at IS NULL AND expires_at > NOW()
+		 RETURNING user_id`,
+		secretHash,
+	).Scan(&userID)
+
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("invalid or expired link")
+	}
+	if err != nil {
+		return "", fmt.Errorf("consume link: %w", err)
+	}
+
+	return userID, nil
+}
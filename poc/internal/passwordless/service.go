@@ -0,0 +1,282 @@
+package passwordless
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/mail"
+)
+
+const (
+	MethodLink = "link"
+	MethodCode = "code"
+
+	defaultTTL         = 10 * time.Minute
+	defaultMaxAttempts = 5
+	defaultRateLimit   = 5
+	defaultRateWindow  = 15 * time.Minute
+)
+
+var (
+	ErrNotEligible = errors.New("passwordless login not available for account")
+	ErrRateLimited = errors.New("too many passwordless login requests")
+)
+
+type Service struct {
+	repo      *Repository
+	auth      *auth.Service
+	transport mail.Transport
+	cfg       *config.PasswordlessConfig
+	pepper    []byte
+}
+
+func NewService(repo *Repository, authService *auth.Service, transport mail.Transport, cfg *config.PasswordlessConfig, authCfg *config.AuthConfig) *Service {
+	return &Service{
+		repo:      repo,
+		auth:      authService,
+		transport: transport,
+		cfg:       cfg,
+		pepper:    []byte(authCfg.JWTSecret),
+	}
+}
+
+// Start issues a magic link or one-time code to the user's email. Only users
+// with a tenant that has enabled passwordless login and who hold no
+// platform role are eligible.
+func (s *Service) Start(email, method, ipAddress string) (string, error) {
+	if method != MethodLink && method != MethodCode {
+		return "", fmt.Errorf("method must be link or code")
+	}
+
+	account, err := s.repo.GetAccount(strings.ToLower(email))
+	if err != nil {
+		return "", ErrNotEligible
+	}
+	if err := eligible(account); err != nil {
+		return account.UserID, err
+	}
+
+	count, err := s.repo.CountRecent(account.UserID, time.Now().Add(-s.rateWindow()))
+	if err != nil {
+		return account.UserID, err
+	}
+	if count >= s.rateLimit() {
+		return account.UserID, ErrRateLimited
+	}
+
+	expiresAt := time.Now().Add(s.ttl())
+	msg := &mail.Message{To: account.Email}
+
+	switch method {
+	case MethodLink:
+		token, err := randomToken()
+		if err != nil {
+			return account.UserID, err
+		}
+		if err := s.repo.CreateChallenge(account.UserID, MethodLink, hashLinkToken(token), expiresAt, ipAddress); err != nil {
+			return account.UserID, err
+		}
+		msg.Subject = "Your Bastion sign-in link"
+		msg.Body = fmt.Sprintf(
+			"Use this link to sign in. It expires in %d minutes and can be used once.\r\n\r\n%s\r\n",
+			int(s.ttl().Minutes()), s.linkURL(token),
+		)
+	case MethodCode:
+		code, err := randomCode()
+		if err != nil {
+			return account.UserID, err
+		}
+		if err := s.repo.CreateChallenge(account.UserID, MethodCode, s.hashCode(account.UserID, code), expiresAt, ipAddress); err != nil {
+			return account.UserID, err
+		}
+		msg.Subject = "Your Bastion sign-in code"
+		msg.Body = fmt.Sprintf(
+			"Your sign-in code is %s. It expires in %d minutes and can be used once.\r\n",
+			code, int(s.ttl().Minutes()),
+		)
+	}
+
+	if err := s.transport.Send(msg); err != nil {
+		return account.UserID, fmt.Errorf("send mail: %w", err)
+	}
+
+	return account.UserID, nil
+}
+
+// RedeemLink exchanges a magic-link token for a new session in
+// requestedTenantID or, when nil, the tenant Login would pick. A
+// TenantSelectionError leaves the link unused so that it can be redeemed
+// again with a tenant chosen.
+func (s *Service) RedeemLink(token string, requestedTenantID *string) (*auth.IssuedSession, string, error) {
+	hash := hashLinkToken(token)
+	userID, err := s.repo.GetLinkUser(hash)
+	if err != nil {
+		return nil, "", err
+	}
+
+	account, err := s.repo.GetAccountByUserID(userID)
+	if err != nil {
+		return nil, userID, err
+	}
+	if err := eligible(account); err != nil {
+		return nil, userID, err
+	}
+	tenantID, err := s.sessionTenant(account, requestedTenantID)
+	if err != nil {
+		return nil, userID, err
+	}
+
+	consumedBy, err := s.repo.ConsumeLink(hash)
+	if err != nil {
+		return nil, userID, err
+	}
+	if consumedBy != userID {
+		return nil, userID, fmt.Errorf("invalid or expired link")
+	}
+
+	sess, err := s.auth.IssueSession(account.UserID, account.Email, tenantID, "", "", time.Now())
+	return sess, userID, err
+}
+
+// RedeemCode checks a one-time code against the user's latest active code
+// challenge and creates a session on success, in the tenant chosen as for
+// RedeemLink. Failed attempts are counted and the challenge is invalidated
+// after the configured maximum.
+func (s *Service) RedeemCode(email, code string, requestedTenantID *string) (*auth.IssuedSession, string, error) {
+	account, err := s.repo.GetAccount(strings.ToLower(email))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid code")
+	}
+	if err := eligible(account); err != nil {
+		return nil, account.UserID, err
+	}
+
+	challenge, err := s.repo.GetActiveCode(account.UserID)
+	if err != nil {
+		return nil, account.UserID, fmt.Errorf("invalid code")
+	}
+
+	if !hmac.Equal([]byte(challenge.SecretHash), []byte(s.hashCode(account.UserID, code))) {
+		if err := s.repo.RecordFailedAttempt(challenge.ID, s.maxAttempts()); err != nil {
+			return nil, account.UserID, fmt.Errorf("record attempt: %w", err)
+		}
+		return nil, account.UserID, fmt.Errorf("invalid code")
+	}
+
+	tenantID, err := s.sessionTenant(account, requestedTenantID)
+	if err != nil {
+		return nil, account.UserID, err
+	}
+
+	if err := s.repo.Consume(challenge.ID); err != nil {
+		return nil, account.UserID, fmt.Errorf("invalid code")
+	}
+
+	sess, err := s.auth.IssueSession(account.UserID, account.Email, tenantID, "", "", time.Now())
+	return sess, account.UserID, err
+}
+
+// sessionTenant resolves the tenant of a passwordless session the way Login
+// does and checks that the tenant itself allows passwordless login.
+func (s *Service) sessionTenant(account *Account, requestedTenantID *string) (*string, error) {
+	tenantID, err := s.auth.ResolveTenant(account.UserID, account.TenantID, requestedTenantID)
+	if err != nil {
+		return nil, err
+	}
+	if tenantID == nil {
+		return nil, ErrNotEligible
+	}
+	allowed, err := s.repo.TenantAllowsPasswordless(*tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrNotEligible
+	}
+	return tenantID, nil
+}
+
+func eligible(account *Account) error {
+	if !account.PasswordlessEnabled || account.Privileged {
+		return ErrNotEligible
+	}
+	return nil
+}
+
+// linkURL builds the emailed magic link. By default it opens Bastion's own
+// landing page (Handler.LinkLanding); an application page configured as
+// passwordless.link_base_url must likewise POST the token to the verify
+// endpoint.
+func (s *Service) linkURL(token string) string {
+	base := s.cfg.LinkBaseURL
+	if base == "" {
+		base = "http://localhost:8081/login/magic"
+	}
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+func (s *Service) hashCode(userID, code string) string {
+	mac := hmac.New(sha256.New, s.pepper)
+	mac.Write([]byte(userID + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Service) ttl() time.Duration {
+	if s.cfg.TTL == 0 {
+		return defaultTTL
+	}
+	return s.cfg.TTL
+}
+
+func (s *Service) maxAttempts() int {
+	if s.cfg.MaxAttempts == 0 {
+		return defaultMaxAttempts
+	}
+	return s.cfg.MaxAttempts
+}
+
+func (s *Service) rateLimit() int {
+	if s.cfg.RateLimit == 0 {
+		return defaultRateLimit
+	}
+	return s.cfg.RateLimit
+}
+
+func (s *Service) rateWindow() time.Duration {
+	if s.cfg.RateWindow == 0 {
+		return defaultRateWindow
+	}
+	return s.cfg.RateWindow
+}
+
+func hashLinkToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("generate random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func randomCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", fmt.Errorf("generate code: %w", err)
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
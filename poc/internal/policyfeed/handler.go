@@ -0,0 +1,155 @@
+package policyfeed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultWait = 30 * time.Second
+	maxWait     = 60 * time.Second
+
+	// heartbeatInterval keeps idle event streams from being closed by
+	// proxies.
+	heartbeatInterval = 15 * time.Second
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+type ChangesResponse struct {
+	Changes []*Change `json:"changes"`
+	Cursor  int64     `json:"cursor"`
+}
+
+// ListChanges long-polls the feed: it returns the changes after ?cursor=,
+// waiting up to ?wait= seconds for the first one. Without a cursor it
+// returns the current cursor immediately so that a new subscriber can start
+// from there.
+func (h *Handler) ListChanges(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("cursor") == "" {
+		cursor, err := h.service.Cursor()
+		if err != nil {
+			writeError(w, "failed to read policy changes", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ChangesResponse{Changes: []*Change{}, Cursor: cursor})
+		return
+	}
+
+	cursor, err := strconv.ParseInt(r.URL.Query().Get("cursor"), 10, 64)
+	if err != nil || cursor < 0 {
+		writeError(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+
+	wait := defaultWait
+	if v := r.URL.Query().Get("wait"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			writeError(w, "invalid wait", http.StatusBadRequest)
+			return
+		}
+		wait = min(time.Duration(seconds)*time.Second, maxWait)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+
+	changes, err := h.service.Wait(ctx, cursor)
+	if err != nil {
+		writeError(w, "failed to read policy changes", http.StatusInternalServerError)
+		return
+	}
+
+	resp := ChangesResponse{Changes: changes, Cursor: cursor}
+	if len(changes) > 0 {
+		resp.Cursor = changes[len(changes)-1].ID
+	} else {
+		resp.Changes = []*Change{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// StreamChanges streams the feed as server-sent events. Each event's id is
+// its cursor, so a reconnecting EventSource resumes via Last-Event-ID;
+// ?cursor= does the same for other clients. Without either the stream
+// starts at the current end of the feed.
+func (h *Handler) StreamChanges(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	start := r.Header.Get("Last-Event-ID")
+	if start == "" {
+		start = r.URL.Query().Get("cursor")
+	}
+
+	var cursor int64
+	if start != "" {
+		var err error
+		cursor, err = strconv.ParseInt(start, 10, 64)
+		if err != nil || cursor < 0 {
+			writeError(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+	} else {
+		var err error
+		cursor, err = h.service.Cursor()
+		if err != nil {
+			writeError(w, "failed to read policy changes", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: 1000\n\n")
+	flusher.Flush()
+
+	for {
+		ctx, cancel := context.WithTimeout(r.Context(), heartbeatInterval)
+		changes, err := h.service.Wait(ctx, cursor)
+		cancel()
+
+		if r.Context().Err() != nil {
+			return
+		}
+		if err != nil {
+			// The client reconnects and resumes from its last event.
+			return
+		}
+
+		if len(changes) == 0 {
+			fmt.Fprintf(w, ": heartbeat\n\n")
+		}
+		for _, c := range changes {
+			data, _ := json.Marshal(c)
+			fmt.Fprintf(w, "id: %d\nevent: policy_change\ndata: %s\n\n", c.ID, data)
+			cursor = c.ID
+		}
+		flusher.Flush()
+	}
+}
+
+func writeError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,65 @@
+package policyfeed
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Change is one entry of the policy change feed. Its ID is the cursor from
+// which a subscriber resumes.
+type Change struct {
+	ID               int64     `json:"id"`
+	Type             string    `json:"type"`
+	UserID           *string   `json:"user_id,omitempty"`
+	ServiceAccountID *string   `json:"service_account_id,omitempty"`
+	RoleID           *string   `json:"role_id,omitempty"`
+	TenantID         *string   `json:"tenant_id,omitempty"`
+	ResourceType     *string   `json:"resource_type,omitempty"`
+	Action           *string   `json:"action,omitempty"`
+	ResourceID       *string   `json:"resource_id,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) ListSince(after int64, limit int) ([]*Change, error) {
+	rows, err := r.db.Query(
+		`SELECT id, change_type, user_id, service_account_id, role_id, tenant_id, resource_type, action, resource_id, created_at
+		 FROM policy_changes
+		 WHERE id > $1
+		 ORDER BY id
+		 LIMIT $2`,
+		after, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list policy changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []*Change
+	for rows.Next() {
+		c := &Change{}
+		if err := rows.Scan(&c.ID, &c.Type, &c.UserID, &c.ServiceAccountID, &c.RoleID, &c.TenantID, &c.ResourceType, &c.Action, &c.ResourceID, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan policy change: %w", err)
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
+
+func (r *Repository) Latest() (int64, error) {
+	var id int64
+	err := r.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM policy_changes`).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("get latest policy change: %w", err)
+	}
+	return id, nil
+}
@@ -0,0 +1,103 @@
+package policyfeed
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	notifyChannel = "policy_changes"
+	batchSize     = 100
+
+	// pollInterval wakes waiting subscribers even without a notification,
+	// covering notifications lost while the listener was reconnecting.
+	pollInterval = 10 * time.Second
+)
+
+// Service serves the policy change feed. Changes are recorded by database
+// triggers on role assignments, role permissions, permissions and the
+// resource registry; the service listens for their notifications and wakes
+// subscribers waiting for new entries.
+type Service struct {
+	repo *Repository
+
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo, wake: make(chan struct{})}
+}
+
+// Start listens for change notifications on a dedicated connection. It
+// runs for the lifetime of the process; if listening fails, subscribers are
+// only woken every pollInterval.
+func (s *Service) Start(dsn string) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("policy change listener: %v", err)
+		}
+	})
+	if err := listener.Listen(notifyChannel); err != nil {
+		log.Printf("policy change listener: listen: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-listener.Notify:
+			case <-ticker.C:
+			}
+			s.broadcast()
+		}
+	}()
+}
+
+func (s *Service) broadcast() {
+	s.mu.Lock()
+	close(s.wake)
+	s.wake = make(chan struct{})
+	s.mu.Unlock()
+}
+
+func (s *Service) changed() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.wake
+}
+
+// Cursor returns the ID of the latest change, from which a new subscriber
+// starts.
+func (s *Service) Cursor() (int64, error) {
+	return s.repo.Latest()
+}
+
+// Wait returns the changes after cursor, blocking until there are some or
+// ctx is done. It returns no changes and no error when ctx ends first.
+func (s *Service) Wait(ctx context.Context, cursor int64) ([]*Change, error) {
+	for {
+		// Take the wake channel before querying so that a change committed
+		// in between still wakes us.
+		wake := s.changed()
+
+		changes, err := s.repo.ListSince(cursor, batchSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) > 0 {
+			return changes, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-wake:
+		}
+	}
+}
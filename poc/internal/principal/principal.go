@@ -0,0 +1,72 @@
+// Package principal identifies the authenticated caller of a request,
+// whichever credential it presented: a user or service account token, or an
+// API key.
+package principal
+
+import (
+	"context"
+	"strings"
+)
+
+const (
+	TypeUser           = "user"
+	TypeServiceAccount = "service_account"
+	TypeAPIKey         = "api_key"
+)
+
+type Principal struct {
+	Type     string
+	ID       string
+	TenantID *string
+
+	// Scope restricts a token to the listed permissions; empty means the
+	// principal is limited only by its roles.
+	Scope string
+}
+
+func (p *Principal) IsUser() bool {
+	return p.Type == TypeUser
+}
+
+// UserID returns the ID of a user principal and "" otherwise, for audit
+// entries and columns that reference users.
+func (p *Principal) UserID() string {
+	if p.Type != TypeUser {
+		return ""
+	}
+	return p.ID
+}
+
+// OptionalUserID is UserID for nullable columns that reference users.
+func (p *Principal) OptionalUserID() *string {
+	if p.Type != TypeUser {
+		return nil
+	}
+	id := p.ID
+	return &id
+}
+
+// HasScope reports whether the principal's scope covers a permission.
+func (p *Principal) HasScope(resourceType, action string) bool {
+	if p.Scope == "" {
+		return true
+	}
+	want := resourceType + ":" + action
+	for _, s := range strings.Fields(p.Scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok
+}
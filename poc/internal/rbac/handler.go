@@ -2,10 +2,13 @@ package rbac
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/condition"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
 )
 
 type Handler struct {
@@ -22,16 +25,12 @@ type RevokeRoleRequest struct {
 	TenantID *string `json:"tenant_id"`
 }
 
-type AuthzCheckRequest struct {
-	UserID       string  `json:"user_id"`
-	TenantID     *string `json:"tenant_id"`
-	ResourceType string  `json:"resource_type"`
-	Action       string  `json:"action"`
+type AuthzCheckBatchRequest struct {
+	Checks []CheckRequest `json:"checks"`
 }
 
-type AuthzCheckResponse struct {
-	Allowed bool   `json:"allowed"`
-	Reason  string `json:"reason"`
+type AuthzCheckBatchResponse struct {
+	Results []Decision `json:"results"`
 }
 
 type PermissionResponse struct {
@@ -46,10 +45,11 @@ type UserPermissionsResponse struct {
 }
 
 type RoleResponse struct {
-	ID        string  `json:"id"`
-	Name      string  `json:"name"`
-	GrantedAt string  `json:"granted_at"`
-	TenantID  *string `json:"tenant_id,omitempty"`
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	GrantedAt     string  `json:"granted_at"`
+	TenantID      *string `json:"tenant_id,omitempty"`
+	InheritedFrom *string `json:"inherited_from,omitempty"`
 }
 
 type UserRolesResponse struct {
@@ -75,11 +75,11 @@ func (h *Handler) AssignRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims := r.Context().Value("claims").(*auth.Claims)
-	grantedBy := &claims.UserID
+	caller, _ := principal.FromContext(r.Context())
+	grantedBy := caller.OptionalUserID()
 
-	if err := h.service.AssignRole(req.UserID, roleID, req.TenantID, grantedBy); err != nil {
-		writeError(w, err.Error(), http.StatusBadRequest)
+	if err := h.service.AssignRole(r.Context(), caller.TenantID, req.UserID, roleID, req.TenantID, grantedBy); err != nil {
+		writeAssignmentError(w, err)
 		return
 	}
 
@@ -106,8 +106,9 @@ func (h *Handler) RevokeRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.RevokeRole(req.UserID, roleID, req.TenantID); err != nil {
-		writeError(w, err.Error(), http.StatusBadRequest)
+	caller, _ := principal.FromContext(r.Context())
+	if err := h.service.RevokeRole(r.Context(), caller.TenantID, req.UserID, roleID, req.TenantID); err != nil {
+		writeAssignmentError(w, err)
 		return
 	}
 
@@ -141,10 +142,11 @@ func (h *Handler) GetUserRoles(w http.ResponseWriter, r *http.Request) {
 
 	for _, role := range roles {
 		resp.Roles = append(resp.Roles, RoleResponse{
-			ID:        role.RoleID,
-			Name:      role.RoleName,
-			GrantedAt: role.GrantedAt.Format("2006-01-02T15:04:05Z07:00"),
-			TenantID:  role.TenantID,
+			ID:            role.RoleID,
+			Name:          role.RoleName,
+			GrantedAt:     role.GrantedAt.Format("2006-01-02T15:04:05Z07:00"),
+			TenantID:      role.TenantID,
+			InheritedFrom: role.InheritedFrom,
 		})
 	}
 
@@ -190,29 +192,572 @@ func (h *Handler) GetUserPermissions(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// CheckAuthorization implements the DD-001 §4.2 check contract for users,
+// service accounts and API keys.
 func (h *Handler) CheckAuthorization(w http.ResponseWriter, r *http.Request) {
-	var req AuthzCheckRequest
+	var req CheckRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeError(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	allowed, reason, err := h.service.CheckPermission(req.UserID, req.TenantID, req.ResourceType, req.Action)
+	caller, _ := principal.FromContext(r.Context())
+	decision, err := h.service.Check(r.Context(), caller, req)
+	if errors.Is(err, ErrInvalidCheck) {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		writeError(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, "authorization check failed", http.StatusInternalServerError)
 		return
 	}
 
-	resp := AuthzCheckResponse{
-		Allowed: allowed,
-		Reason:  reason,
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(decision)
+}
+
+// CheckAuthorizationBatch evaluates up to MaxBatchChecks checks and returns
+// their decisions in request order.
+func (h *Handler) CheckAuthorizationBatch(w http.ResponseWriter, r *http.Request) {
+	var req AuthzCheckBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	decisions, err := h.service.CheckBatch(r.Context(), caller, req.Checks)
+	if errors.Is(err, ErrInvalidCheck) {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeError(w, "authorization check failed", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AuthzCheckBatchResponse{Results: decisions})
+}
+
+type CreateRoleRequest struct {
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	RoleType        string `json:"role_type"`
+	ApplicationName string `json:"application_name"`
+}
+
+type UpdateRoleRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// AddRolePermissionsRequest grants permissions in Scope, tenant scope when
+// it is empty, under Conditions when they are set.
+type AddRolePermissionsRequest struct {
+	PermissionIDs []string              `json:"permission_ids"`
+	Scope         string                `json:"scope,omitempty"`
+	Conditions    *condition.Conditions `json:"conditions,omitempty"`
+}
+
+type AddRoleParentRequest struct {
+	ParentID string `json:"parent_id"`
+}
+
+type RoleDetailResponse struct {
+	*Role
+	Parents     []*Role           `json:"parents"`
+	Permissions []*RolePermission `json:"permissions"`
+}
+
+// ListRoles lists the roles visible to the caller: a caller bound to a
+// tenant does not see the custom roles of other tenants.
+func (h *Handler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principal.FromContext(r.Context())
+	h.listRoles(w, func() ([]*Role, error) { return h.service.ListRoles(caller.TenantID) })
+}
+
+func (h *Handler) CreateRole(w http.ResponseWriter, r *http.Request) {
+	var req CreateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	h.createRole(w, r, req.Name, req.Description, req.RoleType, req.ApplicationName, nil)
+}
+
+func (h *Handler) GetRole(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	caller, _ := principal.FromContext(r.Context())
+
+	role, err := h.service.GetRole(id, caller.TenantID)
+	if err != nil {
+		writeRoleError(w, err, "failed to get role")
+		return
+	}
+
+	parents, err := h.service.GetRoleParents(id, caller.TenantID)
+	if err != nil {
+		writeRoleError(w, err, "failed to get role parents")
+		return
+	}
+	if parents == nil {
+		parents = []*Role{}
+	}
+
+	perms, err := h.service.GetRolePermissions(id, caller.TenantID)
+	if err != nil {
+		writeRoleError(w, err, "failed to get role permissions")
+		return
+	}
+	if perms == nil {
+		perms = []*RolePermission{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RoleDetailResponse{Role: role, Parents: parents, Permissions: perms})
+}
+
+func (h *Handler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	h.updateRole(w, r, nil, chi.URLParam(r, "id"))
+}
+
+func (h *Handler) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	h.deleteRole(w, r, nil, chi.URLParam(r, "id"))
+}
+
+// GetRolePermissions lists the role's permissions with their scopes,
+// marking each as direct, inherited from the named ancestor roles, or both.
+func (h *Handler) GetRolePermissions(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principal.FromContext(r.Context())
+	perms, err := h.service.GetRolePermissions(chi.URLParam(r, "id"), caller.TenantID)
+	if err != nil {
+		writeRoleError(w, err, "failed to get role permissions")
+		return
+	}
+
+	if perms == nil {
+		perms = []*RolePermission{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"permissions": perms})
+}
+
+func (h *Handler) AddRolePermissions(w http.ResponseWriter, r *http.Request) {
+	h.addRolePermissions(w, r, nil, chi.URLParam(r, "id"))
+}
+
+func (h *Handler) RemoveRolePermission(w http.ResponseWriter, r *http.Request) {
+	h.removeRolePermission(w, r, nil, chi.URLParam(r, "id"))
+}
+
+func (h *Handler) GetRoleParents(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principal.FromContext(r.Context())
+	parents, err := h.service.GetRoleParents(chi.URLParam(r, "id"), caller.TenantID)
+	if err != nil {
+		writeRoleError(w, err, "failed to get role parents")
+		return
+	}
+
+	if parents == nil {
+		parents = []*Role{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"parents": parents})
+}
+
+func (h *Handler) AddRoleParent(w http.ResponseWriter, r *http.Request) {
+	h.addRoleParent(w, r, nil, chi.URLParam(r, "id"))
+}
+
+func (h *Handler) RemoveRoleParent(w http.ResponseWriter, r *http.Request) {
+	h.removeRoleParent(w, r, nil, chi.URLParam(r, "id"))
+}
+
+type CreateTenantRoleRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ListTenantRoles and the other /tenants/{id}/roles endpoints manage the
+// custom roles of one tenant. They are open to tenant admins, so only the
+// tenant's own roles can be changed through them.
+func (h *Handler) ListTenantRoles(w http.ResponseWriter, r *http.Request) {
+	scope, ok := tenantScope(w, r)
+	if !ok {
+		return
+	}
+	h.listRoles(w, func() ([]*Role, error) { return h.service.ListTenantRoles(*scope) })
+}
+
+func (h *Handler) CreateTenantRole(w http.ResponseWriter, r *http.Request) {
+	scope, ok := tenantScope(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateTenantRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	h.createRole(w, r, req.Name, req.Description, RoleTypeTenant, "", scope)
+}
+
+func (h *Handler) UpdateTenantRole(w http.ResponseWriter, r *http.Request) {
+	if scope, ok := tenantScope(w, r); ok {
+		h.updateRole(w, r, scope, chi.URLParam(r, "roleId"))
+	}
+}
+
+func (h *Handler) DeleteTenantRole(w http.ResponseWriter, r *http.Request) {
+	if scope, ok := tenantScope(w, r); ok {
+		h.deleteRole(w, r, scope, chi.URLParam(r, "roleId"))
+	}
+}
+
+func (h *Handler) AddTenantRolePermissions(w http.ResponseWriter, r *http.Request) {
+	if scope, ok := tenantScope(w, r); ok {
+		h.addRolePermissions(w, r, scope, chi.URLParam(r, "roleId"))
+	}
+}
+
+func (h *Handler) RemoveTenantRolePermission(w http.ResponseWriter, r *http.Request) {
+	if scope, ok := tenantScope(w, r); ok {
+		h.removeRolePermission(w, r, scope, chi.URLParam(r, "roleId"))
+	}
+}
+
+func (h *Handler) AddTenantRoleParent(w http.ResponseWriter, r *http.Request) {
+	if scope, ok := tenantScope(w, r); ok {
+		h.addRoleParent(w, r, scope, chi.URLParam(r, "roleId"))
+	}
+}
+
+func (h *Handler) RemoveTenantRoleParent(w http.ResponseWriter, r *http.Request) {
+	if scope, ok := tenantScope(w, r); ok {
+		h.removeRoleParent(w, r, scope, chi.URLParam(r, "roleId"))
+	}
+}
+
+// tenantScope returns the tenant of a /tenants/{id} request. Callers bound
+// to a tenant may only act on their own.
+func writeAssignmentError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrAssignmentScope) {
+		writeError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	writeError(w, err.Error(), http.StatusBadRequest)
+}
+
+func tenantScope(w http.ResponseWriter, r *http.Request) (*string, bool) {
+	tenantID := chi.URLParam(r, "id")
+	caller, _ := principal.FromContext(r.Context())
+	if caller.TenantID != nil && *caller.TenantID != tenantID {
+		writeError(w, "token is bound to another tenant", http.StatusForbidden)
+		return nil, false
+	}
+	return &tenantID, true
+}
+
+func (h *Handler) listRoles(w http.ResponseWriter, list func() ([]*Role, error)) {
+	roles, err := list()
+	if err != nil {
+		writeError(w, "failed to list roles", http.StatusInternalServerError)
+		return
+	}
+
+	if roles == nil {
+		roles = []*Role{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"roles": roles})
+}
+
+func (h *Handler) createRole(w http.ResponseWriter, r *http.Request, name, description, roleType, applicationName string, tenantID *string) {
+	caller, _ := principal.FromContext(r.Context())
+	role, err := h.service.CreateRole(r.Context(), caller.UserID(), name, description, roleType, applicationName, tenantID)
+	if err != nil {
+		writeRoleError(w, err, "failed to create role")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(RoleDetailResponse{Role: role, Parents: []*Role{}, Permissions: []*RolePermission{}})
+}
+
+func (h *Handler) updateRole(w http.ResponseWriter, r *http.Request, scope *string, roleID string) {
+	var req UpdateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	role, err := h.service.UpdateRole(r.Context(), caller.UserID(), scope, roleID, req.Name, req.Description)
+	if err != nil {
+		writeRoleError(w, err, "failed to update role")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
+}
+
+func (h *Handler) deleteRole(w http.ResponseWriter, r *http.Request, scope *string, roleID string) {
+	caller, _ := principal.FromContext(r.Context())
+	if err := h.service.DeleteRole(r.Context(), caller.UserID(), scope, roleID); err != nil {
+		writeRoleError(w, err, "failed to delete role")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) addRolePermissions(w http.ResponseWriter, r *http.Request, scope *string, roleID string) {
+	var req AddRolePermissionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	perms, err := h.service.AddRolePermissions(r.Context(), caller.UserID(), scope, roleID, req.PermissionIDs, req.Scope, req.Conditions)
+	if err != nil {
+		writeRoleError(w, err, "failed to add role permissions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"permissions": perms})
+}
+
+// removeRolePermission revokes the permission in the scope given by the
+// scope query parameter, or in all scopes without one.
+func (h *Handler) removeRolePermission(w http.ResponseWriter, r *http.Request, scope *string, roleID string) {
+	caller, _ := principal.FromContext(r.Context())
+	err := h.service.RemoveRolePermission(r.Context(), caller.UserID(), scope, roleID, chi.URLParam(r, "permId"), r.URL.Query().Get("scope"))
+	if err != nil {
+		writeRoleError(w, err, "failed to remove role permission")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) addRoleParent(w http.ResponseWriter, r *http.Request, scope *string, roleID string) {
+	var req AddRoleParentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	perms, err := h.service.AddRoleParent(r.Context(), caller.UserID(), scope, roleID, req.ParentID)
+	if err != nil {
+		writeRoleError(w, err, "failed to add role parent")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"permissions": perms})
+}
+
+func (h *Handler) removeRoleParent(w http.ResponseWriter, r *http.Request, scope *string, roleID string) {
+	caller, _ := principal.FromContext(r.Context())
+	err := h.service.RemoveRoleParent(r.Context(), caller.UserID(), scope, roleID, chi.URLParam(r, "parentId"))
+	if err != nil {
+		writeRoleError(w, err, "failed to remove role parent")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListPermissions(w http.ResponseWriter, r *http.Request) {
+	perms, err := h.service.ListPermissions()
+	if err != nil {
+		writeError(w, "failed to list permissions", http.StatusInternalServerError)
+		return
+	}
+
+	if perms == nil {
+		perms = []*Permission{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"permissions": perms})
+}
+
+func writeRoleError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, ErrRoleNotFound), errors.Is(err, ErrPermissionNotFound), errors.Is(err, ErrNotInherited):
+		writeError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrSystemRole):
+		writeError(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrRoleExists), errors.Is(err, ErrRoleCycle):
+		writeError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalidRole):
+		writeError(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeError(w, message, http.StatusInternalServerError)
+	}
+}
+
+type SaveClaimsProfileRequest struct {
+	Audience     string `json:"audience"`
+	Embed        string `json:"embed"`
+	IncludeRoles bool   `json:"include_roles"`
+}
+
+type PermissionVersionResponse struct {
+	UserID  string `json:"user_id"`
+	Version int64  `json:"version"`
+	Stale   *bool  `json:"stale,omitempty"`
+}
+
+// GetPermissionVersion reports a user's current permission version. When
+// the caller passes the perms_ver of a token as ?version=, the response says
+// whether that token's embedded permissions are stale.
+func (h *Handler) GetPermissionVersion(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+
+	version, err := h.service.GetPermissionVersion(userID)
+	if err != nil {
+		writeError(w, "failed to get permission version", http.StatusInternalServerError)
+		return
+	}
+
+	resp := PermissionVersionResponse{UserID: userID, Version: version}
+	if v := r.URL.Query().Get("version"); v != "" {
+		tokenVersion, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeError(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+		stale := tokenVersion < version
+		resp.Stale = &stale
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+func (h *Handler) ListClaimsProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := h.service.ListClaimsProfiles()
+	if err != nil {
+		writeError(w, "failed to list claims profiles", http.StatusInternalServerError)
+		return
+	}
+
+	if profiles == nil {
+		profiles = []*ClaimsProfile{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"profiles": profiles})
+}
+
+func (h *Handler) SaveClaimsProfile(w http.ResponseWriter, r *http.Request) {
+	var req SaveClaimsProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	profile, err := h.service.SaveClaimsProfile(r.Context(), caller.UserID(), req.Audience, req.Embed, req.IncludeRoles)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+func (h *Handler) DeleteClaimsProfile(w http.ResponseWriter, r *http.Request) {
+	audience := r.URL.Query().Get("audience")
+	if audience == "" {
+		writeError(w, "audience required", http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	if err := h.service.DeleteClaimsProfile(r.Context(), caller.UserID(), audience); err != nil {
+		writeError(w, "failed to delete claims profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetPrincipalAttributes(w http.ResponseWriter, r *http.Request) {
+	identityType := chi.URLParam(r, "identityType")
+	identityID := chi.URLParam(r, "identityId")
+
+	attrs, err := h.service.GetPrincipalAttributes(identityType, identityID)
+	if err != nil {
+		writeAttributesError(w, err, "failed to get principal attributes")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"identity_type": identityType,
+		"identity_id":   identityID,
+		"attributes":    attrs,
+	})
+}
+
+// SetPrincipalAttributes replaces a principal's attributes with the
+// "attributes" object of the request body.
+func (h *Handler) SetPrincipalAttributes(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Attributes map[string]interface{} `json:"attributes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	identityType := chi.URLParam(r, "identityType")
+	identityID := chi.URLParam(r, "identityId")
+
+	caller, _ := principal.FromContext(r.Context())
+	if err := h.service.SetPrincipalAttributes(r.Context(), caller.UserID(), identityType, identityID, req.Attributes); err != nil {
+		writeAttributesError(w, err, "failed to set principal attributes")
+		return
+	}
+
+	if req.Attributes == nil {
+		req.Attributes = map[string]interface{}{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"identity_type": identityType,
+		"identity_id":   identityID,
+		"attributes":    req.Attributes,
+	})
+}
+
+func writeAttributesError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, ErrInvalidAttributes) {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeError(w, message, http.StatusInternalServerError)
+}
+
 func writeError(w http.ResponseWriter, message string, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
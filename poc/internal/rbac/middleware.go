@@ -2,47 +2,41 @@ package rbac
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
-	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
 )
 
-type APIKeyContext struct {
-	APIKeyID string
-	TenantID *string
-}
-
+// RequirePermission allows the request only if its principal holds the
+// permission: users and service accounts through their roles in the
+// principal's tenant, API keys through their directly granted permissions.
 func RequirePermission(service *Service, resourceType, action string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			apiKeyCtx := r.Context().Value("apikey")
-			if apiKeyCtx != nil {
-				keyInfo := apiKeyCtx.(*APIKeyContext)
-				allowed, err := service.CheckAPIKeyPermission(keyInfo.APIKeyID, resourceType, action)
-				if err != nil {
-					writeAuthError(w, "authorization check failed", http.StatusInternalServerError)
-					return
-				}
-				if !allowed {
-					writeAuthError(w, "permission denied", http.StatusForbidden)
-					return
-				}
-				next.ServeHTTP(w, r)
+			p, ok := principal.FromContext(r.Context())
+			if !ok {
+				writeAuthError(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
 
-			claims := r.Context().Value("claims").(*auth.Claims)
-			if claims == nil {
-				writeAuthError(w, "unauthorized", http.StatusUnauthorized)
+			if !p.HasScope(resourceType, action) {
+				writeAuthError(w, fmt.Sprintf("token scope does not include %s:%s", resourceType, action), http.StatusForbidden)
 				return
 			}
 
-			allowed, reason, err := service.CheckPermission(
-				claims.UserID,
-				claims.TenantID,
-				resourceType,
-				action,
-			)
+			ctx := WithCheckContext(r.Context(), RequestCheckContext(r))
+			var allowed bool
+			var reason string
+			var err error
+			switch p.Type {
+			case principal.TypeAPIKey:
+				allowed, reason, err = service.CheckAPIKeyPermission(ctx, p.ID, p.TenantID, resourceType, action)
+			case principal.TypeServiceAccount:
+				allowed, reason, err = service.CheckServiceAccountPermission(ctx, p.ID, p.TenantID, resourceType, action)
+			default:
+				allowed, reason, err = service.CheckPermission(ctx, p.ID, p.TenantID, resourceType, action)
+			}
 
 			if err != nil {
 				writeAuthError(w, "authorization check failed", http.StatusInternalServerError)
@@ -2,8 +2,12 @@ package rbac
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
+
+	"github.com/rustybrownlee-llm/bastion/poc/internal/condition"
 )
 
 type Role struct {
@@ -12,6 +16,8 @@ type Role struct {
 	Description     string    `json:"description"`
 	RoleType        string    `json:"role_type"`
 	ApplicationName string    `json:"application_name,omitempty"`
+	TenantID        *string   `json:"tenant_id,omitempty"`
+	IsSystem        bool      `json:"is_system"`
 	CreatedAt       time.Time `json:"created_at"`
 }
 
@@ -22,12 +28,15 @@ type Permission struct {
 	Description  string `json:"description,omitempty"`
 }
 
+// UserRole is a role a user holds. Roles held through inheritance name the
+// assigned role they are inherited through.
 type UserRole struct {
-	UserID    string    `json:"user_id"`
-	RoleID    string    `json:"role_id"`
-	RoleName  string    `json:"role_name"`
-	TenantID  *string   `json:"tenant_id"`
-	GrantedAt time.Time `json:"granted_at"`
+	UserID        string    `json:"user_id"`
+	RoleID        string    `json:"role_id"`
+	RoleName      string    `json:"role_name"`
+	TenantID      *string   `json:"tenant_id"`
+	GrantedAt     time.Time `json:"granted_at"`
+	InheritedFrom *string   `json:"inherited_from,omitempty"`
 }
 
 type Repository struct {
@@ -38,46 +47,52 @@ func NewRepository(db *sql.DB) *Repository {
 	return &Repository{db: db}
 }
 
-func (r *Repository) GetRoleByID(id string) (*Role, error) {
+// ErrRoleNotFound is returned when no role has the requested ID or name.
+var ErrRoleNotFound = errors.New("role not found")
+
+const roleColumns = `id, name, COALESCE(description, ''), role_type, COALESCE(application_name, ''), tenant_id, is_system, created_at`
+
+func scanRole(row interface{ Scan(...interface{}) error }) (*Role, error) {
 	var role Role
-	err := r.db.QueryRow(
-		`SELECT id, name, description, role_type, COALESCE(application_name, ''), created_at
-		 FROM roles WHERE id = $1`,
-		id,
-	).Scan(&role.ID, &role.Name, &role.Description, &role.RoleType, &role.ApplicationName, &role.CreatedAt)
+	err := row.Scan(&role.ID, &role.Name, &role.Description, &role.RoleType, &role.ApplicationName, &role.TenantID, &role.IsSystem, &role.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
 
+func (r *Repository) GetRoleByID(id string) (*Role, error) {
+	role, err := scanRole(r.db.QueryRow(`SELECT `+roleColumns+` FROM roles WHERE id = $1`, id))
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("role not found")
+		return nil, ErrRoleNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("query role: %w", err)
 	}
 
-	return &role, nil
+	return role, nil
 }
 
 func (r *Repository) GetRoleByName(name string) (*Role, error) {
-	var role Role
-	err := r.db.QueryRow(
-		`SELECT id, name, description, role_type, COALESCE(application_name, ''), created_at
-		 FROM roles WHERE name = $1`,
-		name,
-	).Scan(&role.ID, &role.Name, &role.Description, &role.RoleType, &role.ApplicationName, &role.CreatedAt)
-
+	role, err := scanRole(r.db.QueryRow(`SELECT `+roleColumns+` FROM roles WHERE name = $1`, name))
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("role not found")
+		return nil, ErrRoleNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("query role: %w", err)
 	}
 
-	return &role, nil
+	return role, nil
 }
 
-func (r *Repository) ListRoles() ([]*Role, error) {
+// ListRoles lists the roles visible in tenantID: platform and application
+// roles and the tenant's own roles. A nil tenantID lists every role.
+func (r *Repository) ListRoles(tenantID *string) ([]*Role, error) {
 	rows, err := r.db.Query(
-		`SELECT id, name, description, role_type, COALESCE(application_name, ''), created_at
-		 FROM roles ORDER BY role_type, name`,
+		`SELECT `+roleColumns+` FROM roles
+		 WHERE tenant_id IS NULL OR $1::uuid IS NULL OR tenant_id = $1
+		 ORDER BY role_type, name`,
+		tenantID,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("query roles: %w", err)
@@ -86,16 +101,127 @@ func (r *Repository) ListRoles() ([]*Role, error) {
 
 	var roles []*Role
 	for rows.Next() {
-		var role Role
-		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.RoleType, &role.ApplicationName, &role.CreatedAt); err != nil {
+		role, err := scanRole(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan role: %w", err)
 		}
-		roles = append(roles, &role)
+		roles = append(roles, role)
 	}
 
 	return roles, rows.Err()
 }
 
+func (r *Repository) CreateRole(name, description, roleType, applicationName string, tenantID *string) (*Role, error) {
+	role, err := scanRole(r.db.QueryRow(
+		`INSERT INTO roles (name, description, role_type, application_name, tenant_id)
+		 VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		 RETURNING `+roleColumns,
+		name, description, roleType, applicationName, tenantID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("create role: %w", err)
+	}
+	return role, nil
+}
+
+// UpdateRole renames and redescribes a non-system role. Role types are
+// fixed at creation.
+func (r *Repository) UpdateRole(id, name, description string) (*Role, error) {
+	role, err := scanRole(r.db.QueryRow(
+		`UPDATE roles SET name = $2, description = $3
+		 WHERE id = $1 AND NOT is_system
+		 RETURNING `+roleColumns,
+		id, name, description,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("update role: %w", err)
+	}
+	return role, nil
+}
+
+// DeleteRole deletes a non-system role. Its permissions and assignments go
+// with it.
+func (r *Repository) DeleteRole(id string) (bool, error) {
+	result, err := r.db.Exec(`DELETE FROM roles WHERE id = $1 AND NOT is_system`, id)
+	if err != nil {
+		return false, fmt.Errorf("delete role: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// AddRolePermission grants a permission to a role in a scope, replacing
+// the conditions of an existing grant. It reports whether anything changed.
+func (r *Repository) AddRolePermission(roleID, permissionID, scope string, conditions *condition.Conditions) (bool, error) {
+	result, err := r.db.Exec(
+		`INSERT INTO role_permissions (role_id, permission_id, scope, conditions)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (role_id, permission_id, scope) DO UPDATE SET conditions = EXCLUDED.conditions
+		 WHERE role_permissions.conditions IS DISTINCT FROM EXCLUDED.conditions`,
+		roleID, permissionID, scope, conditions,
+	)
+	if err != nil {
+		return false, fmt.Errorf("add role permission: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// RemoveRolePermission removes a permission from a role in one scope, or
+// in every scope when scope is empty.
+func (r *Repository) RemoveRolePermission(roleID, permissionID, scope string) (bool, error) {
+	result, err := r.db.Exec(
+		`DELETE FROM role_permissions
+		 WHERE role_id = $1 AND permission_id = $2 AND ($3 = '' OR scope = $3)`,
+		roleID, permissionID, scope,
+	)
+	if err != nil {
+		return false, fmt.Errorf("remove role permission: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+var ErrPermissionNotFound = errors.New("permission not found")
+
+func (r *Repository) GetTenantSlug(tenantID string) (string, error) {
+	var slug string
+	err := r.db.QueryRow(`SELECT slug FROM tenants WHERE id = $1`, tenantID).Scan(&slug)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("tenant not found")
+	}
+	if err != nil {
+		return "", fmt.Errorf("query tenant: %w", err)
+	}
+	return slug, nil
+}
+
+// GetTenantAttributes returns the tenant as policy expressions see it:
+// its id, slug, name and settings, or nil if it does not exist.
+func (r *Repository) GetTenantAttributes(tenantID string) (map[string]interface{}, error) {
+	var id, slug, name string
+	var data []byte
+	err := r.db.QueryRow(
+		`SELECT id, slug, name, COALESCE(settings, '{}') FROM tenants WHERE id = $1`,
+		tenantID,
+	).Scan(&id, &slug, &name, &data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query tenant: %w", err)
+	}
+
+	settings := map[string]interface{}{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("decode tenant settings: %w", err)
+	}
+	return map[string]interface{}{"id": id, "slug": slug, "name": name, "settings": settings}, nil
+}
+
 func (r *Repository) GetPermissionByID(id string) (*Permission, error) {
 	var perm Permission
 	err := r.db.QueryRow(
@@ -105,7 +231,7 @@ func (r *Repository) GetPermissionByID(id string) (*Permission, error) {
 	).Scan(&perm.ID, &perm.ResourceType, &perm.Action, &perm.Description)
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("permission not found")
+		return nil, ErrPermissionNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("query permission: %w", err)
@@ -162,6 +288,183 @@ func (r *Repository) GetRolePermissions(roleID string) ([]*Permission, error) {
 	return perms, rows.Err()
 }
 
+// RolePermission is a permission a role holds in a scope, under conditions
+// if any, directly, through one or more of its ancestors, or both.
+type RolePermission struct {
+	Permission
+	Scope         string                `json:"scope"`
+	Conditions    *condition.Conditions `json:"conditions,omitempty"`
+	Direct        bool                  `json:"direct"`
+	InheritedFrom []string              `json:"inherited_from,omitempty"`
+}
+
+// GetEffectiveRolePermissions lists the permissions of a role including
+// those it inherits.
+func (r *Repository) GetEffectiveRolePermissions(roleID string) ([]*RolePermission, error) {
+	rows, err := r.db.Query(
+		`SELECT p.id, p.resource_type, p.action, COALESCE(p.description, ''), rp.scope, rp.conditions,
+		        rc.ancestor_id = rc.role_id, a.name
+		 FROM role_closure rc
+		 JOIN role_permissions rp ON rp.role_id = rc.ancestor_id
+		 JOIN permissions p ON p.id = rp.permission_id
+		 JOIN roles a ON a.id = rc.ancestor_id
+		 WHERE rc.role_id = $1
+		 ORDER BY p.resource_type, p.action, rp.scope, rp.conditions::text NULLS FIRST, a.name`,
+		roleID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query effective role permissions: %w", err)
+	}
+	defer rows.Close()
+
+	var perms []*RolePermission
+	for rows.Next() {
+		var perm RolePermission
+		var conditions []byte
+		var direct bool
+		var source string
+		if err := rows.Scan(&perm.ID, &perm.ResourceType, &perm.Action, &perm.Description, &perm.Scope, &conditions, &direct, &source); err != nil {
+			return nil, fmt.Errorf("scan permission: %w", err)
+		}
+		if perm.Conditions, err = condition.Parse(conditions); err != nil {
+			return nil, err
+		}
+
+		// The same permission may be held under different conditions.
+		if n := len(perms); n == 0 || perms[n-1].ID != perm.ID || perms[n-1].Scope != perm.Scope ||
+			perms[n-1].Conditions.String() != perm.Conditions.String() {
+			perms = append(perms, &perm)
+		}
+		last := perms[len(perms)-1]
+		if direct {
+			last.Direct = true
+		} else {
+			last.InheritedFrom = append(last.InheritedFrom, source)
+		}
+	}
+
+	return perms, rows.Err()
+}
+
+// InheritedByTenantRoles reports whether a role is a tenant role or is
+// inherited, directly or transitively, by one.
+func (r *Repository) InheritedByTenantRoles(roleID string) (bool, error) {
+	var inherited bool
+	err := r.db.QueryRow(
+		`SELECT EXISTS (
+		     SELECT 1 FROM role_closure rc
+		     JOIN roles d ON d.id = rc.role_id
+		     WHERE rc.ancestor_id = $1 AND d.role_type = 'tenant'
+		 )`,
+		roleID,
+	).Scan(&inherited)
+	if err != nil {
+		return false, fmt.Errorf("query tenant descendants: %w", err)
+	}
+	return inherited, nil
+}
+
+// InheritsBastionPermissions reports whether a role or any role it
+// inherits from is a Bastion role or holds Bastion's own permissions.
+func (r *Repository) InheritsBastionPermissions(roleID string) (bool, error) {
+	var inherits bool
+	err := r.db.QueryRow(
+		`SELECT EXISTS (
+		     SELECT 1 FROM role_closure rc
+		     JOIN roles a ON a.id = rc.ancestor_id
+		     WHERE rc.role_id = $1 AND a.application_name = 'bastion'
+		 ) OR EXISTS (
+		     SELECT 1 FROM role_closure rc
+		     JOIN role_permissions rp ON rp.role_id = rc.ancestor_id
+		     JOIN permissions p ON p.id = rp.permission_id
+		     WHERE rc.role_id = $1 AND p.resource_type LIKE 'bastion:%'
+		 )`,
+		roleID,
+	).Scan(&inherits)
+	if err != nil {
+		return false, fmt.Errorf("query inherited bastion permissions: %w", err)
+	}
+	return inherits, nil
+}
+
+// ErrRoleCycle is returned when a role would come to inherit from itself.
+var ErrRoleCycle = errors.New("role inheritance cycle")
+
+func (r *Repository) GetRoleParents(roleID string) ([]*Role, error) {
+	rows, err := r.db.Query(
+		`SELECT `+roleColumns+`
+		 FROM roles WHERE id IN (SELECT parent_role_id FROM role_parents WHERE role_id = $1)
+		 ORDER BY role_type, name`,
+		roleID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query role parents: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []*Role
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// AddRoleParent makes roleID inherit from parentID. Inheritance changes
+// are serialized so that concurrent edges cannot form a cycle together.
+func (r *Repository) AddRoleParent(roleID, parentID string) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`LOCK TABLE role_parents IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return false, fmt.Errorf("lock role parents: %w", err)
+	}
+
+	var cycle bool
+	err = tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM role_closure WHERE role_id = $1 AND ancestor_id = $2)`,
+		parentID, roleID,
+	).Scan(&cycle)
+	if err != nil {
+		return false, fmt.Errorf("check role cycle: %w", err)
+	}
+	if cycle {
+		return false, ErrRoleCycle
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO role_parents (role_id, parent_role_id)
+		 VALUES ($1, $2)
+		 ON CONFLICT DO NOTHING`,
+		roleID, parentID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("add role parent: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+
+	return affected > 0, tx.Commit()
+}
+
+func (r *Repository) RemoveRoleParent(roleID, parentID string) (bool, error) {
+	result, err := r.db.Exec(
+		`DELETE FROM role_parents WHERE role_id = $1 AND parent_role_id = $2`,
+		roleID, parentID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("remove role parent: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
 func (r *Repository) AssignRoleToUser(userID, roleID string, tenantID *string, grantedBy *string) error {
 	_, err := r.db.Exec(
 		`INSERT INTO user_roles (user_id, role_id, tenant_id, granted_by)
@@ -176,11 +479,15 @@ func (r *Repository) AssignRoleToUser(userID, roleID string, tenantID *string, g
 
 func (r *Repository) GetUserRoles(userID string, tenantID *string) ([]*UserRole, error) {
 	query := `
-		SELECT ur.user_id, ur.role_id, r.name, ur.tenant_id, ur.granted_at
+		SELECT DISTINCT ON (r.role_type, r.name, ur.tenant_id)
+			ur.user_id, r.id, r.name, ur.tenant_id, ur.granted_at,
+			CASE WHEN rc.role_id = rc.ancestor_id THEN NULL ELSE held.name END
 		FROM user_roles ur
-		JOIN roles r ON ur.role_id = r.id
+		JOIN role_closure rc ON rc.role_id = ur.role_id
+		JOIN roles r ON r.id = rc.ancestor_id
+		JOIN roles held ON held.id = ur.role_id
 		WHERE ur.user_id = $1 AND (ur.tenant_id = $2 OR ur.tenant_id IS NULL)
-		ORDER BY r.role_type, r.name`
+		ORDER BY r.role_type, r.name, ur.tenant_id, rc.role_id = rc.ancestor_id DESC, held.name`
 
 	rows, err := r.db.Query(query, userID, tenantID)
 	if err != nil {
@@ -191,7 +498,7 @@ func (r *Repository) GetUserRoles(userID string, tenantID *string) ([]*UserRole,
 	var userRoles []*UserRole
 	for rows.Next() {
 		var ur UserRole
-		if err := rows.Scan(&ur.UserID, &ur.RoleID, &ur.RoleName, &ur.TenantID, &ur.GrantedAt); err != nil {
+		if err := rows.Scan(&ur.UserID, &ur.RoleID, &ur.RoleName, &ur.TenantID, &ur.GrantedAt, &ur.InheritedFrom); err != nil {
 			return nil, fmt.Errorf("scan user role: %w", err)
 		}
 		userRoles = append(userRoles, &ur)
@@ -200,13 +507,18 @@ func (r *Repository) GetUserRoles(userID string, tenantID *string) ([]*UserRole,
 	return userRoles, rows.Err()
 }
 
+// GetUserPermissions lists the permissions the user holds in tenant scope
+// without conditions. Narrower grants only apply to a given resource, and
+// conditional ones to a given request, so they are left out.
 func (r *Repository) GetUserPermissions(userID string, tenantID *string) ([]*Permission, error) {
 	query := `
 		SELECT DISTINCT p.id, p.resource_type, p.action, COALESCE(p.description, '')
 		FROM permissions p
 		JOIN role_permissions rp ON p.id = rp.permission_id
-		JOIN user_roles ur ON rp.role_id = ur.role_id
+		JOIN role_closure rc ON rc.ancestor_id = rp.role_id
+		JOIN user_roles ur ON ur.role_id = rc.role_id
 		WHERE ur.user_id = $1 AND (ur.tenant_id = $2 OR ur.tenant_id IS NULL)
+		AND rp.scope = 'tenant' AND rp.conditions IS NULL
 		ORDER BY p.resource_type, p.action`
 
 	rows, err := r.db.Query(query, userID, tenantID)
@@ -239,40 +551,268 @@ func (r *Repository) RevokeRoleFromUser(userID, roleID string, tenantID *string)
 	return nil
 }
 
-func (r *Repository) HasPermission(userID string, tenantID *string, resourceType, action string) (bool, error) {
-	var count int
-	query := `
-		SELECT COUNT(*)
-		FROM permissions p
-		JOIN role_permissions rp ON p.id = rp.permission_id
-		JOIN user_roles ur ON rp.role_id = ur.role_id
-		WHERE ur.user_id = $1
-		AND (ur.tenant_id = $2 OR ur.tenant_id IS NULL)
-		AND p.resource_type = $3
-		AND p.action = $4`
+// Grant is a role granting a permission in a scope, under conditions if
+// any. API key permissions are granted directly, without a role, and always
+// in tenant scope.
+type Grant struct {
+	Role       string
+	Scope      string
+	Conditions *condition.Conditions
+}
 
-	err := r.db.QueryRow(query, userID, tenantID, resourceType, action).Scan(&count)
+// grantOrder lists tenant-wide grants first and specific-instance grants
+// next, so that the grants needing registry lookups are evaluated last.
+const grantOrder = `CASE WHEN rp.scope = 'tenant' THEN 0 WHEN rp.scope LIKE 'specific:%' THEN 1 ELSE 2 END`
+
+// GetUserGrants maps each permission ("resource_type:action") the user
+// holds in the tenant to the grants of it, in evaluation order. For
+// inherited permissions the role is the ancestor holding the permission.
+func (r *Repository) GetUserGrants(userID string, tenantID *string) (map[string][]Grant, error) {
+	rows, err := r.db.Query(
+		`SELECT p.resource_type || ':' || p.action, r.name, rp.scope, rp.conditions
+		 FROM permissions p
+		 JOIN role_permissions rp ON p.id = rp.permission_id
+		 JOIN roles r ON r.id = rp.role_id
+		 JOIN role_closure rc ON rc.ancestor_id = rp.role_id
+		 JOIN user_roles ur ON ur.role_id = rc.role_id
+		 WHERE ur.user_id = $1 AND (ur.tenant_id = $2 OR ur.tenant_id IS NULL)
+		 ORDER BY `+grantOrder+`, r.role_type, r.name`,
+		userID, tenantID,
+	)
 	if err != nil {
-		return false, fmt.Errorf("check permission: %w", err)
+		return nil, fmt.Errorf("query user grants: %w", err)
 	}
+	return scanGrants(rows)
+}
 
-	return count > 0, nil
+// GetServiceAccountGrants is GetUserGrants for an enabled service account.
+// A service account bound to a tenant holds nothing in other tenants.
+func (r *Repository) GetServiceAccountGrants(serviceAccountID string, tenantID *string) (map[string][]Grant, error) {
+	rows, err := r.db.Query(
+		`SELECT p.resource_type || ':' || p.action, r.name, rp.scope, rp.conditions
+		 FROM permissions p
+		 JOIN role_permissions rp ON p.id = rp.permission_id
+		 JOIN roles r ON r.id = rp.role_id
+		 JOIN role_closure rc ON rc.ancestor_id = rp.role_id
+		 JOIN service_account_roles sar ON sar.role_id = rc.role_id
+		 JOIN service_accounts sa ON sa.id = sar.service_account_id
+		 WHERE sa.id = $1 AND sa.enabled
+		 AND (sar.tenant_id = $2 OR sar.tenant_id IS NULL)
+		 AND (sa.tenant_id IS NULL OR $2::uuid IS NULL OR sa.tenant_id = $2)
+		 ORDER BY `+grantOrder+`, r.role_type, r.name`,
+		serviceAccountID, tenantID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query service account grants: %w", err)
+	}
+	return scanGrants(rows)
 }
 
-func (r *Repository) HasAPIKeyPermission(apiKeyID, resourceType, action string) (bool, error) {
-	var count int
-	query := `
-		SELECT COUNT(*)
-		FROM permissions p
-		JOIN api_key_permissions akp ON p.id = akp.permission_id
-		WHERE akp.api_key_id = $1
-		AND p.resource_type = $2
-		AND p.action = $3`
+// GetAPIKeyGrants maps the permissions of an enabled, unexpired API key to
+// a grant without a role, as they are granted directly.
+func (r *Repository) GetAPIKeyGrants(apiKeyID string, tenantID *string) (map[string][]Grant, error) {
+	rows, err := r.db.Query(
+		`SELECT p.resource_type || ':' || p.action, '', 'tenant', akp.conditions
+		 FROM permissions p
+		 JOIN api_key_permissions akp ON p.id = akp.permission_id
+		 JOIN api_keys ak ON ak.id = akp.api_key_id
+		 WHERE ak.id = $1 AND ak.enabled
+		 AND (ak.expires_at IS NULL OR ak.expires_at > NOW())
+		 AND (ak.tenant_id IS NULL OR $2::uuid IS NULL OR ak.tenant_id = $2)`,
+		apiKeyID, tenantID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query api key grants: %w", err)
+	}
+	return scanGrants(rows)
+}
 
-	err := r.db.QueryRow(query, apiKeyID, resourceType, action).Scan(&count)
+func scanGrants(rows *sql.Rows) (map[string][]Grant, error) {
+	defer rows.Close()
+
+	grants := make(map[string][]Grant)
+	for rows.Next() {
+		var perm string
+		var g Grant
+		var conditions []byte
+		if err := rows.Scan(&perm, &g.Role, &g.Scope, &conditions); err != nil {
+			return nil, fmt.Errorf("scan grant: %w", err)
+		}
+		var err error
+		if g.Conditions, err = condition.Parse(conditions); err != nil {
+			return nil, err
+		}
+		grants[perm] = append(grants[perm], g)
+	}
+
+	return grants, rows.Err()
+}
+
+// ResourceRelations is how an identity relates to a resource in the
+// resource registry. A resource that is not registered has no relations.
+type ResourceRelations struct {
+	Owned    bool
+	Assigned bool
+	Team     bool
+}
+
+// GetResourceRelations looks up whether the identity owns the resource, is
+// assigned to it or is a member of its team. A resource registered in
+// another tenant than tenantID has no relations to the identity there.
+func (r *Repository) GetResourceRelations(identityType, identityID string, tenantID *string, resourceType, resourceID string) (*ResourceRelations, error) {
+	rel := &ResourceRelations{}
+	err := r.db.QueryRow(
+		`SELECT
+			COALESCE(res.owner_type = $1 AND res.owner_id::text = $2, FALSE),
+			EXISTS (
+				SELECT 1 FROM resource_assignments ra
+				WHERE ra.resource_type = res.resource_type AND ra.resource_id = res.resource_id
+				AND ra.identity_type = $1 AND ra.identity_id::text = $2
+			),
+			EXISTS (
+				SELECT 1 FROM team_members tm
+				WHERE tm.team_id = res.team_id
+				AND tm.identity_type = $1 AND tm.identity_id::text = $2
+			)
+		 FROM resources res
+		 WHERE res.resource_type = $4 AND res.resource_id = $5
+		 AND (res.tenant_id IS NULL OR $3::uuid IS NULL OR res.tenant_id = $3)`,
+		identityType, identityID, tenantID, resourceType, resourceID,
+	).Scan(&rel.Owned, &rel.Assigned, &rel.Team)
+	if err == sql.ErrNoRows {
+		return rel, nil
+	}
 	if err != nil {
-		return false, fmt.Errorf("check api key permission: %w", err)
+		return nil, fmt.Errorf("query resource relations: %w", err)
 	}
+	return rel, nil
+}
+
+// GetPrincipalAttributes returns the attributes of a user, service account
+// or API key, empty if none were set.
+func (r *Repository) GetPrincipalAttributes(identityType, identityID string) (map[string]interface{}, error) {
+	var data []byte
+	err := r.db.QueryRow(
+		`SELECT attributes FROM principal_attributes WHERE identity_type = $1 AND identity_id = $2`,
+		identityType, identityID,
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		return map[string]interface{}{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query principal attributes: %w", err)
+	}
+
+	attrs := map[string]interface{}{}
+	if err := json.Unmarshal(data, &attrs); err != nil {
+		return nil, fmt.Errorf("decode principal attributes: %w", err)
+	}
+	return attrs, nil
+}
+
+func (r *Repository) SetPrincipalAttributes(identityType, identityID string, attrs map[string]interface{}) error {
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return fmt.Errorf("encode principal attributes: %w", err)
+	}
+
+	_, err = r.db.Exec(
+		`INSERT INTO principal_attributes (identity_type, identity_id, attributes)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (identity_type, identity_id)
+		 DO UPDATE SET attributes = EXCLUDED.attributes, updated_at = NOW()`,
+		identityType, identityID, string(data),
+	)
+	if err != nil {
+		return fmt.Errorf("set principal attributes: %w", err)
+	}
+	return nil
+}
+
+// ClaimsProfile opts an audience into access tokens that embed the user's
+// authorization data. Embed is "permissions" for the full list or "hash"
+// for a permission-set hash only.
+type ClaimsProfile struct {
+	Audience     string    `json:"audience"`
+	Embed        string    `json:"embed"`
+	IncludeRoles bool      `json:"include_roles"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (r *Repository) GetClaimsProfile(audience string) (*ClaimsProfile, error) {
+	p := &ClaimsProfile{}
+	err := r.db.QueryRow(
+		`SELECT audience, embed, include_roles, created_at, updated_at
+		 FROM token_claims_profiles WHERE audience = $1`,
+		audience,
+	).Scan(&p.Audience, &p.Embed, &p.IncludeRoles, &p.CreatedAt, &p.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get claims profile: %w", err)
+	}
+
+	return p, nil
+}
+
+func (r *Repository) ListClaimsProfiles() ([]*ClaimsProfile, error) {
+	rows, err := r.db.Query(
+		`SELECT audience, embed, include_roles, created_at, updated_at
+		 FROM token_claims_profiles ORDER BY audience`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list claims profiles: %w", err)
+	}
+	defer rows.Close()
 
-	return count > 0, nil
+	var profiles []*ClaimsProfile
+	for rows.Next() {
+		p := &ClaimsProfile{}
+		if err := rows.Scan(&p.Audience, &p.Embed, &p.IncludeRoles, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan claims profile: %w", err)
+		}
+		profiles = append(profiles, p)
+	}
+
+	return profiles, rows.Err()
+}
+
+func (r *Repository) UpsertClaimsProfile(audience, embed string, includeRoles bool) (*ClaimsProfile, error) {
+	p := &ClaimsProfile{}
+	err := r.db.QueryRow(
+		`INSERT INTO token_claims_profiles (audience, embed, include_roles)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (audience) DO UPDATE
+		 SET embed = EXCLUDED.embed, include_roles = EXCLUDED.include_roles, updated_at = NOW()
+		 RETURNING audience, embed, include_roles, created_at, updated_at`,
+		audience, embed, includeRoles,
+	).Scan(&p.Audience, &p.Embed, &p.IncludeRoles, &p.CreatedAt, &p.UpdatedAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("save claims profile: %w", err)
+	}
+
+	return p, nil
+}
+
+func (r *Repository) DeleteClaimsProfile(audience string) error {
+	_, err := r.db.Exec(`DELETE FROM token_claims_profiles WHERE audience = $1`, audience)
+	return err
+}
+
+// GetPermissionVersion returns the user's permission version, which triggers
+// on user_roles, role_permissions and role_parents increment on every change.
+func (r *Repository) GetPermissionVersion(userID string) (int64, error) {
+	var version int64
+	err := r.db.QueryRow(
+		`SELECT COALESCE((SELECT version FROM permission_versions WHERE user_id = $1), 1)`,
+		userID,
+	).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("get permission version: %w", err)
+	}
+	return version, nil
 }
@@ -1,9 +1,23 @@
 package rbac
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/condition"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
 )
 
 type Service struct {
@@ -18,17 +32,16 @@ func NewService(repo *Repository, auditLogger *audit.Logger) *Service {
 	}
 }
 
-func (s *Service) AssignRole(userID, roleName string, tenantID *string, grantedBy *string) error {
-	if userID == "" {
-		return fmt.Errorf("user ID required")
-	}
-	if roleName == "" {
-		return fmt.Errorf("role name required")
-	}
-
-	role, err := s.repo.GetRoleByName(roleName)
+// AssignRole grants a role to a user in tenantID, or globally when tenantID
+// is nil. Callers bound to a tenant (scope not nil) manage assignments in
+// that tenant only and cannot grant platform roles.
+func (s *Service) AssignRole(ctx context.Context, scope *string, userID, roleName string, tenantID *string, grantedBy *string) error {
+	role, err := s.assignableRole(scope, userID, roleName, tenantID)
 	if err != nil {
-		return fmt.Errorf("get role: %w", err)
+		return err
+	}
+	if role.TenantID != nil && (tenantID == nil || *tenantID != *role.TenantID) {
+		return fmt.Errorf("role %s can only be assigned in its own tenant", roleName)
 	}
 
 	if err := s.repo.AssignRoleToUser(userID, role.ID, tenantID, grantedBy); err != nil {
@@ -40,7 +53,7 @@ func (s *Service) AssignRole(userID, roleName string, tenantID *string, grantedB
 		grantedByStr = *grantedBy
 	}
 
-	s.auditLogger.Log("role.assigned", grantedByStr, map[string]interface{}{
+	s.auditLogger.LogContext(ctx, "role.assigned", grantedByStr, map[string]interface{}{
 		"user_id":   userID,
 		"role_name": roleName,
 		"role_id":   role.ID,
@@ -50,24 +63,19 @@ func (s *Service) AssignRole(userID, roleName string, tenantID *string, grantedB
 	return nil
 }
 
-func (s *Service) RevokeRole(userID, roleName string, tenantID *string) error {
-	if userID == "" {
-		return fmt.Errorf("user ID required")
-	}
-	if roleName == "" {
-		return fmt.Errorf("role name required")
-	}
-
-	role, err := s.repo.GetRoleByName(roleName)
+// RevokeRole takes a role assigned in tenantID away from a user, under the
+// same restrictions on scope as AssignRole.
+func (s *Service) RevokeRole(ctx context.Context, scope *string, userID, roleName string, tenantID *string) error {
+	role, err := s.assignableRole(scope, userID, roleName, tenantID)
 	if err != nil {
-		return fmt.Errorf("get role: %w", err)
+		return err
 	}
 
 	if err := s.repo.RevokeRoleFromUser(userID, role.ID, tenantID); err != nil {
 		return fmt.Errorf("revoke role: %w", err)
 	}
 
-	s.auditLogger.Log("role.revoked", "", map[string]interface{}{
+	s.auditLogger.LogContext(ctx, "role.revoked", "", map[string]interface{}{
 		"user_id":   userID,
 		"role_name": roleName,
 		"role_id":   role.ID,
@@ -77,7 +85,35 @@ func (s *Service) RevokeRole(userID, roleName string, tenantID *string) error {
 	return nil
 }
 
-func (s *Service) CheckPermission(userID string, tenantID *string, resourceType, action string) (bool, string, error) {
+// ErrAssignmentScope is returned when a tenant-bound caller assigns or
+// revokes a role outside its tenant or a platform role.
+var ErrAssignmentScope = errors.New("role assignment outside caller's tenant")
+
+func (s *Service) assignableRole(scope *string, userID, roleName string, tenantID *string) (*Role, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("user ID required")
+	}
+	if roleName == "" {
+		return nil, fmt.Errorf("role name required")
+	}
+	if scope != nil && (tenantID == nil || *tenantID != *scope) {
+		return nil, fmt.Errorf("%w: token is bound to another tenant", ErrAssignmentScope)
+	}
+
+	role, err := s.repo.GetRoleByName(roleName)
+	if err != nil {
+		return nil, fmt.Errorf("get role: %w", err)
+	}
+	if scope != nil && role.RoleType == RoleTypePlatform {
+		return nil, fmt.Errorf("%w: platform roles can only be managed by platform administrators", ErrAssignmentScope)
+	}
+	return role, nil
+}
+
+// CheckPermission reports whether the user holds the permission in tenant
+// scope, evaluating the conditions of its grants against the request in ctx
+// (see WithCheckContext).
+func (s *Service) CheckPermission(ctx context.Context, userID string, tenantID *string, resourceType, action string) (bool, string, error) {
 	if userID == "" {
 		return false, "user ID required", fmt.Errorf("user ID required")
 	}
@@ -88,19 +124,17 @@ func (s *Service) CheckPermission(userID string, tenantID *string, resourceType,
 		return false, "action required", fmt.Errorf("action required")
 	}
 
-	allowed, err := s.repo.HasPermission(userID, tenantID, resourceType, action)
+	grants, err := s.repo.GetUserGrants(userID, tenantID)
 	if err != nil {
 		return false, "permission check failed", err
 	}
 
-	var reason string
-	if allowed {
-		reason = fmt.Sprintf("user has %s:%s permission", resourceType, action)
-	} else {
-		reason = fmt.Sprintf("user lacks %s:%s permission", resourceType, action)
+	allowed, reason, err := s.checkGrants(ctx, IdentityUser, userID, tenantID, grants, resourceType, action)
+	if err != nil {
+		return false, "permission check failed", err
 	}
 
-	s.auditLogger.Log("authz.check", userID, map[string]interface{}{
+	s.auditLogger.LogContext(ctx, "authz.check", userID, map[string]interface{}{
 		"resource_type": resourceType,
 		"action":        action,
 		"tenant_id":     tenantID,
@@ -111,6 +145,990 @@ func (s *Service) CheckPermission(userID string, tenantID *string, resourceType,
 	return allowed, reason, nil
 }
 
+// Identity types accepted by the authorization check API.
+const (
+	IdentityUser           = "user"
+	IdentityServiceAccount = "service_account"
+	IdentityAPIKey         = "api_key"
+)
+
+// Grant scopes (DD-001 §3.3). A tenant grant covers every resource of the
+// type; the others only cover resources the identity owns, is assigned to
+// or whose team it is a member of, as recorded in the resource registry,
+// or the one resource named after the "specific:" prefix.
+const (
+	ScopeTenant   = "tenant"
+	ScopeOwned    = "owned"
+	ScopeAssigned = "assigned"
+	ScopeTeam     = "team"
+	ScopeSpecific = "specific:"
+)
+
+// ValidScope reports whether scope is a grant scope.
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeTenant, ScopeOwned, ScopeAssigned, ScopeTeam:
+		return true
+	}
+	return strings.HasPrefix(scope, ScopeSpecific) && len(scope) > len(ScopeSpecific)
+}
+
+// MaxBatchChecks bounds the number of checks in one CheckBatch call.
+const MaxBatchChecks = 100
+
+var ErrInvalidCheck = errors.New("invalid authorization check")
+
+// CheckRequest is an authorization check as specified in DD-001 §4.2.
+type CheckRequest struct {
+	Identity CheckIdentity `json:"identity"`
+	Action   string        `json:"action"`
+	Resource CheckResource `json:"resource"`
+	Context  CheckContext  `json:"context"`
+}
+
+type CheckIdentity struct {
+	Type     string  `json:"type"`
+	ID       string  `json:"id"`
+	TenantID *string `json:"tenant_id,omitempty"`
+}
+
+type CheckResource struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// CheckContext describes the request being authorized, as seen by the
+// application: the end user's IP and when the request was made.
+type CheckContext struct {
+	IP        string     `json:"ip,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+type checkContextKey struct{}
+
+// WithCheckContext attaches the request being authorized to ctx, for the
+// grant conditions evaluated by CheckPermission and its variants.
+func WithCheckContext(ctx context.Context, cc CheckContext) context.Context {
+	return context.WithValue(ctx, checkContextKey{}, cc)
+}
+
+// RequestCheckContext describes r: its client IP and the current time.
+func RequestCheckContext(r *http.Request) CheckContext {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	now := time.Now()
+	return CheckContext{IP: ip, Timestamp: &now}
+}
+
+// Decision is the outcome of a check. Conditional decisions depend on the
+// request's time, IP or resource attributes and must not be reused for
+// other requests.
+type Decision struct {
+	Allowed     bool   `json:"allowed"`
+	Reason      string `json:"reason"`
+	Conditional bool   `json:"conditional,omitempty"`
+	AuditID     string `json:"audit_id"`
+}
+
+// Check evaluates an authorization check on behalf of caller and records
+// the decision in the audit log.
+func (s *Service) Check(ctx context.Context, caller *principal.Principal, req CheckRequest) (*Decision, error) {
+	decisions, err := s.CheckBatch(ctx, caller, []CheckRequest{req})
+	if err != nil {
+		return nil, err
+	}
+	return &decisions[0], nil
+}
+
+// CheckBatch evaluates checks in order, loading the grants of each distinct
+// identity once, and records all decisions in a single audit write. The
+// audit entries belong to the caller; the checked identity is recorded in
+// their details, since it need not be a user, or exist at all.
+func (s *Service) CheckBatch(ctx context.Context, caller *principal.Principal, reqs []CheckRequest) ([]Decision, error) {
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("%w: at least one check required", ErrInvalidCheck)
+	}
+	if len(reqs) > MaxBatchChecks {
+		return nil, fmt.Errorf("%w: at most %d checks per batch", ErrInvalidCheck, MaxBatchChecks)
+	}
+	for i, req := range reqs {
+		if err := validateCheck(req); err != nil {
+			if len(reqs) == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("check %d: %w", i, err)
+		}
+	}
+
+	type identityKey struct{ identityType, id, tenantID string }
+	loaded := make(map[identityKey]map[string][]Grant)
+
+	type relationKey struct {
+		identity                 identityKey
+		resourceType, resourceID string
+	}
+	relations := make(map[relationKey]*ResourceRelations)
+	attributes := make(map[identityKey]map[string]interface{})
+	tenants := make(map[string]map[string]interface{})
+
+	decisions := make([]Decision, len(reqs))
+	events := make([]audit.Event, len(reqs))
+	for i, req := range reqs {
+		key := identityKey{identityType: req.Identity.Type, id: req.Identity.ID}
+		if req.Identity.TenantID != nil {
+			key.tenantID = *req.Identity.TenantID
+		}
+
+		grants, ok := loaded[key]
+		if !ok {
+			var err error
+			grants, err = s.loadGrants(req.Identity)
+			if err != nil {
+				return nil, err
+			}
+			loaded[key] = grants
+		}
+
+		candidates := grants[req.Resource.Type+":"+req.Action]
+		env := conditionEnv(req, func() (map[string]interface{}, error) {
+			if attrs, ok := attributes[key]; ok {
+				return attrs, nil
+			}
+			attrs, err := s.repo.GetPrincipalAttributes(req.Identity.Type, req.Identity.ID)
+			if err != nil {
+				return nil, err
+			}
+			attributes[key] = attrs
+			return attrs, nil
+		}, func() (map[string]interface{}, error) {
+			if tenant, ok := tenants[key.tenantID]; ok {
+				return tenant, nil
+			}
+			tenant, err := s.repo.GetTenantAttributes(key.tenantID)
+			if err != nil {
+				return nil, err
+			}
+			tenants[key.tenantID] = tenant
+			return tenant, nil
+		})
+		result, err := matchGrant(req, candidates, env, func() (*ResourceRelations, error) {
+			rk := relationKey{identity: key, resourceType: req.Resource.Type, resourceID: req.Resource.ID}
+			if rel, ok := relations[rk]; ok {
+				return rel, nil
+			}
+			rel, err := s.repo.GetResourceRelations(req.Identity.Type, req.Identity.ID, req.Identity.TenantID, req.Resource.Type, req.Resource.ID)
+			if err != nil {
+				return nil, err
+			}
+			relations[rk] = rel
+			return rel, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		allowed := result.grant != nil
+		decisions[i] = Decision{Allowed: allowed, Reason: checkReason(req, result, candidates), Conditional: result.conditional}
+
+		timestamp := time.Now().UTC()
+		if req.Context.Timestamp != nil {
+			timestamp = *req.Context.Timestamp
+		}
+
+		details := map[string]interface{}{
+			"identity_type": req.Identity.Type,
+			"identity_id":   req.Identity.ID,
+			"tenant_id":     req.Identity.TenantID,
+			"resource_type": req.Resource.Type,
+			"action":        req.Action,
+			"allowed":       allowed,
+			"reason":        decisions[i].Reason,
+			"timestamp":     timestamp,
+			"caller_id":     caller.ID,
+		}
+		if allowed {
+			details["scope"] = result.grant.Scope
+		}
+		if req.Resource.ID != "" {
+			details["resource_id"] = req.Resource.ID
+		}
+		if len(req.Resource.Attributes) > 0 {
+			details["resource_attributes"] = req.Resource.Attributes
+		}
+		if len(reqs) > 1 {
+			details["batch"] = true
+		}
+
+		events[i] = audit.Event{
+			EventType: "authz.check",
+			UserID:    caller.UserID(),
+			Details:   details,
+			IPAddress: req.Context.IP,
+		}
+	}
+
+	auditIDs, err := s.auditLogger.RecordBatch(ctx, events)
+	if err != nil {
+		return nil, fmt.Errorf("record authorization decisions: %w", err)
+	}
+	for i := range decisions {
+		decisions[i].AuditID = auditIDs[i]
+	}
+
+	return decisions, nil
+}
+
+func validateCheck(req CheckRequest) error {
+	switch {
+	case req.Identity.Type != IdentityUser && req.Identity.Type != IdentityServiceAccount && req.Identity.Type != IdentityAPIKey:
+		return fmt.Errorf("%w: identity type must be user, service_account or api_key", ErrInvalidCheck)
+	case req.Identity.ID == "":
+		return fmt.Errorf("%w: identity ID required", ErrInvalidCheck)
+	case req.Resource.Type == "":
+		return fmt.Errorf("%w: resource type required", ErrInvalidCheck)
+	case req.Action == "":
+		return fmt.Errorf("%w: action required", ErrInvalidCheck)
+	case req.Context.IP != "" && net.ParseIP(req.Context.IP) == nil:
+		return fmt.Errorf("%w: context.ip must be an IP address", ErrInvalidCheck)
+	}
+	return nil
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// knownIdentity reports whether an identity's IDs are well formed. Others
+// cannot exist, and hold no grants.
+func knownIdentity(identity CheckIdentity) bool {
+	return uuidPattern.MatchString(identity.ID) && (identity.TenantID == nil || uuidPattern.MatchString(*identity.TenantID))
+}
+
+func (s *Service) loadGrants(identity CheckIdentity) (map[string][]Grant, error) {
+	if !knownIdentity(identity) {
+		return map[string][]Grant{}, nil
+	}
+	switch identity.Type {
+	case IdentityServiceAccount:
+		return s.repo.GetServiceAccountGrants(identity.ID, identity.TenantID)
+	case IdentityAPIKey:
+		return s.repo.GetAPIKeyGrants(identity.ID, identity.TenantID)
+	default:
+		return s.repo.GetUserGrants(identity.ID, identity.TenantID)
+	}
+}
+
+// grantMatch is the outcome of evaluating the grants of a permission: the
+// grant that applies, if any, or the first grant whose conditions did not
+// hold and why.
+type grantMatch struct {
+	grant       *Grant
+	failed      *Grant
+	failure     string
+	conditional bool
+}
+
+// conditionEnv describes req to grant conditions. Principal attributes
+// and the tenant are loaded by principal and tenant when a condition needs
+// them.
+func conditionEnv(req CheckRequest, principal, tenant func() (map[string]interface{}, error)) condition.Env {
+	env := condition.Env{
+		Time:          time.Now(),
+		IP:            req.Context.IP,
+		Resource:      req.Resource.Attributes,
+		Principal:     principal,
+		PrincipalType: req.Identity.Type,
+		PrincipalID:   req.Identity.ID,
+		TenantID:      req.Identity.TenantID,
+		ResourceType:  req.Resource.Type,
+		ResourceID:    req.Resource.ID,
+		Tenant:        tenant,
+	}
+	if req.Context.Timestamp != nil {
+		env.Time = *req.Context.Timestamp
+	}
+	return env
+}
+
+// matchGrant returns the first grant whose scope covers the requested
+// resource and whose conditions hold. Scopes other than tenant need the
+// resource ID; the registry is only consulted, through relations, for
+// owned, assigned and team grants.
+func matchGrant(req CheckRequest, grants []Grant, env condition.Env, relations func() (*ResourceRelations, error)) (*grantMatch, error) {
+	m := &grantMatch{}
+	for i := range grants {
+		g := &grants[i]
+		switch {
+		case g.Scope == ScopeTenant:
+		case req.Resource.ID == "":
+			continue
+		case strings.HasPrefix(g.Scope, ScopeSpecific):
+			if strings.TrimPrefix(g.Scope, ScopeSpecific) != req.Resource.ID {
+				continue
+			}
+		default:
+			rel, err := relations()
+			if err != nil {
+				return nil, err
+			}
+			if !(g.Scope == ScopeOwned && rel.Owned) &&
+				!(g.Scope == ScopeAssigned && rel.Assigned) &&
+				!(g.Scope == ScopeTeam && rel.Team) {
+				continue
+			}
+		}
+
+		if g.Conditions.Empty() {
+			m.grant = g
+			return m, nil
+		}
+
+		m.conditional = true
+		failure, err := g.Conditions.Evaluate(env)
+		if err != nil {
+			return nil, err
+		}
+		if failure == "" {
+			m.grant = g
+			return m, nil
+		}
+		if m.failed == nil {
+			m.failed, m.failure = g, failure
+		}
+	}
+	return m, nil
+}
+
+// checkReason explains a decision: the role and scope of the matching
+// grant, or why the grants held did not apply, the first unmet condition
+// taking precedence over scopes that did not cover the resource.
+func checkReason(req CheckRequest, m *grantMatch, candidates []Grant) string {
+	identity := strings.ReplaceAll(req.Identity.Type, "_", " ")
+	grant := m.grant
+	if grant == nil {
+		reason := fmt.Sprintf("%s lacks %s on %s", identity, req.Action, req.Resource.Type)
+		if m.failed != nil {
+			return fmt.Sprintf("%s: %s", reason, conditionFailure(m.failed, m.failure))
+		}
+		if len(candidates) == 0 {
+			return reason
+		}
+		var scopes []string
+		for _, g := range candidates {
+			if !slices.Contains(scopes, g.Scope) {
+				scopes = append(scopes, g.Scope)
+			}
+		}
+		if req.Resource.ID == "" {
+			return fmt.Sprintf("%s: scopes %s require a resource id", reason, strings.Join(scopes, ", "))
+		}
+		return fmt.Sprintf("%s: %s %s is not in scopes %s", reason, req.Resource.Type, req.Resource.ID, strings.Join(scopes, ", "))
+	}
+
+	var scope string
+	switch {
+	case grant.Scope == ScopeTenant:
+		scope = "scope tenant"
+	case strings.HasPrefix(grant.Scope, ScopeSpecific):
+		scope = "scope " + grant.Scope
+	case grant.Scope == ScopeOwned:
+		scope = fmt.Sprintf("scope owned: %s owns %s", identity, req.Resource.ID)
+	case grant.Scope == ScopeAssigned:
+		scope = fmt.Sprintf("scope assigned: %s is assigned to %s", identity, req.Resource.ID)
+	case grant.Scope == ScopeTeam:
+		scope = fmt.Sprintf("scope team: %s is on the team of %s", identity, req.Resource.ID)
+	}
+	if !grant.Conditions.Empty() {
+		scope += ", conditions met"
+	}
+	if grant.Role == "" {
+		return fmt.Sprintf("%s grants %s on %s (%s)", identity, req.Action, req.Resource.Type, scope)
+	}
+	return fmt.Sprintf("role:%s grants %s on %s (%s)", grant.Role, req.Action, req.Resource.Type, scope)
+}
+
+func conditionFailure(g *Grant, failure string) string {
+	if g.Role == "" {
+		return "condition not met: " + failure
+	}
+	return fmt.Sprintf("condition of role:%s not met: %s", g.Role, failure)
+}
+
+// checkGrants evaluates grants of a permission on the resource type as a
+// whole, as CheckPermission and its variants do, against the request in
+// ctx.
+func (s *Service) checkGrants(ctx context.Context, identityType, identityID string, tenantID *string, grants map[string][]Grant, resourceType, action string) (bool, string, error) {
+	cc, _ := ctx.Value(checkContextKey{}).(CheckContext)
+	req := CheckRequest{
+		Identity: CheckIdentity{Type: identityType, ID: identityID, TenantID: tenantID},
+		Action:   action,
+		Resource: CheckResource{Type: resourceType},
+		Context:  cc,
+	}
+
+	env := conditionEnv(req, func() (map[string]interface{}, error) {
+		return s.repo.GetPrincipalAttributes(identityType, identityID)
+	}, func() (map[string]interface{}, error) {
+		return s.repo.GetTenantAttributes(*tenantID)
+	})
+	m, err := matchGrant(req, grants[resourceType+":"+action], env, nil)
+	if err != nil {
+		return false, "", err
+	}
+
+	identity := strings.ReplaceAll(identityType, "_", " ")
+	if m.grant != nil {
+		return true, fmt.Sprintf("%s has %s:%s permission", identity, resourceType, action), nil
+	}
+	reason := fmt.Sprintf("%s lacks %s:%s permission", identity, resourceType, action)
+	if m.failed != nil {
+		reason += ": " + conditionFailure(m.failed, m.failure)
+	}
+	return false, reason, nil
+}
+
+// CheckServiceAccountPermission is CheckPermission for service accounts,
+// which hold permissions through service_account_roles. Like
+// CheckPermission it only counts tenant-scope grants whose conditions hold.
+func (s *Service) CheckServiceAccountPermission(ctx context.Context, serviceAccountID string, tenantID *string, resourceType, action string) (bool, string, error) {
+	grants, err := s.repo.GetServiceAccountGrants(serviceAccountID, tenantID)
+	if err != nil {
+		return false, "permission check failed", err
+	}
+
+	allowed, reason, err := s.checkGrants(ctx, IdentityServiceAccount, serviceAccountID, tenantID, grants, resourceType, action)
+	if err != nil {
+		return false, "permission check failed", err
+	}
+
+	s.auditLogger.LogContext(ctx, "authz.service_account_check", "", map[string]interface{}{
+		"service_account_id": serviceAccountID,
+		"resource_type":      resourceType,
+		"action":             action,
+		"tenant_id":          tenantID,
+		"allowed":            allowed,
+		"reason":             reason,
+	}, "")
+
+	return allowed, reason, nil
+}
+
+func (s *Service) GetRoleByName(name string) (*Role, error) {
+	if name == "" {
+		return nil, fmt.Errorf("role name required")
+	}
+
+	return s.repo.GetRoleByName(name)
+}
+
+var (
+	ErrInvalidRole  = errors.New("invalid role")
+	ErrRoleExists   = errors.New("role name already in use")
+	ErrSystemRole   = errors.New("system roles cannot be modified")
+	ErrNotInherited = errors.New("role does not inherit from parent")
+)
+
+// Role type values. Tenant roles are defined by a tenant and can only be
+// held within it.
+const (
+	RoleTypePlatform    = "platform"
+	RoleTypeApplication = "application"
+	RoleTypeTenant      = "tenant"
+)
+
+// ListRoles lists the roles visible in scope, a tenant ID: every role but
+// the custom roles of other tenants. A nil scope lists all roles.
+func (s *Service) ListRoles(scope *string) ([]*Role, error) {
+	return s.repo.ListRoles(scope)
+}
+
+// ListTenantRoles lists the custom roles of a tenant.
+func (s *Service) ListTenantRoles(tenantID string) ([]*Role, error) {
+	roles, err := s.repo.ListRoles(&tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var tenantRoles []*Role
+	for _, role := range roles {
+		if role.RoleType == RoleTypeTenant {
+			tenantRoles = append(tenantRoles, role)
+		}
+	}
+	return tenantRoles, nil
+}
+
+func (s *Service) GetRole(id string, scope *string) (*Role, error) {
+	return s.visibleRole(id, scope)
+}
+
+func (s *Service) ListPermissions() ([]*Permission, error) {
+	return s.repo.ListPermissions()
+}
+
+// GetRolePermissions lists a role's permissions, both direct and inherited.
+func (s *Service) GetRolePermissions(roleID string, scope *string) ([]*RolePermission, error) {
+	if _, err := s.visibleRole(roleID, scope); err != nil {
+		return nil, err
+	}
+	return s.repo.GetEffectiveRolePermissions(roleID)
+}
+
+func (s *Service) GetRoleParents(roleID string, scope *string) ([]*Role, error) {
+	if _, err := s.visibleRole(roleID, scope); err != nil {
+		return nil, err
+	}
+	return s.repo.GetRoleParents(roleID)
+}
+
+// CreateRole creates a role without permissions. Platform role names start
+// with "platform:", application role names with the application name and
+// tenant role names with the slug of their tenant.
+func (s *Service) CreateRole(ctx context.Context, actorID, name, description, roleType, applicationName string, tenantID *string) (*Role, error) {
+	if (roleType == RoleTypeTenant) != (tenantID != nil) {
+		return nil, fmt.Errorf("%w: tenant roles must belong to a tenant and other roles to none", ErrInvalidRole)
+	}
+	switch roleType {
+	case RoleTypePlatform, RoleTypeTenant:
+		if applicationName != "" {
+			return nil, fmt.Errorf("%w: %s roles have no application", ErrInvalidRole, roleType)
+		}
+	case RoleTypeApplication:
+		if applicationName == "" {
+			return nil, fmt.Errorf("%w: application_name required for application roles", ErrInvalidRole)
+		}
+	default:
+		return nil, fmt.Errorf("%w: role_type must be platform or application", ErrInvalidRole)
+	}
+
+	prefix, err := s.roleNamePrefix(roleType, applicationName, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validateRoleName(name, prefix, ""); err != nil {
+		return nil, err
+	}
+
+	role, err := s.repo.CreateRole(name, description, roleType, applicationName, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLogger.LogContext(ctx, "role.created", actorID, map[string]interface{}{
+		"role_id":            role.ID,
+		"role_name":          role.Name,
+		"role_type":          role.RoleType,
+		"application_name":   role.ApplicationName,
+		"tenant_id":          role.TenantID,
+		"before_permissions": []string{},
+		"after_permissions":  []string{},
+	}, "")
+
+	return role, nil
+}
+
+// UpdateRole renames a role or changes its description. The new name must
+// keep the role's namespace.
+func (s *Service) UpdateRole(ctx context.Context, actorID string, scope *string, id, name, description string) (*Role, error) {
+	before, err := s.mutableRole(id, scope)
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := s.roleNamePrefix(before.RoleType, before.ApplicationName, before.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validateRoleName(name, prefix, before.ID); err != nil {
+		return nil, err
+	}
+
+	perms, err := s.permissionSet(id)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := s.repo.UpdateRole(id, name, description)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLogger.LogContext(ctx, "role.updated", actorID, map[string]interface{}{
+		"role_id":            role.ID,
+		"role_name":          role.Name,
+		"previous_name":      before.Name,
+		"description":        role.Description,
+		"before_permissions": perms,
+		"after_permissions":  perms,
+	}, "")
+
+	return role, nil
+}
+
+// DeleteRole deletes a role together with its permissions and every user
+// and service account assignment of it.
+func (s *Service) DeleteRole(ctx context.Context, actorID string, scope *string, id string) error {
+	role, err := s.mutableRole(id, scope)
+	if err != nil {
+		return err
+	}
+
+	perms, err := s.permissionSet(id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.repo.DeleteRole(id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrRoleNotFound
+	}
+
+	s.auditLogger.LogContext(ctx, "role.deleted", actorID, map[string]interface{}{
+		"role_id":            role.ID,
+		"role_name":          role.Name,
+		"before_permissions": perms,
+		"after_permissions":  []string{},
+	}, "")
+
+	return nil
+}
+
+// AddRolePermissions grants permissions to a role in grantScope, tenant
+// scope if empty, under conditions if not nil, and returns its resulting
+// permissions. Granting a permission the role already holds in that scope
+// replaces the grant's conditions. Tenant roles
+// compose application permissions and cannot hold Bastion's own, which
+// would let tenant admins escalate their privileges. Neither can roles
+// tenant roles inherit from, through which they would hold them all the
+// same.
+func (s *Service) AddRolePermissions(ctx context.Context, actorID string, scope *string, roleID string, permissionIDs []string, grantScope string, conditions *condition.Conditions) ([]*RolePermission, error) {
+	role, err := s.mutableRole(roleID, scope)
+	if err != nil {
+		return nil, err
+	}
+	if len(permissionIDs) == 0 {
+		return nil, fmt.Errorf("%w: at least one permission_id required", ErrInvalidRole)
+	}
+	if grantScope == "" {
+		grantScope = ScopeTenant
+	}
+	if !ValidScope(grantScope) {
+		return nil, fmt.Errorf("%w: scope must be tenant, owned, assigned, team or specific:{id}", ErrInvalidRole)
+	}
+	if err := conditions.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRole, err)
+	}
+	if conditions.Empty() {
+		conditions = nil
+	}
+	tenantBound, err := s.repo.InheritedByTenantRoles(roleID)
+	if err != nil {
+		return nil, err
+	}
+	for _, permID := range permissionIDs {
+		perm, err := s.repo.GetPermissionByID(permID)
+		if errors.Is(err, ErrPermissionNotFound) {
+			return nil, fmt.Errorf("%w: permission %s not found", ErrInvalidRole, permID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tenantBound && strings.HasPrefix(perm.ResourceType, "bastion:") {
+			if role.RoleType == RoleTypeTenant {
+				return nil, fmt.Errorf("%w: tenant roles cannot hold %s permissions", ErrInvalidRole, perm.ResourceType)
+			}
+			return nil, fmt.Errorf("%w: roles inherited by tenant roles cannot hold %s permissions", ErrInvalidRole, perm.ResourceType)
+		}
+	}
+
+	before, err := s.permissionSet(roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []string
+	for _, permID := range permissionIDs {
+		ok, err := s.repo.AddRolePermission(roleID, permID, grantScope, conditions)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			added = append(added, permID)
+		}
+	}
+
+	perms, err := s.repo.GetEffectiveRolePermissions(roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(added) > 0 {
+		s.auditLogger.LogContext(ctx, "role.permissions_granted", actorID, map[string]interface{}{
+			"role_id":            role.ID,
+			"role_name":          role.Name,
+			"permission_ids":     added,
+			"scope":              grantScope,
+			"conditions":         conditions,
+			"before_permissions": before,
+			"after_permissions":  effectiveScopes(perms),
+		}, "")
+	}
+
+	return perms, nil
+}
+
+// RemoveRolePermission revokes a permission from a role in grantScope, or
+// in every scope the role holds it in when grantScope is empty.
+func (s *Service) RemoveRolePermission(ctx context.Context, actorID string, scope *string, roleID, permissionID, grantScope string) error {
+	role, err := s.mutableRole(roleID, scope)
+	if err != nil {
+		return err
+	}
+	if grantScope != "" && !ValidScope(grantScope) {
+		return fmt.Errorf("%w: scope must be tenant, owned, assigned, team or specific:{id}", ErrInvalidRole)
+	}
+
+	before, err := s.permissionSet(roleID)
+	if err != nil {
+		return err
+	}
+
+	removed, err := s.repo.RemoveRolePermission(roleID, permissionID, grantScope)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrPermissionNotFound
+	}
+
+	after, err := s.permissionSet(roleID)
+	if err != nil {
+		return err
+	}
+
+	s.auditLogger.LogContext(ctx, "role.permissions_revoked", actorID, map[string]interface{}{
+		"role_id":            role.ID,
+		"role_name":          role.Name,
+		"permission_ids":     []string{permissionID},
+		"scope":              grantScope,
+		"before_permissions": before,
+		"after_permissions":  after,
+	}, "")
+
+	return nil
+}
+
+// AddRoleParent makes a role inherit the permissions of parentID. Only
+// platform roles may inherit from platform roles, so that holding a platform
+// role stays visible in the role's type. Tenant roles inherit from
+// application roles and roles of their own tenant, and only roles of the
+// same tenant may inherit from them. No role a tenant role inherits from
+// may come to inherit from Bastion roles or Bastion permissions, however
+// many roles lie between them.
+func (s *Service) AddRoleParent(ctx context.Context, actorID string, scope *string, roleID, parentID string) ([]*RolePermission, error) {
+	role, err := s.mutableRole(roleID, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := s.visibleRole(parentID, role.TenantID)
+	if errors.Is(err, ErrRoleNotFound) {
+		return nil, fmt.Errorf("%w: parent role not found", ErrInvalidRole)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if parent.ID == role.ID {
+		return nil, fmt.Errorf("%w: a role cannot inherit from itself", ErrRoleCycle)
+	}
+	switch {
+	case parent.RoleType == RoleTypePlatform && role.RoleType != RoleTypePlatform:
+		return nil, fmt.Errorf("%w: only platform roles may inherit from platform roles", ErrInvalidRole)
+	case parent.RoleType == RoleTypeTenant && role.RoleType != RoleTypeTenant:
+		return nil, fmt.Errorf("%w: only roles of the same tenant may inherit from tenant roles", ErrInvalidRole)
+	}
+
+	tenantBound, err := s.repo.InheritedByTenantRoles(roleID)
+	if err != nil {
+		return nil, err
+	}
+	if tenantBound {
+		inherits, err := s.repo.InheritsBastionPermissions(parentID)
+		if err != nil {
+			return nil, err
+		}
+		if inherits && role.RoleType == RoleTypeTenant {
+			return nil, fmt.Errorf("%w: tenant roles cannot inherit from Bastion roles or Bastion permissions", ErrInvalidRole)
+		}
+		if inherits {
+			return nil, fmt.Errorf("%w: roles inherited by tenant roles cannot inherit from Bastion roles or Bastion permissions", ErrInvalidRole)
+		}
+	}
+
+	before, err := s.permissionSet(roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := s.repo.AddRoleParent(roleID, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	perms, err := s.repo.GetEffectiveRolePermissions(roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if added {
+		s.auditLogger.LogContext(ctx, "role.parent_added", actorID, map[string]interface{}{
+			"role_id":            role.ID,
+			"role_name":          role.Name,
+			"parent_role_id":     parent.ID,
+			"parent_role_name":   parent.Name,
+			"before_permissions": before,
+			"after_permissions":  effectiveScopes(perms),
+		}, "")
+	}
+
+	return perms, nil
+}
+
+func (s *Service) RemoveRoleParent(ctx context.Context, actorID string, scope *string, roleID, parentID string) error {
+	role, err := s.mutableRole(roleID, scope)
+	if err != nil {
+		return err
+	}
+
+	before, err := s.permissionSet(roleID)
+	if err != nil {
+		return err
+	}
+
+	removed, err := s.repo.RemoveRoleParent(roleID, parentID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrNotInherited
+	}
+
+	after, err := s.permissionSet(roleID)
+	if err != nil {
+		return err
+	}
+
+	s.auditLogger.LogContext(ctx, "role.parent_removed", actorID, map[string]interface{}{
+		"role_id":            role.ID,
+		"role_name":          role.Name,
+		"parent_role_id":     parentID,
+		"before_permissions": before,
+		"after_permissions":  after,
+	}, "")
+
+	return nil
+}
+
+// visibleRole returns the role unless it is the custom role of a tenant
+// other than scope.
+func (s *Service) visibleRole(id string, scope *string) (*Role, error) {
+	role, err := s.repo.GetRoleByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if scope != nil && role.TenantID != nil && *role.TenantID != *scope {
+		return nil, ErrRoleNotFound
+	}
+	return role, nil
+}
+
+// mutableRole returns the role if it may be changed in scope: any role but
+// the system roles when scope is nil, otherwise only the tenant's own roles.
+func (s *Service) mutableRole(id string, scope *string) (*Role, error) {
+	role, err := s.visibleRole(id, scope)
+	if err != nil {
+		return nil, err
+	}
+	if scope != nil && role.TenantID == nil {
+		return nil, ErrRoleNotFound
+	}
+	if role.IsSystem {
+		return nil, ErrSystemRole
+	}
+	return role, nil
+}
+
+func (s *Service) roleNamePrefix(roleType, applicationName string, tenantID *string) (string, error) {
+	switch roleType {
+	case RoleTypeApplication:
+		return applicationName + ":", nil
+	case RoleTypeTenant:
+		slug, err := s.repo.GetTenantSlug(*tenantID)
+		if err != nil {
+			return "", err
+		}
+		return slug + ":", nil
+	default:
+		return "platform:", nil
+	}
+}
+
+// validateRoleName checks that name is free (other than for the role being
+// renamed) and lies in the namespace given by prefix.
+func (s *Service) validateRoleName(name, prefix, roleID string) error {
+	if !strings.HasPrefix(name, prefix) || len(name) == len(prefix) {
+		return fmt.Errorf("%w: name must start with %q", ErrInvalidRole, prefix)
+	}
+
+	existing, err := s.repo.GetRoleByName(name)
+	if errors.Is(err, ErrRoleNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.ID != roleID {
+		return ErrRoleExists
+	}
+	return nil
+}
+
+// permissionSet lists a role's permissions, including inherited ones, as
+// "resource_type:action" values for audit events. Grants narrower than the
+// tenant carry their scope as "resource_type:action@scope".
+func (s *Service) permissionSet(roleID string) ([]string, error) {
+	perms, err := s.repo.GetEffectiveRolePermissions(roleID)
+	if err != nil {
+		return nil, err
+	}
+	return effectiveScopes(perms), nil
+}
+
+func effectiveScopes(perms []*RolePermission) []string {
+	scopes := make([]string, 0, len(perms))
+	for _, p := range perms {
+		scope := p.ResourceType + ":" + p.Action
+		if p.Scope != ScopeTenant {
+			scope += "@" + p.Scope
+		}
+		if !p.Conditions.Empty() {
+			scope += " when " + p.Conditions.String()
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+func permissionScopes(perms []*Permission) []string {
+	scopes := make([]string, 0, len(perms))
+	for _, p := range perms {
+		scopes = append(scopes, p.ResourceType+":"+p.Action)
+	}
+	return scopes
+}
+
 func (s *Service) GetUserPermissions(userID string, tenantID *string) ([]*Permission, error) {
 	if userID == "" {
 		return nil, fmt.Errorf("user ID required")
@@ -119,6 +1137,17 @@ func (s *Service) GetUserPermissions(userID string, tenantID *string) ([]*Permis
 	return s.repo.GetUserPermissions(userID, tenantID)
 }
 
+// PermissionScopes lists the user's permissions in tenantID as
+// "resource_type:action" scope values.
+func (s *Service) PermissionScopes(userID string, tenantID *string) ([]string, error) {
+	perms, err := s.GetUserPermissions(userID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return permissionScopes(perms), nil
+}
+
 func (s *Service) GetUserRoles(userID string, tenantID *string) ([]*UserRole, error) {
 	if userID == "" {
 		return nil, fmt.Errorf("user ID required")
@@ -127,21 +1156,191 @@ func (s *Service) GetUserRoles(userID string, tenantID *string) ([]*UserRole, er
 	return s.repo.GetUserRoles(userID, tenantID)
 }
 
-func (s *Service) CheckAPIKeyPermission(apiKeyID, resourceType, action string) (bool, error) {
+// CheckAPIKeyPermission reports whether the API key, bound to tenantID if
+// not nil, holds the permission, evaluating the conditions of the grant
+// against the request in ctx.
+func (s *Service) CheckAPIKeyPermission(ctx context.Context, apiKeyID string, tenantID *string, resourceType, action string) (bool, string, error) {
 	if apiKeyID == "" {
-		return false, fmt.Errorf("api key ID required")
+		return false, "api key ID required", fmt.Errorf("api key ID required")
 	}
 
-	allowed, err := s.repo.HasAPIKeyPermission(apiKeyID, resourceType, action)
+	grants, err := s.repo.GetAPIKeyGrants(apiKeyID, tenantID)
 	if err != nil {
-		return false, err
+		return false, "permission check failed", err
+	}
+
+	allowed, reason, err := s.checkGrants(ctx, IdentityAPIKey, apiKeyID, tenantID, grants, resourceType, action)
+	if err != nil {
+		return false, "permission check failed", err
 	}
 
-	s.auditLogger.Log("authz.api_key_check", apiKeyID, map[string]interface{}{
+	s.auditLogger.LogContext(ctx, "authz.api_key_check", "", map[string]interface{}{
+		"api_key_id":    apiKeyID,
 		"resource_type": resourceType,
 		"action":        action,
 		"allowed":       allowed,
+		"reason":        reason,
+	}, "")
+
+	return allowed, reason, nil
+}
+
+const (
+	EmbedPermissions = "permissions"
+	EmbedHash        = "hash"
+)
+
+func (s *Service) ListClaimsProfiles() ([]*ClaimsProfile, error) {
+	return s.repo.ListClaimsProfiles()
+}
+
+func (s *Service) SaveClaimsProfile(ctx context.Context, actorID, audience, embed string, includeRoles bool) (*ClaimsProfile, error) {
+	if audience == "" {
+		return nil, fmt.Errorf("audience required")
+	}
+	if embed != EmbedPermissions && embed != EmbedHash {
+		return nil, fmt.Errorf("embed must be %q or %q", EmbedPermissions, EmbedHash)
+	}
+
+	profile, err := s.repo.UpsertClaimsProfile(audience, embed, includeRoles)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLogger.LogContext(ctx, "claims_profile.saved", actorID, map[string]interface{}{
+		"audience":      audience,
+		"embed":         embed,
+		"include_roles": includeRoles,
+	}, "")
+
+	return profile, nil
+}
+
+func (s *Service) DeleteClaimsProfile(ctx context.Context, actorID, audience string) error {
+	if err := s.repo.DeleteClaimsProfile(audience); err != nil {
+		return fmt.Errorf("delete claims profile: %w", err)
+	}
+
+	s.auditLogger.LogContext(ctx, "claims_profile.deleted", actorID, map[string]interface{}{
+		"audience": audience,
 	}, "")
 
-	return allowed, nil
+	return nil
+}
+
+var ErrInvalidAttributes = errors.New("invalid principal attributes")
+
+// GetPrincipalAttributes returns the attributes grant conditions can match
+// as principal.{key} for a user, service account or API key.
+func (s *Service) GetPrincipalAttributes(identityType, identityID string) (map[string]interface{}, error) {
+	if err := validPrincipal(identityType, identityID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetPrincipalAttributes(identityType, identityID)
+}
+
+// SetPrincipalAttributes replaces the attributes of a user, service account
+// or API key.
+func (s *Service) SetPrincipalAttributes(ctx context.Context, actorID, identityType, identityID string, attrs map[string]interface{}) error {
+	if err := validPrincipal(identityType, identityID); err != nil {
+		return err
+	}
+	if attrs == nil {
+		attrs = map[string]interface{}{}
+	}
+
+	before, err := s.repo.GetPrincipalAttributes(identityType, identityID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.SetPrincipalAttributes(identityType, identityID, attrs); err != nil {
+		return err
+	}
+
+	s.auditLogger.LogContext(ctx, "principal.attributes_updated", actorID, map[string]interface{}{
+		"identity_type":     identityType,
+		"identity_id":       identityID,
+		"before_attributes": before,
+		"after_attributes":  attrs,
+	}, "")
+
+	return nil
+}
+
+func validPrincipal(identityType, identityID string) error {
+	switch identityType {
+	case IdentityUser, IdentityServiceAccount, IdentityAPIKey:
+	default:
+		return fmt.Errorf("%w: identity type must be user, service_account or api_key", ErrInvalidAttributes)
+	}
+	if identityID == "" {
+		return fmt.Errorf("%w: identity ID required", ErrInvalidAttributes)
+	}
+	return nil
+}
+
+func (s *Service) GetPermissionVersion(userID string) (int64, error) {
+	if userID == "" {
+		return 0, fmt.Errorf("user ID required")
+	}
+	return s.repo.GetPermissionVersion(userID)
+}
+
+// EmbedClaims adds roles, permissions and the permission version to a user
+// access token when its audience has a claims profile. It is registered with
+// the auth service and runs each time a user token is issued or refreshed.
+// A scoped token only carries, and hashes, the permissions its scope allows.
+func (s *Service) EmbedClaims(claims *auth.Claims) error {
+	if claims.IdentityType != "user" || len(claims.Audience) == 0 {
+		return nil
+	}
+
+	profile, err := s.repo.GetClaimsProfile(claims.Audience[0])
+	if err != nil || profile == nil {
+		return err
+	}
+
+	version, err := s.repo.GetPermissionVersion(claims.UserID)
+	if err != nil {
+		return err
+	}
+
+	held, err := s.PermissionScopes(claims.UserID, claims.TenantID)
+	if err != nil {
+		return err
+	}
+	scoped := claims.Principal()
+	perms := []string{}
+	for _, p := range held {
+		i := strings.LastIndex(p, ":")
+		if scoped.HasScope(p[:i], p[i+1:]) {
+			perms = append(perms, p)
+		}
+	}
+	sort.Strings(perms)
+
+	if profile.Embed == EmbedPermissions {
+		claims.Permissions = perms
+	}
+	claims.PermissionsHash = PermissionsHash(perms)
+	claims.PermissionsVersion = version
+
+	if profile.IncludeRoles {
+		roles, err := s.repo.GetUserRoles(claims.UserID, claims.TenantID)
+		if err != nil {
+			return err
+		}
+		for _, r := range roles {
+			claims.Roles = append(claims.Roles, r.RoleName)
+		}
+	}
+
+	return nil
+}
+
+// PermissionsHash is a stable digest of a sorted permission list, so that
+// applications can compare permission sets without embedding them.
+func PermissionsHash(sortedPerms []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(sortedPerms, "\n")))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
 }
@@ -0,0 +1,281 @@
+package relation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
+)
+
+type Handler struct {
+	service     *Service
+	auditLogger *audit.Logger
+}
+
+func NewHandler(service *Service, auditLogger *audit.Logger) *Handler {
+	return &Handler{service: service, auditLogger: auditLogger}
+}
+
+// PutNamespaceRequest replaces a namespace's configuration.
+type PutNamespaceRequest struct {
+	ApplicationName *string              `json:"application_name"`
+	Relations       map[string]*Relation `json:"relations"`
+}
+
+// WriteTuplesRequest deletes and writes tuples in one transaction.
+type WriteTuplesRequest struct {
+	Writes  []Tuple `json:"writes"`
+	Deletes []Tuple `json:"deletes"`
+}
+
+type CheckRequest struct {
+	Namespace string  `json:"namespace"`
+	ObjectID  string  `json:"object_id"`
+	Relation  string  `json:"relation"`
+	Subject   Subject `json:"subject"`
+}
+
+type CheckResponse struct {
+	Allowed bool `json:"allowed"`
+}
+
+type ExpandRequest struct {
+	Namespace string `json:"namespace"`
+	ObjectID  string `json:"object_id"`
+	Relation  string `json:"relation"`
+}
+
+type ListObjectsRequest struct {
+	Namespace string  `json:"namespace"`
+	Relation  string  `json:"relation"`
+	Subject   Subject `json:"subject"`
+}
+
+type ListObjectsResponse struct {
+	ObjectIDs []string `json:"object_ids"`
+}
+
+func (h *Handler) ListNamespaces(w http.ResponseWriter, r *http.Request) {
+	namespaces, err := h.service.ListNamespaces()
+	if err != nil {
+		writeError(w, "failed to list relation namespaces", http.StatusInternalServerError)
+		return
+	}
+
+	if namespaces == nil {
+		namespaces = []*Namespace{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(namespaces)
+}
+
+func (h *Handler) GetNamespace(w http.ResponseWriter, r *http.Request) {
+	n, err := h.service.GetNamespace(chi.URLParam(r, "name"))
+	if err != nil {
+		writeRelationError(w, err, "failed to get relation namespace")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(n)
+}
+
+func (h *Handler) PutNamespace(w http.ResponseWriter, r *http.Request) {
+	var req PutNamespaceRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	n, err := h.service.SaveNamespace(&Namespace{
+		Name:            chi.URLParam(r, "name"),
+		ApplicationName: req.ApplicationName,
+		Relations:       req.Relations,
+	})
+	if err != nil {
+		writeRelationError(w, err, "failed to save relation namespace")
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "relation_namespace.saved", caller.UserID(), map[string]interface{}{
+		"namespace":        n.Name,
+		"application_name": n.ApplicationName,
+		"relations":        n.relationNames(),
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(n)
+}
+
+func (h *Handler) DeleteNamespace(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := h.service.DeleteNamespace(name); err != nil {
+		writeRelationError(w, err, "failed to delete relation namespace")
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "relation_namespace.deleted", caller.UserID(), map[string]interface{}{
+		"namespace": name,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) WriteTuples(w http.ResponseWriter, r *http.Request) {
+	var req WriteTuplesRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	result, err := h.service.Write(r.Context(), req.Writes, req.Deletes, caller.TenantID)
+	if err != nil {
+		writeRelationError(w, err, "failed to write relation tuples")
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "relation.tuples_written", caller.UserID(), map[string]interface{}{
+		"writes":    tupleStrings(req.Writes),
+		"deletes":   tupleStrings(req.Deletes),
+		"written":   result.Written,
+		"deleted":   result.Deleted,
+		"tenant_id": caller.TenantID,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ListTuples returns the tuples matching the namespace, object_id,
+// relation and subject query parameters, up to MaxTuples.
+func (h *Handler) ListTuples(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := TupleFilter{
+		Namespace: query.Get("namespace"),
+		ObjectID:  query.Get("object_id"),
+		Relation:  query.Get("relation"),
+	}
+	if text := query.Get("subject"); text != "" {
+		subject, err := ParseSubject(text)
+		if err != nil {
+			writeRelationError(w, err, "invalid subject")
+			return
+		}
+		filter.Subject = &subject
+	}
+	if filter.Namespace == "" && filter.Subject == nil {
+		writeError(w, "namespace or subject required", http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	tuples, err := h.service.ListTuples(r.Context(), filter, caller.TenantID)
+	if err != nil {
+		writeRelationError(w, err, "failed to list relation tuples")
+		return
+	}
+
+	if tuples == nil {
+		tuples = []Tuple{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tuples)
+}
+
+func (h *Handler) Check(w http.ResponseWriter, r *http.Request) {
+	var req CheckRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	allowed, err := h.service.Check(r.Context(), req.Namespace, req.ObjectID, req.Relation, req.Subject, caller.TenantID)
+	if err != nil {
+		writeRelationError(w, err, "failed to check relation")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CheckResponse{Allowed: allowed})
+}
+
+func (h *Handler) Expand(w http.ResponseWriter, r *http.Request) {
+	var req ExpandRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	tree, err := h.service.Expand(r.Context(), req.Namespace, req.ObjectID, req.Relation, caller.TenantID)
+	if err != nil {
+		writeRelationError(w, err, "failed to expand relation")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+func (h *Handler) ListObjects(w http.ResponseWriter, r *http.Request) {
+	var req ListObjectsRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	objectIDs, err := h.service.ListObjects(r.Context(), req.Namespace, req.Relation, req.Subject, caller.TenantID)
+	if err != nil {
+		writeRelationError(w, err, "failed to list objects")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListObjectsResponse{ObjectIDs: objectIDs})
+}
+
+func tupleStrings(tuples []Tuple) []string {
+	strs := make([]string, len(tuples))
+	for i, tuple := range tuples {
+		strs[i] = tuple.String()
+	}
+	return strs
+}
+
+// decodeRequest decodes a request body, reporting invalid subjects, which
+// are parsed while decoding, with the reason.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		if errors.Is(err, ErrInvalidTuple) {
+			writeError(w, err.Error(), http.StatusBadRequest)
+		} else {
+			writeError(w, "invalid request body", http.StatusBadRequest)
+		}
+		return false
+	}
+	return true
+}
+
+func writeRelationError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, ErrNamespaceNotFound):
+		writeError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrNamespaceInUse):
+		writeError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalidNamespace), errors.Is(err, ErrInvalidTuple), errors.Is(err, ErrTooComplex):
+		writeError(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeError(w, message, http.StatusInternalServerError)
+	}
+}
+
+func writeError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
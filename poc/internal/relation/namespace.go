@@ -0,0 +1,87 @@
+package relation
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Namespace configures the relations of an object type. A subject has a
+// relation to an object if a tuple says so, if it has one of the
+// relation's computed relations to the same object, or if it has an
+// inherited relation to an object related through a tupleset: with
+// {"through": "parent", "relation": "editor"} on document#editor, editors
+// of a document's parent folder are editors of the document.
+type Namespace struct {
+	Name            string               `json:"name"`
+	ApplicationName *string              `json:"application_name,omitempty"`
+	Relations       map[string]*Relation `json:"relations"`
+	CreatedAt       time.Time            `json:"created_at"`
+	UpdatedAt       time.Time            `json:"updated_at"`
+}
+
+type Relation struct {
+	Computed  []string      `json:"computed,omitempty"`
+	Inherited []Inheritance `json:"inherited,omitempty"`
+}
+
+// Inheritance grants a relation to subjects having Relation to the objects
+// the object relates to through the Through relation.
+type Inheritance struct {
+	Through  string `json:"through"`
+	Relation string `json:"relation"`
+}
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+func validName(name string) bool {
+	return len(name) <= 100 && namePattern.MatchString(name)
+}
+
+func (n *Namespace) validate() error {
+	if !validName(n.Name) {
+		return fmt.Errorf("%w: name must be lowercase letters, digits and underscores", ErrInvalidNamespace)
+	}
+	if len(n.Relations) == 0 {
+		return fmt.Errorf("%w: at least one relation required", ErrInvalidNamespace)
+	}
+	for _, name := range n.relationNames() {
+		rel := n.Relations[name]
+		if !validName(name) {
+			return fmt.Errorf("%w: relation %q must be lowercase letters, digits and underscores", ErrInvalidNamespace, name)
+		}
+		if rel == nil {
+			n.Relations[name] = &Relation{}
+			continue
+		}
+		for _, computed := range rel.Computed {
+			if computed == name {
+				return fmt.Errorf("%w: relation %q cannot compute itself", ErrInvalidNamespace, name)
+			}
+			if _, ok := n.Relations[computed]; !ok {
+				return fmt.Errorf("%w: relation %q computes undefined relation %q", ErrInvalidNamespace, name, computed)
+			}
+		}
+		for _, inh := range rel.Inherited {
+			if _, ok := n.Relations[inh.Through]; !ok {
+				return fmt.Errorf("%w: relation %q inherits through undefined relation %q", ErrInvalidNamespace, name, inh.Through)
+			}
+			if !validName(inh.Relation) {
+				return fmt.Errorf("%w: relation %q inherits an invalid relation name", ErrInvalidNamespace, name)
+			}
+		}
+	}
+	return nil
+}
+
+// relationNames returns the relation names sorted, so that validation
+// errors and expansions are stable.
+func (n *Namespace) relationNames() []string {
+	names := make([]string, 0, len(n.Relations))
+	for name := range n.Relations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
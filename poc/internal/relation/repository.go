@@ -0,0 +1,377 @@
+package relation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNamespaceNotFound = errors.New("relation namespace not found")
+	ErrNamespaceInUse    = errors.New("relation namespace in use")
+)
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+const namespaceColumns = `name, application_name, relations, created_at, updated_at`
+
+func scanNamespace(row interface{ Scan(...interface{}) error }) (*Namespace, error) {
+	n := &Namespace{}
+	var relations []byte
+	if err := row.Scan(&n.Name, &n.ApplicationName, &relations, &n.CreatedAt, &n.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(relations, &n.Relations); err != nil {
+		return nil, fmt.Errorf("decode relations of namespace %s: %w", n.Name, err)
+	}
+	return n, nil
+}
+
+func (r *Repository) ListNamespaces() ([]*Namespace, error) {
+	rows, err := r.db.Query(`SELECT ` + namespaceColumns + ` FROM relation_namespaces ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("list relation namespaces: %w", err)
+	}
+	defer rows.Close()
+
+	var namespaces []*Namespace
+	for rows.Next() {
+		n, err := scanNamespace(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan relation namespace: %w", err)
+		}
+		namespaces = append(namespaces, n)
+	}
+	return namespaces, rows.Err()
+}
+
+func (r *Repository) GetNamespace(name string) (*Namespace, error) {
+	n, err := scanNamespace(r.db.QueryRow(
+		`SELECT `+namespaceColumns+` FROM relation_namespaces WHERE name = $1`,
+		name,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrNamespaceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get relation namespace: %w", err)
+	}
+	return n, nil
+}
+
+// SaveNamespace creates or replaces a namespace configuration. Removing a
+// relation that tuples still use, as their relation or their subject's, is
+// rejected.
+func (r *Repository) SaveNamespace(n *Namespace) (*Namespace, error) {
+	relations, err := json.Marshal(n.Relations)
+	if err != nil {
+		return nil, fmt.Errorf("encode relations: %w", err)
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin save relation namespace: %w", err)
+	}
+	defer tx.Rollback()
+
+	saved, err := scanNamespace(tx.QueryRow(
+		`INSERT INTO relation_namespaces (name, application_name, relations)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (name) DO UPDATE SET
+			application_name = EXCLUDED.application_name,
+			relations = EXCLUDED.relations,
+			updated_at = NOW()
+		 RETURNING `+namespaceColumns,
+		n.Name, n.ApplicationName, relations,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("save relation namespace: %w", err)
+	}
+
+	var inUse string
+	err = tx.QueryRow(
+		`SELECT relation FROM relation_tuples
+		 WHERE namespace = $1 AND NOT relation = ANY($2)
+		 UNION ALL
+		 SELECT subject_relation FROM relation_tuples
+		 WHERE subject_namespace = $1 AND subject_relation <> '' AND NOT subject_relation = ANY($2)
+		 LIMIT 1`,
+		n.Name, pq.Array(n.relationNames()),
+	).Scan(&inUse)
+	if err == nil {
+		return nil, fmt.Errorf("%w: tuples still use relation %q", ErrNamespaceInUse, inUse)
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("check relation usage: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit save relation namespace: %w", err)
+	}
+	return saved, nil
+}
+
+// DeleteNamespace removes a namespace no tuple refers to.
+func (r *Repository) DeleteNamespace(name string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin delete relation namespace: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`SELECT TRUE FROM relation_namespaces WHERE name = $1 FOR UPDATE`, name).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return ErrNamespaceNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("lock relation namespace: %w", err)
+	}
+
+	var inUse bool
+	err = tx.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM relation_tuples
+			WHERE namespace = $1 OR (subject_namespace = $1 AND subject_relation <> '')
+		 )`,
+		name,
+	).Scan(&inUse)
+	if err != nil {
+		return fmt.Errorf("check namespace usage: %w", err)
+	}
+	if inUse {
+		return fmt.Errorf("%w: delete its tuples first", ErrNamespaceInUse)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM relation_namespaces WHERE name = $1`, name); err != nil {
+		return fmt.Errorf("delete relation namespace: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit delete relation namespace: %w", err)
+	}
+	return nil
+}
+
+// Tx reads and writes tuples in one Postgres transaction, so that a check,
+// expansion or listing sees a single snapshot of the tuples and namespaces,
+// and a batch of tuple changes is applied atomically.
+//
+// Tuples read and written are limited to the scope, the tenant the
+// caller's token is bound to, when it is not nil.
+type Tx struct {
+	tx         *sql.Tx
+	scope      *string
+	lock       string
+	namespaces map[string]*Namespace
+}
+
+// BeginRead starts a read-only repeatable read transaction.
+func (r *Repository) BeginRead(ctx context.Context, scope *string) (*Tx, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("begin relation read: %w", err)
+	}
+	return &Tx{tx: tx, scope: scope, namespaces: map[string]*Namespace{}}, nil
+}
+
+// BeginWrite starts a transaction for tuple changes. Namespaces read in it
+// are locked against concurrent changes until it ends.
+func (r *Repository) BeginWrite(ctx context.Context, scope *string) (*Tx, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin relation write: %w", err)
+	}
+	return &Tx{tx: tx, scope: scope, lock: " FOR SHARE", namespaces: map[string]*Namespace{}}, nil
+}
+
+func (t *Tx) Commit() error {
+	if err := t.tx.Commit(); err != nil {
+		return fmt.Errorf("commit relation tuples: %w", err)
+	}
+	return nil
+}
+
+func (t *Tx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// Namespace returns a namespace configuration, or nil if there is none.
+func (t *Tx) Namespace(name string) (*Namespace, error) {
+	if n, ok := t.namespaces[name]; ok {
+		return n, nil
+	}
+	n, err := scanNamespace(t.tx.QueryRow(
+		`SELECT `+namespaceColumns+` FROM relation_namespaces WHERE name = $1`+t.lock,
+		name,
+	))
+	if err == sql.ErrNoRows {
+		n, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get relation namespace: %w", err)
+	}
+	t.namespaces[name] = n
+	return n, nil
+}
+
+// Namespaces returns every namespace configuration.
+func (t *Tx) Namespaces() ([]*Namespace, error) {
+	rows, err := t.tx.Query(`SELECT ` + namespaceColumns + ` FROM relation_namespaces ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("list relation namespaces: %w", err)
+	}
+	defer rows.Close()
+
+	var namespaces []*Namespace
+	for rows.Next() {
+		n, err := scanNamespace(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan relation namespace: %w", err)
+		}
+		namespaces = append(namespaces, n)
+		t.namespaces[n.Name] = n
+	}
+	return namespaces, rows.Err()
+}
+
+// Subjects returns the subjects of the tuples of an object's relation.
+func (t *Tx) Subjects(namespace, objectID, relation string) ([]Subject, error) {
+	rows, err := t.tx.Query(
+		`SELECT subject_namespace, subject_id, subject_relation
+		 FROM relation_tuples
+		 WHERE namespace = $1 AND object_id = $2 AND relation = $3
+		 AND ($4::uuid IS NULL OR tenant_id = $4)
+		 ORDER BY subject_namespace, subject_id, subject_relation`,
+		namespace, objectID, relation, t.scope,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list tuple subjects: %w", err)
+	}
+	defer rows.Close()
+
+	var subjects []Subject
+	for rows.Next() {
+		var s Subject
+		if err := rows.Scan(&s.Namespace, &s.ID, &s.Relation); err != nil {
+			return nil, fmt.Errorf("scan tuple subject: %w", err)
+		}
+		subjects = append(subjects, s)
+	}
+	return subjects, rows.Err()
+}
+
+// TupleFilter selects tuples; empty fields match any value. With
+// AnySubjectRelation, Subject.Relation is ignored.
+type TupleFilter struct {
+	Namespace          string
+	ObjectID           string
+	Relation           string
+	Subject            *Subject
+	AnySubjectRelation bool
+}
+
+// Tuples returns up to limit tuples matching a filter, in key order.
+func (t *Tx) Tuples(filter TupleFilter, limit int) ([]Tuple, error) {
+	var subjectNamespace, subjectID, subjectRelation *string
+	if filter.Subject != nil {
+		subjectNamespace, subjectID = &filter.Subject.Namespace, &filter.Subject.ID
+		if !filter.AnySubjectRelation {
+			subjectRelation = &filter.Subject.Relation
+		}
+	}
+
+	rows, err := t.tx.Query(
+		`SELECT namespace, object_id, relation, subject_namespace, subject_id, subject_relation
+		 FROM relation_tuples
+		 WHERE ($1::text = '' OR namespace = $1)
+		 AND ($2::text = '' OR object_id = $2)
+		 AND ($3::text = '' OR relation = $3)
+		 AND ($4::text IS NULL OR subject_namespace = $4)
+		 AND ($5::text IS NULL OR subject_id = $5)
+		 AND ($6::text IS NULL OR subject_relation = $6)
+		 AND ($7::uuid IS NULL OR tenant_id = $7)
+		 ORDER BY namespace, object_id, relation, subject_namespace, subject_id, subject_relation
+		 LIMIT $8`,
+		filter.Namespace, filter.ObjectID, filter.Relation,
+		subjectNamespace, subjectID, subjectRelation, t.scope, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list relation tuples: %w", err)
+	}
+	defer rows.Close()
+
+	var tuples []Tuple
+	for rows.Next() {
+		var tuple Tuple
+		err := rows.Scan(&tuple.Namespace, &tuple.ObjectID, &tuple.Relation,
+			&tuple.Subject.Namespace, &tuple.Subject.ID, &tuple.Subject.Relation)
+		if err != nil {
+			return nil, fmt.Errorf("scan relation tuple: %w", err)
+		}
+		tuples = append(tuples, tuple)
+	}
+	return tuples, rows.Err()
+}
+
+// Insert writes a tuple, reporting whether it was new. A tuple already
+// written in another tenant than the scope is rejected.
+func (t *Tx) Insert(tuple Tuple) (bool, error) {
+	var tenantID *string
+	err := t.tx.QueryRow(
+		`SELECT tenant_id FROM relation_tuples
+		 WHERE namespace = $1 AND object_id = $2 AND relation = $3
+		 AND subject_namespace = $4 AND subject_id = $5 AND subject_relation = $6
+		 FOR UPDATE`,
+		tuple.Namespace, tuple.ObjectID, tuple.Relation,
+		tuple.Subject.Namespace, tuple.Subject.ID, tuple.Subject.Relation,
+	).Scan(&tenantID)
+	if err == nil {
+		if t.scope != nil && (tenantID == nil || *tenantID != *t.scope) {
+			return false, fmt.Errorf("%w: %s exists in another tenant", ErrInvalidTuple, tuple)
+		}
+		return false, nil
+	}
+	if err != sql.ErrNoRows {
+		return false, fmt.Errorf("get relation tuple: %w", err)
+	}
+
+	result, err := t.tx.Exec(
+		`INSERT INTO relation_tuples (namespace, object_id, relation, subject_namespace, subject_id, subject_relation, tenant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT DO NOTHING`,
+		tuple.Namespace, tuple.ObjectID, tuple.Relation,
+		tuple.Subject.Namespace, tuple.Subject.ID, tuple.Subject.Relation, t.scope,
+	)
+	if err != nil {
+		return false, fmt.Errorf("insert relation tuple: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// Delete removes a tuple, reporting whether it existed.
+func (t *Tx) Delete(tuple Tuple) (bool, error) {
+	result, err := t.tx.Exec(
+		`DELETE FROM relation_tuples
+		 WHERE namespace = $1 AND object_id = $2 AND relation = $3
+		 AND subject_namespace = $4 AND subject_id = $5 AND subject_relation = $6
+		 AND ($7::uuid IS NULL OR tenant_id = $7)`,
+		tuple.Namespace, tuple.ObjectID, tuple.Relation,
+		tuple.Subject.Namespace, tuple.Subject.ID, tuple.Subject.Relation, t.scope,
+	)
+	if err != nil {
+		return false, fmt.Errorf("delete relation tuple: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
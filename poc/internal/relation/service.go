@@ -0,0 +1,494 @@
+package relation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrInvalidNamespace = errors.New("invalid relation namespace")
+	ErrInvalidTuple     = errors.New("invalid relation tuple")
+	ErrTooComplex       = errors.New("relation graph too deep or too large to evaluate")
+)
+
+// Limits on a single request: tuple changes written together, relations
+// followed by a check or expansion, and objects visited listing objects.
+const (
+	MaxWrites     = 500
+	MaxDepth      = 25
+	MaxDispatches = 1000
+	MaxVisited    = 10000
+	MaxTuples     = 1000
+)
+
+// Service stores relation tuples and answers whether a subject has a
+// relation to an object, alongside role based checks: applications model
+// document level sharing as tuples in namespaces they configure.
+//
+// Methods take a scope, the tenant the caller's token is bound to: such a
+// caller only sees and writes tuples of that tenant.
+type Service struct {
+	repo *Repository
+}
+
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo}
+}
+
+func (s *Service) ListNamespaces() ([]*Namespace, error) {
+	return s.repo.ListNamespaces()
+}
+
+func (s *Service) GetNamespace(name string) (*Namespace, error) {
+	return s.repo.GetNamespace(name)
+}
+
+func (s *Service) SaveNamespace(n *Namespace) (*Namespace, error) {
+	if err := n.validate(); err != nil {
+		return nil, err
+	}
+	return s.repo.SaveNamespace(n)
+}
+
+func (s *Service) DeleteNamespace(name string) error {
+	return s.repo.DeleteNamespace(name)
+}
+
+// WriteResult counts the tuples a write actually added and removed.
+type WriteResult struct {
+	Written int `json:"written"`
+	Deleted int `json:"deleted"`
+}
+
+// Write deletes and then writes tuples in one transaction: either all
+// changes are applied or none. Writing an existing tuple or deleting a
+// missing one is not an error.
+func (s *Service) Write(ctx context.Context, writes, deletes []Tuple, scope *string) (*WriteResult, error) {
+	if len(writes)+len(deletes) == 0 {
+		return nil, fmt.Errorf("%w: no tuples to write or delete", ErrInvalidTuple)
+	}
+	if len(writes)+len(deletes) > MaxWrites {
+		return nil, fmt.Errorf("%w: at most %d tuples per request", ErrInvalidTuple, MaxWrites)
+	}
+	for _, tuple := range deletes {
+		if err := tuple.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, tuple := range writes {
+		if err := tuple.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := s.repo.BeginWrite(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &WriteResult{}
+	for _, tuple := range deletes {
+		deleted, err := tx.Delete(tuple)
+		if err != nil {
+			return nil, err
+		}
+		if deleted {
+			result.Deleted++
+		}
+	}
+	for _, tuple := range writes {
+		if err := checkTuple(tx, tuple); err != nil {
+			return nil, err
+		}
+		written, err := tx.Insert(tuple)
+		if err != nil {
+			return nil, err
+		}
+		if written {
+			result.Written++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkTuple verifies that the namespaces define the relations a tuple
+// uses: the object's, and the subject's when it is a userset.
+func checkTuple(tx *Tx, tuple Tuple) error {
+	if _, err := relationOf(tx, tuple.Namespace, tuple.Relation); err != nil {
+		return fmt.Errorf("%w: %s", err, tuple)
+	}
+	if tuple.Subject.Relation != "" {
+		if _, err := relationOf(tx, tuple.Subject.Namespace, tuple.Subject.Relation); err != nil {
+			return fmt.Errorf("%w: %s", err, tuple)
+		}
+	}
+	return nil
+}
+
+// relationOf returns the configuration of a namespace's relation.
+func relationOf(tx *Tx, namespace, relation string) (*Relation, error) {
+	n, err := tx.Namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, fmt.Errorf("%w: namespace %q is not configured", ErrInvalidTuple, namespace)
+	}
+	rel, ok := n.Relations[relation]
+	if !ok {
+		return nil, fmt.Errorf("%w: namespace %q has no relation %q", ErrInvalidTuple, namespace, relation)
+	}
+	if rel == nil {
+		rel = &Relation{}
+	}
+	return rel, nil
+}
+
+// ListTuples returns up to MaxTuples tuples matching a filter.
+func (s *Service) ListTuples(ctx context.Context, filter TupleFilter, scope *string) ([]Tuple, error) {
+	tx, err := s.repo.BeginRead(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return tx.Tuples(filter, MaxTuples)
+}
+
+// Check reports whether the subject has the relation to the object.
+func (s *Service) Check(ctx context.Context, namespace, objectID, relation string, subject Subject, scope *string) (bool, error) {
+	if err := (Tuple{Namespace: namespace, ObjectID: objectID, Relation: relation, Subject: subject}).validate(); err != nil {
+		return false, err
+	}
+
+	tx, err := s.repo.BeginRead(ctx, scope)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := relationOf(tx, namespace, relation); err != nil {
+		return false, err
+	}
+	c := &checker{tx: tx, subject: subject, visited: map[string]bool{}}
+	return c.check(namespace, objectID, relation, 0)
+}
+
+// checker evaluates a check. Relations found not to hold, or being
+// evaluated further up, are recorded in visited so that each is evaluated
+// once and cycles end.
+type checker struct {
+	tx         *Tx
+	subject    Subject
+	visited    map[string]bool
+	dispatches int
+}
+
+func (c *checker) check(namespace, objectID, relation string, depth int) (bool, error) {
+	userset := Subject{Namespace: namespace, ID: objectID, Relation: relation}
+	if userset == c.subject {
+		return true, nil
+	}
+	key := userset.String()
+	if c.visited[key] {
+		return false, nil
+	}
+	c.visited[key] = true
+
+	c.dispatches++
+	if depth > MaxDepth || c.dispatches > MaxDispatches {
+		return false, ErrTooComplex
+	}
+
+	n, err := c.tx.Namespace(namespace)
+	if err != nil || n == nil {
+		return false, err
+	}
+	rel, ok := n.Relations[relation]
+	if !ok {
+		return false, nil
+	}
+
+	subjects, err := c.tx.Subjects(namespace, objectID, relation)
+	if err != nil {
+		return false, err
+	}
+	for _, subject := range subjects {
+		if subject == c.subject {
+			return true, nil
+		}
+	}
+	for _, subject := range subjects {
+		if subject.Relation == "" {
+			continue
+		}
+		if ok, err := c.check(subject.Namespace, subject.ID, subject.Relation, depth+1); ok || err != nil {
+			return ok, err
+		}
+	}
+
+	if rel == nil {
+		return false, nil
+	}
+	for _, computed := range rel.Computed {
+		if ok, err := c.check(namespace, objectID, computed, depth+1); ok || err != nil {
+			return ok, err
+		}
+	}
+	for _, inh := range rel.Inherited {
+		related, err := c.tx.Subjects(namespace, objectID, inh.Through)
+		if err != nil {
+			return false, err
+		}
+		for _, object := range related {
+			if ok, err := c.check(object.Namespace, object.ID, inh.Relation, depth+1); ok || err != nil {
+				return ok, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// ExpandNode is the tree of subjects having a relation to an object: the
+// subjects of its tuples, with usersets among them expanded, and the
+// expansions of its computed and inherited relations. A node for a
+// relation already being expanded further up is marked Cycle and not
+// expanded again.
+type ExpandNode struct {
+	Userset   Subject       `json:"userset"`
+	Subjects  []Subject     `json:"subjects,omitempty"`
+	Usersets  []*ExpandNode `json:"usersets,omitempty"`
+	Computed  []*ExpandNode `json:"computed,omitempty"`
+	Inherited []*ExpandNode `json:"inherited,omitempty"`
+	Cycle     bool          `json:"cycle,omitempty"`
+}
+
+// Expand returns the tree of subjects having the relation to the object.
+func (s *Service) Expand(ctx context.Context, namespace, objectID, relation string, scope *string) (*ExpandNode, error) {
+	root := Subject{Namespace: namespace, ID: objectID, Relation: relation}
+	if !validName(namespace) || !validName(relation) || !validID(objectID) {
+		return nil, fmt.Errorf("%w: cannot expand %s", ErrInvalidTuple, root)
+	}
+
+	tx, err := s.repo.BeginRead(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := relationOf(tx, namespace, relation); err != nil {
+		return nil, err
+	}
+	e := &expander{tx: tx, path: map[Subject]bool{}}
+	return e.expand(root, 0)
+}
+
+type expander struct {
+	tx         *Tx
+	path       map[Subject]bool
+	dispatches int
+}
+
+func (e *expander) expand(userset Subject, depth int) (*ExpandNode, error) {
+	node := &ExpandNode{Userset: userset}
+	if e.path[userset] {
+		node.Cycle = true
+		return node, nil
+	}
+
+	e.dispatches++
+	if depth > MaxDepth || e.dispatches > MaxDispatches {
+		return nil, ErrTooComplex
+	}
+
+	n, err := e.tx.Namespace(userset.Namespace)
+	if err != nil || n == nil {
+		return node, err
+	}
+	rel, ok := n.Relations[userset.Relation]
+	if !ok {
+		return node, nil
+	}
+
+	e.path[userset] = true
+	defer delete(e.path, userset)
+
+	node.Subjects, err = e.tx.Subjects(userset.Namespace, userset.ID, userset.Relation)
+	if err != nil {
+		return nil, err
+	}
+	for _, subject := range node.Subjects {
+		if subject.Relation == "" {
+			continue
+		}
+		child, err := e.expand(subject, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		node.Usersets = append(node.Usersets, child)
+	}
+
+	if rel == nil {
+		return node, nil
+	}
+	for _, computed := range rel.Computed {
+		child, err := e.expand(Subject{Namespace: userset.Namespace, ID: userset.ID, Relation: computed}, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		node.Computed = append(node.Computed, child)
+	}
+	for _, inh := range rel.Inherited {
+		related, err := e.tx.Subjects(userset.Namespace, userset.ID, inh.Through)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range related {
+			child, err := e.expand(Subject{Namespace: object.Namespace, ID: object.ID, Relation: inh.Relation}, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Inherited = append(node.Inherited, child)
+		}
+	}
+	return node, nil
+}
+
+// inheritance is a relation of a namespace inheriting through a tupleset.
+type inheritance struct {
+	namespace, relation, through string
+}
+
+// ListObjects returns the IDs of the objects in a namespace the subject
+// has the relation to, sorted. It walks the relation graph backwards from
+// the subject: to the objects whose tuples name it or a userset it is in,
+// to the relations computed from those, and to the objects inheriting
+// from them.
+func (s *Service) ListObjects(ctx context.Context, namespace, relation string, subject Subject, scope *string) ([]string, error) {
+	if !validName(namespace) || !validName(relation) {
+		return nil, fmt.Errorf("%w: namespace and relation must be lowercase names", ErrInvalidTuple)
+	}
+	if !validName(subject.Namespace) || !validID(subject.ID) || (subject.Relation != "" && !validName(subject.Relation)) {
+		return nil, fmt.Errorf("%w: invalid subject %s", ErrInvalidTuple, subject)
+	}
+
+	tx, err := s.repo.BeginRead(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := relationOf(tx, namespace, relation); err != nil {
+		return nil, err
+	}
+	namespaces, err := tx.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	// computedFrom maps namespace#relation to the relations of the same
+	// namespace computed from it; inheritedFrom maps a relation name to
+	// the relations inheriting it, through a tupleset, from related
+	// objects of any namespace.
+	computedFrom := map[string][]string{}
+	inheritedFrom := map[string][]inheritance{}
+	for _, n := range namespaces {
+		for _, name := range n.relationNames() {
+			rel := n.Relations[name]
+			if rel == nil {
+				continue
+			}
+			for _, computed := range rel.Computed {
+				key := n.Name + "#" + computed
+				computedFrom[key] = append(computedFrom[key], name)
+			}
+			for _, inh := range rel.Inherited {
+				inheritedFrom[inh.Relation] = append(inheritedFrom[inh.Relation], inheritance{namespace: n.Name, relation: name, through: inh.Through})
+			}
+		}
+	}
+
+	visited := map[Subject]bool{}
+	var queue []Subject
+	reach := func(userset Subject) error {
+		if visited[userset] {
+			return nil
+		}
+		if len(visited) >= MaxVisited {
+			return ErrTooComplex
+		}
+		visited[userset] = true
+		queue = append(queue, userset)
+		return nil
+	}
+
+	// A single object reaches the objects whose tuples name it; a userset
+	// is itself the start of the walk.
+	if subject.Relation == "" {
+		tuples, err := tx.Tuples(TupleFilter{Subject: &subject}, MaxVisited+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, tuple := range tuples {
+			if err := reach(Subject{Namespace: tuple.Namespace, ID: tuple.ObjectID, Relation: tuple.Relation}); err != nil {
+				return nil, err
+			}
+		}
+	} else if err := reach(subject); err != nil {
+		return nil, err
+	}
+
+	for len(queue) > 0 {
+		userset := queue[0]
+		queue = queue[1:]
+
+		tuples, err := tx.Tuples(TupleFilter{Subject: &userset}, MaxVisited+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, tuple := range tuples {
+			if err := reach(Subject{Namespace: tuple.Namespace, ID: tuple.ObjectID, Relation: tuple.Relation}); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, computed := range computedFrom[userset.Namespace+"#"+userset.Relation] {
+			if err := reach(Subject{Namespace: userset.Namespace, ID: userset.ID, Relation: computed}); err != nil {
+				return nil, err
+			}
+		}
+
+		object := Subject{Namespace: userset.Namespace, ID: userset.ID}
+		for _, inheriting := range inheritedFrom[userset.Relation] {
+			related, err := tx.Tuples(TupleFilter{
+				Namespace:          inheriting.namespace,
+				Relation:           inheriting.through,
+				Subject:            &object,
+				AnySubjectRelation: true,
+			}, MaxVisited+1)
+			if err != nil {
+				return nil, err
+			}
+			for _, tuple := range related {
+				if err := reach(Subject{Namespace: tuple.Namespace, ID: tuple.ObjectID, Relation: inheriting.relation}); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	objectIDs := []string{}
+	for userset := range visited {
+		if userset.Namespace == namespace && userset.Relation == relation {
+			objectIDs = append(objectIDs, userset.ID)
+		}
+	}
+	sort.Strings(objectIDs)
+	return objectIDs, nil
+}
@@ -0,0 +1,80 @@
+package relation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Subject is what a tuple relates an object to: a single object such as
+// user:alice, or, with Relation set, a userset such as group:eng#member,
+// everyone with that relation to the object. It is written as text in
+// that form.
+type Subject struct {
+	Namespace string
+	ID        string
+	Relation  string
+}
+
+func (s Subject) String() string {
+	if s.Relation == "" {
+		return s.Namespace + ":" + s.ID
+	}
+	return s.Namespace + ":" + s.ID + "#" + s.Relation
+}
+
+func (s Subject) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Subject) UnmarshalText(text []byte) error {
+	subject, err := ParseSubject(string(text))
+	if err != nil {
+		return err
+	}
+	*s = subject
+	return nil
+}
+
+// ParseSubject parses "namespace:id" or "namespace:id#relation".
+func ParseSubject(text string) (Subject, error) {
+	var s Subject
+	object, relation, hasRelation := strings.Cut(text, "#")
+	if hasRelation && relation == "" {
+		return s, fmt.Errorf("%w: subject %q has an empty relation", ErrInvalidTuple, text)
+	}
+	namespace, id, ok := strings.Cut(object, ":")
+	if !ok || namespace == "" || id == "" {
+		return s, fmt.Errorf("%w: subject %q must be namespace:id or namespace:id#relation", ErrInvalidTuple, text)
+	}
+	return Subject{Namespace: namespace, ID: id, Relation: relation}, nil
+}
+
+// Tuple relates an object to a subject: object#relation@subject.
+type Tuple struct {
+	Namespace string  `json:"namespace"`
+	ObjectID  string  `json:"object_id"`
+	Relation  string  `json:"relation"`
+	Subject   Subject `json:"subject"`
+}
+
+func (t Tuple) String() string {
+	return fmt.Sprintf("%s:%s#%s@%s", t.Namespace, t.ObjectID, t.Relation, t.Subject)
+}
+
+// validID rejects IDs that would make tuples ambiguous in text form.
+func validID(id string) bool {
+	return id != "" && len(id) <= 255 && !strings.ContainsAny(id, "#@ \t\n")
+}
+
+func (t Tuple) validate() error {
+	if !validName(t.Namespace) || !validName(t.Relation) {
+		return fmt.Errorf("%w: %s: namespace and relation must be lowercase names", ErrInvalidTuple, t)
+	}
+	if !validID(t.ObjectID) || !validID(t.Subject.ID) {
+		return fmt.Errorf("%w: %s: IDs must be 1 to 255 characters without #, @ or spaces", ErrInvalidTuple, t)
+	}
+	if !validName(t.Subject.Namespace) || (t.Subject.Relation != "" && !validName(t.Subject.Relation)) {
+		return fmt.Errorf("%w: %s: subject namespace and relation must be lowercase names", ErrInvalidTuple, t)
+	}
+	return nil
+}
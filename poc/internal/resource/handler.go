@@ -0,0 +1,181 @@
+package resource
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
+)
+
+type Handler struct {
+	service     *Service
+	auditLogger *audit.Logger
+}
+
+func NewHandler(service *Service, auditLogger *audit.Logger) *Handler {
+	return &Handler{service: service, auditLogger: auditLogger}
+}
+
+// PutResourceRequest records a resource's tenant, owner and team. Fields
+// left out are cleared.
+type PutResourceRequest struct {
+	TenantID  *string `json:"tenant_id"`
+	OwnerType *string `json:"owner_type"`
+	OwnerID   *string `json:"owner_id"`
+	TeamID    *string `json:"team_id"`
+}
+
+type AssignRequest struct {
+	IdentityType string `json:"identity_type"`
+	IdentityID   string `json:"identity_id"`
+}
+
+func (h *Handler) GetResource(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principal.FromContext(r.Context())
+	res, err := h.service.Get(resourceParam(r, "type"), resourceParam(r, "id"), caller.TenantID)
+	if err != nil {
+		writeResourceError(w, err, "failed to get resource")
+		return
+	}
+
+	if res.Assignments == nil {
+		res.Assignments = []*Assignment{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *Handler) PutResource(w http.ResponseWriter, r *http.Request) {
+	var req PutResourceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	res, err := h.service.Put(&Resource{
+		Type:      resourceParam(r, "type"),
+		ID:        resourceParam(r, "id"),
+		TenantID:  req.TenantID,
+		OwnerType: req.OwnerType,
+		OwnerID:   req.OwnerID,
+		TeamID:    req.TeamID,
+	}, caller.TenantID)
+	if err != nil {
+		writeResourceError(w, err, "failed to record resource")
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "resource.updated", caller.UserID(), map[string]interface{}{
+		"resource_type": res.Type,
+		"resource_id":   res.ID,
+		"tenant_id":     res.TenantID,
+		"owner_type":    res.OwnerType,
+		"owner_id":      res.OwnerID,
+		"team_id":       res.TeamID,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *Handler) DeleteResource(w http.ResponseWriter, r *http.Request) {
+	resourceType := resourceParam(r, "type")
+	resourceID := resourceParam(r, "id")
+
+	caller, _ := principal.FromContext(r.Context())
+	if err := h.service.Delete(resourceType, resourceID, caller.TenantID); err != nil {
+		writeResourceError(w, err, "failed to delete resource")
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "resource.deleted", caller.UserID(), map[string]interface{}{
+		"resource_type": resourceType,
+		"resource_id":   resourceID,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) Assign(w http.ResponseWriter, r *http.Request) {
+	var req AssignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resourceType := resourceParam(r, "type")
+	resourceID := resourceParam(r, "id")
+
+	caller, _ := principal.FromContext(r.Context())
+	assignment, err := h.service.Assign(resourceType, resourceID, caller.TenantID, req.IdentityType, req.IdentityID)
+	if err != nil {
+		writeResourceError(w, err, "failed to assign resource")
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "resource.assigned", caller.UserID(), map[string]interface{}{
+		"resource_type": resourceType,
+		"resource_id":   resourceID,
+		"identity_type": assignment.IdentityType,
+		"identity_id":   assignment.IdentityID,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(assignment)
+}
+
+func (h *Handler) Unassign(w http.ResponseWriter, r *http.Request) {
+	resourceType := resourceParam(r, "type")
+	resourceID := resourceParam(r, "id")
+	identityType := chi.URLParam(r, "identityType")
+	identityID := chi.URLParam(r, "identityId")
+
+	caller, _ := principal.FromContext(r.Context())
+	if err := h.service.Unassign(resourceType, resourceID, caller.TenantID, identityType, identityID); err != nil {
+		writeResourceError(w, err, "failed to unassign resource")
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "resource.unassigned", caller.UserID(), map[string]interface{}{
+		"resource_type": resourceType,
+		"resource_id":   resourceID,
+		"identity_type": identityType,
+		"identity_id":   identityID,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resourceParam returns a URL parameter, unescaped: chi matches escaped
+// paths as sent, and application resource IDs may contain any character.
+func resourceParam(r *http.Request, key string) string {
+	value := chi.URLParam(r, key)
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		return unescaped
+	}
+	return value
+}
+
+func writeResourceError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, ErrResourceNotFound), errors.Is(err, ErrAssignmentNotFound):
+		writeError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidResource):
+		writeError(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeError(w, message, http.StatusInternalServerError)
+	}
+}
+
+func writeError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
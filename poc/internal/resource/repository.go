@@ -0,0 +1,187 @@
+package resource
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Resource is an application resource as recorded in the registry: its
+// tenant, owner and team. IDs are the application's own.
+type Resource struct {
+	Type        string        `json:"type"`
+	ID          string        `json:"id"`
+	TenantID    *string       `json:"tenant_id,omitempty"`
+	OwnerType   *string       `json:"owner_type,omitempty"`
+	OwnerID     *string       `json:"owner_id,omitempty"`
+	TeamID      *string       `json:"team_id,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	Assignments []*Assignment `json:"assignments,omitempty"`
+}
+
+// Assignment is a user or service account assigned to a resource.
+type Assignment struct {
+	IdentityType string    `json:"identity_type"`
+	IdentityID   string    `json:"identity_id"`
+	AssignedAt   time.Time `json:"assigned_at"`
+}
+
+var (
+	ErrResourceNotFound   = errors.New("resource not found")
+	ErrAssignmentNotFound = errors.New("assignment not found")
+)
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+const resourceColumns = `resource_type, resource_id, tenant_id, owner_type, owner_id, team_id, created_at, updated_at`
+
+func scanResource(row interface{ Scan(...interface{}) error }) (*Resource, error) {
+	res := &Resource{}
+	err := row.Scan(&res.Type, &res.ID, &res.TenantID, &res.OwnerType, &res.OwnerID, &res.TeamID, &res.CreatedAt, &res.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Get returns a resource. With a non-nil scope, resources of other tenants
+// are not found.
+func (r *Repository) Get(resourceType, resourceID string, scope *string) (*Resource, error) {
+	res, err := scanResource(r.db.QueryRow(
+		`SELECT `+resourceColumns+`
+		 FROM resources
+		 WHERE resource_type = $1 AND resource_id = $2
+		 AND ($3::uuid IS NULL OR tenant_id = $3)`,
+		resourceType, resourceID, scope,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrResourceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get resource: %w", err)
+	}
+	return res, nil
+}
+
+// Put records a resource or replaces its tenant, owner and team. With a
+// non-nil scope, a resource already recorded in another tenant is left
+// untouched and not found.
+func (r *Repository) Put(res *Resource, scope *string) (*Resource, error) {
+	saved, err := scanResource(r.db.QueryRow(
+		`INSERT INTO resources (resource_type, resource_id, tenant_id, owner_type, owner_id, team_id)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (resource_type, resource_id) DO UPDATE SET
+			tenant_id = EXCLUDED.tenant_id,
+			owner_type = EXCLUDED.owner_type,
+			owner_id = EXCLUDED.owner_id,
+			team_id = EXCLUDED.team_id,
+			updated_at = NOW()
+		 WHERE $7::uuid IS NULL OR resources.tenant_id = $7
+		 RETURNING `+resourceColumns,
+		res.Type, res.ID, res.TenantID, res.OwnerType, res.OwnerID, res.TeamID, scope,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrResourceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("put resource: %w", err)
+	}
+	return saved, nil
+}
+
+// Delete removes a resource and its assignments.
+func (r *Repository) Delete(resourceType, resourceID string, scope *string) error {
+	result, err := r.db.Exec(
+		`DELETE FROM resources
+		 WHERE resource_type = $1 AND resource_id = $2
+		 AND ($3::uuid IS NULL OR tenant_id = $3)`,
+		resourceType, resourceID, scope,
+	)
+	if err != nil {
+		return fmt.Errorf("delete resource: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrResourceNotFound
+	}
+	return nil
+}
+
+// GetTeamTenant returns the tenant of a team.
+func (r *Repository) GetTeamTenant(teamID string) (string, error) {
+	var tenantID string
+	err := r.db.QueryRow(`SELECT tenant_id FROM teams WHERE id = $1`, teamID).Scan(&tenantID)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("team not found")
+	}
+	if err != nil {
+		return "", fmt.Errorf("get team: %w", err)
+	}
+	return tenantID, nil
+}
+
+func (r *Repository) ListAssignments(resourceType, resourceID string) ([]*Assignment, error) {
+	rows, err := r.db.Query(
+		`SELECT identity_type, identity_id, assigned_at
+		 FROM resource_assignments
+		 WHERE resource_type = $1 AND resource_id = $2
+		 ORDER BY assigned_at`,
+		resourceType, resourceID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list assignments: %w", err)
+	}
+	defer rows.Close()
+
+	var assignments []*Assignment
+	for rows.Next() {
+		a := &Assignment{}
+		if err := rows.Scan(&a.IdentityType, &a.IdentityID, &a.AssignedAt); err != nil {
+			return nil, fmt.Errorf("scan assignment: %w", err)
+		}
+		assignments = append(assignments, a)
+	}
+
+	return assignments, rows.Err()
+}
+
+func (r *Repository) AddAssignment(resourceType, resourceID, identityType, identityID string) (*Assignment, error) {
+	a := &Assignment{}
+	err := r.db.QueryRow(
+		`INSERT INTO resource_assignments (resource_type, resource_id, identity_type, identity_id)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (resource_type, resource_id, identity_type, identity_id)
+		 DO UPDATE SET assigned_at = resource_assignments.assigned_at
+		 RETURNING identity_type, identity_id, assigned_at`,
+		resourceType, resourceID, identityType, identityID,
+	).Scan(&a.IdentityType, &a.IdentityID, &a.AssignedAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("add assignment: %w", err)
+	}
+
+	return a, nil
+}
+
+func (r *Repository) RemoveAssignment(resourceType, resourceID, identityType, identityID string) error {
+	result, err := r.db.Exec(
+		`DELETE FROM resource_assignments
+		 WHERE resource_type = $1 AND resource_id = $2
+		 AND identity_type = $3 AND identity_id = $4`,
+		resourceType, resourceID, identityType, identityID,
+	)
+	if err != nil {
+		return fmt.Errorf("remove assignment: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrAssignmentNotFound
+	}
+	return nil
+}
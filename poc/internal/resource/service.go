@@ -0,0 +1,109 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Identity types of owners and assignees.
+const (
+	IdentityUser           = "user"
+	IdentityServiceAccount = "service_account"
+)
+
+var ErrInvalidResource = errors.New("invalid resource")
+
+// Service maintains the resource registry applications write to, which
+// owned, assigned and team scoped grants are evaluated against.
+//
+// Methods take a scope, the tenant the caller's token is bound to: such a
+// caller only sees and records resources of that tenant.
+type Service struct {
+	repo *Repository
+}
+
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo}
+}
+
+// Get returns a resource with its assignments.
+func (s *Service) Get(resourceType, resourceID string, scope *string) (*Resource, error) {
+	res, err := s.repo.Get(resourceType, resourceID, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Assignments, err = s.repo.ListAssignments(resourceType, resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Put records a resource. A caller bound to a tenant records it in that
+// tenant. A team must belong to the resource's tenant.
+func (s *Service) Put(res *Resource, scope *string) (*Resource, error) {
+	if res.Type == "" || res.ID == "" {
+		return nil, fmt.Errorf("%w: resource type and ID required", ErrInvalidResource)
+	}
+	if (res.OwnerType == nil) != (res.OwnerID == nil) {
+		return nil, fmt.Errorf("%w: owner_type and owner_id must be given together", ErrInvalidResource)
+	}
+	if res.OwnerType != nil && !validIdentityType(*res.OwnerType) {
+		return nil, fmt.Errorf("%w: owner_type must be user or service_account", ErrInvalidResource)
+	}
+
+	if scope != nil {
+		if res.TenantID == nil {
+			res.TenantID = scope
+		}
+		if *res.TenantID != *scope {
+			return nil, fmt.Errorf("%w: token is bound to another tenant", ErrInvalidResource)
+		}
+	}
+
+	if res.TeamID != nil {
+		if res.TenantID == nil {
+			return nil, fmt.Errorf("%w: resources with a team need a tenant", ErrInvalidResource)
+		}
+		teamTenant, err := s.repo.GetTeamTenant(*res.TeamID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidResource, err)
+		}
+		if teamTenant != *res.TenantID {
+			return nil, fmt.Errorf("%w: team belongs to another tenant", ErrInvalidResource)
+		}
+	}
+
+	return s.repo.Put(res, scope)
+}
+
+func (s *Service) Delete(resourceType, resourceID string, scope *string) error {
+	return s.repo.Delete(resourceType, resourceID, scope)
+}
+
+func (s *Service) Assign(resourceType, resourceID string, scope *string, identityType, identityID string) (*Assignment, error) {
+	if !validIdentityType(identityType) {
+		return nil, fmt.Errorf("%w: identity type must be user or service_account", ErrInvalidResource)
+	}
+	if identityID == "" {
+		return nil, fmt.Errorf("%w: identity ID required", ErrInvalidResource)
+	}
+	if _, err := s.repo.Get(resourceType, resourceID, scope); err != nil {
+		return nil, err
+	}
+
+	return s.repo.AddAssignment(resourceType, resourceID, identityType, identityID)
+}
+
+func (s *Service) Unassign(resourceType, resourceID string, scope *string, identityType, identityID string) error {
+	if _, err := s.repo.Get(resourceType, resourceID, scope); err != nil {
+		return err
+	}
+	return s.repo.RemoveAssignment(resourceType, resourceID, identityType, identityID)
+}
+
+func validIdentityType(identityType string) bool {
+	return identityType == IdentityUser || identityType == IdentityServiceAccount
+}
@@ -11,9 +11,21 @@ import (
 	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/database"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/federation"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/identity"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/impersonation"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/mail"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/oauth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/passwordless"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/policyfeed"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/rbac"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/relation"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/resource"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/serviceaccount"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/team"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/tenant"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/tokenexchange"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/user"
 )
 
@@ -22,7 +34,7 @@ type HealthResponse struct {
 	Timestamp string `json:"timestamp"`
 }
 
-func New(db *sql.DB, cfg *config.Config, auditLogger *audit.Logger) http.Handler {
+func New(db *sql.DB, cfg *config.Config, auditLogger *audit.Logger, mailTransport mail.Transport) http.Handler {
 	r := chi.NewRouter()
 
 	userRepo := user.NewRepository(db)
@@ -32,14 +44,34 @@ func New(db *sql.DB, cfg *config.Config, auditLogger *audit.Logger) http.Handler
 	authService := auth.NewService(db, &cfg.Auth)
 	authHandler := auth.NewHandler(authService, auditLogger, &cfg.Auth)
 
-	tenantRepo := tenant.NewRepository(db)
-	tenantService := tenant.NewService(tenantRepo)
-	tenantHandler := tenant.NewHandler(tenantService, auditLogger)
-
 	rbacRepo := rbac.NewRepository(db)
 	rbacService := rbac.NewService(rbacRepo, auditLogger)
 	rbacHandler := rbac.NewHandler(rbacService)
 
+	authService.SetPermissionResolver(rbacService.PermissionScopes)
+	authService.SetClaimsEnricher(rbacService.EmbedClaims)
+
+	policyFeedRepo := policyfeed.NewRepository(db)
+	policyFeedService := policyfeed.NewService(policyFeedRepo)
+	policyFeedService.Start(database.DSN(&cfg.Database))
+	policyFeedHandler := policyfeed.NewHandler(policyFeedService)
+
+	tenantRepo := tenant.NewRepository(db)
+	tenantService := tenant.NewService(tenantRepo)
+	tenantHandler := tenant.NewHandler(tenantService, authService, rbacService, auditLogger, &cfg.Auth)
+
+	teamRepo := team.NewRepository(db)
+	teamService := team.NewService(teamRepo)
+	teamHandler := team.NewHandler(teamService, auditLogger)
+
+	resourceRepo := resource.NewRepository(db)
+	resourceService := resource.NewService(resourceRepo)
+	resourceHandler := resource.NewHandler(resourceService, auditLogger)
+
+	relationRepo := relation.NewRepository(db)
+	relationService := relation.NewService(relationRepo)
+	relationHandler := relation.NewHandler(relationService, auditLogger)
+
 	serviceAccountRepo := serviceaccount.NewRepository(db)
 	serviceAccountService := serviceaccount.NewService(serviceAccountRepo, &cfg.Auth)
 	serviceAccountHandler := serviceaccount.NewHandler(serviceAccountService, auditLogger)
@@ -48,20 +80,72 @@ func New(db *sql.DB, cfg *config.Config, auditLogger *audit.Logger) http.Handler
 	apiKeyService := apikey.NewService(apiKeyRepo)
 	apiKeyHandler := apikey.NewHandler(apiKeyService, auditLogger)
 
+	identityRepo := identity.NewRepository(db)
+	identityService := identity.NewService(identityRepo)
+	identityHandler := identity.NewHandler(identityService, auditLogger, &cfg.Auth)
+
+	federationRepo := federation.NewRepository(db)
+	federationService := federation.NewService(federationRepo, userService, identityService, rbacService, auditLogger)
+	federationHandler := federation.NewHandler(federationService, authService, auditLogger, &cfg.Auth)
+
+	passwordlessRepo := passwordless.NewRepository(db)
+	passwordlessService := passwordless.NewService(passwordlessRepo, authService, mailTransport, &cfg.Passwordless, &cfg.Auth)
+	passwordlessHandler := passwordless.NewHandler(passwordlessService, authService, auditLogger, &cfg.Auth)
+
+	impersonationRepo := impersonation.NewRepository(db)
+	impersonationService := impersonation.NewService(impersonationRepo, userService, rbacService, &cfg.Auth)
+	impersonationHandler := impersonation.NewHandler(impersonationService, auditLogger)
+
+	oauthRepo := oauth.NewRepository(db)
+	oauthService := oauth.NewService(oauthRepo, authService, &cfg.Auth, &cfg.OAuth)
+	oauthHandler := oauth.NewHandler(oauthService, authService, auditLogger, &cfg.Auth, &cfg.OAuth)
+
+	tokenExchangeRepo := tokenexchange.NewRepository(db)
+	tokenExchangeService := tokenexchange.NewService(tokenExchangeRepo, serviceAccountService, rbacService, &cfg.Auth)
+	tokenExchangeHandler := tokenexchange.NewHandler(tokenExchangeService, auditLogger)
+
+	tokenEndpoint := oauth.NewTokenEndpoint()
+	tokenEndpoint.RegisterGrant("client_credentials", serviceAccountHandler.ClientCredentialsToken)
+	tokenEndpoint.RegisterGrant(oauth.GrantAuthorizationCode, oauthHandler.AuthorizationCodeToken)
+	tokenEndpoint.RegisterGrant(oauth.GrantDeviceCode, oauthHandler.DeviceCodeToken)
+	tokenEndpoint.RegisterGrant(tokenexchange.GrantTokenExchange, tokenExchangeHandler.TokenExchange)
+
 	r.Get("/health", handleHealth)
+	r.Get("/.well-known/jwks.json", authHandler.JWKS)
+	r.Get("/.well-known/openid-configuration", oauthHandler.Discovery)
+	r.Get("/oauth/authorize", oauthHandler.Authorize)
+	r.Post("/oauth/device_authorization", oauthHandler.DeviceAuthorization)
+	r.Get("/oauth/device", oauthHandler.GetDevice)
+	r.Post("/oauth/device", oauthHandler.DecideDevice)
+	r.Get("/login/magic", passwordlessHandler.LinkLanding)
 
 	r.Route("/api/v1", func(r chi.Router) {
 		r.Post("/users", userHandler.CreateUser)
 
 		r.Post("/auth/login", authHandler.Login)
 		r.Post("/auth/refresh", authHandler.Refresh)
-		r.Post("/auth/token", serviceAccountHandler.ClientCredentialsToken)
+		r.Post("/auth/passwordless/start", passwordlessHandler.Start)
+		r.Post("/auth/passwordless/verify", passwordlessHandler.Verify)
+		r.Post("/auth/federation/{connectionId}/start", federationHandler.StartSignIn)
+		r.Post("/auth/federation/{connectionId}/callback", federationHandler.Callback)
+		r.Method(http.MethodPost, "/auth/token", tokenEndpoint)
 
 		r.Group(func(r chi.Router) {
 			r.Use(apikey.AuthenticateAPIKey(apiKeyService))
 			r.Use(auth.RequireAuth(&cfg.Auth))
-			r.Post("/auth/logout", authHandler.Logout)
-			r.Get("/users/me", userHandler.GetMe)
+
+			r.Group(func(r chi.Router) {
+				r.Use(auth.RequireUser)
+				r.Post("/auth/logout", authHandler.Logout)
+				r.Post("/auth/switch-tenant", tenantHandler.SwitchTenant)
+				r.Get("/users/me", userHandler.GetMe)
+				r.Get("/users/me/tenants", tenantHandler.ListMyTenants)
+				r.Get("/users/me/identities", identityHandler.ListMyIdentities)
+				r.Post("/users/me/identities", identityHandler.LinkIdentity)
+				r.Get("/users/me/impersonations", impersonationHandler.ListMyImpersonations)
+				r.Post("/users/{userId}/impersonate", impersonationHandler.Impersonate)
+				r.Delete("/users/me/identities/{id}", identityHandler.UnlinkIdentity)
+			})
 
 			r.Route("/tenants", func(r chi.Router) {
 				r.Use(rbac.RequirePermission(rbacService, "bastion:tenant", "create"))
@@ -71,13 +155,182 @@ func New(db *sql.DB, cfg *config.Config, auditLogger *audit.Logger) http.Handler
 			r.Get("/tenants", tenantHandler.ListTenants)
 			r.Get("/tenants/{id}", tenantHandler.GetTenant)
 
-			r.Post("/roles/{roleId}/assign", rbacHandler.AssignRole)
-			r.Delete("/roles/{roleId}/assign", rbacHandler.RevokeRole)
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:tenant", "read"))
+				r.Get("/tenants/{id}/members", tenantHandler.ListMembers)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:tenant", "update"))
+				r.Put("/tenants/{id}/login-methods", tenantHandler.UpdateLoginMethods)
+				r.Post("/tenants/{id}/members", tenantHandler.AddMember)
+				r.Delete("/tenants/{id}/members/{userId}", tenantHandler.RemoveMember)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:tenant-role", "read"))
+				r.Get("/tenants/{id}/roles", rbacHandler.ListTenantRoles)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:tenant-role", "create"))
+				r.Post("/tenants/{id}/roles", rbacHandler.CreateTenantRole)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:tenant-role", "update"))
+				r.Put("/tenants/{id}/roles/{roleId}", rbacHandler.UpdateTenantRole)
+				r.Post("/tenants/{id}/roles/{roleId}/permissions", rbacHandler.AddTenantRolePermissions)
+				r.Delete("/tenants/{id}/roles/{roleId}/permissions/{permId}", rbacHandler.RemoveTenantRolePermission)
+				r.Post("/tenants/{id}/roles/{roleId}/parents", rbacHandler.AddTenantRoleParent)
+				r.Delete("/tenants/{id}/roles/{roleId}/parents/{parentId}", rbacHandler.RemoveTenantRoleParent)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:tenant-role", "delete"))
+				r.Delete("/tenants/{id}/roles/{roleId}", rbacHandler.DeleteTenantRole)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:team", "read"))
+				r.Get("/tenants/{id}/teams", teamHandler.ListTeams)
+				r.Get("/tenants/{id}/teams/{teamId}", teamHandler.GetTeam)
+				r.Get("/tenants/{id}/teams/{teamId}/members", teamHandler.ListMembers)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:team", "create"))
+				r.Post("/tenants/{id}/teams", teamHandler.CreateTeam)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:team", "update"))
+				r.Post("/tenants/{id}/teams/{teamId}/members", teamHandler.AddMember)
+				r.Delete("/tenants/{id}/teams/{teamId}/members/{identityType}/{identityId}", teamHandler.RemoveMember)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:team", "delete"))
+				r.Delete("/tenants/{id}/teams/{teamId}", teamHandler.DeleteTeam)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:role", "read"))
+				r.Get("/roles", rbacHandler.ListRoles)
+				r.Get("/roles/{id}", rbacHandler.GetRole)
+				r.Get("/roles/{id}/permissions", rbacHandler.GetRolePermissions)
+				r.Get("/roles/{id}/parents", rbacHandler.GetRoleParents)
+				r.Get("/permissions", rbacHandler.ListPermissions)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:role", "create"))
+				r.Post("/roles", rbacHandler.CreateRole)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:role", "update"))
+				r.Put("/roles/{id}", rbacHandler.UpdateRole)
+				r.Post("/roles/{id}/permissions", rbacHandler.AddRolePermissions)
+				r.Delete("/roles/{id}/permissions/{permId}", rbacHandler.RemoveRolePermission)
+				r.Post("/roles/{id}/parents", rbacHandler.AddRoleParent)
+				r.Delete("/roles/{id}/parents/{parentId}", rbacHandler.RemoveRoleParent)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:role", "delete"))
+				r.Delete("/roles/{id}", rbacHandler.DeleteRole)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:role", "assign"))
+				r.Post("/roles/{roleId}/assign", rbacHandler.AssignRole)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:role", "revoke"))
+				r.Delete("/roles/{roleId}/assign", rbacHandler.RevokeRole)
+			})
 
 			r.Get("/users/{userId}/roles", rbacHandler.GetUserRoles)
 			r.Get("/users/{userId}/permissions", rbacHandler.GetUserPermissions)
 
-			r.Post("/authz/check", rbacHandler.CheckAuthorization)
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:authz", "check"))
+				r.Post("/authz/check", rbacHandler.CheckAuthorization)
+				r.Post("/authz/check-batch", rbacHandler.CheckAuthorizationBatch)
+				r.Get("/authz/changes", policyFeedHandler.ListChanges)
+				r.Get("/authz/changes/stream", policyFeedHandler.StreamChanges)
+				r.Get("/authz/permission-versions/{userId}", rbacHandler.GetPermissionVersion)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:resource", "read"))
+				r.Get("/resources/{type}/{id}", resourceHandler.GetResource)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:resource", "write"))
+				r.Put("/resources/{type}/{id}", resourceHandler.PutResource)
+				r.Delete("/resources/{type}/{id}", resourceHandler.DeleteResource)
+				r.Post("/resources/{type}/{id}/assignments", resourceHandler.Assign)
+				r.Delete("/resources/{type}/{id}/assignments/{identityType}/{identityId}", resourceHandler.Unassign)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:relation-namespace", "read"))
+				r.Get("/relations/namespaces", relationHandler.ListNamespaces)
+				r.Get("/relations/namespaces/{name}", relationHandler.GetNamespace)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:relation-namespace", "write"))
+				r.Put("/relations/namespaces/{name}", relationHandler.PutNamespace)
+				r.Delete("/relations/namespaces/{name}", relationHandler.DeleteNamespace)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:relation", "read"))
+				r.Get("/relations/tuples", relationHandler.ListTuples)
+				r.Post("/relations/expand", relationHandler.Expand)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:relation", "write"))
+				r.Post("/relations/tuples", relationHandler.WriteTuples)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:relation", "check"))
+				r.Post("/relations/check", relationHandler.Check)
+				r.Post("/relations/list-objects", relationHandler.ListObjects)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:principal-attribute", "read"))
+				r.Get("/principals/{identityType}/{identityId}/attributes", rbacHandler.GetPrincipalAttributes)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:principal-attribute", "update"))
+				r.Put("/principals/{identityType}/{identityId}/attributes", rbacHandler.SetPrincipalAttributes)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:claims-profile", "read"))
+				r.Get("/claims-profiles", rbacHandler.ListClaimsProfiles)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:claims-profile", "update"))
+				r.Put("/claims-profiles", rbacHandler.SaveClaimsProfile)
+				r.Delete("/claims-profiles", rbacHandler.DeleteClaimsProfile)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:user", "merge"))
+				r.Post("/users/{userId}/merge", identityHandler.MergeUsers)
+			})
 
 			r.Group(func(r chi.Router) {
 				r.Use(rbac.RequirePermission(rbacService, "bastion:service-account", "create"))
@@ -88,12 +341,21 @@ func New(db *sql.DB, cfg *config.Config, auditLogger *audit.Logger) http.Handler
 				r.Use(rbac.RequirePermission(rbacService, "bastion:service-account", "read"))
 				r.Get("/service-accounts", serviceAccountHandler.ListServiceAccounts)
 				r.Get("/service-accounts/{id}", serviceAccountHandler.GetServiceAccount)
+				r.Get("/service-accounts/{id}/exchange-policies", tokenExchangeHandler.ListPolicies)
+				r.Get("/service-accounts/{id}/audiences", serviceAccountHandler.ListAudiences)
+				r.Get("/service-accounts/{id}/roles", serviceAccountHandler.ListRoles)
 			})
 
 			r.Group(func(r chi.Router) {
 				r.Use(rbac.RequirePermission(rbacService, "bastion:service-account", "update"))
 				r.Put("/service-accounts/{id}", serviceAccountHandler.UpdateServiceAccount)
 				r.Post("/service-accounts/{id}/regenerate-secret", serviceAccountHandler.RegenerateSecret)
+				r.Post("/service-accounts/{id}/exchange-policies", tokenExchangeHandler.CreatePolicy)
+				r.Delete("/service-accounts/{id}/exchange-policies/{policyId}", tokenExchangeHandler.DeletePolicy)
+				r.Post("/service-accounts/{id}/audiences", serviceAccountHandler.AddAudience)
+				r.Delete("/service-accounts/{id}/audiences", serviceAccountHandler.RemoveAudience)
+				r.Post("/service-accounts/{id}/roles", serviceAccountHandler.AssignRole)
+				r.Delete("/service-accounts/{id}/roles/{roleId}", serviceAccountHandler.RevokeRole)
 			})
 
 			r.Group(func(r chi.Router) {
@@ -117,6 +379,50 @@ func New(db *sql.DB, cfg *config.Config, auditLogger *audit.Logger) http.Handler
 				r.Delete("/api-keys/{id}", apiKeyHandler.DeleteAPIKey)
 			})
 
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:idp-connection", "create"))
+				r.Post("/idp-connections", federationHandler.CreateConnection)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:idp-connection", "read"))
+				r.Get("/idp-connections", federationHandler.ListConnections)
+				r.Get("/idp-connections/{id}", federationHandler.GetConnection)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:idp-connection", "update"))
+				r.Put("/idp-connections/{id}", federationHandler.UpdateConnection)
+				r.Post("/idp-connections/{id}/mappings", federationHandler.CreateMapping)
+				r.Delete("/idp-connections/{id}/mappings/{mappingId}", federationHandler.DeleteMapping)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:idp-connection", "delete"))
+				r.Delete("/idp-connections/{id}", federationHandler.DeleteConnection)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:oauth-client", "create"))
+				r.Post("/oauth-clients", oauthHandler.CreateClient)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:oauth-client", "read"))
+				r.Get("/oauth-clients", oauthHandler.ListClients)
+				r.Get("/oauth-clients/{id}", oauthHandler.GetClient)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:oauth-client", "update"))
+				r.Put("/oauth-clients/{id}", oauthHandler.UpdateClient)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(rbac.RequirePermission(rbacService, "bastion:oauth-client", "delete"))
+				r.Delete("/oauth-clients/{id}", oauthHandler.DeleteClient)
+			})
+
 			r.Post("/api-keys/{id}/permissions", apiKeyHandler.AddPermission)
 			r.Delete("/api-keys/{id}/permissions/{permId}", apiKeyHandler.RemovePermission)
 		})
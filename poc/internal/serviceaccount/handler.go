@@ -2,11 +2,13 @@ package serviceaccount
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
 )
 
 type Handler struct {
@@ -42,8 +44,8 @@ func (h *Handler) CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims := r.Context().Value("claims").(*auth.Claims)
-	h.auditLogger.Log("service_account.created", claims.UserID, map[string]interface{}{
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "service_account.created", caller.UserID(), map[string]interface{}{
 		"service_account_id": sa.ID,
 		"name":               sa.Name,
 	}, r.RemoteAddr)
@@ -65,9 +67,9 @@ func (h *Handler) CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) ListServiceAccounts(w http.ResponseWriter, r *http.Request) {
-	claims := r.Context().Value("claims").(*auth.Claims)
+	caller, _ := principal.FromContext(r.Context())
 
-	accounts, err := h.service.List(claims.TenantID)
+	accounts, err := h.service.List(caller.TenantID)
 	if err != nil {
 		writeError(w, "failed to list service accounts", http.StatusInternalServerError)
 		return
@@ -140,8 +142,8 @@ func (h *Handler) UpdateServiceAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims := r.Context().Value("claims").(*auth.Claims)
-	h.auditLogger.Log("service_account.updated", claims.UserID, map[string]interface{}{
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "service_account.updated", caller.UserID(), map[string]interface{}{
 		"service_account_id": id,
 	}, r.RemoteAddr)
 
@@ -156,8 +158,8 @@ func (h *Handler) DeleteServiceAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims := r.Context().Value("claims").(*auth.Claims)
-	h.auditLogger.Log("service_account.deleted", claims.UserID, map[string]interface{}{
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "service_account.deleted", caller.UserID(), map[string]interface{}{
 		"service_account_id": id,
 	}, r.RemoteAddr)
 
@@ -179,8 +181,8 @@ func (h *Handler) RegenerateSecret(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims := r.Context().Value("claims").(*auth.Claims)
-	h.auditLogger.Log("service_account.secret_regenerated", claims.UserID, map[string]interface{}{
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "service_account.secret_regenerated", caller.UserID(), map[string]interface{}{
 		"service_account_id": id,
 	}, r.RemoteAddr)
 
@@ -213,7 +215,17 @@ func (h *Handler) ClientCredentialsToken(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	accessToken, err := h.service.Authenticate(clientID, clientSecret)
+	accessToken, scope, err := h.service.Authenticate(clientID, clientSecret, r.FormValue("audience"), r.FormValue("scope"))
+	if errors.Is(err, auth.ErrInvalidScope) {
+		h.auditLogger.LogError("service_account.auth_failed", err, r.RemoteAddr)
+		writeOAuthError(w, "invalid_scope", "service account holds none of the requested permissions")
+		return
+	}
+	if errors.Is(err, ErrAudienceNotAllowed) {
+		h.auditLogger.LogError("service_account.auth_failed", err, r.RemoteAddr)
+		writeOAuthError(w, "invalid_target", "service account may not request tokens for this audience")
+		return
+	}
 	if err != nil {
 		h.auditLogger.LogError("service_account.auth_failed", err, r.RemoteAddr)
 		writeOAuthError(w, "invalid_client", "invalid client credentials")
@@ -222,7 +234,7 @@ func (h *Handler) ClientCredentialsToken(w http.ResponseWriter, r *http.Request)
 
 	sa, _ := h.service.repo.GetByClientID(clientID)
 	if sa != nil {
-		h.auditLogger.Log("service_account.authenticated", sa.ID, nil, r.RemoteAddr)
+		h.auditLogger.LogContext(r.Context(), "service_account.authenticated", sa.ID, nil, r.RemoteAddr)
 	}
 
 	resp := map[string]interface{}{
@@ -230,11 +242,146 @@ func (h *Handler) ClientCredentialsToken(w http.ResponseWriter, r *http.Request)
 		"token_type":   "Bearer",
 		"expires_in":   900,
 	}
+	if scope != "" {
+		resp["scope"] = scope
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+func (h *Handler) ListAudiences(w http.ResponseWriter, r *http.Request) {
+	audiences, err := h.service.ListAudiences(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "failed to list audiences", http.StatusInternalServerError)
+		return
+	}
+
+	if audiences == nil {
+		audiences = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"audiences": audiences})
+}
+
+func (h *Handler) AddAudience(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req struct {
+		Audience string `json:"audience"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.AddAudience(id, req.Audience); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "service_account.audience_added", caller.UserID(), map[string]interface{}{
+		"service_account_id": id,
+		"audience":           req.Audience,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) RemoveAudience(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	audience := r.URL.Query().Get("audience")
+
+	if err := h.service.RemoveAudience(id, audience); err != nil {
+		writeError(w, "failed to remove audience", http.StatusInternalServerError)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "service_account.audience_removed", caller.UserID(), map[string]interface{}{
+		"service_account_id": id,
+		"audience":           audience,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.service.ListRoles(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "failed to list roles", http.StatusInternalServerError)
+		return
+	}
+
+	if roles == nil {
+		roles = []*RoleAssignment{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"roles": roles})
+}
+
+func (h *Handler) AssignRole(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req struct {
+		RoleID   string  `json:"role_id"`
+		TenantID *string `json:"tenant_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	tenantID, err := h.service.AssignRole(id, req.RoleID, req.TenantID, caller.OptionalUserID())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "service_account.role_assigned", caller.UserID(), map[string]interface{}{
+		"service_account_id": id,
+		"role_id":            req.RoleID,
+		"tenant_id":          tenantID,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) RevokeRole(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	roleID := chi.URLParam(r, "roleId")
+
+	var tenantID *string
+	if t := r.URL.Query().Get("tenant_id"); t != "" {
+		tenantID = &t
+	}
+
+	tenantID, err := h.service.RevokeRole(id, roleID, tenantID)
+	if errors.Is(err, ErrRoleNotAssigned) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "service_account.role_revoked", caller.UserID(), map[string]interface{}{
+		"service_account_id": id,
+		"role_id":            roleID,
+		"tenant_id":          tenantID,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func writeError(w http.ResponseWriter, message string, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -242,8 +389,13 @@ func writeError(w http.ResponseWriter, message string, status int) {
 }
 
 func writeOAuthError(w http.ResponseWriter, code, description string) {
+	status := http.StatusUnauthorized
+	if code == "invalid_target" || code == "invalid_scope" {
+		status = http.StatusBadRequest
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusUnauthorized)
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(map[string]string{
 		"error":             code,
 		"error_description": description,
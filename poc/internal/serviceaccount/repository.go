@@ -138,16 +138,82 @@ func (r *Repository) RegenerateSecret(id, newSecretHash string) error {
 	return err
 }
 
-func (r *Repository) AssignRole(serviceAccountID, roleID string) error {
+// RoleAssignment is a role held by a service account, in one tenant or in
+// all tenants when TenantID is nil.
+type RoleAssignment struct {
+	RoleID    string    `json:"role_id"`
+	RoleName  string    `json:"role_name"`
+	TenantID  *string   `json:"tenant_id"`
+	GrantedBy *string   `json:"granted_by,omitempty"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+func (r *Repository) AssignRole(serviceAccountID, roleID string, tenantID, grantedBy *string) error {
 	_, err := r.db.Exec(
-		`INSERT INTO service_account_roles (service_account_id, role_id)
-		 VALUES ($1, $2)
-		 ON CONFLICT (service_account_id, role_id) DO NOTHING`,
-		serviceAccountID, roleID,
+		`INSERT INTO service_account_roles (service_account_id, role_id, tenant_id, granted_by)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT DO NOTHING`,
+		serviceAccountID, roleID, tenantID, grantedBy,
 	)
 	return err
 }
 
+func (r *Repository) RevokeRole(serviceAccountID, roleID string, tenantID *string) (bool, error) {
+	result, err := r.db.Exec(
+		`DELETE FROM service_account_roles
+		 WHERE service_account_id = $1 AND role_id = $2 AND tenant_id IS NOT DISTINCT FROM $3`,
+		serviceAccountID, roleID, tenantID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("revoke service account role: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("revoke service account role: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (r *Repository) ListRoles(serviceAccountID string) ([]*RoleAssignment, error) {
+	rows, err := r.db.Query(
+		`SELECT sar.role_id, ro.name, sar.tenant_id, sar.granted_by, sar.granted_at
+		 FROM service_account_roles sar
+		 JOIN roles ro ON ro.id = sar.role_id
+		 WHERE sar.service_account_id = $1
+		 ORDER BY ro.name, sar.tenant_id NULLS FIRST`,
+		serviceAccountID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list service account roles: %w", err)
+	}
+	defer rows.Close()
+
+	var assignments []*RoleAssignment
+	for rows.Next() {
+		a := &RoleAssignment{}
+		if err := rows.Scan(&a.RoleID, &a.RoleName, &a.TenantID, &a.GrantedBy, &a.GrantedAt); err != nil {
+			return nil, fmt.Errorf("scan service account role: %w", err)
+		}
+		assignments = append(assignments, a)
+	}
+
+	return assignments, rows.Err()
+}
+
+// GetRoleTenant reports whether the role exists and, for a tenant custom
+// role, the tenant it belongs to.
+func (r *Repository) GetRoleTenant(roleID string) (bool, *string, error) {
+	var tenantID *string
+	err := r.db.QueryRow(`SELECT tenant_id FROM roles WHERE id = $1`, roleID).Scan(&tenantID)
+	if err == sql.ErrNoRows {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, fmt.Errorf("check role: %w", err)
+	}
+	return true, tenantID, nil
+}
+
 func (r *Repository) GetRoles(serviceAccountID string) ([]string, error) {
 	rows, err := r.db.Query(
 		`SELECT role_id FROM service_account_roles WHERE service_account_id = $1`,
@@ -169,3 +235,93 @@ func (r *Repository) GetRoles(serviceAccountID string) ([]string, error) {
 
 	return roleIDs, nil
 }
+
+func (r *Repository) ListAudiences(serviceAccountID string) ([]string, error) {
+	rows, err := r.db.Query(
+		`SELECT audience FROM service_account_audiences
+		 WHERE service_account_id = $1 ORDER BY audience`,
+		serviceAccountID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list service account audiences: %w", err)
+	}
+	defer rows.Close()
+
+	var audiences []string
+	for rows.Next() {
+		var audience string
+		if err := rows.Scan(&audience); err != nil {
+			return nil, fmt.Errorf("scan audience: %w", err)
+		}
+		audiences = append(audiences, audience)
+	}
+
+	return audiences, rows.Err()
+}
+
+func (r *Repository) HasAudience(serviceAccountID, audience string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM service_account_audiences
+			WHERE service_account_id = $1 AND audience = $2
+		)`,
+		serviceAccountID, audience,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("check service account audience: %w", err)
+	}
+	return exists, nil
+}
+
+func (r *Repository) AddAudience(serviceAccountID, audience string) error {
+	_, err := r.db.Exec(
+		`INSERT INTO service_account_audiences (service_account_id, audience)
+		 VALUES ($1, $2)
+		 ON CONFLICT DO NOTHING`,
+		serviceAccountID, audience,
+	)
+	return err
+}
+
+func (r *Repository) RemoveAudience(serviceAccountID, audience string) error {
+	_, err := r.db.Exec(
+		`DELETE FROM service_account_audiences WHERE service_account_id = $1 AND audience = $2`,
+		serviceAccountID, audience,
+	)
+	return err
+}
+
+// GetPermissionScopes lists the permissions granted through the service
+// account's roles in tenantID as "resource_type:action" scope values. Only
+// unconditional tenant-scope grants are included; narrower ones need a
+// resource to apply and conditional ones a request.
+func (r *Repository) GetPermissionScopes(serviceAccountID string, tenantID *string) ([]string, error) {
+	rows, err := r.db.Query(
+		`SELECT DISTINCT p.resource_type || ':' || p.action
+		 FROM permissions p
+		 JOIN role_permissions rp ON rp.permission_id = p.id
+		 JOIN role_closure rc ON rc.ancestor_id = rp.role_id
+		 JOIN service_account_roles sar ON sar.role_id = rc.role_id
+		 WHERE sar.service_account_id = $1
+		 AND (sar.tenant_id = $2 OR sar.tenant_id IS NULL)
+		 AND rp.scope = 'tenant'
+		 AND rp.conditions IS NULL`,
+		serviceAccountID, tenantID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get service account permissions: %w", err)
+	}
+	defer rows.Close()
+
+	var scopes []string
+	for rows.Next() {
+		var scope string
+		if err := rows.Scan(&scope); err != nil {
+			return nil, fmt.Errorf("scan permission: %w", err)
+		}
+		scopes = append(scopes, scope)
+	}
+
+	return scopes, rows.Err()
+}
@@ -3,11 +3,13 @@ package serviceaccount
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
 )
 
@@ -35,7 +37,7 @@ func (s *Service) Create(name, description string, tenantID *string, roleIDs []s
 	}
 
 	for _, roleID := range roleIDs {
-		if err := s.repo.AssignRole(sa.ID, roleID); err != nil {
+		if err := s.repo.AssignRole(sa.ID, roleID, sa.TenantID, nil); err != nil {
 			return nil, "", fmt.Errorf("assign role: %w", err)
 		}
 	}
@@ -43,30 +45,73 @@ func (s *Service) Create(name, description string, tenantID *string, roleIDs []s
 	return sa, clientSecret, nil
 }
 
-func (s *Service) Authenticate(clientID, clientSecret string) (string, error) {
+// ErrAudienceNotAllowed is returned by Authenticate when the service account
+// is not allowed to obtain tokens for the requested audience.
+var ErrAudienceNotAllowed = errors.New("audience not allowed for service account")
+
+// Authenticate issues a client_credentials token for audience, or for the
+// Bastion API when audience is empty. Other audiences must be on the service
+// account's allowlist. A requested scope is narrowed to the permissions of
+// the account's roles; the granted scope is returned with the token.
+func (s *Service) Authenticate(clientID, clientSecret, audience, scope string) (string, string, error) {
+	sa, err := s.Verify(clientID, clientSecret)
+	if err != nil {
+		return "", "", err
+	}
+
+	if audience == "" {
+		audience = auth.Audience(s.cfg)
+	}
+	if audience != auth.Audience(s.cfg) {
+		allowed, err := s.repo.HasAudience(sa.ID, audience)
+		if err != nil {
+			return "", "", err
+		}
+		if !allowed {
+			return "", "", ErrAudienceNotAllowed
+		}
+	}
+
+	if scope != "" {
+		held, err := s.repo.GetPermissionScopes(sa.ID, sa.TenantID)
+		if err != nil {
+			return "", "", err
+		}
+		if scope, err = auth.IntersectScope(scope, held); err != nil {
+			return "", "", err
+		}
+	}
+
+	token, err := s.generateAccessToken(sa, audience, scope)
+	if err != nil {
+		return "", "", fmt.Errorf("generate token: %w", err)
+	}
+
+	return token, scope, nil
+}
+
+// Verify checks a service account's client credentials without issuing a
+// token, for grants where the service account authenticates itself as the
+// client.
+func (s *Service) Verify(clientID, clientSecret string) (*ServiceAccount, error) {
 	sa, err := s.repo.GetByClientID(clientID)
 	if err != nil {
-		return "", fmt.Errorf("invalid credentials")
+		return nil, fmt.Errorf("invalid credentials")
 	}
 
 	if !sa.Enabled {
-		return "", fmt.Errorf("service account disabled")
+		return nil, fmt.Errorf("service account disabled")
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(sa.ClientSecretHash), []byte(clientSecret)); err != nil {
-		return "", fmt.Errorf("invalid credentials")
+		return nil, fmt.Errorf("invalid credentials")
 	}
 
 	if err := s.repo.UpdateLastUsed(sa.ID); err != nil {
-		return "", fmt.Errorf("update last used: %w", err)
-	}
-
-	token, err := s.generateAccessToken(sa)
-	if err != nil {
-		return "", fmt.Errorf("generate token: %w", err)
+		return nil, fmt.Errorf("update last used: %w", err)
 	}
 
-	return token, nil
+	return sa, nil
 }
 
 func (s *Service) List(tenantID *string) ([]*ServiceAccount, error) {
@@ -99,24 +144,117 @@ func (s *Service) RegenerateSecret(id string) (string, error) {
 	return newSecret, nil
 }
 
-func (s *Service) generateAccessToken(sa *ServiceAccount) (string, error) {
-	now := time.Now()
-	claims := jwt.MapClaims{
-		"sub":           sa.ID,
-		"identity_type": "service_account",
-		"name":          sa.Name,
-		"tenant_id":     sa.TenantID,
-		"iat":           now.Unix(),
-		"exp":           now.Add(s.cfg.AccessTokenTTL).Unix(),
+func (s *Service) ListAudiences(id string) ([]string, error) {
+	return s.repo.ListAudiences(id)
+}
+
+func (s *Service) AddAudience(id, audience string) error {
+	if audience == "" {
+		return fmt.Errorf("audience required")
+	}
+	if audience == auth.Audience(s.cfg) {
+		return fmt.Errorf("the Bastion API audience is always allowed")
+	}
+	if _, err := s.repo.GetByID(id); err != nil {
+		return fmt.Errorf("service account not found")
+	}
+	return s.repo.AddAudience(id, audience)
+}
+
+func (s *Service) RemoveAudience(id, audience string) error {
+	return s.repo.RemoveAudience(id, audience)
+}
+
+func (s *Service) ListRoles(id string) ([]*RoleAssignment, error) {
+	return s.repo.ListRoles(id)
+}
+
+// AssignRole grants a role to a service account in tenantID, or in every
+// tenant when tenantID is nil. Roles of a tenant-bound service account
+// always apply in its own tenant; the tenant returned is the one used.
+func (s *Service) AssignRole(id, roleID string, tenantID, grantedBy *string) (*string, error) {
+	sa, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("service account not found")
+	}
+
+	tenantID, err = assignmentTenant(sa, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if roleID == "" {
+		return nil, fmt.Errorf("role_id required")
 	}
+	exists, roleTenantID, err := s.repo.GetRoleTenant(roleID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("role not found")
+	}
+	if roleTenantID != nil && (tenantID == nil || *tenantID != *roleTenantID) {
+		return nil, fmt.Errorf("role can only be assigned in its own tenant")
+	}
+
+	if err := s.repo.AssignRole(sa.ID, roleID, tenantID, grantedBy); err != nil {
+		return nil, fmt.Errorf("assign role: %w", err)
+	}
+	return tenantID, nil
+}
+
+// ErrRoleNotAssigned is returned by RevokeRole when the service account does
+// not hold the role in the tenant.
+var ErrRoleNotAssigned = errors.New("role not assigned to service account")
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString([]byte(s.cfg.JWTSecret))
+func (s *Service) RevokeRole(id, roleID string, tenantID *string) (*string, error) {
+	sa, err := s.repo.GetByID(id)
 	if err != nil {
-		return "", fmt.Errorf("sign token: %w", err)
+		return nil, fmt.Errorf("service account not found")
+	}
+
+	tenantID, err = assignmentTenant(sa, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := s.repo.RevokeRole(sa.ID, roleID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if !revoked {
+		return nil, ErrRoleNotAssigned
+	}
+	return tenantID, nil
+}
+
+func assignmentTenant(sa *ServiceAccount, tenantID *string) (*string, error) {
+	if sa.TenantID == nil {
+		return tenantID, nil
+	}
+	if tenantID != nil && *tenantID != *sa.TenantID {
+		return nil, fmt.Errorf("service account belongs to another tenant")
+	}
+	return sa.TenantID, nil
+}
+
+func (s *Service) generateAccessToken(sa *ServiceAccount, audience, scope string) (string, error) {
+	now := time.Now()
+	claims := &auth.Claims{
+		UserID:       sa.ID,
+		IdentityType: "service_account",
+		Name:         sa.Name,
+		TenantID:     sa.TenantID,
+		Scope:        scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    auth.Issuer(s.cfg),
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.cfg.AccessTokenTTL)),
+		},
 	}
 
-	return signed, nil
+	return auth.SignAccessToken(s.cfg, claims)
 }
 
 func generateClientID() string {
@@ -0,0 +1,226 @@
+package team
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
+)
+
+type Handler struct {
+	service     *Service
+	auditLogger *audit.Logger
+}
+
+func NewHandler(service *Service, auditLogger *audit.Logger) *Handler {
+	return &Handler{service: service, auditLogger: auditLogger}
+}
+
+type CreateTeamRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type AddMemberRequest struct {
+	IdentityType string `json:"identity_type"`
+	IdentityID   string `json:"identity_id"`
+}
+
+func (h *Handler) ListTeams(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := tenantScope(w, r)
+	if !ok {
+		return
+	}
+
+	teams, err := h.service.List(tenantID)
+	if err != nil {
+		writeError(w, "failed to list teams", http.StatusInternalServerError)
+		return
+	}
+
+	if teams == nil {
+		teams = []*Team{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"teams": teams})
+}
+
+func (h *Handler) CreateTeam(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := tenantScope(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateTeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	team, err := h.service.Create(tenantID, req.Name, req.Description)
+	if err != nil {
+		writeTeamError(w, err, "failed to create team")
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "team.created", caller.UserID(), map[string]interface{}{
+		"tenant_id": tenantID,
+		"team_id":   team.ID,
+		"name":      team.Name,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(team)
+}
+
+func (h *Handler) GetTeam(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := tenantScope(w, r)
+	if !ok {
+		return
+	}
+
+	team, err := h.service.Get(tenantID, chi.URLParam(r, "teamId"))
+	if err != nil {
+		writeTeamError(w, err, "failed to get team")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(team)
+}
+
+func (h *Handler) DeleteTeam(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := tenantScope(w, r)
+	if !ok {
+		return
+	}
+
+	teamID := chi.URLParam(r, "teamId")
+	if err := h.service.Delete(tenantID, teamID); err != nil {
+		writeTeamError(w, err, "failed to delete team")
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "team.deleted", caller.UserID(), map[string]interface{}{
+		"tenant_id": tenantID,
+		"team_id":   teamID,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := tenantScope(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := h.service.ListMembers(tenantID, chi.URLParam(r, "teamId"))
+	if err != nil {
+		writeTeamError(w, err, "failed to list team members")
+		return
+	}
+
+	if members == nil {
+		members = []*Member{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"members": members})
+}
+
+func (h *Handler) AddMember(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := tenantScope(w, r)
+	if !ok {
+		return
+	}
+
+	var req AddMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	teamID := chi.URLParam(r, "teamId")
+	member, err := h.service.AddMember(tenantID, teamID, req.IdentityType, req.IdentityID)
+	if err != nil {
+		writeTeamError(w, err, "failed to add team member")
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "team.member_added", caller.UserID(), map[string]interface{}{
+		"tenant_id":     tenantID,
+		"team_id":       teamID,
+		"identity_type": member.IdentityType,
+		"identity_id":   member.IdentityID,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(member)
+}
+
+func (h *Handler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := tenantScope(w, r)
+	if !ok {
+		return
+	}
+
+	teamID := chi.URLParam(r, "teamId")
+	identityType := chi.URLParam(r, "identityType")
+	identityID := chi.URLParam(r, "identityId")
+
+	if err := h.service.RemoveMember(tenantID, teamID, identityType, identityID); err != nil {
+		writeTeamError(w, err, "failed to remove team member")
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "team.member_removed", caller.UserID(), map[string]interface{}{
+		"tenant_id":     tenantID,
+		"team_id":       teamID,
+		"identity_type": identityType,
+		"identity_id":   identityID,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tenantScope returns the tenant in the URL, rejecting callers whose token
+// is bound to another tenant.
+func tenantScope(w http.ResponseWriter, r *http.Request) (string, bool) {
+	tenantID := chi.URLParam(r, "id")
+	caller, _ := principal.FromContext(r.Context())
+	if caller.TenantID != nil && *caller.TenantID != tenantID {
+		writeError(w, "token is bound to another tenant", http.StatusForbidden)
+		return "", false
+	}
+	return tenantID, true
+}
+
+func writeTeamError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, ErrTeamNotFound), errors.Is(err, ErrMemberNotFound):
+		writeError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrTeamExists):
+		writeError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalidTeam):
+		writeError(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeError(w, message, http.StatusInternalServerError)
+	}
+}
+
+func writeError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,183 @@
+package team
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Team struct {
+	ID          string    `json:"id"`
+	TenantID    string    `json:"tenant_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Member is a user or service account on a team.
+type Member struct {
+	TeamID       string    `json:"team_id"`
+	IdentityType string    `json:"identity_type"`
+	IdentityID   string    `json:"identity_id"`
+	AddedAt      time.Time `json:"added_at"`
+}
+
+var (
+	ErrTeamNotFound   = errors.New("team not found")
+	ErrTeamExists     = errors.New("team name already in use")
+	ErrMemberNotFound = errors.New("team member not found")
+)
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) Create(tenantID, name, description string) (*Team, error) {
+	t := &Team{}
+	err := r.db.QueryRow(
+		`INSERT INTO teams (tenant_id, name, description)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (tenant_id, name) DO NOTHING
+		 RETURNING id, tenant_id, name, COALESCE(description, ''), created_at`,
+		tenantID, name, description,
+	).Scan(&t.ID, &t.TenantID, &t.Name, &t.Description, &t.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTeamExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create team: %w", err)
+	}
+
+	return t, nil
+}
+
+// Get returns a team of the tenant; teams of other tenants are not found.
+func (r *Repository) Get(tenantID, id string) (*Team, error) {
+	t := &Team{}
+	err := r.db.QueryRow(
+		`SELECT id, tenant_id, name, COALESCE(description, ''), created_at
+		 FROM teams WHERE id = $1 AND tenant_id = $2`,
+		id, tenantID,
+	).Scan(&t.ID, &t.TenantID, &t.Name, &t.Description, &t.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTeamNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get team: %w", err)
+	}
+
+	return t, nil
+}
+
+func (r *Repository) List(tenantID string) ([]*Team, error) {
+	rows, err := r.db.Query(
+		`SELECT id, tenant_id, name, COALESCE(description, ''), created_at
+		 FROM teams WHERE tenant_id = $1
+		 ORDER BY name`,
+		tenantID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list teams: %w", err)
+	}
+	defer rows.Close()
+
+	var teams []*Team
+	for rows.Next() {
+		t := &Team{}
+		if err := rows.Scan(&t.ID, &t.TenantID, &t.Name, &t.Description, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan team: %w", err)
+		}
+		teams = append(teams, t)
+	}
+
+	return teams, rows.Err()
+}
+
+func (r *Repository) Delete(tenantID, id string) error {
+	result, err := r.db.Exec(`DELETE FROM teams WHERE id = $1 AND tenant_id = $2`, id, tenantID)
+	if err != nil {
+		return fmt.Errorf("delete team: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrTeamNotFound
+	}
+	return nil
+}
+
+// InTenant reports whether the identity can act in the tenant: a member
+// user, or a service account bound to the tenant or to none.
+func (r *Repository) InTenant(identityType, identityID, tenantID string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM tenant_memberships WHERE user_id = $1 AND tenant_id = $2)`
+	if identityType == IdentityServiceAccount {
+		query = `SELECT EXISTS (SELECT 1 FROM service_accounts WHERE id = $1 AND (tenant_id IS NULL OR tenant_id = $2))`
+	}
+
+	var ok bool
+	if err := r.db.QueryRow(query, identityID, tenantID).Scan(&ok); err != nil {
+		return false, fmt.Errorf("check tenant identity: %w", err)
+	}
+	return ok, nil
+}
+
+func (r *Repository) AddMember(teamID, identityType, identityID string) (*Member, error) {
+	m := &Member{}
+	err := r.db.QueryRow(
+		`INSERT INTO team_members (team_id, identity_type, identity_id)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (team_id, identity_type, identity_id) DO UPDATE SET added_at = team_members.added_at
+		 RETURNING team_id, identity_type, identity_id, added_at`,
+		teamID, identityType, identityID,
+	).Scan(&m.TeamID, &m.IdentityType, &m.IdentityID, &m.AddedAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("add team member: %w", err)
+	}
+
+	return m, nil
+}
+
+func (r *Repository) RemoveMember(teamID, identityType, identityID string) error {
+	result, err := r.db.Exec(
+		`DELETE FROM team_members
+		 WHERE team_id = $1 AND identity_type = $2 AND identity_id = $3`,
+		teamID, identityType, identityID,
+	)
+	if err != nil {
+		return fmt.Errorf("remove team member: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+func (r *Repository) ListMembers(teamID string) ([]*Member, error) {
+	rows, err := r.db.Query(
+		`SELECT team_id, identity_type, identity_id, added_at
+		 FROM team_members WHERE team_id = $1
+		 ORDER BY identity_type, added_at`,
+		teamID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list team members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []*Member
+	for rows.Next() {
+		m := &Member{}
+		if err := rows.Scan(&m.TeamID, &m.IdentityType, &m.IdentityID, &m.AddedAt); err != nil {
+			return nil, fmt.Errorf("scan team member: %w", err)
+		}
+		members = append(members, m)
+	}
+
+	return members, rows.Err()
+}
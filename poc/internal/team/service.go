@@ -0,0 +1,81 @@
+package team
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Identity types of team members.
+const (
+	IdentityUser           = "user"
+	IdentityServiceAccount = "service_account"
+)
+
+var ErrInvalidTeam = errors.New("invalid team")
+
+type Service struct {
+	repo *Repository
+}
+
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo}
+}
+
+func (s *Service) Create(tenantID, name, description string) (*Team, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name required", ErrInvalidTeam)
+	}
+	return s.repo.Create(tenantID, name, description)
+}
+
+func (s *Service) Get(tenantID, id string) (*Team, error) {
+	return s.repo.Get(tenantID, id)
+}
+
+func (s *Service) List(tenantID string) ([]*Team, error) {
+	return s.repo.List(tenantID)
+}
+
+func (s *Service) Delete(tenantID, id string) error {
+	return s.repo.Delete(tenantID, id)
+}
+
+// AddMember puts a user or service account on a team. Members must be able
+// to act in the team's tenant.
+func (s *Service) AddMember(tenantID, teamID, identityType, identityID string) (*Member, error) {
+	if identityType != IdentityUser && identityType != IdentityServiceAccount {
+		return nil, fmt.Errorf("%w: identity type must be user or service_account", ErrInvalidTeam)
+	}
+	if identityID == "" {
+		return nil, fmt.Errorf("%w: identity ID required", ErrInvalidTeam)
+	}
+	if _, err := s.repo.Get(tenantID, teamID); err != nil {
+		return nil, err
+	}
+
+	ok, err := s.repo.InTenant(identityType, identityID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s %s is not in the tenant", ErrInvalidTeam, strings.ReplaceAll(identityType, "_", " "), identityID)
+	}
+
+	return s.repo.AddMember(teamID, identityType, identityID)
+}
+
+func (s *Service) RemoveMember(tenantID, teamID, identityType, identityID string) error {
+	if _, err := s.repo.Get(tenantID, teamID); err != nil {
+		return err
+	}
+	return s.repo.RemoveMember(teamID, identityType, identityID)
+}
+
+func (s *Service) ListMembers(tenantID, teamID string) ([]*Member, error) {
+	if _, err := s.repo.Get(tenantID, teamID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListMembers(teamID)
+}
@@ -2,15 +2,23 @@ package tenant
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/rbac"
 )
 
 type Handler struct {
 	service     *Service
+	authService *auth.Service
+	rbacService *rbac.Service
 	auditLogger *audit.Logger
+	authCfg     *config.AuthConfig
 }
 
 type CreateTenantRequest struct {
@@ -19,20 +27,43 @@ type CreateTenantRequest struct {
 }
 
 type TenantResponse struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	Slug      string `json:"slug"`
-	CreatedAt string `json:"created_at"`
+	ID                  string `json:"id"`
+	Name                string `json:"name"`
+	Slug                string `json:"slug"`
+	PasswordlessEnabled bool   `json:"passwordless_enabled"`
+	CreatedAt           string `json:"created_at"`
+}
+
+type UpdateLoginMethodsRequest struct {
+	PasswordlessEnabled bool `json:"passwordless_enabled"`
 }
 
 type ListTenantsResponse struct {
 	Tenants []TenantResponse `json:"tenants"`
 }
 
-func NewHandler(service *Service, auditLogger *audit.Logger) *Handler {
+type SwitchTenantRequest struct {
+	TenantID string `json:"tenant_id"`
+}
+
+type SwitchTenantResponse struct {
+	AccessToken string           `json:"access_token"`
+	ExpiresIn   int              `json:"expires_in"`
+	TenantID    string           `json:"tenant_id"`
+	Roles       []*rbac.UserRole `json:"roles"`
+}
+
+type AddMemberRequest struct {
+	UserID string `json:"user_id"`
+}
+
+func NewHandler(service *Service, authService *auth.Service, rbacService *rbac.Service, auditLogger *audit.Logger, authCfg *config.AuthConfig) *Handler {
 	return &Handler{
 		service:     service,
+		authService: authService,
+		rbacService: rbacService,
 		auditLogger: auditLogger,
+		authCfg:     authCfg,
 	}
 }
 
@@ -49,17 +80,18 @@ func (h *Handler) CreateTenant(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.auditLogger.Log("tenant.created", "", map[string]interface{}{
+	h.auditLogger.LogContext(r.Context(), "tenant.created", "", map[string]interface{}{
 		"tenant_id": tenant.ID,
 		"name":      tenant.Name,
 		"slug":      tenant.Slug,
 	}, "")
 
 	resp := TenantResponse{
-		ID:        tenant.ID,
-		Name:      tenant.Name,
-		Slug:      tenant.Slug,
-		CreatedAt: tenant.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		ID:                  tenant.ID,
+		Name:                tenant.Name,
+		Slug:                tenant.Slug,
+		PasswordlessEnabled: tenant.PasswordlessEnabled,
+		CreatedAt:           tenant.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -80,10 +112,11 @@ func (h *Handler) ListTenants(w http.ResponseWriter, r *http.Request) {
 
 	for _, t := range tenants {
 		resp.Tenants = append(resp.Tenants, TenantResponse{
-			ID:        t.ID,
-			Name:      t.Name,
-			Slug:      t.Slug,
-			CreatedAt: t.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+			ID:                  t.ID,
+			Name:                t.Name,
+			Slug:                t.Slug,
+			PasswordlessEnabled: t.PasswordlessEnabled,
+			CreatedAt:           t.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
 		})
 	}
 
@@ -106,10 +139,11 @@ func (h *Handler) GetTenant(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := TenantResponse{
-		ID:        tenant.ID,
-		Name:      tenant.Name,
-		Slug:      tenant.Slug,
-		CreatedAt: tenant.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		ID:                  tenant.ID,
+		Name:                tenant.Name,
+		Slug:                tenant.Slug,
+		PasswordlessEnabled: tenant.PasswordlessEnabled,
+		CreatedAt:           tenant.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -117,6 +151,150 @@ func (h *Handler) GetTenant(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+func (h *Handler) UpdateLoginMethods(w http.ResponseWriter, r *http.Request) {
+	tenantID := chi.URLParam(r, "id")
+	if tenantID == "" {
+		writeError(w, "tenant ID required", http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateLoginMethodsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.SetPasswordlessEnabled(tenantID, req.PasswordlessEnabled); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "tenant.login_methods_updated", caller.UserID(), map[string]interface{}{
+		"tenant_id":            tenantID,
+		"passwordless_enabled": req.PasswordlessEnabled,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SwitchTenant reissues the caller's access token for another tenant they
+// are a member of. The response lists the roles the user holds there.
+func (h *Handler) SwitchTenant(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+
+	var req SwitchTenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TenantID == "" {
+		writeError(w, "tenant_id required", http.StatusBadRequest)
+		return
+	}
+
+	accessToken, err := h.authService.SwitchTenant(claims, req.TenantID)
+	if errors.Is(err, auth.ErrNotMember) {
+		writeError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	roles, err := h.rbacService.GetUserRoles(claims.UserID, &req.TenantID)
+	if err != nil {
+		writeError(w, "failed to resolve roles", http.StatusInternalServerError)
+		return
+	}
+	if roles == nil {
+		roles = []*rbac.UserRole{}
+	}
+
+	details := map[string]interface{}{"tenant_id": req.TenantID}
+	if claims.TenantID != nil {
+		details["previous_tenant_id"] = *claims.TenantID
+	}
+	h.auditLogger.LogContext(r.Context(), "tenant.switched", claims.UserID, details, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(SwitchTenantResponse{
+		AccessToken: accessToken,
+		ExpiresIn:   int(h.authCfg.AccessTokenTTL.Seconds()),
+		TenantID:    req.TenantID,
+		Roles:       roles,
+	})
+}
+
+func (h *Handler) ListMyTenants(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+
+	memberships, err := h.service.ListMemberships(claims.UserID)
+	if err != nil {
+		writeError(w, "failed to list tenants", http.StatusInternalServerError)
+		return
+	}
+	if memberships == nil {
+		memberships = []*Membership{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"memberships": memberships})
+}
+
+func (h *Handler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := h.service.ListMembers(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "failed to list members", http.StatusInternalServerError)
+		return
+	}
+	if members == nil {
+		members = []*Membership{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"members": members})
+}
+
+func (h *Handler) AddMember(w http.ResponseWriter, r *http.Request) {
+	tenantID := chi.URLParam(r, "id")
+
+	var req AddMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.AddMember(tenantID, req.UserID); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "tenant.member_added", caller.UserID(), map[string]interface{}{
+		"tenant_id":      tenantID,
+		"member_user_id": req.UserID,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	tenantID := chi.URLParam(r, "id")
+	userID := chi.URLParam(r, "userId")
+
+	if err := h.service.RemoveMember(tenantID, userID); err != nil {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "tenant.member_removed", caller.UserID(), map[string]interface{}{
+		"tenant_id":      tenantID,
+		"member_user_id": userID,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func writeError(w http.ResponseWriter, message string, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -7,12 +7,13 @@ import (
 )
 
 type Tenant struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Slug      string    `json:"slug"`
-	Settings  string    `json:"settings,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID                  string    `json:"id"`
+	Name                string    `json:"name"`
+	Slug                string    `json:"slug"`
+	Settings            string    `json:"settings,omitempty"`
+	PasswordlessEnabled bool      `json:"passwordless_enabled"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
 }
 
 type Repository struct {
@@ -28,9 +29,9 @@ func (r *Repository) Create(name, slug string) (*Tenant, error) {
 	err := r.db.QueryRow(
 		`INSERT INTO tenants (name, slug)
 		 VALUES ($1, $2)
-		 RETURNING id, name, slug, created_at, updated_at`,
+		 RETURNING id, name, slug, passwordless_enabled, created_at, updated_at`,
 		name, slug,
-	).Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.CreatedAt, &tenant.UpdatedAt)
+	).Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.PasswordlessEnabled, &tenant.CreatedAt, &tenant.UpdatedAt)
 
 	if err != nil {
 		return nil, fmt.Errorf("insert tenant: %w", err)
@@ -42,10 +43,10 @@ func (r *Repository) Create(name, slug string) (*Tenant, error) {
 func (r *Repository) GetByID(id string) (*Tenant, error) {
 	var tenant Tenant
 	err := r.db.QueryRow(
-		`SELECT id, name, slug, created_at, updated_at
+		`SELECT id, name, slug, passwordless_enabled, created_at, updated_at
 		 FROM tenants WHERE id = $1`,
 		id,
-	).Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.CreatedAt, &tenant.UpdatedAt)
+	).Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.PasswordlessEnabled, &tenant.CreatedAt, &tenant.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("tenant not found")
@@ -60,10 +61,10 @@ func (r *Repository) GetByID(id string) (*Tenant, error) {
 func (r *Repository) GetBySlug(slug string) (*Tenant, error) {
 	var tenant Tenant
 	err := r.db.QueryRow(
-		`SELECT id, name, slug, created_at, updated_at
+		`SELECT id, name, slug, passwordless_enabled, created_at, updated_at
 		 FROM tenants WHERE slug = $1`,
 		slug,
-	).Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.CreatedAt, &tenant.UpdatedAt)
+	).Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.PasswordlessEnabled, &tenant.CreatedAt, &tenant.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("tenant not found")
@@ -77,7 +78,7 @@ func (r *Repository) GetBySlug(slug string) (*Tenant, error) {
 
 func (r *Repository) List() ([]*Tenant, error) {
 	rows, err := r.db.Query(
-		`SELECT id, name, slug, created_at, updated_at
+		`SELECT id, name, slug, passwordless_enabled, created_at, updated_at
 		 FROM tenants ORDER BY created_at DESC`,
 	)
 	if err != nil {
@@ -88,7 +89,7 @@ func (r *Repository) List() ([]*Tenant, error) {
 	var tenants []*Tenant
 	for rows.Next() {
 		var t Tenant
-		if err := rows.Scan(&t.ID, &t.Name, &t.Slug, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		if err := rows.Scan(&t.ID, &t.Name, &t.Slug, &t.PasswordlessEnabled, &t.CreatedAt, &t.UpdatedAt); err != nil {
 			return nil, fmt.Errorf("scan tenant: %w", err)
 		}
 		tenants = append(tenants, &t)
@@ -100,3 +101,129 @@ func (r *Repository) List() ([]*Tenant, error) {
 
 	return tenants, nil
 }
+
+func (r *Repository) SetPasswordlessEnabled(id string, enabled bool) error {
+	result, err := r.db.Exec(
+		`UPDATE tenants SET passwordless_enabled = $1, updated_at = NOW() WHERE id = $2`,
+		enabled, id,
+	)
+	if err != nil {
+		return fmt.Errorf("update tenant login methods: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("update tenant login methods: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("tenant not found")
+	}
+
+	return nil
+}
+
+type Membership struct {
+	UserID     string     `json:"user_id"`
+	Email      string     `json:"email"`
+	TenantID   string     `json:"tenant_id"`
+	TenantName string     `json:"tenant_name"`
+	TenantSlug string     `json:"tenant_slug"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+const membershipColumns = `m.user_id, u.email, m.tenant_id, t.name, t.slug, m.last_used_at, m.created_at
+		 FROM tenant_memberships m
+		 JOIN users u ON u.id = m.user_id
+		 JOIN tenants t ON t.id = m.tenant_id`
+
+func (r *Repository) AddMember(tenantID, userID string) error {
+	_, err := r.db.Exec(
+		`INSERT INTO tenant_memberships (user_id, tenant_id)
+		 VALUES ($1, $2)
+		 ON CONFLICT DO NOTHING`,
+		userID, tenantID,
+	)
+	if err != nil {
+		return fmt.Errorf("insert tenant membership: %w", err)
+	}
+	return nil
+}
+
+// RemoveMember deletes the membership together with the user's role
+// assignments in the tenant and revokes any session acting in it.
+func (r *Repository) RemoveMember(tenantID, userID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`DELETE FROM tenant_memberships WHERE user_id = $1 AND tenant_id = $2`,
+		userID, tenantID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete tenant membership: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("membership not found")
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM user_roles WHERE user_id = $1 AND tenant_id = $2`,
+		userID, tenantID,
+	); err != nil {
+		return fmt.Errorf("delete tenant roles: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE sessions SET revoked = TRUE WHERE user_id = $1 AND tenant_id = $2`,
+		userID, tenantID,
+	); err != nil {
+		return fmt.Errorf("revoke tenant sessions: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) ListMembers(tenantID string) ([]*Membership, error) {
+	return r.queryMemberships(
+		`SELECT `+membershipColumns+`
+		 WHERE m.tenant_id = $1
+		 ORDER BY u.email`,
+		tenantID,
+	)
+}
+
+func (r *Repository) ListMemberships(userID string) ([]*Membership, error) {
+	return r.queryMemberships(
+		`SELECT `+membershipColumns+`
+		 WHERE m.user_id = $1
+		 ORDER BY m.last_used_at DESC NULLS LAST, t.name`,
+		userID,
+	)
+}
+
+func (r *Repository) queryMemberships(query string, args ...interface{}) ([]*Membership, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query tenant memberships: %w", err)
+	}
+	defer rows.Close()
+
+	var memberships []*Membership
+	for rows.Next() {
+		var m Membership
+		if err := rows.Scan(&m.UserID, &m.Email, &m.TenantID, &m.TenantName, &m.TenantSlug, &m.LastUsedAt, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan tenant membership: %w", err)
+		}
+		memberships = append(memberships, &m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate tenant memberships: %w", err)
+	}
+
+	return memberships, nil
+}
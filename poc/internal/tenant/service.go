@@ -47,6 +47,38 @@ func (s *Service) ListTenants() ([]*Tenant, error) {
 	return s.repo.List()
 }
 
+func (s *Service) SetPasswordlessEnabled(id string, enabled bool) error {
+	if id == "" {
+		return fmt.Errorf("tenant ID required")
+	}
+	return s.repo.SetPasswordlessEnabled(id, enabled)
+}
+
+func (s *Service) AddMember(tenantID, userID string) error {
+	if tenantID == "" || userID == "" {
+		return fmt.Errorf("tenant ID and user ID required")
+	}
+	if _, err := s.repo.GetByID(tenantID); err != nil {
+		return err
+	}
+	return s.repo.AddMember(tenantID, userID)
+}
+
+func (s *Service) RemoveMember(tenantID, userID string) error {
+	if tenantID == "" || userID == "" {
+		return fmt.Errorf("tenant ID and user ID required")
+	}
+	return s.repo.RemoveMember(tenantID, userID)
+}
+
+func (s *Service) ListMembers(tenantID string) ([]*Membership, error) {
+	return s.repo.ListMembers(tenantID)
+}
+
+func (s *Service) ListMemberships(userID string) ([]*Membership, error) {
+	return s.repo.ListMemberships(userID)
+}
+
 func isValidSlug(slug string) bool {
 	slug = strings.TrimSpace(slug)
 	if len(slug) < 2 || len(slug) > 50 {
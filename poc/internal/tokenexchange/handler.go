@@ -0,0 +1,152 @@
+package tokenexchange
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/audit"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/oauth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/principal"
+)
+
+type Handler struct {
+	service     *Service
+	auditLogger *audit.Logger
+}
+
+func NewHandler(service *Service, auditLogger *audit.Logger) *Handler {
+	return &Handler{service: service, auditLogger: auditLogger}
+}
+
+// TokenExchange handles grant_type=urn:ietf:params:oauth:grant-type:token-exchange.
+// The calling service account authenticates as the client.
+func (h *Handler) TokenExchange(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.FormValue("client_id")
+		clientSecret = r.FormValue("client_secret")
+	}
+
+	resp, subject, err := h.service.Exchange(
+		clientID, clientSecret,
+		r.FormValue("subject_token"), r.FormValue("subject_token_type"),
+		r.FormValue("audience"), r.FormValue("scope"),
+	)
+
+	var userID string
+	if subject != nil {
+		userID = subject.UserID
+	}
+
+	if err != nil {
+		h.auditLogger.LogContext(r.Context(), "token_exchange.rejected", userID, map[string]interface{}{
+			"client_id": clientID,
+			"audience":  r.FormValue("audience"),
+			"error":     err.Error(),
+		}, r.RemoteAddr)
+
+		var oe *oauth.Error
+		if !errors.As(err, &oe) {
+			oe = &oauth.Error{Code: "server_error", Description: "failed to exchange token"}
+		}
+		writeOAuthError(w, oe)
+		return
+	}
+
+	h.auditLogger.LogContext(r.Context(), "token_exchange.issued", userID, map[string]interface{}{
+		"client_id": clientID,
+		"audience":  r.FormValue("audience"),
+		"scope":     resp.Scope,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) CreatePolicy(w http.ResponseWriter, r *http.Request) {
+	serviceAccountID := chi.URLParam(r, "id")
+
+	var req struct {
+		Audience      string   `json:"audience"`
+		AllowedScopes []string `json:"allowed_scopes"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	policy, err := h.service.CreatePolicy(serviceAccountID, req.Audience, req.AllowedScopes)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "token_exchange_policy.created", caller.UserID(), map[string]interface{}{
+		"service_account_id": serviceAccountID,
+		"audience":           policy.Audience,
+		"allowed_scopes":     policy.AllowedScopes,
+	}, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(policy)
+}
+
+func (h *Handler) ListPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := h.service.ListPolicies(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "failed to list token exchange policies", http.StatusInternalServerError)
+		return
+	}
+
+	if policies == nil {
+		policies = []*Policy{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"policies": policies})
+}
+
+func (h *Handler) DeletePolicy(w http.ResponseWriter, r *http.Request) {
+	serviceAccountID := chi.URLParam(r, "id")
+	policyID := chi.URLParam(r, "policyId")
+
+	if err := h.service.DeletePolicy(serviceAccountID, policyID); err != nil {
+		writeError(w, "failed to delete token exchange policy", http.StatusInternalServerError)
+		return
+	}
+
+	caller, _ := principal.FromContext(r.Context())
+	h.auditLogger.LogContext(r.Context(), "token_exchange_policy.deleted", caller.UserID(), map[string]interface{}{
+		"service_account_id": serviceAccountID,
+		"policy_id":          policyID,
+	}, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func writeOAuthError(w http.ResponseWriter, oe *oauth.Error) {
+	status := http.StatusBadRequest
+	if oe.Code == "invalid_client" {
+		status = http.StatusUnauthorized
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             oe.Code,
+		"error_description": oe.Description,
+	})
+}
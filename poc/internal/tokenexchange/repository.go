@@ -0,0 +1,93 @@
+package tokenexchange
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Policy struct {
+	ID               string    `json:"id"`
+	ServiceAccountID string    `json:"service_account_id"`
+	Audience         string    `json:"audience"`
+	AllowedScopes    []string  `json:"allowed_scopes"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) Create(serviceAccountID, audience string, allowedScopes []string) (*Policy, error) {
+	p := &Policy{}
+	err := r.db.QueryRow(
+		`INSERT INTO token_exchange_policies (service_account_id, audience, allowed_scopes)
+		 VALUES ($1, $2, $3)
+		 RETURNING id, service_account_id, audience, allowed_scopes, created_at`,
+		serviceAccountID, audience, pq.Array(allowedScopes),
+	).Scan(&p.ID, &p.ServiceAccountID, &p.Audience, pq.Array(&p.AllowedScopes), &p.CreatedAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("create token exchange policy: %w", err)
+	}
+
+	return p, nil
+}
+
+func (r *Repository) Get(serviceAccountID, audience string) (*Policy, error) {
+	p := &Policy{}
+	err := r.db.QueryRow(
+		`SELECT id, service_account_id, audience, allowed_scopes, created_at
+		 FROM token_exchange_policies
+		 WHERE service_account_id = $1 AND audience = $2`,
+		serviceAccountID, audience,
+	).Scan(&p.ID, &p.ServiceAccountID, &p.Audience, pq.Array(&p.AllowedScopes), &p.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("token exchange policy not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get token exchange policy: %w", err)
+	}
+
+	return p, nil
+}
+
+func (r *Repository) List(serviceAccountID string) ([]*Policy, error) {
+	rows, err := r.db.Query(
+		`SELECT id, service_account_id, audience, allowed_scopes, created_at
+		 FROM token_exchange_policies
+		 WHERE service_account_id = $1
+		 ORDER BY audience`,
+		serviceAccountID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list token exchange policies: %w", err)
+	}
+	defer rows.Close()
+
+	var policies []*Policy
+	for rows.Next() {
+		p := &Policy{}
+		if err := rows.Scan(&p.ID, &p.ServiceAccountID, &p.Audience, pq.Array(&p.AllowedScopes), &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan token exchange policy: %w", err)
+		}
+		policies = append(policies, p)
+	}
+
+	return policies, rows.Err()
+}
+
+func (r *Repository) Delete(serviceAccountID, id string) error {
+	_, err := r.db.Exec(
+		`DELETE FROM token_exchange_policies WHERE id = $1 AND service_account_id = $2`,
+		id, serviceAccountID,
+	)
+	return err
+}
@@ -0,0 +1,205 @@
+package tokenexchange
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rustybrownlee-llm/bastion/poc/internal/auth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/config"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/oauth"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/rbac"
+	"github.com/rustybrownlee-llm/bastion/poc/internal/serviceaccount"
+)
+
+const (
+	GrantTokenExchange   = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// Response is the token exchange response of RFC 8693 §2.2.1.
+type Response struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int    `json:"expires_in"`
+	Scope           string `json:"scope"`
+}
+
+type Service struct {
+	repo            *Repository
+	serviceAccounts *serviceaccount.Service
+	rbac            *rbac.Service
+	cfg             *config.AuthConfig
+}
+
+func NewService(repo *Repository, serviceAccounts *serviceaccount.Service, rbacService *rbac.Service, cfg *config.AuthConfig) *Service {
+	return &Service{repo: repo, serviceAccounts: serviceAccounts, rbac: rbacService, cfg: cfg}
+}
+
+func (s *Service) CreatePolicy(serviceAccountID, audience string, allowedScopes []string) (*Policy, error) {
+	if audience == "" {
+		return nil, fmt.Errorf("audience required")
+	}
+	if len(allowedScopes) == 0 {
+		return nil, fmt.Errorf("at least one allowed scope required")
+	}
+	for _, scope := range allowedScopes {
+		if _, _, ok := splitScope(scope); !ok {
+			return nil, fmt.Errorf("invalid scope %q: expected resource_type:action", scope)
+		}
+	}
+
+	if _, err := s.serviceAccounts.GetByID(serviceAccountID); err != nil {
+		return nil, fmt.Errorf("service account not found")
+	}
+
+	return s.repo.Create(serviceAccountID, audience, allowedScopes)
+}
+
+func (s *Service) ListPolicies(serviceAccountID string) ([]*Policy, error) {
+	return s.repo.List(serviceAccountID)
+}
+
+func (s *Service) DeletePolicy(serviceAccountID, id string) error {
+	return s.repo.Delete(serviceAccountID, id)
+}
+
+// Exchange swaps a user access token presented by a service account for a
+// token scoped to audience. The user token must have been issued to the
+// service account. The issued scope is the intersection of what was
+// requested, what the service account's policy allows for that audience,
+// what the subject token already carried and what the user currently holds.
+func (s *Service) Exchange(clientID, clientSecret, subjectToken, subjectTokenType, audience, scope string) (*Response, *auth.Claims, error) {
+	sa, err := s.serviceAccounts.Verify(clientID, clientSecret)
+	if err != nil {
+		return nil, nil, &oauth.Error{Code: "invalid_client", Description: "invalid client credentials"}
+	}
+
+	if subjectToken == "" || audience == "" {
+		return nil, nil, &oauth.Error{Code: "invalid_request", Description: "subject_token and audience required"}
+	}
+	if subjectTokenType != TokenTypeAccessToken {
+		return nil, nil, &oauth.Error{Code: "invalid_request", Description: "subject_token_type must be " + TokenTypeAccessToken}
+	}
+
+	subject, err := auth.ValidateAccessToken(s.cfg, subjectToken)
+	if err != nil || subject.IdentityType != "user" {
+		return nil, nil, &oauth.Error{Code: "invalid_grant", Description: "invalid subject_token"}
+	}
+	if sa.TenantID != nil && (subject.TenantID == nil || *subject.TenantID != *sa.TenantID) {
+		return nil, subject, &oauth.Error{Code: "invalid_grant", Description: "subject belongs to another tenant"}
+	}
+
+	// The subject token must have been issued to this service account, not
+	// merely to some audience it can reach, so that a token sent to one
+	// service cannot be exchanged by another.
+	addressed, err := s.addressedTo(sa, subject)
+	if err != nil {
+		return nil, subject, err
+	}
+	if !addressed {
+		return nil, subject, &oauth.Error{Code: "invalid_grant", Description: "subject_token was not issued to this service account"}
+	}
+
+	policy, err := s.repo.Get(sa.ID, audience)
+	if err != nil {
+		return nil, subject, &oauth.Error{Code: "invalid_target", Description: "service account may not exchange tokens for this audience"}
+	}
+
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		requested = policy.AllowedScopes
+	} else {
+		for _, r := range requested {
+			if !contains(policy.AllowedScopes, r) {
+				return nil, subject, &oauth.Error{Code: "invalid_scope", Description: fmt.Sprintf("scope %s is not allowed for this audience", r)}
+			}
+		}
+	}
+
+	perms, err := s.rbac.GetUserPermissions(subject.UserID, subject.TenantID)
+	if err != nil {
+		return nil, subject, fmt.Errorf("get user permissions: %w", err)
+	}
+	held := make(map[string]bool, len(perms))
+	for _, p := range perms {
+		held[p.ResourceType+":"+p.Action] = true
+	}
+
+	var granted []string
+	for _, r := range requested {
+		resourceType, action, ok := splitScope(r)
+		if ok && held[r] && subject.Principal().HasScope(resourceType, action) {
+			granted = append(granted, r)
+		}
+	}
+	if len(granted) == 0 {
+		return nil, subject, &oauth.Error{Code: "invalid_scope", Description: "user holds none of the requested permissions"}
+	}
+
+	expiresAt := time.Now().Add(s.cfg.AccessTokenTTL)
+	if subject.ExpiresAt != nil && subject.ExpiresAt.Time.Before(expiresAt) {
+		expiresAt = subject.ExpiresAt.Time
+	}
+
+	actor := &auth.Actor{
+		Subject:      sa.ID,
+		IdentityType: "service_account",
+		Name:         sa.Name,
+		Act:          subject.Act,
+	}
+
+	grantedScope := strings.Join(granted, " ")
+	token, err := auth.GenerateDelegatedToken(s.cfg, subject, audience, grantedScope, actor, expiresAt)
+	if err != nil {
+		return nil, subject, err
+	}
+
+	return &Response{
+		AccessToken:     token,
+		IssuedTokenType: TokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int(time.Until(expiresAt).Seconds()),
+		Scope:           grantedScope,
+	}, subject, nil
+}
+
+// addressedTo reports whether the subject token's aud names the service
+// account, by ID or client ID, or one of the audiences on its allowlist.
+// The Bastion API audience, which every service account may use, does not
+// count.
+func (s *Service) addressedTo(sa *serviceaccount.ServiceAccount, subject *auth.Claims) (bool, error) {
+	if subject.HasAudience(sa.ID) || subject.HasAudience(sa.ClientID) {
+		return true, nil
+	}
+	audiences, err := s.serviceAccounts.ListAudiences(sa.ID)
+	if err != nil {
+		return false, fmt.Errorf("list audiences: %w", err)
+	}
+	for _, aud := range audiences {
+		if subject.HasAudience(aud) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// splitScope splits a permission scope at its last colon, since resource
+// types are themselves namespaced ("bastion:user:read").
+func splitScope(scope string) (string, string, bool) {
+	i := strings.LastIndex(scope, ":")
+	if i <= 0 || i == len(scope)-1 {
+		return "", "", false
+	}
+	return scope[:i], scope[i+1:], true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
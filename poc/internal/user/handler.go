@@ -39,7 +39,7 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.service.CreateUser(req.Email, req.Password, req.TenantID)
 	if err != nil {
-		h.audit.Log("user_creation_failure", "", map[string]interface{}{
+		h.audit.LogContext(r.Context(), "user_creation_failure", "", map[string]interface{}{
 			"email": req.Email,
 			"error": err.Error(),
 		}, getIP(r))
@@ -47,7 +47,7 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.audit.Log("user_created", user.ID, map[string]interface{}{
+	h.audit.LogContext(r.Context(), "user_created", user.ID, map[string]interface{}{
 		"email": user.Email,
 	}, getIP(r))
 
@@ -57,7 +57,7 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetMe(w http.ResponseWriter, r *http.Request) {
-	claims := r.Context().Value("claims").(*auth.Claims)
+	claims, _ := auth.ClaimsFromContext(r.Context())
 
 	user, err := h.service.GetByID(claims.UserID)
 	if err != nil {
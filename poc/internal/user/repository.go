@@ -3,16 +3,16 @@ package user
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 )
 
 type User struct {
-	ID           string    `json:"id"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	TenantID     *string   `json:"tenant_id,omitempty"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	TenantID  *string   `json:"tenant_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type Repository struct {
@@ -23,13 +23,34 @@ func NewRepository(db *sql.DB) *Repository {
 	return &Repository{db: db}
 }
 
+// Create inserts a user together with its local login identity, which holds
+// the password hash.
 func (r *Repository) Create(email, passwordHash string, tenantID *string) (*User, error) {
+	return r.createWithIdentity(email, tenantID, "local", strings.ToLower(email), &passwordHash)
+}
+
+// CreateFederated inserts a user whose only login identity is the external
+// IdP subject that provisioned it.
+func (r *Repository) CreateFederated(email string, tenantID *string, provider, subject string) (*User, error) {
+	return r.createWithIdentity(email, tenantID, provider, subject, nil)
+}
+
+func (r *Repository) createWithIdentity(email string, tenantID *string, provider, subject string, credentialHash *string) (*User, error) {
 	var user User
 	err := r.db.QueryRow(
-		`INSERT INTO users (email, password_hash, tenant_id)
-		 VALUES ($1, $2, $3)
-		 RETURNING id, email, tenant_id, created_at, updated_at`,
-		email, passwordHash, tenantID,
+		`WITH u AS (
+			INSERT INTO users (email, tenant_id)
+			VALUES ($1, $2)
+			RETURNING id, email, tenant_id, created_at, updated_at
+		 ), i AS (
+			INSERT INTO user_identities (user_id, provider, subject, email, credential_hash)
+			SELECT id, $3, $4, email, $5 FROM u
+		 ), m AS (
+			INSERT INTO tenant_memberships (user_id, tenant_id)
+			SELECT id, tenant_id FROM u WHERE tenant_id IS NOT NULL
+		 )
+		 SELECT id, email, tenant_id, created_at, updated_at FROM u`,
+		email, tenantID, provider, subject, credentialHash,
 	).Scan(&user.ID, &user.Email, &user.TenantID, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
@@ -42,10 +63,10 @@ func (r *Repository) Create(email, passwordHash string, tenantID *string) (*User
 func (r *Repository) GetByEmail(email string) (*User, error) {
 	var user User
 	err := r.db.QueryRow(
-		`SELECT id, email, password_hash, tenant_id, created_at, updated_at
-		 FROM users WHERE email = $1`,
+		`SELECT id, email, tenant_id, created_at, updated_at
+		 FROM users WHERE email = $1 AND merged_into IS NULL`,
 		email,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.TenantID, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Email, &user.TenantID, &user.CreatedAt, &user.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("user not found")
@@ -60,10 +81,10 @@ func (r *Repository) GetByEmail(email string) (*User, error) {
 func (r *Repository) GetByID(id string) (*User, error) {
 	var user User
 	err := r.db.QueryRow(
-		`SELECT id, email, password_hash, tenant_id, created_at, updated_at
+		`SELECT id, email, tenant_id, created_at, updated_at
 		 FROM users WHERE id = $1`,
 		id,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.TenantID, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Email, &user.TenantID, &user.CreatedAt, &user.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("user not found")
@@ -28,6 +28,22 @@ func (s *Service) CreateUser(email, password string, tenantID *string) (*User, e
 	return user, nil
 }
 
+// CreateFederatedUser creates a user provisioned from an external IdP. The
+// account has no local password; it signs in only through the linked IdP
+// identity.
+func (s *Service) CreateFederatedUser(email string, tenantID *string, provider, subject string) (*User, error) {
+	user, err := s.repo.CreateFederated(email, tenantID, provider, subject)
+	if err != nil {
+		return nil, fmt.Errorf("create user: %w", err)
+	}
+
+	return user, nil
+}
+
 func (s *Service) GetByID(id string) (*User, error) {
 	return s.repo.GetByID(id)
 }
+
+func (s *Service) GetByEmail(email string) (*User, error) {
+	return s.repo.GetByEmail(email)
+}
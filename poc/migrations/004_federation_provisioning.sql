@@ -0,0 +1,48 @@
+-- Migration 004: Federated identity provider connections
+-- Just-in-time provisioning and claim-to-role mapping rules
+
+-- Identity provider connections (one per external IdP per tenant)
+CREATE TABLE idp_connections (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) NOT NULL,
+    issuer VARCHAR(500) NOT NULL,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    jit_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    allowed_domains TEXT[] NOT NULL DEFAULT '{}',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE(tenant_id, issuer)
+);
+
+CREATE INDEX idx_idp_connections_tenant_id ON idp_connections(tenant_id);
+
+-- Claim-to-role mapping rules, re-evaluated at every federated login
+-- Example: claim 'groups', operator 'contains', value 'secops' -> acme:soc-analyst
+CREATE TABLE idp_role_mappings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    connection_id UUID NOT NULL REFERENCES idp_connections(id) ON DELETE CASCADE,
+    claim VARCHAR(255) NOT NULL,
+    operator VARCHAR(20) NOT NULL CHECK (operator IN ('equals', 'contains', 'exists')),
+    value VARCHAR(500) NOT NULL DEFAULT '',
+    role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_idp_role_mappings_connection_id ON idp_role_mappings(connection_id);
+
+-- New permissions for identity provider connections
+INSERT INTO permissions (resource_type, action, description) VALUES
+('bastion:idp-connection', 'create', 'Create identity provider connections'),
+('bastion:idp-connection', 'read', 'View identity provider connections'),
+('bastion:idp-connection', 'update', 'Modify connections and role mappings'),
+('bastion:idp-connection', 'delete', 'Delete identity provider connections')
+ON CONFLICT (resource_type, action) DO NOTHING;
+
+-- Grant identity provider permissions to platform:superadmin
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'platform:superadmin'
+  AND p.resource_type = 'bastion:idp-connection'
+ON CONFLICT DO NOTHING;
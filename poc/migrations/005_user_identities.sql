@@ -0,0 +1,55 @@
+-- Migration 005: Linked login identities
+-- A user can sign in through several identities (local password, external IdPs)
+
+-- Login identities attached to a user
+-- provider is 'local' for email/password, or 'idp:{connection_id}' for federated logins
+CREATE TABLE user_identities (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(100) NOT NULL,
+    subject VARCHAR(500) NOT NULL,
+    email VARCHAR(255),
+    credential_hash TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP,
+    UNIQUE(provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+
+-- Backfill a local identity for every existing password account
+INSERT INTO user_identities (user_id, provider, subject, email, credential_hash)
+SELECT id, 'local', LOWER(email), email, password_hash
+FROM users
+WHERE password_hash IS NOT NULL AND password_hash <> ''
+ON CONFLICT (provider, subject) DO NOTHING;
+
+-- Credentials now live on identities
+ALTER TABLE users ALTER COLUMN password_hash DROP NOT NULL;
+
+-- Merged accounts are kept so audit history keeps its references
+ALTER TABLE users ADD COLUMN IF NOT EXISTS merged_into UUID REFERENCES users(id) ON DELETE SET NULL;
+
+-- Pending links issued when a federated login matches an existing account
+CREATE TABLE identity_link_tickets (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    provider VARCHAR(100) NOT NULL,
+    subject VARCHAR(500) NOT NULL,
+    email VARCHAR(255),
+    expires_at TIMESTAMP NOT NULL,
+    consumed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Permission for admin account merge tooling
+INSERT INTO permissions (resource_type, action, description) VALUES
+('bastion:user', 'merge', 'Merge duplicate user accounts')
+ON CONFLICT (resource_type, action) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name IN ('platform:superadmin', 'platform:admin')
+  AND p.resource_type = 'bastion:user' AND p.action = 'merge'
+ON CONFLICT DO NOTHING;
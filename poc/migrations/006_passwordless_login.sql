@@ -0,0 +1,21 @@
+-- Migration 006: Passwordless login
+-- Email magic links and one-time codes for low-privilege tenant users
+
+-- Per-tenant switch for passwordless login
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS passwordless_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Issued login challenges; secrets are stored as SHA-256/HMAC hashes only
+CREATE TABLE login_challenges (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    method VARCHAR(10) NOT NULL CHECK (method IN ('link', 'code')),
+    secret_hash VARCHAR(64) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    ip_address VARCHAR(45),
+    expires_at TIMESTAMP NOT NULL,
+    consumed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_login_challenges_link_hash ON login_challenges(secret_hash) WHERE method = 'link';
+CREATE INDEX idx_login_challenges_user_created ON login_challenges(user_id, created_at);
@@ -0,0 +1,60 @@
+-- Migration 007: OAuth 2.0 authorization code flow
+-- Registered client applications, single-use authorization codes and
+-- browser SSO sessions shared across applications
+
+-- Browser SSO: the session cookie carries an opaque token stored here as a
+-- SHA-256 hash; auth_time records when the user last authenticated
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS sso_token_hash VARCHAR(64);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS auth_time TIMESTAMP;
+UPDATE sessions SET auth_time = created_at WHERE auth_time IS NULL;
+ALTER TABLE sessions ALTER COLUMN auth_time SET NOT NULL;
+ALTER TABLE sessions ALTER COLUMN auth_time SET DEFAULT NOW();
+
+CREATE UNIQUE INDEX idx_sessions_sso_token_hash ON sessions(sso_token_hash) WHERE sso_token_hash IS NOT NULL;
+
+-- Registered OAuth client applications. Public clients (SPAs, native apps)
+-- have no secret and rely on PKCE alone.
+CREATE TABLE oauth_clients (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id VARCHAR(100) UNIQUE NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    secret_hash TEXT,
+    redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Authorization codes, stored as SHA-256 hashes and consumed exactly once
+CREATE TABLE oauth_authorization_codes (
+    code_hash VARCHAR(64) PRIMARY KEY,
+    client_id UUID NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    code_challenge VARCHAR(128) NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    nonce TEXT,
+    expires_at TIMESTAMP NOT NULL,
+    consumed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_oauth_codes_expires ON oauth_authorization_codes(expires_at);
+
+-- New permissions for OAuth client applications
+INSERT INTO permissions (resource_type, action, description) VALUES
+('bastion:oauth-client', 'create', 'Register OAuth client applications'),
+('bastion:oauth-client', 'read', 'View OAuth client applications'),
+('bastion:oauth-client', 'update', 'Modify OAuth client applications'),
+('bastion:oauth-client', 'delete', 'Remove OAuth client applications')
+ON CONFLICT (resource_type, action) DO NOTHING;
+
+-- Grant OAuth client permissions to platform:superadmin
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'platform:superadmin'
+  AND p.resource_type = 'bastion:oauth-client'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,27 @@
+-- Migration 008: OAuth 2.0 Device Authorization Grant (RFC 8628)
+-- Lets CLIs and headless tools sign users in through a browser on another
+-- device
+
+-- Grant types each client may use; device-only clients need no redirect URI
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS grant_types TEXT[] NOT NULL DEFAULT '{authorization_code}';
+
+-- Pending and completed device authorizations. Device codes are stored as
+-- SHA-256 hashes; user codes are short, human-typable and stored normalised
+-- (upper case, no separator).
+CREATE TABLE oauth_device_authorizations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    device_code_hash VARCHAR(64) UNIQUE NOT NULL,
+    user_code VARCHAR(16) UNIQUE NOT NULL,
+    client_id UUID NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    scope TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'denied', 'consumed')),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    auth_time TIMESTAMP,
+    interval_seconds INTEGER NOT NULL,
+    last_polled_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_oauth_device_expires ON oauth_device_authorizations(expires_at);
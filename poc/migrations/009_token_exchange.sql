@@ -0,0 +1,16 @@
+-- Migration 009: Token exchange (RFC 8693)
+-- Allowlist of the audiences each service account may obtain delegated
+-- user tokens for
+
+CREATE TABLE token_exchange_policies (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    service_account_id UUID NOT NULL REFERENCES service_accounts(id) ON DELETE CASCADE,
+    audience VARCHAR(255) NOT NULL,
+    -- Permissions ("resource_type:action") the exchanged token may carry;
+    -- the token never exceeds what the user holds
+    allowed_scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE(service_account_id, audience)
+);
+
+CREATE INDEX idx_token_exchange_policies_sa ON token_exchange_policies(service_account_id);
@@ -0,0 +1,36 @@
+-- Migration 010: Admin impersonation
+-- Support staff can act as a user; every session is recorded and visible to
+-- the impersonated user, and audit events carry the real actor
+
+-- The identity acting on behalf of user_id, when different (impersonating
+-- admin or delegated service account)
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS actor_id UUID;
+
+CREATE INDEX idx_audit_log_actor_id ON audit_log(actor_id) WHERE actor_id IS NOT NULL;
+
+CREATE TABLE impersonation_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    admin_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    target_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    ip_address VARCHAR(45),
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_impersonation_sessions_target ON impersonation_sessions(target_user_id, started_at);
+CREATE INDEX idx_impersonation_sessions_admin ON impersonation_sessions(admin_id, started_at);
+
+-- Permission to impersonate users; tenant admins may impersonate users of
+-- the tenants they administer
+INSERT INTO permissions (resource_type, action, description) VALUES
+('bastion:user', 'impersonate', 'Obtain a short-lived token acting as another user')
+ON CONFLICT (resource_type, action) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name IN ('platform:superadmin', 'platform:admin', 'bastion:tenant-admin')
+  AND p.resource_type = 'bastion:user' AND p.action = 'impersonate'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,25 @@
+-- Migration 011: Multi-tenant membership
+-- Users may belong to several tenants and choose one per session
+
+CREATE TABLE tenant_memberships (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, tenant_id)
+);
+
+CREATE INDEX idx_tenant_memberships_tenant_id ON tenant_memberships(tenant_id);
+
+-- Every existing user belongs to their current tenant; users.tenant_id
+-- remains as the home tenant used for provisioning
+INSERT INTO tenant_memberships (user_id, tenant_id)
+SELECT id, tenant_id FROM users WHERE tenant_id IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+-- The tenant a session is acting in; access tokens and refreshes use it
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE;
+
+UPDATE sessions s SET tenant_id = u.tenant_id
+FROM users u
+WHERE u.id = s.user_id AND s.tenant_id IS NULL;
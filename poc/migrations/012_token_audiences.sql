@@ -0,0 +1,20 @@
+-- Migration 012: Audience-restricted access tokens
+-- Every access token names the application it was issued for in its aud
+-- claim; an empty audience below means the Bastion API itself
+
+-- Audience of the tokens a session refreshes into
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS audience VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Audience requested with an authorization or device flow, carried into the
+-- tokens eventually issued for it
+ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS audience VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE oauth_device_authorizations ADD COLUMN IF NOT EXISTS audience VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Application audiences each service account may obtain client_credentials
+-- tokens for, in addition to the Bastion API
+CREATE TABLE service_account_audiences (
+    service_account_id UUID NOT NULL REFERENCES service_accounts(id) ON DELETE CASCADE,
+    audience VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (service_account_id, audience)
+);
@@ -0,0 +1,5 @@
+-- Migration 013: Scope-restricted access tokens
+-- Permission scopes ("resource_type:action") a session's access tokens are
+-- limited to; empty means limited only by the user's roles
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
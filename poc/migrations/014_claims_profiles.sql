@@ -0,0 +1,79 @@
+-- Migration 014: Embedded authorization claims
+-- Applications can opt in, per audience, to access tokens that carry the
+-- user's roles and permissions so that they can authorize locally; a
+-- per-user permission version lets them detect tokens that have gone stale
+
+CREATE TABLE token_claims_profiles (
+    audience VARCHAR(255) PRIMARY KEY,
+    -- 'permissions' embeds the permission list, 'hash' only its hash; both
+    -- carry the permission version
+    embed VARCHAR(20) NOT NULL CHECK (embed IN ('permissions', 'hash')),
+    include_roles BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Incremented whenever a user's effective permissions may have changed.
+-- Users without a row are at version 1.
+CREATE TABLE permission_versions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    version BIGINT NOT NULL DEFAULT 1,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE OR REPLACE FUNCTION bump_permission_versions(user_ids UUID[]) RETURNS VOID AS $$
+    INSERT INTO permission_versions (user_id, version)
+    SELECT u.id, 2 FROM users u WHERE u.id = ANY(user_ids)
+    ON CONFLICT (user_id) DO UPDATE
+    SET version = permission_versions.version + 1, updated_at = NOW();
+$$ LANGUAGE sql;
+
+CREATE OR REPLACE FUNCTION user_roles_bump_permission_version() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM bump_permission_versions(ARRAY[OLD.user_id]);
+    ELSIF TG_OP = 'UPDATE' THEN
+        PERFORM bump_permission_versions(ARRAY[OLD.user_id, NEW.user_id]);
+    ELSE
+        PERFORM bump_permission_versions(ARRAY[NEW.user_id]);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_roles_permission_version
+AFTER INSERT OR UPDATE OR DELETE ON user_roles
+FOR EACH ROW EXECUTE FUNCTION user_roles_bump_permission_version();
+
+CREATE OR REPLACE FUNCTION role_permissions_bump_permission_version() RETURNS TRIGGER AS $$
+DECLARE
+    changed_role UUID;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed_role := OLD.role_id;
+    ELSE
+        changed_role := NEW.role_id;
+    END IF;
+    PERFORM bump_permission_versions(ARRAY(
+        SELECT DISTINCT user_id FROM user_roles WHERE role_id = changed_role
+    ));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER role_permissions_permission_version
+AFTER INSERT OR UPDATE OR DELETE ON role_permissions
+FOR EACH ROW EXECUTE FUNCTION role_permissions_bump_permission_version();
+
+-- Managing claims profiles
+INSERT INTO permissions (resource_type, action, description) VALUES
+('bastion:claims-profile', 'read', 'View per-audience token claims profiles'),
+('bastion:claims-profile', 'update', 'Configure per-audience token claims profiles')
+ON CONFLICT (resource_type, action) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'platform:superadmin'
+  AND p.resource_type = 'bastion:claims-profile'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,96 @@
+-- Migration 015: Policy change feed
+-- An append-only log of changes to role assignments and role permissions.
+-- Applications that cache authorization decisions (DD-001 §4.3) follow it
+-- from a cursor (the event id) and drop affected entries as events arrive.
+-- Every insert is announced on the policy_changes notification channel.
+
+CREATE TABLE policy_changes (
+    id BIGSERIAL PRIMARY KEY,
+    change_type VARCHAR(50) NOT NULL CHECK (change_type IN (
+        'user_role.granted', 'user_role.revoked',
+        'role_permission.granted', 'role_permission.revoked',
+        'permission.updated'
+    )),
+    user_id UUID,
+    role_id UUID,
+    tenant_id UUID,
+    resource_type VARCHAR(100),
+    action VARCHAR(50),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE OR REPLACE FUNCTION notify_policy_change() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('policy_changes', NEW.id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER policy_changes_notify
+AFTER INSERT ON policy_changes
+FOR EACH ROW EXECUTE FUNCTION notify_policy_change();
+
+CREATE OR REPLACE FUNCTION user_roles_record_policy_change() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('DELETE', 'UPDATE') THEN
+        INSERT INTO policy_changes (change_type, user_id, role_id, tenant_id)
+        VALUES ('user_role.revoked', OLD.user_id, OLD.role_id, OLD.tenant_id);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO policy_changes (change_type, user_id, role_id, tenant_id)
+        VALUES ('user_role.granted', NEW.user_id, NEW.role_id, NEW.tenant_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_roles_policy_change
+AFTER INSERT OR UPDATE OR DELETE ON user_roles
+FOR EACH ROW EXECUTE FUNCTION user_roles_record_policy_change();
+
+-- Role permission events carry the permission itself, since applications
+-- cache decisions per permission and do not know who holds which role.
+-- When the permission itself is being deleted it is no longer visible, and
+-- the event carries no permission at all.
+CREATE OR REPLACE FUNCTION role_permissions_record_policy_change() RETURNS TRIGGER AS $$
+DECLARE
+    perm_resource_type VARCHAR(100);
+    perm_action VARCHAR(50);
+BEGIN
+    IF TG_OP IN ('DELETE', 'UPDATE') THEN
+        SELECT resource_type, action INTO perm_resource_type, perm_action
+        FROM permissions WHERE id = OLD.permission_id;
+        INSERT INTO policy_changes (change_type, role_id, resource_type, action)
+        VALUES ('role_permission.revoked', OLD.role_id, perm_resource_type, perm_action);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        SELECT resource_type, action INTO perm_resource_type, perm_action
+        FROM permissions WHERE id = NEW.permission_id;
+        INSERT INTO policy_changes (change_type, role_id, resource_type, action)
+        VALUES ('role_permission.granted', NEW.role_id, perm_resource_type, perm_action);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER role_permissions_policy_change
+AFTER INSERT OR UPDATE OR DELETE ON role_permissions
+FOR EACH ROW EXECUTE FUNCTION role_permissions_record_policy_change();
+
+-- Renaming a permission changes the decision for both its old and new name.
+CREATE OR REPLACE FUNCTION permissions_record_policy_change() RETURNS TRIGGER AS $$
+BEGIN
+    IF OLD.resource_type IS DISTINCT FROM NEW.resource_type OR OLD.action IS DISTINCT FROM NEW.action THEN
+        INSERT INTO policy_changes (change_type, resource_type, action) VALUES
+        ('permission.updated', OLD.resource_type, OLD.action),
+        ('permission.updated', NEW.resource_type, NEW.action);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER permissions_policy_change
+AFTER UPDATE ON permissions
+FOR EACH ROW EXECUTE FUNCTION permissions_record_policy_change();
+
+CREATE INDEX idx_policy_changes_created_at ON policy_changes(created_at);
@@ -0,0 +1,14 @@
+-- Migration 016: Authorization check permission
+-- The check API reveals what any identity may do, so callers (normally the
+-- service accounts of applications) need a dedicated permission
+
+INSERT INTO permissions (resource_type, action, description) VALUES
+('bastion:authz', 'check', 'Check the permissions of any identity')
+ON CONFLICT (resource_type, action) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'platform:superadmin'
+  AND p.resource_type = 'bastion:authz' AND p.action = 'check'
+ON CONFLICT DO NOTHING;
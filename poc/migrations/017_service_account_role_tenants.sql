@@ -0,0 +1,47 @@
+-- Migration 017: Tenant-scoped service account roles
+-- Service account role assignments become tenant-scoped like user_roles
+-- (tenant_id NULL applies in every tenant) and are evaluated by RBAC.
+-- Changes to them are recorded in the policy change feed.
+
+ALTER TABLE service_account_roles
+    ADD COLUMN tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE,
+    ADD COLUMN granted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    ADD COLUMN granted_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+-- Roles of tenant-bound service accounts applied in their tenant only.
+UPDATE service_account_roles sar
+SET tenant_id = sa.tenant_id
+FROM service_accounts sa
+WHERE sa.id = sar.service_account_id;
+
+ALTER TABLE service_account_roles DROP CONSTRAINT service_account_roles_pkey;
+
+CREATE UNIQUE INDEX idx_service_account_roles_unique ON service_account_roles(service_account_id, role_id, COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000'::uuid));
+
+ALTER TABLE policy_changes ADD COLUMN service_account_id UUID;
+
+ALTER TABLE policy_changes DROP CONSTRAINT policy_changes_change_type_check;
+ALTER TABLE policy_changes ADD CONSTRAINT policy_changes_change_type_check CHECK (change_type IN (
+    'user_role.granted', 'user_role.revoked',
+    'service_account_role.granted', 'service_account_role.revoked',
+    'role_permission.granted', 'role_permission.revoked',
+    'permission.updated'
+));
+
+CREATE OR REPLACE FUNCTION service_account_roles_record_policy_change() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('DELETE', 'UPDATE') THEN
+        INSERT INTO policy_changes (change_type, service_account_id, role_id, tenant_id)
+        VALUES ('service_account_role.revoked', OLD.service_account_id, OLD.role_id, OLD.tenant_id);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO policy_changes (change_type, service_account_id, role_id, tenant_id)
+        VALUES ('service_account_role.granted', NEW.service_account_id, NEW.role_id, NEW.tenant_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER service_account_roles_policy_change
+AFTER INSERT OR UPDATE OR DELETE ON service_account_roles
+FOR EACH ROW EXECUTE FUNCTION service_account_roles_record_policy_change();
@@ -0,0 +1,26 @@
+-- Migration 018: Role management
+-- Roles and their permissions can now be managed through the API. The roles
+-- seeded by Bastion itself are marked as system roles: the platform and its
+-- migrations rely on their names and permission sets, so they are immutable.
+
+ALTER TABLE roles ADD COLUMN is_system BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE roles SET is_system = TRUE
+WHERE name IN (
+    'platform:superadmin', 'platform:admin', 'platform:auditor',
+    'bastion:tenant-admin', 'bastion:user-admin', 'bastion:viewer'
+);
+
+INSERT INTO permissions (resource_type, action, description) VALUES
+('bastion:role', 'create', 'Create roles'),
+('bastion:role', 'update', 'Modify roles and their permissions'),
+('bastion:role', 'delete', 'Delete roles')
+ON CONFLICT (resource_type, action) DO NOTHING;
+
+-- Role definitions are global, so only platform roles may manage them
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name IN ('platform:superadmin', 'platform:admin')
+  AND p.resource_type = 'bastion:role' AND p.action IN ('create', 'update', 'delete')
+ON CONFLICT DO NOTHING;
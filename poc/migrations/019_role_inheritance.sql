@@ -0,0 +1,150 @@
+-- Migration 019: Role inheritance (DD-001 §3.4)
+-- A role inherits every permission of its parent roles, transitively. The
+-- parent relation must stay acyclic. role_closure materializes it: one row
+-- for each role and each of its ancestors, including the role itself, so
+-- that permission queries need a single extra join.
+
+CREATE TABLE role_parents (
+    role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    parent_role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (role_id, parent_role_id),
+    CHECK (role_id <> parent_role_id)
+);
+
+CREATE INDEX idx_role_parents_parent ON role_parents(parent_role_id);
+
+CREATE TABLE role_closure (
+    role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    ancestor_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, ancestor_id)
+);
+
+CREATE INDEX idx_role_closure_ancestor ON role_closure(ancestor_id);
+
+INSERT INTO role_closure (role_id, ancestor_id)
+SELECT id, id FROM roles;
+
+CREATE OR REPLACE FUNCTION roles_add_closure() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO role_closure (role_id, ancestor_id) VALUES (NEW.id, NEW.id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER roles_closure
+AFTER INSERT ON roles
+FOR EACH ROW EXECUTE FUNCTION roles_add_closure();
+
+-- Rebuilds the closure from role_parents. Paths only run through roles that
+-- still exist, as edges of a role being deleted may not be removed yet.
+CREATE OR REPLACE FUNCTION rebuild_role_closure() RETURNS VOID AS $$
+    DELETE FROM role_closure;
+
+    INSERT INTO role_closure (role_id, ancestor_id)
+    SELECT id, id FROM roles;
+
+    INSERT INTO role_closure (role_id, ancestor_id)
+    WITH RECURSIVE reachable(role_id, ancestor_id) AS (
+        SELECT rp.role_id, rp.parent_role_id FROM role_parents rp
+        UNION
+        SELECT r.role_id, rp.parent_role_id
+        FROM reachable r
+        JOIN roles via ON via.id = r.ancestor_id
+        JOIN role_parents rp ON rp.role_id = r.ancestor_id
+    )
+    SELECT r.role_id, r.ancestor_id
+    FROM reachable r
+    JOIN roles c ON c.id = r.role_id
+    JOIN roles a ON a.id = r.ancestor_id
+    ON CONFLICT DO NOTHING;
+$$ LANGUAGE sql;
+
+-- An edge is added by linking every descendant of the child to every
+-- ancestor of the parent. Removing one may leave other paths in place, so
+-- the closure is rebuilt; inheritance changes are rare.
+CREATE OR REPLACE FUNCTION role_parents_maintain_closure() RETURNS TRIGGER AS $$
+DECLARE
+    descendants UUID[];
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        descendants := ARRAY(
+            WITH RECURSIVE d(role_id) AS (
+                SELECT OLD.role_id
+                UNION
+                SELECT rp.role_id FROM role_parents rp JOIN d ON rp.parent_role_id = d.role_id
+            )
+            SELECT role_id FROM d
+        );
+        PERFORM rebuild_role_closure();
+    ELSE
+        IF EXISTS (SELECT 1 FROM role_closure WHERE role_id = NEW.parent_role_id AND ancestor_id = NEW.role_id) THEN
+            RAISE EXCEPTION 'role inheritance cycle: % already inherits from %', NEW.parent_role_id, NEW.role_id;
+        END IF;
+
+        descendants := ARRAY(SELECT role_id FROM role_closure WHERE ancestor_id = NEW.role_id);
+
+        INSERT INTO role_closure (role_id, ancestor_id)
+        SELECT d, a.ancestor_id
+        FROM unnest(descendants) d, role_closure a
+        WHERE a.role_id = NEW.parent_role_id
+        ON CONFLICT DO NOTHING;
+    END IF;
+
+    -- Everyone holding the child or one of its descendants is affected.
+    PERFORM bump_permission_versions(ARRAY(
+        SELECT DISTINCT user_id FROM user_roles WHERE role_id = ANY(descendants)
+    ));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER role_parents_closure
+AFTER INSERT OR DELETE ON role_parents
+FOR EACH ROW EXECUTE FUNCTION role_parents_maintain_closure();
+
+-- A permission change on a role reaches every role inheriting from it.
+CREATE OR REPLACE FUNCTION role_permissions_bump_permission_version() RETURNS TRIGGER AS $$
+DECLARE
+    changed_role UUID;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed_role := OLD.role_id;
+    ELSE
+        changed_role := NEW.role_id;
+    END IF;
+    PERFORM bump_permission_versions(ARRAY(
+        SELECT DISTINCT ur.user_id
+        FROM user_roles ur
+        JOIN role_closure rc ON rc.role_id = ur.role_id
+        WHERE rc.ancestor_id = changed_role
+    ));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE policy_changes DROP CONSTRAINT policy_changes_change_type_check;
+ALTER TABLE policy_changes ADD CONSTRAINT policy_changes_change_type_check CHECK (change_type IN (
+    'user_role.granted', 'user_role.revoked',
+    'service_account_role.granted', 'service_account_role.revoked',
+    'role_permission.granted', 'role_permission.revoked',
+    'role_parent.added', 'role_parent.removed',
+    'permission.updated'
+));
+
+-- Inheritance changes carry only the child role; they may change any
+-- permission of anyone holding it, so applications drop all cached decisions.
+CREATE OR REPLACE FUNCTION role_parents_record_policy_change() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO policy_changes (change_type, role_id) VALUES ('role_parent.removed', OLD.role_id);
+    ELSE
+        INSERT INTO policy_changes (change_type, role_id) VALUES ('role_parent.added', NEW.role_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER role_parents_policy_change
+AFTER INSERT OR DELETE ON role_parents
+FOR EACH ROW EXECUTE FUNCTION role_parents_record_policy_change();
@@ -0,0 +1,48 @@
+-- Migration 020: Tenant custom roles (DD-001 §3.1)
+-- Tenants define their own roles, named {tenant slug}:{role}, composing
+-- application permissions and roles. A tenant role belongs to one tenant and
+-- can only be held within it.
+
+ALTER TABLE roles ADD COLUMN tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE;
+
+ALTER TABLE roles DROP CONSTRAINT roles_role_type_check;
+ALTER TABLE roles ADD CONSTRAINT roles_role_type_check CHECK (role_type IN ('platform', 'application', 'tenant'));
+ALTER TABLE roles ADD CONSTRAINT roles_tenant_check CHECK ((role_type = 'tenant') = (tenant_id IS NOT NULL));
+
+CREATE INDEX idx_roles_tenant ON roles(tenant_id);
+
+-- Assignments of a tenant role must name its tenant, for users and service
+-- accounts alike.
+CREATE OR REPLACE FUNCTION check_role_assignment_tenant() RETURNS TRIGGER AS $$
+DECLARE
+    role_tenant UUID;
+BEGIN
+    SELECT tenant_id INTO role_tenant FROM roles WHERE id = NEW.role_id;
+    IF role_tenant IS NOT NULL AND NEW.tenant_id IS DISTINCT FROM role_tenant THEN
+        RAISE EXCEPTION 'role % can only be assigned in tenant %', NEW.role_id, role_tenant;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_roles_check_tenant
+BEFORE INSERT OR UPDATE ON user_roles
+FOR EACH ROW EXECUTE FUNCTION check_role_assignment_tenant();
+
+CREATE TRIGGER service_account_roles_check_tenant
+BEFORE INSERT OR UPDATE ON service_account_roles
+FOR EACH ROW EXECUTE FUNCTION check_role_assignment_tenant();
+
+INSERT INTO permissions (resource_type, action, description) VALUES
+('bastion:tenant-role', 'create', 'Create custom roles in a tenant'),
+('bastion:tenant-role', 'read', 'View the custom roles of a tenant'),
+('bastion:tenant-role', 'update', 'Modify custom roles and their permissions'),
+('bastion:tenant-role', 'delete', 'Delete custom roles')
+ON CONFLICT (resource_type, action) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name IN ('platform:superadmin', 'platform:admin', 'bastion:tenant-admin')
+  AND p.resource_type = 'bastion:tenant-role'
+ON CONFLICT DO NOTHING;
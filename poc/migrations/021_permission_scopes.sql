@@ -0,0 +1,164 @@
+-- Migration 021: Permission scopes (DD-001 §3.3)
+-- A role grants a permission with a scope: on every resource of the type in
+-- the tenant, or only on resources the identity owns, is assigned to or
+-- whose team it belongs to, or on one specific resource. Applications record
+-- ownership, assignments and teams of their resources in a registry.
+
+ALTER TABLE role_permissions
+    ADD COLUMN scope VARCHAR(255) NOT NULL DEFAULT 'tenant'
+    CHECK (scope IN ('tenant', 'owned', 'assigned', 'team') OR scope ~ '^specific:.+');
+
+ALTER TABLE role_permissions DROP CONSTRAINT role_permissions_pkey;
+ALTER TABLE role_permissions ADD PRIMARY KEY (role_id, permission_id, scope);
+
+CREATE TABLE teams (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (tenant_id, name)
+);
+
+-- Members are users or service accounts
+CREATE TABLE team_members (
+    team_id UUID NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    identity_type VARCHAR(20) NOT NULL CHECK (identity_type IN ('user', 'service_account')),
+    identity_id UUID NOT NULL,
+    added_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (team_id, identity_type, identity_id)
+);
+
+CREATE INDEX idx_team_members_identity ON team_members(identity_type, identity_id);
+
+-- Resource IDs are the application's own
+CREATE TABLE resources (
+    resource_type VARCHAR(100) NOT NULL,
+    resource_id VARCHAR(255) NOT NULL,
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE,
+    owner_type VARCHAR(20) CHECK (owner_type IN ('user', 'service_account')),
+    owner_id UUID,
+    team_id UUID REFERENCES teams(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (resource_type, resource_id),
+    CHECK ((owner_type IS NULL) = (owner_id IS NULL))
+);
+
+CREATE INDEX idx_resources_owner ON resources(owner_type, owner_id);
+
+CREATE TABLE resource_assignments (
+    resource_type VARCHAR(100) NOT NULL,
+    resource_id VARCHAR(255) NOT NULL,
+    identity_type VARCHAR(20) NOT NULL CHECK (identity_type IN ('user', 'service_account')),
+    identity_id UUID NOT NULL,
+    assigned_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (resource_type, resource_id, identity_type, identity_id),
+    FOREIGN KEY (resource_type, resource_id) REFERENCES resources(resource_type, resource_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_resource_assignments_identity ON resource_assignments(identity_type, identity_id);
+
+-- Registry changes alter scoped decisions, so they are part of the feed
+ALTER TABLE policy_changes ADD COLUMN resource_id VARCHAR(255);
+
+ALTER TABLE policy_changes DROP CONSTRAINT policy_changes_change_type_check;
+ALTER TABLE policy_changes ADD CONSTRAINT policy_changes_change_type_check CHECK (change_type IN (
+    'user_role.granted', 'user_role.revoked',
+    'service_account_role.granted', 'service_account_role.revoked',
+    'role_permission.granted', 'role_permission.revoked',
+    'role_parent.added', 'role_parent.removed',
+    'permission.updated',
+    'resource.updated',
+    'resource_assignment.granted', 'resource_assignment.revoked',
+    'team_member.added', 'team_member.removed'
+));
+
+CREATE OR REPLACE FUNCTION resources_record_policy_change() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO policy_changes (change_type, tenant_id, resource_type, resource_id)
+        VALUES ('resource.updated', OLD.tenant_id, OLD.resource_type, OLD.resource_id);
+    ELSE
+        INSERT INTO policy_changes (change_type, tenant_id, resource_type, resource_id)
+        VALUES ('resource.updated', NEW.tenant_id, NEW.resource_type, NEW.resource_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER resources_policy_change
+AFTER INSERT OR UPDATE OR DELETE ON resources
+FOR EACH ROW EXECUTE FUNCTION resources_record_policy_change();
+
+CREATE OR REPLACE FUNCTION resource_assignments_record_policy_change() RETURNS TRIGGER AS $$
+DECLARE
+    change VARCHAR(50) := 'resource_assignment.granted';
+    rec RECORD := NEW;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        change := 'resource_assignment.revoked';
+        rec := OLD;
+    END IF;
+    INSERT INTO policy_changes (change_type, user_id, service_account_id, resource_type, resource_id)
+    VALUES (
+        change,
+        CASE WHEN rec.identity_type = 'user' THEN rec.identity_id END,
+        CASE WHEN rec.identity_type = 'service_account' THEN rec.identity_id END,
+        rec.resource_type, rec.resource_id
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER resource_assignments_policy_change
+AFTER INSERT OR DELETE ON resource_assignments
+FOR EACH ROW EXECUTE FUNCTION resource_assignments_record_policy_change();
+
+CREATE OR REPLACE FUNCTION team_members_record_policy_change() RETURNS TRIGGER AS $$
+DECLARE
+    change VARCHAR(50) := 'team_member.added';
+    rec RECORD := NEW;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        change := 'team_member.removed';
+        rec := OLD;
+    END IF;
+    INSERT INTO policy_changes (change_type, user_id, service_account_id, tenant_id)
+    SELECT
+        change,
+        CASE WHEN rec.identity_type = 'user' THEN rec.identity_id END,
+        CASE WHEN rec.identity_type = 'service_account' THEN rec.identity_id END,
+        t.tenant_id
+    FROM (SELECT tenant_id FROM teams WHERE id = rec.team_id
+          UNION ALL SELECT NULL LIMIT 1) t;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER team_members_policy_change
+AFTER INSERT OR DELETE ON team_members
+FOR EACH ROW EXECUTE FUNCTION team_members_record_policy_change();
+
+INSERT INTO permissions (resource_type, action, description) VALUES
+('bastion:resource', 'read', 'View the ownership, team and assignments of application resources'),
+('bastion:resource', 'write', 'Record the ownership, team and assignments of application resources'),
+('bastion:team', 'create', 'Create teams in a tenant'),
+('bastion:team', 'read', 'View teams and their members'),
+('bastion:team', 'update', 'Add and remove team members'),
+('bastion:team', 'delete', 'Delete teams')
+ON CONFLICT (resource_type, action) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'platform:superadmin'
+  AND p.resource_type IN ('bastion:resource', 'bastion:team')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name IN ('platform:admin', 'bastion:tenant-admin')
+  AND p.resource_type = 'bastion:team'
+ON CONFLICT DO NOTHING;
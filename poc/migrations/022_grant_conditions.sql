@@ -0,0 +1,64 @@
+-- Migration 022: Conditional grants (DD-001 §3.3)
+-- Role and API key grants may carry conditions: time windows, IP ranges and
+-- matches on resource and principal attributes. A grant only applies while
+-- its conditions hold, so tokens never carry conditional permissions;
+-- Bastion evaluates them on every check.
+
+ALTER TABLE role_permissions ADD COLUMN conditions JSONB;
+ALTER TABLE api_key_permissions ADD COLUMN conditions JSONB;
+
+-- Principal attributes conditions can refer to, for users, service
+-- accounts and API keys alike.
+CREATE TABLE principal_attributes (
+    identity_type VARCHAR(20) NOT NULL CHECK (identity_type IN ('user', 'service_account', 'api_key')),
+    identity_id UUID NOT NULL,
+    attributes JSONB NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (identity_type, identity_id)
+);
+
+ALTER TABLE policy_changes DROP CONSTRAINT policy_changes_change_type_check;
+ALTER TABLE policy_changes ADD CONSTRAINT policy_changes_change_type_check CHECK (change_type IN (
+    'user_role.granted', 'user_role.revoked',
+    'service_account_role.granted', 'service_account_role.revoked',
+    'role_permission.granted', 'role_permission.revoked',
+    'role_parent.added', 'role_parent.removed',
+    'permission.updated',
+    'resource.updated',
+    'resource_assignment.granted', 'resource_assignment.revoked',
+    'team_member.added', 'team_member.removed',
+    'principal_attributes.updated'
+));
+
+CREATE OR REPLACE FUNCTION principal_attributes_record_policy_change() RETURNS TRIGGER AS $$
+DECLARE
+    rec RECORD := NEW;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        rec := OLD;
+    END IF;
+    INSERT INTO policy_changes (change_type, user_id, service_account_id)
+    VALUES (
+        'principal_attributes.updated',
+        CASE WHEN rec.identity_type = 'user' THEN rec.identity_id END,
+        CASE WHEN rec.identity_type = 'service_account' THEN rec.identity_id END
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER principal_attributes_policy_change
+AFTER INSERT OR UPDATE OR DELETE ON principal_attributes
+FOR EACH ROW EXECUTE FUNCTION principal_attributes_record_policy_change();
+
+INSERT INTO permissions (resource_type, action, description) VALUES
+('bastion:principal-attribute', 'read', 'View the attributes of users, service accounts and API keys'),
+('bastion:principal-attribute', 'update', 'Set the attributes of users, service accounts and API keys')
+ON CONFLICT (resource_type, action) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name IN ('platform:superadmin', 'platform:admin')
+  AND p.resource_type = 'bastion:principal-attribute'
+ON CONFLICT DO NOTHING;
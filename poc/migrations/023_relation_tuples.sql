@@ -0,0 +1,80 @@
+-- Migration 023: Relationship-based access control
+-- Applications model sharing as relation tuples, object#relation@subject,
+-- such as document:readme#editor@user:alice or
+-- folder:plans#viewer@group:eng#member. A namespace configures the
+-- relations of an object type, each the union of its own tuples, other
+-- relations of the same object (computed) and relations of related objects
+-- (inherited), so that editors of a folder can edit the documents in it.
+
+CREATE TABLE relation_namespaces (
+    name VARCHAR(100) PRIMARY KEY,
+    application_name VARCHAR(100),
+    relations JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- subject_relation is empty for a subject that is a single object, and
+-- names a relation for a userset such as group:eng#member. Tuples written
+-- by a caller bound to a tenant belong to it.
+CREATE TABLE relation_tuples (
+    namespace VARCHAR(100) NOT NULL REFERENCES relation_namespaces(name),
+    object_id VARCHAR(255) NOT NULL,
+    relation VARCHAR(100) NOT NULL,
+    subject_namespace VARCHAR(100) NOT NULL,
+    subject_id VARCHAR(255) NOT NULL,
+    subject_relation VARCHAR(100) NOT NULL DEFAULT '',
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (namespace, object_id, relation, subject_namespace, subject_id, subject_relation)
+);
+
+CREATE INDEX idx_relation_tuples_subject ON relation_tuples(subject_namespace, subject_id, subject_relation);
+
+ALTER TABLE policy_changes DROP CONSTRAINT policy_changes_change_type_check;
+ALTER TABLE policy_changes ADD CONSTRAINT policy_changes_change_type_check CHECK (change_type IN (
+    'user_role.granted', 'user_role.revoked',
+    'service_account_role.granted', 'service_account_role.revoked',
+    'role_permission.granted', 'role_permission.revoked',
+    'role_parent.added', 'role_parent.removed',
+    'permission.updated',
+    'resource.updated',
+    'resource_assignment.granted', 'resource_assignment.revoked',
+    'team_member.added', 'team_member.removed',
+    'principal_attributes.updated',
+    'relation_tuple.written', 'relation_tuple.deleted'
+));
+
+-- Tuple changes are recorded against the object, namespace as the resource
+-- type, in the same transaction as the change.
+CREATE OR REPLACE FUNCTION relation_tuples_record_policy_change() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO policy_changes (change_type, tenant_id, resource_type, resource_id)
+        VALUES ('relation_tuple.deleted', OLD.tenant_id, OLD.namespace, OLD.object_id);
+    ELSE
+        INSERT INTO policy_changes (change_type, tenant_id, resource_type, resource_id)
+        VALUES ('relation_tuple.written', NEW.tenant_id, NEW.namespace, NEW.object_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER relation_tuples_policy_change
+AFTER INSERT OR DELETE ON relation_tuples
+FOR EACH ROW EXECUTE FUNCTION relation_tuples_record_policy_change();
+
+INSERT INTO permissions (resource_type, action, description) VALUES
+('bastion:relation', 'check', 'Check relations and list the objects a subject relates to'),
+('bastion:relation', 'read', 'Read and expand relation tuples'),
+('bastion:relation', 'write', 'Write and delete relation tuples'),
+('bastion:relation-namespace', 'read', 'View relation namespace configurations'),
+('bastion:relation-namespace', 'write', 'Create, update and delete relation namespace configurations')
+ON CONFLICT (resource_type, action) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name IN ('platform:superadmin', 'platform:admin')
+  AND p.resource_type IN ('bastion:relation', 'bastion:relation-namespace')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,9 @@
+-- Migration 024: Federated sign-in
+-- The sign-in callback verifies OpenID Connect ID tokens issued by a
+-- connection's IdP: their audience must be the client ID Bastion is
+-- registered with at the IdP, and their signature must verify against the
+-- IdP's published keys, found at jwks_uri or through discovery from the
+-- issuer when it is not set.
+
+ALTER TABLE idp_connections ADD COLUMN client_id VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE idp_connections ADD COLUMN jwks_uri VARCHAR(500);
@@ -0,0 +1,8 @@
+-- Migration 025: OAuth client audiences
+-- Clients obtain tokens for their own application. Any other audience,
+-- including the Bastion API, must be listed for the client explicitly:
+-- the authorization endpoint reuses the SSO session without a consent
+-- step, so an unlisted Bastion API audience would let any application
+-- silently obtain a token carrying the user's administrative permissions.
+
+ALTER TABLE oauth_clients ADD COLUMN audiences TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,16 @@
+-- Migration 026: Federated sign-in requests
+-- A sign-in starts at Bastion, which issues a nonce the client passes to
+-- the IdP. The callback accepts only ID tokens carrying the nonce of an
+-- open request for the same connection, presented by the browser that
+-- started it, and each request completes at most once. Only a hash of the
+-- nonce is stored.
+
+CREATE TABLE idp_sign_in_requests (
+    nonce_hash VARCHAR(64) PRIMARY KEY,
+    connection_id UUID NOT NULL REFERENCES idp_connections(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    consumed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_idp_sign_in_requests_expires_at ON idp_sign_in_requests(expires_at);
@@ -0,0 +1,6 @@
+-- Migration 027: Device authorization tenant
+-- A device authorization is approved in the tenant of the approving
+-- session, which must be the client's tenant for tenant-bound clients,
+-- and the device's tokens are issued in that tenant.
+
+ALTER TABLE oauth_device_authorizations ADD COLUMN tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE;
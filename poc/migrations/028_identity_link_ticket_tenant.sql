@@ -0,0 +1,6 @@
+-- Migration 028: Identity link ticket tenant
+-- A link ticket records the tenant of the IdP connection it came from. The
+-- ticket can only be redeemed by the account whose email it was issued for
+-- and whose home tenant is that tenant.
+
+ALTER TABLE identity_link_tickets ADD COLUMN tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE;
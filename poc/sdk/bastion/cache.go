@@ -0,0 +1,230 @@
+package bastion
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultDecisionTTL bounds how long a cached decision is used. DD-001 §4.3
+// caches role assignments for 5 minutes and role permissions for 15; a
+// decision depends on both, so it gets the shorter of the two.
+const DefaultDecisionTTL = 5 * time.Minute
+
+const (
+	changesWait       = 30 * time.Second
+	watchRetryBackoff = 5 * time.Second
+)
+
+// Checker answers authorization checks. Both Client and DecisionCache
+// implement it.
+type Checker interface {
+	Check(ctx context.Context, req CheckRequest) (*CheckResponse, error)
+}
+
+type decisionKey struct {
+	identityType string
+	identityID   string
+	tenantID     string
+	resourceType string
+	resourceID   string
+	action       string
+}
+
+type decision struct {
+	resp      CheckResponse
+	expiresAt time.Time
+}
+
+// DecisionCache caches the results of Client.Check. Entries expire after
+// the TTL and are dropped early when Watch sees a policy change that
+// affects them. Checks with resource attributes are always sent to Bastion,
+// conditional decisions are not cached, and cached decisions keep the audit
+// ID of the check that produced them.
+type DecisionCache struct {
+	client *Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	entries map[decisionKey]decision
+	// generation is incremented by every invalidation, so that a check
+	// that was in flight at the time does not store a stale decision.
+	generation uint64
+}
+
+func NewDecisionCache(client *Client, ttl time.Duration) *DecisionCache {
+	if ttl == 0 {
+		ttl = DefaultDecisionTTL
+	}
+	return &DecisionCache{client: client, ttl: ttl, entries: make(map[decisionKey]decision)}
+}
+
+// newDecisionKey returns the cache key of req, and false if its decision
+// must not be cached.
+func newDecisionKey(req CheckRequest) (decisionKey, bool) {
+	key := decisionKey{
+		identityType: req.Identity.Type,
+		identityID:   req.Identity.ID,
+		resourceType: req.Resource.Type,
+		resourceID:   req.Resource.ID,
+		action:       req.Action,
+	}
+	if req.Identity.TenantID != nil {
+		key.tenantID = *req.Identity.TenantID
+	}
+	return key, len(req.Resource.Attributes) == 0
+}
+
+func (c *DecisionCache) Check(ctx context.Context, req CheckRequest) (*CheckResponse, error) {
+	key, cacheable := newDecisionKey(req)
+	if !cacheable {
+		return c.client.Check(ctx, req)
+	}
+
+	c.mu.Lock()
+	d, ok := c.entries[key]
+	generation := c.generation
+	c.mu.Unlock()
+
+	if ok && time.Now().Before(d.expiresAt) {
+		resp := d.resp
+		return &resp, nil
+	}
+
+	resp, err := c.client.Check(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.generation == generation && !resp.Conditional {
+		c.entries[key] = decision{resp: *resp, expiresAt: time.Now().Add(c.ttl)}
+	}
+	c.mu.Unlock()
+
+	return resp, nil
+}
+
+// CheckBatch answers reqs from the cache where possible and sends the
+// misses to Bastion in a single batch call.
+func (c *DecisionCache) CheckBatch(ctx context.Context, reqs []CheckRequest) ([]CheckResponse, error) {
+	results := make([]CheckResponse, len(reqs))
+	var misses []CheckRequest
+	var missIndexes []int
+
+	c.mu.Lock()
+	now := time.Now()
+	for i, req := range reqs {
+		key, cacheable := newDecisionKey(req)
+		if d, ok := c.entries[key]; cacheable && ok && now.Before(d.expiresAt) {
+			results[i] = d.resp
+			continue
+		}
+		misses = append(misses, req)
+		missIndexes = append(missIndexes, i)
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	if len(misses) == 0 {
+		return results, nil
+	}
+
+	resps, err := c.client.CheckBatch(ctx, misses)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for j, resp := range resps {
+		results[missIndexes[j]] = resp
+		if key, cacheable := newDecisionKey(misses[j]); cacheable && c.generation == generation && !resp.Conditional {
+			c.entries[key] = decision{resp: resp, expiresAt: time.Now().Add(c.ttl)}
+		}
+	}
+
+	return results, nil
+}
+
+// Apply drops the cached decisions a policy change may have affected.
+// Role assignment changes affect every decision for the identity; role
+// permission changes every decision for the permission, since the cache
+// does not know who holds which role. Changes to a resource's owner or team
+// affect every decision on that resource.
+func (c *DecisionCache) Apply(change *Change) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	switch {
+	case change.UserID != nil:
+		for key := range c.entries {
+			if key.identityType == "user" && key.identityID == *change.UserID {
+				delete(c.entries, key)
+			}
+		}
+	case change.ServiceAccountID != nil:
+		for key := range c.entries {
+			if key.identityType == "service_account" && key.identityID == *change.ServiceAccountID {
+				delete(c.entries, key)
+			}
+		}
+	case change.ResourceType != nil && change.Action != nil:
+		for key := range c.entries {
+			if key.resourceType == *change.ResourceType && key.action == *change.Action {
+				delete(c.entries, key)
+			}
+		}
+	case change.ResourceType != nil && change.ResourceID != nil:
+		for key := range c.entries {
+			if key.resourceType == *change.ResourceType && key.resourceID == *change.ResourceID {
+				delete(c.entries, key)
+			}
+		}
+	default:
+		clear(c.entries)
+	}
+}
+
+// Flush drops all cached decisions.
+func (c *DecisionCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	clear(c.entries)
+}
+
+// Watch follows Bastion's policy change feed and applies each change to
+// the cache until ctx is done. After a failed request it retries from the
+// same cursor, so no change is missed. Run it in its own goroutine.
+func (c *DecisionCache) Watch(ctx context.Context) error {
+	var cursor *int64
+	for {
+		resp, err := c.client.Changes(ctx, cursor, changesWait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(watchRetryBackoff):
+			}
+			continue
+		}
+
+		if cursor == nil {
+			// Decisions cached before the feed was followed may have missed
+			// changes.
+			c.Flush()
+		}
+		for _, change := range resp.Changes {
+			c.Apply(change)
+		}
+		next := resp.Cursor
+		cursor = &next
+	}
+}
@@ -0,0 +1,83 @@
+// Package bastion is the client SDK for applications that integrate with
+// Bastion. It verifies Bastion access tokens locally, calls the
+// authorization and token APIs, and provides net/http middleware that
+// protects an application's own routes.
+package bastion
+
+import (
+	"context"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the claims of a Bastion access token.
+type Claims struct {
+	Subject      string  `json:"sub"`
+	Email        string  `json:"email,omitempty"`
+	IdentityType string  `json:"identity_type,omitempty"`
+	Name         string  `json:"name,omitempty"`
+	TenantID     *string `json:"tenant_id,omitempty"`
+	SessionID    string  `json:"sid,omitempty"`
+	AuthTime     int64   `json:"auth_time,omitempty"`
+	Scope        string  `json:"scope,omitempty"`
+	Act          *Actor  `json:"act,omitempty"`
+
+	// Present when the application's audience has a claims profile.
+	Roles              []string `json:"roles,omitempty"`
+	Permissions        []string `json:"perms,omitempty"`
+	PermissionsHash    string   `json:"perms_hash,omitempty"`
+	PermissionsVersion int64    `json:"perms_ver,omitempty"`
+
+	jwt.RegisteredClaims
+}
+
+// Actor identifies the party acting on behalf of the token subject.
+type Actor struct {
+	Subject      string `json:"sub"`
+	IdentityType string `json:"identity_type,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Act          *Actor `json:"act,omitempty"`
+}
+
+// HasScope reports whether a scope-restricted token covers a permission.
+// Tokens without a scope claim are limited only by the subject's roles.
+func (c *Claims) HasScope(resourceType, action string) bool {
+	if c.Scope == "" {
+		return true
+	}
+	want := resourceType + ":" + action
+	for _, s := range strings.Fields(c.Scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// EmbeddedPermission reports whether the token embeds the given permission.
+// Only permissions granted unconditionally at tenant scope are embedded, so
+// a token that does not embed a permission may still hold it through a
+// scoped or conditional grant, which only Bastion can decide.
+func (c *Claims) EmbeddedPermission(resourceType, action string) bool {
+	want := resourceType + ":" + action
+	for _, p := range c.Permissions {
+		if p == want {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// ContextWithClaims returns a copy of ctx carrying verified claims.
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims stored by Verifier.RequireAuth.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok
+}
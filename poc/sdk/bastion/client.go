@@ -0,0 +1,346 @@
+package bastion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	retryBaseDelay    = 100 * time.Millisecond
+
+	// tokenRefreshMargin renews cached tokens slightly before they expire.
+	tokenRefreshMargin = 30 * time.Second
+)
+
+// ClientConfig configures a Client. ClientID and ClientSecret are the
+// application's Bastion service account credentials.
+type ClientConfig struct {
+	// BaseURL is Bastion's root URL, e.g. https://bastion.example.com.
+	BaseURL      string
+	ClientID     string
+	ClientSecret string
+
+	// MaxRetries bounds retries of requests that failed with a network
+	// error or a 5xx response. Defaults to 3.
+	MaxRetries int
+
+	HTTPClient *http.Client
+}
+
+// Client calls the Bastion API as the application's service account. It
+// obtains client_credentials tokens from /auth/token and caches them until
+// shortly before they expire.
+type Client struct {
+	cfg        ClientConfig
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// APIError is a non-2xx response from Bastion.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("bastion: %d: %s", e.StatusCode, e.Message)
+}
+
+func NewClient(cfg ClientConfig) *Client {
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{cfg: cfg, httpClient: httpClient}
+}
+
+// CheckRequest asks whether an identity may perform an action on a
+// resource (DD-001 §4.2).
+type CheckRequest struct {
+	Identity Identity       `json:"identity"`
+	Action   string         `json:"action"`
+	Resource Resource       `json:"resource"`
+	Context  RequestContext `json:"context"`
+}
+
+// Identity is the subject of a check. Type is "user", "service_account" or
+// "api_key".
+type Identity struct {
+	Type     string  `json:"type"`
+	ID       string  `json:"id"`
+	TenantID *string `json:"tenant_id,omitempty"`
+}
+
+type Resource struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// RequestContext describes the request being authorized: the end user's IP
+// and when it was made.
+type RequestContext struct {
+	IP        string     `json:"ip,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+// CheckResponse is Bastion's decision. AuditID references the audit log
+// entry recording it. Conditional decisions depend on grant conditions such
+// as time windows or IP ranges and only hold for the request checked.
+type CheckResponse struct {
+	Allowed     bool   `json:"allowed"`
+	Reason      string `json:"reason"`
+	Conditional bool   `json:"conditional,omitempty"`
+	AuditID     string `json:"audit_id"`
+}
+
+// Check calls POST /api/v1/authz/check.
+func (c *Client) Check(ctx context.Context, req CheckRequest) (*CheckResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("bastion: encode check request: %w", err)
+	}
+
+	var resp CheckResponse
+	if err := c.doAuthorized(ctx, c.httpClient, http.MethodPost, "/api/v1/authz/check", body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CheckBatch calls POST /api/v1/authz/check-batch, returning one response
+// per request in the same order. Bastion accepts up to 100 checks per call.
+func (c *Client) CheckBatch(ctx context.Context, reqs []CheckRequest) ([]CheckResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{"checks": reqs})
+	if err != nil {
+		return nil, fmt.Errorf("bastion: encode check request: %w", err)
+	}
+
+	var resp struct {
+		Results []CheckResponse `json:"results"`
+	}
+	if err := c.doAuthorized(ctx, c.httpClient, http.MethodPost, "/api/v1/authz/check-batch", body, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Results) != len(reqs) {
+		return nil, fmt.Errorf("bastion: check-batch returned %d results for %d checks", len(resp.Results), len(reqs))
+	}
+	return resp.Results, nil
+}
+
+// Change is an entry of Bastion's policy change feed.
+type Change struct {
+	ID               int64     `json:"id"`
+	Type             string    `json:"type"`
+	UserID           *string   `json:"user_id,omitempty"`
+	ServiceAccountID *string   `json:"service_account_id,omitempty"`
+	RoleID           *string   `json:"role_id,omitempty"`
+	TenantID         *string   `json:"tenant_id,omitempty"`
+	ResourceType     *string   `json:"resource_type,omitempty"`
+	Action           *string   `json:"action,omitempty"`
+	ResourceID       *string   `json:"resource_id,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type ChangesResponse struct {
+	Changes []*Change `json:"changes"`
+	Cursor  int64     `json:"cursor"`
+}
+
+// Changes long-polls GET /api/v1/authz/changes for the policy changes after
+// cursor, waiting up to wait for the first one. A nil cursor returns no
+// changes and the cursor to start following the feed from.
+func (c *Client) Changes(ctx context.Context, cursor *int64, wait time.Duration) (*ChangesResponse, error) {
+	path := "/api/v1/authz/changes"
+	httpClient := c.httpClient
+	if cursor != nil {
+		path += fmt.Sprintf("?cursor=%d&wait=%d", *cursor, int(wait.Seconds()))
+
+		// The request is expected to outlive the client's usual timeout.
+		if httpClient.Timeout > 0 {
+			longPoll := *httpClient
+			longPoll.Timeout += wait
+			httpClient = &longPoll
+		}
+	}
+
+	var resp ChangesResponse
+	if err := c.doAuthorized(ctx, httpClient, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Token returns a cached client_credentials access token for the Bastion
+// API, requesting a new one when the cached token is about to expire.
+func (c *Client) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Add(tokenRefreshMargin).Before(c.expiresAt) {
+		return c.token, nil
+	}
+
+	token, expiresIn, err := c.requestToken(ctx, "", "")
+	if err != nil {
+		return "", err
+	}
+	c.token = token
+	c.expiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	return c.token, nil
+}
+
+// TokenFor requests an uncached client_credentials token for another
+// audience, optionally narrowed to scope. The service account must be
+// allowed to use the audience.
+func (c *Client) TokenFor(ctx context.Context, audience, scope string) (string, error) {
+	token, _, err := c.requestToken(ctx, audience, scope)
+	return token, err
+}
+
+func (c *Client) requestToken(ctx context.Context, audience, scope string) (string, int, error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.cfg.ClientID},
+		"client_secret": {c.cfg.ClientSecret},
+	}
+	if audience != "" {
+		form.Set("audience", audience)
+	}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err := c.do(ctx, c.httpClient, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.BaseURL+"/api/v1/auth/token", strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	}, &resp)
+	if err != nil {
+		return "", 0, err
+	}
+	return resp.AccessToken, resp.ExpiresIn, nil
+}
+
+// doAuthorized sends an API request with the cached service account token.
+// A 401 drops the cached token and retries once with a fresh one.
+func (c *Client) doAuthorized(ctx context.Context, httpClient *http.Client, method, path string, body []byte, out interface{}) error {
+	for attempt := 0; ; attempt++ {
+		token, err := c.Token(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = c.do(ctx, httpClient, func() (*http.Request, error) {
+			var reqBody io.Reader
+			if body != nil {
+				reqBody = bytes.NewReader(body)
+			}
+			req, err := http.NewRequestWithContext(ctx, method, c.cfg.BaseURL+path, reqBody)
+			if err != nil {
+				return nil, err
+			}
+			if body != nil {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			return req, nil
+		}, out)
+
+		var apiErr *APIError
+		if attempt == 0 && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+			c.mu.Lock()
+			c.token = ""
+			c.mu.Unlock()
+			continue
+		}
+		return err
+	}
+}
+
+// do sends a request built by newRequest, retrying network errors and 5xx
+// responses with exponential backoff, and decodes a 2xx body into out.
+func (c *Client) do(ctx context.Context, httpClient *http.Client, newRequest func() (*http.Request, error), out interface{}) error {
+	var lastErr error
+	for attempt := 0; attempt <= c.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(retryBaseDelay << (attempt - 1)):
+			}
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			return fmt.Errorf("bastion: build request: %w", err)
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("bastion: %w", err)
+			continue
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("bastion: read response: %w", err)
+			continue
+		}
+
+		if resp.StatusCode >= 500 {
+			lastErr = &APIError{StatusCode: resp.StatusCode, Message: errorMessage(data)}
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return &APIError{StatusCode: resp.StatusCode, Message: errorMessage(data)}
+		}
+
+		if out != nil {
+			if err := json.Unmarshal(data, out); err != nil {
+				return fmt.Errorf("bastion: decode response: %w", err)
+			}
+		}
+		return nil
+	}
+	return lastErr
+}
+
+func errorMessage(body []byte) string {
+	var e struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error != "" {
+		if e.ErrorDescription != "" {
+			return e.Error + ": " + e.ErrorDescription
+		}
+		return e.Error
+	}
+	return strings.TrimSpace(string(body))
+}
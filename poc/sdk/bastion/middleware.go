@@ -0,0 +1,175 @@
+package bastion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RequireAuth verifies the bearer token of each request and stores its
+// claims and the client's IP in the request context (see
+// ClaimsFromContext).
+func (v *Verifier) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			writeError(w, "missing authorization header", http.StatusUnauthorized)
+			return
+		}
+
+		parts := strings.Split(header, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			writeError(w, "invalid authorization header", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := v.Verify(r.Context(), parts[1])
+		if err != nil {
+			writeError(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := ContextWithClaims(r.Context(), claims)
+		ctx = context.WithValue(ctx, clientIPKey{}, v.clientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequirePermission allows a request only if the verified caller holds the
+// permission. Permissions embedded in the token are allowed locally; any
+// other is left to checker, either a Client or a DecisionCache, as it may
+// be held through a grant the token cannot embed. It must run after
+// RequireAuth.
+func RequirePermission(checker Checker, resourceType, action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				writeError(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !claims.HasScope(resourceType, action) {
+				writeError(w, fmt.Sprintf("token scope does not include %s:%s", resourceType, action), http.StatusForbidden)
+				return
+			}
+
+			if claims.EmbeddedPermission(resourceType, action) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			identityType := claims.IdentityType
+			if identityType == "" {
+				identityType = "user"
+			}
+			now := time.Now().UTC()
+
+			resp, err := checker.Check(r.Context(), CheckRequest{
+				Identity: Identity{Type: identityType, ID: claims.Subject, TenantID: claims.TenantID},
+				Action:   action,
+				Resource: Resource{Type: resourceType},
+				Context:  RequestContext{IP: requestIP(r), Timestamp: &now},
+			})
+			if err != nil {
+				writeError(w, "authorization check failed", http.StatusBadGateway)
+				return
+			}
+			if !resp.Allowed {
+				writeError(w, resp.Reason, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type clientIPKey struct{}
+
+// requestIP returns the client IP RequireAuth determined, or the remote
+// address when it did not run.
+func requestIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// clientIP returns the remote address of r or, when that is a trusted
+// proxy, the nearest address in X-Forwarded-For not added by a trusted
+// proxy. Addresses to its left were supplied by the client and are
+// ignored.
+func (v *Verifier) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !v.trustedProxy(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			break
+		}
+		ip = hops[i]
+		if !v.trustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func (v *Verifier) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range v.proxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func parseProxies(entries []string) []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+		if _, n, err := net.ParseCIDR(entry); err == nil {
+			proxies = append(proxies, n)
+		}
+	}
+	return proxies
+}
+
+func writeError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,82 @@
+package bastion
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const pasetoHeader = "v4.public."
+
+// splitPASETO decodes a v4.public token into its payload, signature and
+// footer.
+func splitPASETO(token string) (payload, sig, footer []byte, err error) {
+	body, encodedFooter := strings.TrimPrefix(token, pasetoHeader), ""
+	if i := strings.IndexByte(body, '.'); i >= 0 {
+		body, encodedFooter = body[:i], body[i+1:]
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil || len(raw) < ed25519.SignatureSize {
+		return nil, nil, nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+	footer, err = base64.RawURLEncoding.DecodeString(encodedFooter)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: malformed footer", ErrInvalidToken)
+	}
+
+	n := len(raw) - ed25519.SignatureSize
+	return raw[:n], raw[n:], footer, nil
+}
+
+// decodePASETOClaims converts the RFC 3339 exp, iat and nbf claims of a
+// PASETO payload to numeric dates and decodes the result.
+func decodePASETOClaims(payload []byte) (*Claims, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	for _, name := range []string{"exp", "iat", "nbf"} {
+		s, ok := fields[name].(string)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad %s claim", ErrInvalidToken, name)
+		}
+		fields[name] = t.Unix()
+	}
+
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	claims := &Claims{}
+	if err := json.Unmarshal(raw, claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+// pae is PASETO's pre-authentication encoding.
+func pae(pieces ...[]byte) []byte {
+	var buf bytes.Buffer
+	le64 := func(n int) {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(n)&^(1<<63))
+		buf.Write(b[:])
+	}
+
+	le64(len(pieces))
+	for _, p := range pieces {
+		le64(len(p))
+		buf.Write(p)
+	}
+	return buf.Bytes()
+}
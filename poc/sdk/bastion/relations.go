@@ -0,0 +1,76 @@
+package bastion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// RelationTuple relates an object to a subject, written "namespace:id" for
+// a single object such as "user:alice" or "namespace:id#relation" for
+// everyone with a relation to an object, such as "group:eng#member".
+// Applications write tuples as objects are shared, in namespaces
+// configured in Bastion, and check them for document level access.
+type RelationTuple struct {
+	Namespace string `json:"namespace"`
+	ObjectID  string `json:"object_id"`
+	Relation  string `json:"relation"`
+	Subject   string `json:"subject"`
+}
+
+// RelationWriteResult counts the tuples a write actually added and removed.
+type RelationWriteResult struct {
+	Written int `json:"written"`
+	Deleted int `json:"deleted"`
+}
+
+// WriteRelations calls POST /api/v1/relations/tuples, deleting and then
+// writing tuples in one transaction.
+func (c *Client) WriteRelations(ctx context.Context, writes, deletes []RelationTuple) (*RelationWriteResult, error) {
+	body, err := json.Marshal(map[string][]RelationTuple{"writes": writes, "deletes": deletes})
+	if err != nil {
+		return nil, fmt.Errorf("bastion: encode relation tuples: %w", err)
+	}
+
+	var resp RelationWriteResult
+	if err := c.doAuthorized(ctx, c.httpClient, http.MethodPost, "/api/v1/relations/tuples", body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CheckRelation calls POST /api/v1/relations/check, reporting whether the
+// tuple's subject has its relation to its object, directly or through
+// computed and inherited relations.
+func (c *Client) CheckRelation(ctx context.Context, tuple RelationTuple) (bool, error) {
+	body, err := json.Marshal(tuple)
+	if err != nil {
+		return false, fmt.Errorf("bastion: encode relation check: %w", err)
+	}
+
+	var resp struct {
+		Allowed bool `json:"allowed"`
+	}
+	if err := c.doAuthorized(ctx, c.httpClient, http.MethodPost, "/api/v1/relations/check", body, &resp); err != nil {
+		return false, err
+	}
+	return resp.Allowed, nil
+}
+
+// ListRelatedObjects calls POST /api/v1/relations/list-objects, returning
+// the IDs of the objects in namespace that subject has relation to.
+func (c *Client) ListRelatedObjects(ctx context.Context, namespace, relation, subject string) ([]string, error) {
+	body, err := json.Marshal(map[string]string{"namespace": namespace, "relation": relation, "subject": subject})
+	if err != nil {
+		return nil, fmt.Errorf("bastion: encode list objects: %w", err)
+	}
+
+	var resp struct {
+		ObjectIDs []string `json:"object_ids"`
+	}
+	if err := c.doAuthorized(ctx, c.httpClient, http.MethodPost, "/api/v1/relations/list-objects", body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.ObjectIDs, nil
+}
@@ -0,0 +1,246 @@
+package bastion
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultKeyCacheTTL      = 5 * time.Minute
+	defaultMinRefreshPeriod = 30 * time.Second
+)
+
+var (
+	ErrInvalidToken  = errors.New("bastion: invalid token")
+	ErrUnknownKey    = errors.New("bastion: token signed with unknown key")
+	ErrWrongAudience = errors.New("bastion: token not issued for this audience")
+)
+
+// VerifierConfig configures local token verification. PASETO tokens are
+// verified against the keys published at JWKSURL; HS256 JWTs need the
+// shared SharedKey (Bastion's auth.jwt_secret). Set whichever formats the
+// Bastion deployment issues.
+type VerifierConfig struct {
+	// Issuer and Audience must match the iss and aud claims.
+	Issuer   string
+	Audience string
+
+	JWKSURL   string
+	SharedKey string
+
+	// KeyCacheTTL is how long fetched keys are trusted before the key set
+	// is fetched again. Defaults to five minutes.
+	KeyCacheTTL time.Duration
+
+	// TrustedProxies lists the addresses or CIDR ranges of reverse proxies
+	// in front of the application. X-Forwarded-For is only believed when
+	// it was added by one of them; otherwise the client IP that grant
+	// conditions are evaluated against is the connection's remote
+	// address. Entries that do not parse are ignored.
+	TrustedProxies []string
+
+	HTTPClient *http.Client
+}
+
+// Verifier verifies Bastion access tokens without calling Bastion. Keys
+// are cached; a token naming an unknown key triggers a refetch, so that
+// signing-key rotations are picked up without waiting for the cache to
+// expire.
+type Verifier struct {
+	cfg        VerifierConfig
+	httpClient *http.Client
+	proxies    []*net.IPNet
+
+	mu          sync.Mutex
+	keys        map[string]ed25519.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+func NewVerifier(cfg VerifierConfig) *Verifier {
+	if cfg.KeyCacheTTL == 0 {
+		cfg.KeyCacheTTL = defaultKeyCacheTTL
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Verifier{cfg: cfg, httpClient: httpClient, proxies: parseProxies(cfg.TrustedProxies)}
+}
+
+// Verify checks a token's signature, expiry, issuer and audience and
+// returns its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	var claims *Claims
+	var err error
+	if strings.HasPrefix(token, pasetoHeader) {
+		claims, err = v.verifyPASETO(ctx, token)
+	} else {
+		claims, err = v.verifyJWT(token)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := jwt.NewValidator().Validate(claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if v.cfg.Issuer != "" && claims.Issuer != v.cfg.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+	if v.cfg.Audience != "" && !hasAudience(claims, v.cfg.Audience) {
+		return nil, ErrWrongAudience
+	}
+
+	return claims, nil
+}
+
+func (v *Verifier) verifyJWT(token string) (*Claims, error) {
+	if v.cfg.SharedKey == "" {
+		return nil, fmt.Errorf("%w: no shared key configured for JWT tokens", ErrInvalidToken)
+	}
+
+	parsed, err := jwt.ParseWithClaims(token, &Claims{}, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return []byte(v.cfg.SharedKey), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	claims, ok := parsed.Claims.(*Claims)
+	if !ok || !parsed.Valid {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (v *Verifier) verifyPASETO(ctx context.Context, token string) (*Claims, error) {
+	payload, sig, footer, err := splitPASETO(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var ft struct {
+		KeyID string `json:"kid"`
+	}
+	if err := json.Unmarshal(footer, &ft); err != nil || ft.KeyID == "" {
+		return nil, fmt.Errorf("%w: missing key id", ErrInvalidToken)
+	}
+
+	key, err := v.key(ctx, ft.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(key, pae([]byte(pasetoHeader), payload, footer, nil), sig) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	return decodePASETOClaims(payload)
+}
+
+// key returns the verification key with the given ID, fetching the key set
+// when the cache has expired or does not know the key.
+func (v *Verifier) key(ctx context.Context, kid string) (ed25519.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fresh := time.Since(v.fetchedAt) < v.cfg.KeyCacheTTL
+	if key, ok := v.keys[kid]; ok && fresh {
+		return key, nil
+	}
+
+	// Rate-limit refetches so that tokens with bogus key IDs, or an
+	// unreachable Bastion, cannot make every request hit the JWKS endpoint.
+	if time.Since(v.lastAttempt) < defaultMinRefreshPeriod {
+		if key, ok := v.keys[kid]; ok {
+			return key, nil
+		}
+		return nil, ErrUnknownKey
+	}
+
+	v.lastAttempt = time.Now()
+	keys, err := v.fetchKeys(ctx)
+	if err != nil {
+		if key, ok := v.keys[kid]; ok {
+			// Keep serving a known key while Bastion is unreachable.
+			return key, nil
+		}
+		return nil, err
+	}
+	v.keys = keys
+	v.fetchedAt = time.Now()
+
+	key, ok := v.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (v *Verifier) fetchKeys(ctx context.Context) (map[string]ed25519.PublicKey, error) {
+	if v.cfg.JWKSURL == "" {
+		return nil, fmt.Errorf("bastion: no JWKS URL configured for PASETO tokens")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.cfg.JWKSURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("bastion: build JWKS request: %w", err)
+	}
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("bastion: fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bastion: fetch JWKS: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			Curve   string `json:"crv"`
+			KeyID   string `json:"kid"`
+			X       string `json:"x"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("bastion: decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]ed25519.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.KeyType != "OKP" || k.Curve != "Ed25519" {
+			continue
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			continue
+		}
+		keys[k.KeyID] = ed25519.PublicKey(raw)
+	}
+	return keys, nil
+}
+
+func hasAudience(claims *Claims, audience string) bool {
+	for _, aud := range claims.Audience {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}